@@ -0,0 +1,668 @@
+package logra
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+/*
+**
+Bitmaps
+Bit commands work on strings, but a string written by SETBIT or BITFIELD is
+stored in chunks, so that flipping a bit of a large bitmap appends only the
+chunk the bit is in. It is a collection of type "string" whose elements are
+the chunk numbers:
+
+	"\x00logra:type:<key>"              -> "string"
+	"\x00logra:elem:<len>:<key><chunk>" -> bytes of the chunk
+
+A chunk may be missing or shorter than bitmapChunkSize where the string holds
+zero bytes, but the last one ends where the string does, so the length is
+known from the index without reading any chunk. Reads assemble the chunks,
+and commands that write a whole value store the string plainly again.
+**
+*/
+const (
+	stringType      = "string"
+	bitmapChunkSize = 4096
+)
+
+// ErrBitOffset is returned for a bit offset outside a string of
+// MaxStringSize bytes.
+var ErrBitOffset = errors.New("bit offset is not an integer or out of range")
+
+func chunkElem(i int64) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(i))
+	return string(b[:])
+}
+
+func chunkIndex(elem string) int64 {
+	if len(elem) != 4 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint32([]byte(elem)))
+}
+
+// valueSize returns the length of the record at key from the index, so the
+// value is not read.
+func (db *LograDB) valueSize(key string) int64 {
+	if db.tx != nil {
+		if rec, found, _ := db.tx.pendingRecord(key); found {
+			return int64(len(rec.Value))
+		}
+	}
+	entry, _ := db.Index.Lookup(key)
+	return int64(entry.ValueSize)
+}
+
+// chunkedLen returns the length of the chunked string c at key.
+func (db *LograDB) chunkedLen(key string, c *collection) int64 {
+	if len(c.elems) == 0 {
+		return 0
+	}
+	return c.tail*bitmapChunkSize + db.valueSize(elemKey(key, chunkElem(c.tail)))
+}
+
+// stringLen returns the length of the string at key, which must exist.
+func (db *LograDB) stringLen(key string) (int64, error) {
+	if c := db.colls[key]; c != nil {
+		if c.kind != stringType {
+			return 0, ErrWrongType
+		}
+		return db.chunkedLen(key, c), nil
+	}
+	return db.valueSize(key), nil
+}
+
+// stringRange reads the bytes [start, end) of the string at key, which must
+// exist, cut short where the string ends. Of a chunked string only the
+// chunks in the range are read.
+func (db *LograDB) stringRange(key string, start, end int64) ([]byte, error) {
+	c := db.colls[key]
+	if c == nil {
+		rec, err := db.readEntry(key)
+		if err != nil {
+			return nil, err
+		}
+		end = min(end, int64(len(rec.Value)))
+		if start >= end {
+			return nil, nil
+		}
+		return []byte(rec.Value[start:end]), nil
+	}
+	if c.kind != stringType {
+		return nil, ErrWrongType
+	}
+
+	end = min(end, db.chunkedLen(key, c))
+	if start >= end {
+		return nil, nil
+	}
+	buf := make([]byte, end-start)
+	for i := start / bitmapChunkSize; i*bitmapChunkSize < end; i++ {
+		if _, ok := c.elems[chunkElem(i)]; !ok {
+			continue
+		}
+		rec, err := db.readEntry(elemKey(key, chunkElem(i)))
+		if err != nil {
+			return nil, err
+		}
+		lo := i * bitmapChunkSize
+		from, to := max(lo, start), min(lo+int64(len(rec.Value)), end)
+		if from < to {
+			copy(buf[from-start:], rec.Value[from-lo:to-lo])
+		}
+	}
+	return buf, nil
+}
+
+// bitmapEdit collects changes to the string at key. It reads each chunk it
+// touches once and writes back only the chunks it changed; a plain string is
+// rewritten in chunks.
+type bitmapEdit struct {
+	db      *LograDB
+	key     string
+	exists  bool
+	convert bool // the string is stored plainly
+	size    int64
+	chunks  map[int64][]byte
+	changed map[int64]bool
+}
+
+// editBitmap starts an edit of the string at key, which may be missing. The
+// caller must hold the write lock.
+func (db *LograDB) editBitmap(key string) (*bitmapEdit, error) {
+	e := &bitmapEdit{db: db, key: key, exists: db.has(key), chunks: make(map[int64][]byte), changed: make(map[int64]bool)}
+	if !e.exists {
+		return e, nil
+	}
+	size, err := db.stringLen(key)
+	if err != nil {
+		return nil, err
+	}
+	e.size = size
+	if db.colls[key] == nil {
+		value, err := db.stringRange(key, 0, size)
+		if err != nil {
+			return nil, err
+		}
+		e.convert = true
+		for i := int64(0); i*bitmapChunkSize < size; i++ {
+			e.chunks[i] = value[i*bitmapChunkSize : min((i+1)*bitmapChunkSize, size)]
+		}
+	}
+	return e, nil
+}
+
+// chunk returns chunk i, padded with zero bytes up to the end of the string.
+func (e *bitmapEdit) chunk(i int64) ([]byte, error) {
+	if b, ok := e.chunks[i]; ok {
+		return b, nil
+	}
+	var b []byte
+	if e.exists && !e.convert {
+		var err error
+		b, err = e.db.stringRange(e.key, i*bitmapChunkSize, (i+1)*bitmapChunkSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+	e.chunks[i] = b
+	return b, nil
+}
+
+// byteAt returns byte p of the string, or 0 past its end.
+func (e *bitmapEdit) byteAt(p int64) (byte, error) {
+	b, err := e.chunk(p / bitmapChunkSize)
+	if err != nil {
+		return 0, err
+	}
+	if off := p % bitmapChunkSize; off < int64(len(b)) {
+		return b[off], nil
+	}
+	return 0, nil
+}
+
+// setByte sets byte p of the string, growing it as needed.
+func (e *bitmapEdit) setByte(p int64, v byte) error {
+	i := p / bitmapChunkSize
+	b, err := e.chunk(i)
+	if err != nil {
+		return err
+	}
+	off := p % bitmapChunkSize
+	if off >= int64(len(b)) {
+		b = append(b, make([]byte, off+1-int64(len(b)))...)
+	}
+	b[off] = v
+	e.chunks[i] = b
+	e.changed[i] = true
+	e.size = max(e.size, p+1)
+	return nil
+}
+
+// getBits reads the width bits from bit offset on as an unsigned number.
+func (e *bitmapEdit) getBits(offset int64, width uint) (uint64, error) {
+	var v uint64
+	for i := int64(0); i < int64(width); i++ {
+		p := offset + i
+		b, err := e.byteAt(p / 8)
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | uint64(b>>(7-p%8)&1)
+	}
+	return v, nil
+}
+
+// setBits writes the low width bits of v from bit offset on.
+func (e *bitmapEdit) setBits(offset int64, width uint, v uint64) error {
+	for i := int64(0); i < int64(width); i++ {
+		p := offset + i
+		b, err := e.byteAt(p / 8)
+		if err != nil {
+			return err
+		}
+		mask := byte(1) << (7 - p%8)
+		if v>>(int64(width)-1-i)&1 == 1 {
+			b |= mask
+		} else {
+			b &^= mask
+		}
+		if err := e.setByte(p/8, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ops returns the writes that store the edit, or nil if nothing changed.
+func (e *bitmapEdit) ops() []writeOp {
+	if len(e.changed) == 0 {
+		return nil
+	}
+	var ops []writeOp
+	switch {
+	case !e.exists:
+		ops = e.db.createOps(e.key, stringType)
+	case e.convert:
+		// The deadline stays, so the value is deleted by hand
+		ops = []writeOp{{key: e.key, del: true}, {key: typeKey(e.key), value: stringType}}
+		last := (e.size - 1) / bitmapChunkSize
+		for i, b := range e.chunks {
+			if i == last || !allZero(b) {
+				e.changed[i] = true
+			}
+		}
+	}
+	for i := range e.changed {
+		ops = append(ops, writeOp{key: elemKey(e.key, chunkElem(i)), value: string(e.chunks[i])})
+	}
+	return ops
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// checkBitOffset fails with ErrBitOffset unless the width bits from offset
+// on fit in a string of MaxStringSize bytes.
+func checkBitOffset(offset int64, width uint) error {
+	if offset < 0 || offset+int64(width) > MaxStringSize*8 {
+		return ErrBitOffset
+	}
+	return nil
+}
+
+// GetBit returns the bit at offset in the string at key, which is 0 past
+// its end or if the key is missing.
+func (db *LograDB) GetBit(key string, offset int64) (int, error) {
+	if err := checkBitOffset(offset, 1); err != nil {
+		return 0, err
+	}
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if !db.has(key) {
+		return 0, nil
+	}
+	b, err := db.stringRange(key, offset/8, offset/8+1)
+	if err != nil || len(b) == 0 {
+		return 0, err
+	}
+	return int(b[0] >> (7 - offset%8) & 1), nil
+}
+
+// SetBit sets the bit at offset in the string at key to bit, growing the
+// string with zero bytes as needed, and returns the bit's previous value.
+// Only the chunk holding the bit is written.
+func (db *LograDB) SetBit(key string, offset int64, bit int) (int, error) {
+	if err := checkBitOffset(offset, 1); err != nil {
+		return 0, err
+	}
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	e, err := db.editBitmap(key)
+	if err != nil {
+		return 0, err
+	}
+	old, err := e.getBits(offset, 1)
+	if err != nil {
+		return 0, err
+	}
+	if err := e.setBits(offset, 1, uint64(bit)); err != nil {
+		return 0, err
+	}
+	if err := db.write(e.ops()); err != nil {
+		return 0, err
+	}
+	db.notify(EventString, "setbit", key)
+	return int(old), nil
+}
+
+// BitRange selects part of a string for BitCount and BitPos. Start and End
+// are inclusive and count from the end of the string when negative, in
+// bytes or, with Bits, in bits.
+type BitRange struct {
+	Start, End int64
+	Bits       bool
+
+	// OpenEnd means End was not given, so BitPos looking for a 0 bit may
+	// report the first bit past the string.
+	OpenEnd bool
+}
+
+// AllBits selects the whole string.
+var AllBits = BitRange{Start: 0, End: -1, OpenEnd: true}
+
+// bits resolves r against a string of size bytes to an inclusive range of
+// bit positions. ok is false if it is empty.
+func (r BitRange) bits(size int64) (first, last int64, ok bool) {
+	n := size
+	if r.Bits {
+		n *= 8
+	}
+	start, end := r.Start, r.End
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	start, end = max(start, 0), min(max(end, 0), n-1)
+	if start > end {
+		return 0, 0, false
+	}
+	if r.Bits {
+		return start, end, true
+	}
+	return start * 8, end*8 + 7, true
+}
+
+// bitsIn reads the bytes holding the bits [first, last] of the string at key
+// and returns them with first and last made relative to the bytes.
+func (db *LograDB) bitsIn(key string, first, last int64) ([]byte, int64, int64, error) {
+	b, err := db.stringRange(key, first/8, last/8+1)
+	return b, first % 8, last - first/8*8, err
+}
+
+// BitCount counts the bits set in the range r of the string at key.
+func (db *LograDB) BitCount(key string, r BitRange) (int64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if !db.has(key) {
+		return 0, nil
+	}
+	size, err := db.stringLen(key)
+	if err != nil {
+		return 0, err
+	}
+	first, last, ok := r.bits(size)
+	if !ok {
+		return 0, nil
+	}
+	b, first, last, err := db.bitsIn(key, first, last)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, c := range b {
+		n += int64(bits.OnesCount8(c))
+	}
+	// Leave out the bits of the end bytes outside the range
+	n -= int64(bits.OnesCount8(b[0] >> (8 - first)))
+	n -= int64(bits.OnesCount8(b[len(b)-1] << (last%8 + 1)))
+	return n, nil
+}
+
+// BitPos returns the position of the first bit set to bit in the range r of
+// the string at key, or -1 if there is none. A missing key reads as zero
+// bits, as does the rest of the string past its end unless r ends it.
+func (db *LograDB) BitPos(key string, bit int, r BitRange) (int64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if !db.has(key) {
+		if bit == 1 {
+			return -1, nil
+		}
+		return 0, nil
+	}
+	size, err := db.stringLen(key)
+	if err != nil {
+		return 0, err
+	}
+	first, last, ok := r.bits(size)
+	if !ok {
+		return -1, nil
+	}
+	b, from, to, err := db.bitsIn(key, first, last)
+	if err != nil {
+		return 0, err
+	}
+	skip := byte(0)
+	if bit == 0 {
+		skip = 0xff
+	}
+	for p := from; p <= to; {
+		if p%8 == 0 && p+7 <= to && b[p/8] == skip {
+			p += 8
+			continue
+		}
+		if int(b[p/8]>>(7-p%8)&1) == bit {
+			return first + p - from, nil
+		}
+		p++
+	}
+	if bit == 0 && r.OpenEnd {
+		return last + 1, nil
+	}
+	return -1, nil
+}
+
+// BitOperator is the operation BitOp applies.
+type BitOperator int
+
+const (
+	BitAnd BitOperator = iota
+	BitOr
+	BitXor
+	BitNot
+)
+
+// BitOp stores at dest the result of op over the strings at keys, which for
+// BitNot is a single key. Shorter strings are padded with zero bytes, and
+// missing keys read as empty strings. It returns the length of the result;
+// an empty result deletes dest.
+func (db *LograDB) BitOp(op BitOperator, dest string, keys []string) (int64, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	values := make([]string, len(keys))
+	n := 0
+	for i, key := range keys {
+		value, _, err := db.current(key)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = value
+		n = max(n, len(value))
+	}
+
+	result := make([]byte, n)
+	for i := range result {
+		var acc byte
+		for j, value := range values {
+			var c byte
+			if i < len(value) {
+				c = value[i]
+			}
+			switch {
+			case j == 0:
+				acc = c
+			case op == BitAnd:
+				acc &= c
+			case op == BitOr:
+				acc |= c
+			case op == BitXor:
+				acc ^= c
+			}
+		}
+		if op == BitNot {
+			acc = ^acc
+		}
+		result[i] = acc
+	}
+
+	if n == 0 {
+		if !db.has(dest) {
+			return 0, nil
+		}
+		if err := db.write(db.deleteOps(dest)); err != nil {
+			return 0, err
+		}
+		db.notify(EventGeneric, "del", dest)
+		return 0, nil
+	}
+	ops := append([]writeOp{{key: dest, value: string(result)}}, db.dropCollectionOps(dest)...)
+	if err := db.write(append(ops, db.clearExpireOps(dest)...)); err != nil {
+		return 0, err
+	}
+	db.notify(EventString, "set", dest)
+	return int64(n), nil
+}
+
+// BitFieldAction is what a BitFieldOp does with its field.
+type BitFieldAction int
+
+const (
+	BitFieldGet BitFieldAction = iota
+	BitFieldSet
+	BitFieldIncrBy
+)
+
+// BitFieldOverflow is how a BitFieldOp handles a value that does not fit
+// its field.
+type BitFieldOverflow int
+
+const (
+	OverflowWrap BitFieldOverflow = iota // keep the low bits
+	OverflowSat                          // clamp to the field's range
+	OverflowFail                         // leave the field as is
+)
+
+// BitFieldOp is one operation of BitField on the Width bits at bit Offset,
+// read as a signed or unsigned integer. Value is the value to set or the
+// increment.
+type BitFieldOp struct {
+	Action   BitFieldAction
+	Signed   bool
+	Width    uint // 1 to 64 signed, 1 to 63 unsigned
+	Offset   int64
+	Value    int64
+	Overflow BitFieldOverflow
+}
+
+// fit brings v, the result of adding incr to a field that held old, into
+// the field's range according to op.Overflow. ok is false if it does not fit
+// and the overflow policy is OverflowFail.
+func (op BitFieldOp) fit(old, incr int64) (v int64, ok bool) {
+	if op.Signed {
+		return op.fitSigned(old, incr)
+	}
+	limit := uint64(1)<<op.Width - 1
+	value, uincr := uint64(old), uint64(incr)
+	switch {
+	case value > limit || (incr > 0 && uincr > limit-value):
+		v = int64(limit)
+	case incr < 0 && uint64(-incr) > value:
+		v = 0
+	default:
+		return int64(value + uincr), true
+	}
+	switch op.Overflow {
+	case OverflowWrap:
+		return int64((value + uincr) & limit), true
+	case OverflowSat:
+		return v, true
+	}
+	return 0, false
+}
+
+func (op BitFieldOp) fitSigned(value, incr int64) (v int64, ok bool) {
+	hi := int64(math.MaxInt64)
+	if op.Width < 64 {
+		hi = int64(1)<<(op.Width-1) - 1
+	}
+	lo := -hi - 1
+	switch {
+	case value > hi || (op.Width != 64 && incr > hi-value) || (value >= 0 && incr > 0 && incr > hi-value):
+		v = hi
+	case value < lo || (op.Width != 64 && incr < lo-value) || (value < 0 && incr < 0 && incr < lo-value):
+		v = lo
+	default:
+		return value + incr, true
+	}
+	switch op.Overflow {
+	case OverflowWrap:
+		return signExtend(uint64(value)+uint64(incr), op.Width), true
+	case OverflowSat:
+		return v, true
+	}
+	return 0, false
+}
+
+// signExtend reads the low width bits of v as a two's complement number.
+func signExtend(v uint64, width uint) int64 {
+	shift := 64 - width
+	return int64(v<<shift) >> shift
+}
+
+// BitField runs ops on the string at key in order and returns one result for
+// each: the value read, the value a set replaced, or the value an increment
+// produced. An operation whose result did not fit under OverflowFail yields
+// nil and changes nothing. Only the chunks holding changed fields are
+// written.
+func (db *LograDB) BitField(key string, ops []BitFieldOp) ([]*int64, error) {
+	for _, op := range ops {
+		if err := checkBitOffset(op.Offset, op.Width); err != nil {
+			return nil, err
+		}
+	}
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	e, err := db.editBitmap(key)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]*int64, len(ops))
+	for i, op := range ops {
+		raw, err := e.getBits(op.Offset, op.Width)
+		if err != nil {
+			return nil, err
+		}
+		old := int64(raw)
+		if op.Signed {
+			old = signExtend(raw, op.Width)
+		}
+		if op.Action == BitFieldGet {
+			results[i] = &old
+			continue
+		}
+
+		v, ok := op.fit(old, op.Value)
+		if op.Action == BitFieldSet {
+			v, ok = op.fit(op.Value, 0)
+		}
+		if !ok {
+			continue
+		}
+		if err := e.setBits(op.Offset, op.Width, uint64(v)); err != nil {
+			return nil, err
+		}
+		if op.Action == BitFieldSet {
+			v = old
+		}
+		results[i] = &v
+	}
+
+	writes := e.ops()
+	if err := db.write(writes); err != nil {
+		return nil, err
+	}
+	if writes != nil {
+		db.notify(EventString, "setbit", key)
+	}
+	return results, nil
+}
@@ -15,6 +15,8 @@ import (
 func main() {
 	addr := flag.String("addr", ":6379", "listen address")
 	dbPath := flag.String("db", "logra_data", "database directory path")
+	compactWorkers := flag.Int("compact-workers", 1, "parallel workers used by COMPACT")
+	pubsubLimit := flag.Int("pubsub-buffer-limit", 32*1024*1024, "bytes queued for a subscriber before it is disconnected")
 	flag.Parse()
 
 	db, err := logra.Open(*dbPath, "1.0.0")
@@ -27,6 +29,8 @@ func main() {
 		db.Close()
 		log.Fatalf("failed to start server: %v", err)
 	}
+	srv.SetCompactionWorkers(*compactWorkers)
+	srv.SetPubSubBufferLimit(*pubsubLimit)
 
 	go func() {
 		sigCh := make(chan os.Signal, 1)
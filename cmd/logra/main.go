@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strconv"
 
 	"sakthirathinam/logra"
 	"sakthirathinam/logra/internal/compact"
@@ -77,6 +78,14 @@ func main() {
 
 	case "compact":
 		compact := compact.NewCompact(db)
+		if len(os.Args) >= 3 {
+			workers, err := strconv.Atoi(os.Args[2])
+			if err != nil || workers < 1 {
+				fmt.Println("Usage: logra compact [workers]")
+				os.Exit(1)
+			}
+			compact.SetWorkers(workers)
+		}
 		if err := compact.Execute(); err != nil {
 			fmt.Println("Failed to compact database:", err)
 			os.Exit(1)
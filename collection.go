@@ -0,0 +1,394 @@
+package logra
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra/internal/index"
+	"sakthirathinam/logra/internal/skiplist"
+)
+
+/*
+**
+Collections
+Values other than strings are stored one record per element, so changing an
+element appends only that element. A type record marks the key and names its
+type, and each element is a record keyed by the length-prefixed key and the
+element:
+
+	"\x00logra:type:<key>"          -> "hash"
+	"\x00logra:elem:<len>:<key><f>" -> value of field f
+
+The type record stands in for the key in the index: it is what key listings
+report and what Len counts. db.colls mirrors these records in memory and is
+rebuilt on Open; compaction copies them like any other record.
+**
+*/
+const (
+	typeKeyPrefix = internalKeyPrefix + "type:"
+	elemKeyPrefix = internalKeyPrefix + "elem:"
+)
+
+// ErrWrongType is returned when a command is used on a key of another type.
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// collection is the in-memory view of a key stored as elements.
+type collection struct {
+	kind  string // type name, as TYPE reports it
+	elems map[string]struct{}
+
+	// head and tail are the positions of the first and last element of a
+	// list (see list.go); tail is also the last chunk of a string stored in
+	// chunks (see bitmap.go).
+	head, tail int64
+
+	// scores and zsl order the members of a sorted set (see zset.go).
+	scores map[string]float64
+	zsl    *skiplist.List
+
+	stream *stream // entries and consumer groups of a stream (see stream.go)
+
+	filter *filterMeta // options and counts of a Bloom or Cuckoo filter (see filter.go)
+
+	vectors *vectorSet // vectors and index of a vector set (see vector.go)
+}
+
+func newCollection(kind string) *collection {
+	c := &collection{kind: kind, elems: make(map[string]struct{})}
+	if kind == zsetType {
+		c.scores = make(map[string]float64)
+		c.zsl = skiplist.New()
+	}
+	if kind == streamType {
+		c.stream = newStream()
+	}
+	if kind == vectorType {
+		c.vectors = newVectorSet()
+	}
+	return c
+}
+
+// keepsValue reports whether c keeps the value of elem's record in memory.
+func (c *collection) keepsValue(elem string) bool {
+	switch c.kind {
+	case zsetType:
+		return true
+	case streamType:
+		return elem[0] != streamEntryElem
+	case bloomType, cuckooType:
+		return elem == filterMetaElem
+	case vectorType:
+		return true
+	}
+	return false
+}
+
+// value rebuilds the value of elem's record, for an element whose value c
+// keeps.
+func (c *collection) value(elem string) string {
+	if c.kind == zsetType {
+		return FormatScore(c.scores[elem])
+	}
+	if c.filter != nil {
+		return c.filter.encode()
+	}
+	if c.vectors != nil {
+		return c.vectors.value(elem)
+	}
+	return c.stream.value(elem)
+}
+
+// add and remove keep c in step with writes to its element records. value is
+// the value of the record, which matters only where keepsValue says so.
+func (c *collection) add(elem, value string) {
+	c.elems[elem] = struct{}{}
+	if c.kind == streamType {
+		c.stream.add(elem, value)
+	}
+	if c.kind == zsetType {
+		if old, ok := c.scores[elem]; ok {
+			c.zsl.Delete(skiplist.Element{Member: elem, Score: old})
+		}
+		score := parseScore(value)
+		c.scores[elem] = score
+		c.zsl.Insert(skiplist.Element{Member: elem, Score: score})
+	}
+	if c.kind == listType {
+		pos := listPos(elem)
+		if len(c.elems) == 1 {
+			c.head, c.tail = pos, pos
+		}
+		c.head, c.tail = min(c.head, pos), max(c.tail, pos)
+	}
+	if (c.kind == bloomType || c.kind == cuckooType) && elem == filterMetaElem {
+		c.filter = parseFilterMeta(value)
+	}
+	if c.kind == vectorType {
+		if elem == vectorConfigElem {
+			c.vectors.setConfig(value)
+		} else {
+			c.vectors.add(elem[1:], decodeVector(value))
+		}
+	}
+	if c.kind == stringType {
+		// Chunks are only dropped with the whole string
+		c.tail = max(c.tail, chunkIndex(elem))
+	}
+}
+
+func (c *collection) remove(elem string) {
+	if _, ok := c.elems[elem]; !ok {
+		return
+	}
+	delete(c.elems, elem)
+	if c.kind == streamType {
+		c.stream.remove(elem)
+	}
+	if c.kind == zsetType {
+		c.zsl.Delete(skiplist.Element{Member: elem, Score: c.scores[elem]})
+		delete(c.scores, elem)
+	}
+	if c.kind == vectorType && elem != vectorConfigElem {
+		c.vectors.remove(elem[1:])
+	}
+	if c.kind == listType {
+		// Lists only lose elements at their ends
+		switch pos := listPos(elem); pos {
+		case c.head:
+			c.head++
+		case c.tail:
+			c.tail--
+		}
+	}
+}
+
+func typeKey(key string) string {
+	return typeKeyPrefix + key
+}
+
+func elemKey(key, elem string) string {
+	return elemKeyPrefix + strconv.Itoa(len(key)) + ":" + key + elem
+}
+
+// parseElemKey splits an element record key into the key and the element.
+func parseElemKey(s string) (key, elem string, ok bool) {
+	if !strings.HasPrefix(s, elemKeyPrefix) {
+		return "", "", false
+	}
+	s = s[len(elemKeyPrefix):]
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	n, err := strconv.Atoi(s[:i])
+	if err != nil || n < 0 || n > len(s)-i-1 {
+		return "", "", false
+	}
+	s = s[i+1:]
+	return s[:n], s[n:], true
+}
+
+// ownerKey returns the key an internal record belongs to, or the key itself
+// for a value.
+func ownerKey(key string) string {
+	switch {
+	case strings.HasPrefix(key, expireKeyPrefix):
+		return key[len(expireKeyPrefix):]
+	case strings.HasPrefix(key, typeKeyPrefix):
+		return key[len(typeKeyPrefix):]
+	}
+	if owner, _, ok := parseElemKey(key); ok {
+		return owner
+	}
+	return key
+}
+
+// loadCollections reads the type and element records found by loadIndex.
+func (db *LograDB) loadCollections() error {
+	var elems []string
+	for _, key := range db.Index.Keys() {
+		if strings.HasPrefix(key, elemKeyPrefix) {
+			elems = append(elems, key)
+			continue
+		}
+		if !strings.HasPrefix(key, typeKeyPrefix) {
+			continue
+		}
+		rec, err := db.readEntry(key)
+		if err != nil {
+			return err
+		}
+		db.colls[key[len(typeKeyPrefix):]] = newCollection(rec.Value)
+	}
+	for _, key := range elems {
+		owner, elem, ok := parseElemKey(key)
+		c := db.colls[owner]
+		if !ok || c == nil {
+			continue
+		}
+		value := ""
+		if c.keepsValue(elem) {
+			rec, err := db.readEntry(key)
+			if err != nil {
+				return err
+			}
+			value = rec.Value
+		}
+		c.add(elem, value)
+	}
+	return nil
+}
+
+// trackCollection keeps db.colls in step with a write to a type or element
+// record. A type record must be written before the elements of a new key.
+func (db *LograDB) trackCollection(op writeOp) {
+	if strings.HasPrefix(op.key, typeKeyPrefix) {
+		key := op.key[len(typeKeyPrefix):]
+		old := db.colls[key]
+		if op.del {
+			delete(db.colls, key)
+		} else if old == nil {
+			db.colls[key] = newCollection(op.value)
+		}
+		db.onAbort(func() {
+			if old != nil {
+				db.colls[key] = old
+			} else {
+				delete(db.colls, key)
+			}
+		})
+		return
+	}
+
+	key, elem, ok := parseElemKey(op.key)
+	if !ok || db.colls[key] == nil {
+		return
+	}
+	c := db.colls[key]
+	_, had := c.elems[elem]
+	head, tail := c.head, c.tail
+	var top StreamID
+	if c.stream != nil {
+		top = c.stream.top
+	}
+	prev := ""
+	if had && c.keepsValue(elem) {
+		prev = c.value(elem)
+	}
+	if op.del {
+		c.remove(elem)
+	} else {
+		c.add(elem, op.value)
+	}
+	db.onAbort(func() {
+		if had {
+			c.add(elem, prev)
+		} else {
+			c.remove(elem)
+		}
+		c.head, c.tail = head, tail
+		if c.stream != nil {
+			c.stream.top = top
+		}
+	})
+}
+
+// onAbort registers fn to undo an in-memory change if the transaction it was
+// made in fails to commit. Outside a transaction writes are already durable.
+func (db *LograDB) onAbort(fn func()) {
+	if db.tx != nil {
+		db.tx.undo = append(db.tx.undo, fn)
+	}
+}
+
+// collectionOf returns the collection at key, or nil if the key does not
+// exist. It fails with ErrWrongType if key holds something other than kind.
+// The caller must hold the lock.
+func (db *LograDB) collectionOf(key, kind string) (*collection, error) {
+	if !db.has(key) {
+		return nil, nil
+	}
+	c := db.colls[key]
+	if c == nil || c.kind != kind {
+		return nil, ErrWrongType
+	}
+	return c, nil
+}
+
+// createOps returns the writes that start a new collection at key. A value
+// left behind by an expired key is deleted with the same write.
+func (db *LograDB) createOps(key, kind string) []writeOp {
+	var ops []writeOp
+	if db.indexed(key) {
+		ops = db.deleteOps(key)
+	}
+	return append(ops, writeOp{key: typeKey(key), value: kind})
+}
+
+// dropCollectionOps returns the writes that delete the elements and type
+// record of key, if it is a collection.
+func (db *LograDB) dropCollectionOps(key string) []writeOp {
+	c := db.colls[key]
+	if c == nil {
+		return nil
+	}
+	ops := make([]writeOp, 0, len(c.elems)+1)
+	for elem := range c.elems {
+		ops = append(ops, writeOp{key: elemKey(key, elem), del: true})
+	}
+	return append(ops, writeOp{key: typeKey(key), del: true})
+}
+
+// elemRecords counts the element records of all collections.
+func (db *LograDB) elemRecords() int {
+	n := 0
+	for _, c := range db.colls {
+		n += len(c.elems)
+	}
+	return n
+}
+
+// sortedElems returns the elements of c in byte order.
+func (c *collection) sortedElems() []string {
+	elems := make([]string, 0, len(c.elems))
+	for elem := range c.elems {
+		elems = append(elems, elem)
+	}
+	sort.Strings(elems)
+	return elems
+}
+
+// scan calls fn for about count elements from cursor on, in the same hash
+// order as Index.Scan, and returns the cursor to continue from, or 0 once
+// every element was visited.
+func (c *collection) scan(cursor uint64, count int, fn func(elem string)) uint64 {
+	type item struct {
+		hash uint64
+		elem string
+	}
+
+	if count < 1 {
+		count = 1
+	}
+	items := make([]item, 0, len(c.elems))
+	for elem := range c.elems {
+		if h := index.Hash(elem); h >= cursor {
+			items = append(items, item{h, elem})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].hash != items[j].hash {
+			return items[i].hash < items[j].hash
+		}
+		return items[i].elem < items[j].elem
+	})
+	for i, it := range items {
+		if i >= count && it.hash != items[i-1].hash {
+			return it.hash
+		}
+		fn(it.elem)
+	}
+	return 0
+}
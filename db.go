@@ -3,6 +3,7 @@ package logra
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 
 	"sakthirathinam/logra/internal/index"
@@ -19,6 +20,20 @@ type LograDB struct {
 	version string
 	Mutex   sync.RWMutex
 	Flock   *flock.Flock
+
+	// expires maps keys to their deadline in Unix milliseconds (see expire.go).
+	expires map[string]int64
+
+	// colls holds the keys stored as elements (see collection.go).
+	colls map[string]*collection
+
+	// watches and tx back transactions (see tx.go). tx is only set on the
+	// view a transaction runs against.
+	watches map[string]*watch
+	tx      *txn
+
+	// notifier receives keyspace events (see notify.go).
+	notifier Notifier
 }
 
 type Record struct {
@@ -27,6 +42,11 @@ type Record struct {
 	Timestamp int64
 }
 
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
 func Open(path string, version string) (*LograDB, error) {
 	// flock := flock.New(filepath.Join(path, lograLockFile))
 
@@ -38,6 +58,11 @@ func Open(path string, version string) (*LograDB, error) {
 	// 	return nil, fmt.Errorf("failed to acquire lock")
 	// }
 
+	// Settle any compaction interrupted by a crash before picking the active file
+	if err := storage.RecoverMerge(path); err != nil {
+		return nil, fmt.Errorf("failed to recover compaction: %w", err)
+	}
+
 	store, err := storage.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open storage: %w", err)
@@ -50,6 +75,9 @@ func Open(path string, version string) (*LograDB, error) {
 		Storage: store,
 		version: version,
 		Flock:   nil,
+		expires: make(map[string]int64),
+		colls:   make(map[string]*collection),
+		watches: make(map[string]*watch),
 	}
 
 	if err := db.loadIndex(); err != nil {
@@ -93,10 +121,18 @@ func (db *LograDB) loadIndex() error {
 		db.Index.Remove(string(key))
 	}
 
-	return db.Storage.Scan(onAppend, onDelete)
-
+	if err := db.Storage.Scan(onAppend, onDelete); err != nil {
+		return err
+	}
+	if err := db.loadExpires(); err != nil {
+		return err
+	}
+	return db.loadCollections()
 }
+
 func (db *LograDB) SwapIndex(newIndex *index.Index) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
 	db.Index = newIndex
 }
 
@@ -105,32 +141,111 @@ func (db *LograDB) Version() string {
 }
 
 func (db *LograDB) Has(key string) bool {
+	db.Mutex.RLock()
+	found, expired := db.has(key), db.expired(key)
+	db.Mutex.RUnlock()
+	if expired {
+		db.deleteExpired(key)
+	}
+	return found
+}
+
+// Len returns the number of live keys.
+func (db *LograDB) Len() int {
 	db.Mutex.RLock()
 	defer db.Mutex.RUnlock()
-	return db.Index.Has(key)
+	// Every deadline and element record is an index entry of its own, and
+	// keys whose deadline passed stay indexed until they are deleted.
+	n := db.Index.Len() - len(db.expires) - db.elemRecords() - db.expiredKeys()
+	if db.tx != nil {
+		n += db.tx.lenDelta(db)
+	}
+	return n
 }
 
 func (db *LograDB) has(key string) bool {
+	return db.indexed(key) && !db.expired(key)
+}
+
+// indexed reports whether key has a value, expired or not, taking writes
+// buffered by a transaction into account.
+func (db *LograDB) indexed(key string) bool {
+	if db.colls[key] != nil {
+		return true
+	}
+	if db.tx != nil {
+		if op, found := db.tx.pending[key]; found {
+			return !op.del
+		}
+	}
 	return db.Index.Has(key)
 }
 
 func (db *LograDB) Delete(key string) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
-	if !db.has(key) {
+	if !db.indexed(key) {
 		return fmt.Errorf("key not found")
 	}
-	deleted := db.Index.Remove(key)
-	if !deleted {
+	expired := db.expired(key)
+	if err := db.write(db.deleteOps(key)); err != nil {
+		return err
+	}
+	if expired {
+		db.notify(EventExpired, "expired", key)
 		return fmt.Errorf("key not found")
 	}
-
-	db.Storage.MarkDeleted([]byte(key))
+	db.notify(EventGeneric, "del", key)
 	return nil
 }
+
+// deleteOps returns the writes that remove key and its deadline.
+func (db *LograDB) deleteOps(key string) []writeOp {
+	ops := db.dropCollectionOps(key)
+	if ops == nil {
+		ops = []writeOp{{key: key, del: true}}
+	}
+	return append(ops, db.clearExpireOps(key)...)
+}
+
 func (db *LograDB) Get(key string) (Record, error) {
 	db.Mutex.RLock()
-	defer db.Mutex.RUnlock()
+	rec, err := db.get(key)
+	expired := db.expired(key)
+	db.Mutex.RUnlock()
+	if expired {
+		db.deleteExpired(key)
+	}
+	return rec, err
+}
+
+func (db *LograDB) get(key string) (Record, error) {
+	if db.expired(key) {
+		return Record{}, fmt.Errorf("key not found")
+	}
+	if c := db.colls[key]; c != nil {
+		if c.kind != stringType {
+			return Record{}, ErrWrongType
+		}
+		value, err := db.stringRange(key, 0, MaxStringSize)
+		if err != nil {
+			return Record{}, err
+		}
+		return Record{Key: key, Value: string(value)}, nil
+	}
+	return db.readEntry(key)
+}
+
+// readEntry reads the record the index holds for key, ignoring expiry.
+func (db *LograDB) readEntry(key string) (Record, error) {
+	if db.tx != nil {
+		if rec, found, ok := db.tx.pendingRecord(key); found {
+			if !ok {
+				return Record{}, fmt.Errorf("key not found")
+			}
+			return rec, nil
+		}
+	}
 	entry, exists := db.Index.Lookup(key)
 	if !exists {
 		return Record{}, fmt.Errorf("key not found")
@@ -155,23 +270,188 @@ func (db *LograDB) Get(key string) (Record, error) {
 	}, nil
 }
 
+// Set writes key, replacing a value of any type, and clears any deadline it
+// had.
 func (db *LograDB) Set(key, value string) error {
 	db.Mutex.Lock()
 	defer db.Mutex.Unlock()
-	fileID := db.Storage.ActiveFileID()
-	offset, header, err := db.Storage.Append([]byte(key), []byte(value))
-	if err != nil {
+	ops := append([]writeOp{{key: key, value: value}}, db.dropCollectionOps(key)...)
+	if err := db.write(append(ops, db.clearExpireOps(key)...)); err != nil {
+		return err
+	}
+	db.notify(EventString, "set", key)
+	return nil
+}
+
+// writeOp is one record of a write; del writes a tombstone.
+type writeOp struct {
+	key   string
+	value string
+	del   bool
+}
+
+// write appends ops, as a single record or else as one batch, and applies
+// them to the index. Inside a transaction they are buffered instead. The
+// caller must hold the write lock.
+func (db *LograDB) write(ops []writeOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	created := db.createdKeys(ops)
+	if db.tx != nil {
+		db.tx.buffer(ops)
+	} else if err := db.appendOps(ops); err != nil {
 		return err
 	}
+	for _, op := range ops {
+		db.trackExpire(op)
+		db.trackCollection(op)
+		db.touch(op.key)
+	}
+	for _, key := range created {
+		db.notify(EventNew, "new", key)
+	}
+	return nil
+}
+
+// createdKeys returns the keys ops would create, for "new" events.
+func (db *LograDB) createdKeys(ops []writeOp) []string {
+	if db.notifier == nil {
+		return nil
+	}
+	var created []string
+	for i, op := range ops {
+		// A collection is created by its type record
+		key := op.key
+		if strings.HasPrefix(key, typeKeyPrefix) {
+			key = ownerKey(key)
+		}
+		if op.del || isInternalKey(key) || db.has(key) {
+			continue
+		}
+		repeated := false
+		for _, prev := range ops[:i] {
+			repeated = repeated || ownerKey(prev.key) == key
+		}
+		if !repeated {
+			created = append(created, key)
+		}
+	}
+	return created
+}
+
+// appendOps writes ops to the log and the index.
+func (db *LograDB) appendOps(ops []writeOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	fileID := db.Storage.ActiveFileID()
+	var offsets []int64
+	var headers []storage.Header
+	if len(ops) == 1 {
+		value := []byte(ops[0].value)
+		if ops[0].del {
+			value = []byte{}
+		}
+		offset, header, err := db.Storage.Append([]byte(ops[0].key), value)
+		if err != nil {
+			return err
+		}
+		offsets, headers = []int64{offset}, []storage.Header{header}
+	} else {
+		records := make([]storage.BatchRecord, len(ops))
+		for i, op := range ops {
+			records[i] = storage.BatchRecord{Key: []byte(op.key)}
+			if !op.del {
+				records[i].Value = []byte(op.value)
+			}
+		}
+		var err error
+		offsets, headers, err = db.Storage.AppendBatch(records)
+		if err != nil {
+			return err
+		}
+	}
 
-	db.Index.Add(key, index.Entry{
-		Offset:    offset,
-		CRC:       header.CRC,
-		Timestamp: header.Timestamp,
-		KeySize:   header.KeySize,
-		ValueSize: header.ValueSize,
-		FileID:    fileID,
-	})
+	for i, op := range ops {
+		if op.del {
+			db.Index.Remove(op.key)
+		} else {
+			db.Index.Add(op.key, index.Entry{
+				Offset:    offsets[i],
+				CRC:       headers[i].CRC,
+				Timestamp: headers[i].Timestamp,
+				KeySize:   headers[i].KeySize,
+				ValueSize: headers[i].ValueSize,
+				FileID:    fileID,
+			})
+		}
+	}
+	return nil
+}
+
+// MultiGet reads several keys under one read lock. Missing keys yield nil.
+func (db *LograDB) MultiGet(keys []string) ([]*Record, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
 
+	records := make([]*Record, len(keys))
+	for i, key := range keys {
+		// As in Redis, keys of other types read as missing
+		if c := db.colls[key]; !db.has(key) || (c != nil && c.kind != stringType) {
+			continue
+		}
+		rec, err := db.get(key)
+		if err != nil {
+			return nil, err
+		}
+		records[i] = &rec
+	}
+	return records, nil
+}
+
+// MultiSet writes all pairs as one batch under one lock. The batch is a
+// single append, so after a crash either every pair is visible or none is.
+// Later pairs win when a key repeats.
+func (db *LograDB) MultiSet(pairs []KeyValue) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	return db.multiSet(pairs)
+}
+
+// MultiSetNX behaves like MultiSet but writes nothing, and returns false, if
+// any of the keys already exists.
+func (db *LograDB) MultiSetNX(pairs []KeyValue) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	for _, pair := range pairs {
+		if db.has(pair.Key) {
+			return false, nil
+		}
+	}
+	if err := db.multiSet(pairs); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (db *LograDB) multiSet(pairs []KeyValue) error {
+	ops := make([]writeOp, 0, len(pairs))
+	cleared := make(map[string]bool)
+	for _, pair := range pairs {
+		ops = append(ops, writeOp{key: pair.Key, value: pair.Value})
+		if !cleared[pair.Key] {
+			ops = append(ops, db.dropCollectionOps(pair.Key)...)
+			ops = append(ops, db.clearExpireOps(pair.Key)...)
+			cleared[pair.Key] = true
+		}
+	}
+	if err := db.write(ops); err != nil {
+		return err
+	}
+	for _, pair := range pairs {
+		db.notify(EventString, "set", pair.Key)
+	}
 	return nil
 }
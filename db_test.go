@@ -1,9 +1,16 @@
 package logra
 
 import (
+	"errors"
+	"math"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestOpen(t *testing.T) {
@@ -303,6 +310,2016 @@ func TestLograDB_Set(t *testing.T) {
 	})
 }
 
+func TestLograDB_MultiSetAndMultiGet(t *testing.T) {
+	t.Parallel()
+
+	t.Run("sets and reads several keys", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		err := db.MultiSet([]KeyValue{{"a", "1"}, {"b", "2"}, {"a", "3"}})
+		assertNoError(t, err, "MultiSet")
+
+		records, err := db.MultiGet([]string{"a", "missing", "b"})
+		assertNoError(t, err, "MultiGet")
+		assertEqual(t, len(records), 3, "MultiGet length")
+		assertEqual(t, records[0].Value, "3", "later pair wins")
+		assertTrue(t, records[1] == nil, "missing key is nil")
+		assertEqual(t, records[2].Value, "2", "value of b")
+	})
+
+	t.Run("MultiSetNX writes nothing if any key exists", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("b", "old")
+		set, err := db.MultiSetNX([]KeyValue{{"a", "1"}, {"b", "2"}})
+		assertNoError(t, err, "MultiSetNX")
+		assertFalse(t, set, "MultiSetNX with existing key")
+		assertFalse(t, db.Has("a"), "a must not be written")
+
+		set, err = db.MultiSetNX([]KeyValue{{"c", "1"}, {"d", "2"}})
+		assertNoError(t, err, "MultiSetNX")
+		assertTrue(t, set, "MultiSetNX with new keys")
+		assertTrue(t, db.Has("c") && db.Has("d"), "c and d written")
+	})
+
+	t.Run("batch survives reopen", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		db.Set("before", "x")
+		db.MultiSet([]KeyValue{{"k1", "v1"}, {"k2", "v2"}})
+		db.Set("after", "y")
+		db.Close()
+
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		for key, want := range map[string]string{"before": "x", "k1": "v1", "k2": "v2", "after": "y"} {
+			rec, err := db.Get(key)
+			assertNoError(t, err, "Get "+key)
+			assertEqual(t, rec.Value, want, "value of "+key)
+		}
+	})
+
+	t.Run("torn batch is not partially visible", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		db.Set("before", "x")
+		db.MultiSet([]KeyValue{{"k1", "v1"}, {"k2", "v2"}, {"k3", "v3"}})
+		activePath := db.Storage.ActiveFile.Name()
+		db.Close()
+
+		// Chop the last record of the batch as if the write was cut short.
+		info, _ := os.Stat(activePath)
+		os.Truncate(activePath, info.Size()-3)
+
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		assertTrue(t, db.Has("before"), "record before the batch survives")
+		for _, key := range []string{"k1", "k2", "k3"} {
+			assertFalse(t, db.Has(key), "torn batch member "+key)
+		}
+
+		// The torn tail is dropped, so writes after recovery stay readable.
+		assertNoError(t, db.Set("later", "z"), "Set after recovery")
+		db.Close()
+		db, err = Open(path, "1.0.0")
+		assertNoError(t, err, "second reopen")
+		rec, err := db.Get("later")
+		assertNoError(t, err, "Get later")
+		assertEqual(t, rec.Value, "z", "value written after recovery")
+	})
+
+	t.Run("oversized batch is refused", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		big := strings.Repeat("x", 33<<20)
+		err := db.MultiSet([]KeyValue{{"a", big}, {"b", big}})
+		assertTrue(t, err != nil && strings.Contains(err.Error(), "batch too large"), "MultiSet over the batch limit fails")
+		assertFalse(t, db.Has("a"), "nothing written")
+		assertNoError(t, db.MultiSet([]KeyValue{{"c", "1"}, {"d", "2"}}), "MultiSet after refusal")
+		db.Close()
+
+		db, err = Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		assertFalse(t, db.Has("a") || db.Has("b"), "refused batch stays missing")
+		assertTrue(t, db.Has("c") && db.Has("d"), "later batch survives")
+	})
+}
+
+func TestLograDB_SetWithOptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("NX and XX", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		_, written, err := db.SetWithOptions("k", "v1", SetOptions{XX: true})
+		assertNoError(t, err, "SetWithOptions XX")
+		assertFalse(t, written, "XX on a missing key")
+		assertFalse(t, db.Has("k"), "k must not be written")
+
+		_, written, err = db.SetWithOptions("k", "v1", SetOptions{NX: true})
+		assertNoError(t, err, "SetWithOptions NX")
+		assertTrue(t, written, "NX on a missing key")
+
+		old, written, err := db.SetWithOptions("k", "v2", SetOptions{NX: true, Get: true})
+		assertNoError(t, err, "SetWithOptions NX GET")
+		assertFalse(t, written, "NX on an existing key")
+		assertEqual(t, old.Value, "v1", "previous value")
+
+		old, written, err = db.SetWithOptions("k", "v3", SetOptions{XX: true, Get: true})
+		assertNoError(t, err, "SetWithOptions XX GET")
+		assertTrue(t, written, "XX on an existing key")
+		assertEqual(t, old.Value, "v1", "previous value")
+		rec, _ := db.Get("k")
+		assertEqual(t, rec.Value, "v3", "value after XX")
+	})
+
+	t.Run("expired key reads as missing", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		_, _, err := db.SetWithOptions("k", "v", SetOptions{ExpireAt: time.Now().Add(30 * time.Millisecond).UnixMilli()})
+		assertNoError(t, err, "SetWithOptions with expiry")
+		assertTrue(t, db.Has("k"), "k before its deadline")
+		assertEqual(t, db.Len(), 1, "deadline records are not counted")
+
+		time.Sleep(50 * time.Millisecond)
+		assertFalse(t, db.Has("k"), "k after its deadline")
+		_, err = db.Get("k")
+		assertError(t, err, "Get after the deadline")
+
+		_, written, _ := db.SetWithOptions("k", "again", SetOptions{NX: true})
+		assertTrue(t, written, "NX on an expired key")
+		rec, err := db.Get("k")
+		assertNoError(t, err, "Get after NX")
+		assertEqual(t, rec.Value, "again", "value after NX")
+	})
+
+	t.Run("Set clears the deadline unless KeepTTL", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		soon := time.Now().Add(30 * time.Millisecond).UnixMilli()
+		db.SetWithOptions("plain", "v", SetOptions{ExpireAt: soon})
+		db.SetWithOptions("kept", "v", SetOptions{ExpireAt: soon})
+		assertNoError(t, db.Set("plain", "v2"), "Set")
+		_, _, err := db.SetWithOptions("kept", "v2", SetOptions{KeepTTL: true})
+		assertNoError(t, err, "SetWithOptions KEEPTTL")
+
+		time.Sleep(50 * time.Millisecond)
+		assertTrue(t, db.Has("plain"), "Set removed the deadline")
+		assertFalse(t, db.Has("kept"), "KEEPTTL kept the deadline")
+	})
+
+	t.Run("deadline survives reopen", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		db.SetWithOptions("gone", "v", SetOptions{ExpireAt: time.Now().Add(-time.Second).UnixMilli()})
+		db.SetWithOptions("later", "v", SetOptions{ExpireAt: time.Now().Add(time.Hour).UnixMilli()})
+		db.Close()
+
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		assertFalse(t, db.Has("gone"), "past deadline after reopen")
+		assertTrue(t, db.Has("later"), "future deadline after reopen")
+	})
+
+	t.Run("GetDel and GetEx", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("a", "1")
+		rec, err := db.GetDel("a")
+		assertNoError(t, err, "GetDel")
+		assertEqual(t, rec.Value, "1", "GetDel value")
+		assertFalse(t, db.Has("a"), "a after GetDel")
+		_, err = db.GetDel("a")
+		assertError(t, err, "GetDel on a missing key")
+
+		db.Set("b", "2")
+		_, err = db.GetEx("b", time.Now().Add(30*time.Millisecond).UnixMilli(), false)
+		assertNoError(t, err, "GetEx with deadline")
+		_, err = db.GetEx("b", 0, true)
+		assertNoError(t, err, "GetEx PERSIST")
+		time.Sleep(50 * time.Millisecond)
+		assertTrue(t, db.Has("b"), "PERSIST removed the deadline")
+	})
+}
+
+func TestLograDB_Counters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("IncrBy", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		n, err := db.IncrBy("n", 5)
+		assertNoError(t, err, "IncrBy on a missing key")
+		assertEqual(t, n, int64(5), "IncrBy result")
+		n, _ = db.IncrBy("n", -7)
+		assertEqual(t, n, int64(-2), "IncrBy negative")
+
+		db.Set("s", "abc")
+		_, err = db.IncrBy("s", 1)
+		assertTrue(t, err == ErrNotInteger, "IncrBy on a non-integer")
+
+		db.Set("max", "9223372036854775807")
+		_, err = db.IncrBy("max", 1)
+		assertTrue(t, err == ErrOverflow, "IncrBy overflow")
+	})
+
+	t.Run("IncrBy is atomic", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					db.IncrBy("n", 1)
+				}
+			}()
+		}
+		wg.Wait()
+		rec, _ := db.Get("n")
+		assertEqual(t, rec.Value, "400", "concurrent increments")
+	})
+
+	t.Run("IncrByFloat", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("f", "10.50")
+		v, err := db.IncrByFloat("f", 0.1)
+		assertNoError(t, err, "IncrByFloat")
+		assertEqual(t, v, "10.6", "IncrByFloat result")
+		v, _ = db.IncrByFloat("g", 5e3)
+		assertEqual(t, v, "5000", "IncrByFloat on a missing key")
+	})
+
+	t.Run("counter keeps its deadline", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.SetWithOptions("n", "1", SetOptions{ExpireAt: time.Now().Add(30 * time.Millisecond).UnixMilli()})
+		db.IncrBy("n", 1)
+		time.Sleep(50 * time.Millisecond)
+		assertFalse(t, db.Has("n"), "IncrBy kept the deadline")
+
+		n, _ := db.IncrBy("n", 1)
+		assertEqual(t, n, int64(1), "expired counter restarts at 0")
+		assertTrue(t, db.Has("n"), "restarted counter has no deadline")
+	})
+}
+
+func TestLograDB_AppendAndSetRange(t *testing.T) {
+	t.Parallel()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	n, err := db.Append("k", "Hello")
+	assertNoError(t, err, "Append on a missing key")
+	assertEqual(t, n, 5, "Append length")
+	n, _ = db.Append("k", " World")
+	assertEqual(t, n, 11, "Append length")
+	assertEqual(t, db.StrLen("k"), 11, "StrLen")
+	assertEqual(t, db.StrLen("missing"), 0, "StrLen of a missing key")
+
+	n, err = db.SetRange("k", 6, "Redis")
+	assertNoError(t, err, "SetRange")
+	assertEqual(t, n, 11, "SetRange length")
+	rec, _ := db.Get("k")
+	assertEqual(t, rec.Value, "Hello Redis", "SetRange value")
+
+	n, _ = db.SetRange("pad", 3, "x")
+	assertEqual(t, n, 4, "SetRange pads")
+	rec, _ = db.Get("pad")
+	assertEqual(t, rec.Value, "\x00\x00\x00x", "zero padding")
+
+	_, err = db.SetRange("big", MaxStringSize, "x")
+	assertTrue(t, err == ErrTooLarge, "SetRange past the size limit")
+}
+
+func TestLograDB_Expire(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Expire, ExpireTime and Persist", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		later := time.Now().Add(time.Hour).UnixMilli()
+		set, err := db.Expire("missing", later, ExpireAlways)
+		assertNoError(t, err, "Expire on a missing key")
+		assertFalse(t, set, "Expire on a missing key")
+		assertEqual(t, db.ExpireTime("missing"), int64(-2), "ExpireTime of a missing key")
+
+		db.Set("k", "v")
+		assertEqual(t, db.ExpireTime("k"), int64(-1), "ExpireTime without a deadline")
+		set, _ = db.Expire("k", later, ExpireAlways)
+		assertTrue(t, set, "Expire")
+		assertEqual(t, db.ExpireTime("k"), later, "ExpireTime")
+
+		removed, _ := db.Persist("k")
+		assertTrue(t, removed, "Persist")
+		removed, _ = db.Persist("k")
+		assertFalse(t, removed, "Persist without a deadline")
+		assertEqual(t, db.ExpireTime("k"), int64(-1), "ExpireTime after Persist")
+
+		set, _ = db.Expire("k", time.Now().Add(-time.Second).UnixMilli(), ExpireAlways)
+		assertTrue(t, set, "Expire in the past")
+		assertFalse(t, db.Has("k"), "past deadline deletes the key")
+	})
+
+	t.Run("conditions", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("k", "v")
+		t1 := time.Now().Add(time.Hour).UnixMilli()
+		t2 := t1 + 1000
+		tests := []struct {
+			name string
+			at   int64
+			cond ExpireCondition
+			want bool
+		}{
+			{"XX without deadline", t1, ExpireXX, false},
+			{"GT without deadline", t1, ExpireGT, false},
+			{"NX without deadline", t1, ExpireNX, true},
+			{"NX with deadline", t2, ExpireNX, false},
+			{"LT with later time", t2, ExpireLT, false},
+			{"GT with later time", t2, ExpireGT, true},
+			{"XX and LT with later time", t2 + 1000, ExpireXX | ExpireLT, false},
+			{"LT with earlier time", t1, ExpireLT, true},
+		}
+		for _, tt := range tests {
+			set, err := db.Expire("k", tt.at, tt.cond)
+			assertNoError(t, err, tt.name)
+			assertEqual(t, set, tt.want, tt.name)
+		}
+		assertEqual(t, db.ExpireTime("k"), t1, "final deadline")
+	})
+
+	t.Run("Len ignores expired keys", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("a", "1")
+		db.SetWithOptions("b", "2", SetOptions{ExpireAt: time.Now().Add(20 * time.Millisecond).UnixMilli()})
+		db.SetWithOptions("c", "3", SetOptions{ExpireAt: time.Now().Add(time.Hour).UnixMilli()})
+		assertEqual(t, db.Len(), 3, "Len before expiry")
+		time.Sleep(40 * time.Millisecond)
+		assertEqual(t, db.Len(), 2, "Len after expiry")
+	})
+
+	t.Run("active expiry deletes from the log", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		soon := time.Now().Add(20 * time.Millisecond).UnixMilli()
+		for i := 0; i < 50; i++ {
+			db.SetWithOptions("tmp"+itoa(i), "v", SetOptions{ExpireAt: soon})
+		}
+		db.Set("keep", "v")
+		time.Sleep(40 * time.Millisecond)
+
+		deleted, err := db.ActiveExpireCycle()
+		assertNoError(t, err, "ActiveExpireCycle")
+		assertEqual(t, deleted, 50, "expired keys deleted")
+		assertEqual(t, db.Index.Len(), 1, "only keep is indexed")
+		db.Close()
+
+		db, err = Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		assertEqual(t, db.Index.Len(), 1, "deletes were persisted")
+	})
+}
+
+func TestLograDB_ScanKeys(t *testing.T) {
+	t.Parallel()
+	db, cleanup := setupTestDB(t)
+	defer cleanup()
+
+	for i := 0; i < 100; i++ {
+		db.Set("key"+itoa(i), "v")
+	}
+	db.SetWithOptions("volatile", "v", SetOptions{ExpireAt: time.Now().Add(time.Hour).UnixMilli()})
+	db.SetWithOptions("expired", "v", SetOptions{ExpireAt: time.Now().Add(-time.Second).UnixMilli()})
+
+	seen := make(map[string]bool)
+	cursor := uint64(0)
+	for {
+		cursor = db.ScanKeys(cursor, 10, func(key string) { seen[key] = true })
+		if cursor == 0 {
+			break
+		}
+	}
+	assertEqual(t, len(seen), 101, "keys seen by ScanKeys")
+	assertTrue(t, seen["volatile"], "key with a deadline is listed")
+	assertFalse(t, seen["expired"], "expired key is hidden")
+
+	n := 0
+	db.RangeKeys(func(key string) bool {
+		assertFalse(t, isInternalKey(key), "internal key listed: "+key)
+		n++
+		return true
+	})
+	assertEqual(t, n, 101, "keys seen by RangeKeys")
+	assertEqual(t, db.Type("key1"), "string", "Type of a string")
+	assertEqual(t, db.Type("expired"), "none", "Type of an expired key")
+
+	// Internal records, such as hash fields, do not use up the count
+	db2, cleanup2 := setupTestDB(t)
+	defer cleanup2()
+	for i := 0; i < 30; i++ {
+		fields := make([]FieldValue, 50)
+		for j := range fields {
+			fields[j] = FieldValue{"f" + itoa(j), "v"}
+		}
+		db2.HSet("hash"+itoa(i), fields)
+	}
+	cursor = 0
+	for {
+		got := 0
+		cursor = db2.ScanKeys(cursor, 10, func(string) { got++ })
+		if cursor == 0 {
+			break
+		}
+		assertTrue(t, got >= 10, "a call reports at least count keys, got "+itoa(got))
+	}
+}
+
+func TestLograDB_Transaction(t *testing.T) {
+	t.Parallel()
+
+	t.Run("writes are visible inside and persisted together", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		db.Set("gone", "x")
+
+		ok, err := db.Transaction(nil, func(tx *LograDB) {
+			tx.Set("a", "1")
+			tx.IncrBy("a", 41)
+			tx.Delete("gone")
+			rec, err := tx.Get("a")
+			assertNoError(t, err, "Get inside transaction")
+			assertEqual(t, rec.Value, "42", "read own write")
+			assertFalse(t, tx.Has("gone"), "deleted inside transaction")
+			assertEqual(t, tx.Len(), 1, "Len inside transaction")
+			assertEqual(t, tx.StrLen("a"), 2, "StrLen inside transaction")
+		})
+		assertNoError(t, err, "Transaction")
+		assertTrue(t, ok, "Transaction ran")
+		activePath := db.Storage.ActiveFile.Name()
+		db.Close()
+
+		// Chop the end of the batch: none of the transaction survives.
+		info, _ := os.Stat(activePath)
+		os.Truncate(activePath, info.Size()-1)
+		db, err = Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		assertFalse(t, db.Has("a"), "torn transaction is not applied")
+		assertTrue(t, db.Has("gone"), "torn transaction delete is not applied")
+	})
+
+	t.Run("watched key changed", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("k", "1")
+		watched := []WatchedKey{db.Watch("k"), db.Watch("other")}
+		defer db.Unwatch(watched)
+		db.Set("unrelated", "x")
+		ok, err := db.Transaction(watched, func(tx *LograDB) {})
+		assertNoError(t, err, "Transaction")
+		assertTrue(t, ok, "unrelated write does not abort")
+
+		watched2 := []WatchedKey{db.Watch("k")}
+		defer db.Unwatch(watched2)
+		db.Set("k", "2")
+		ran := false
+		ok, err = db.Transaction(watched2, func(tx *LograDB) { ran = true })
+		assertNoError(t, err, "Transaction")
+		assertFalse(t, ok || ran, "write to watched key aborts")
+	})
+
+	t.Run("watched key expired", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.SetWithOptions("k", "v", SetOptions{ExpireAt: time.Now().Add(20 * time.Millisecond).UnixMilli()})
+		watched := []WatchedKey{db.Watch("k")}
+		defer db.Unwatch(watched)
+		time.Sleep(40 * time.Millisecond)
+		ok, _ := db.Transaction(watched, func(tx *LograDB) {})
+		assertFalse(t, ok, "expiry of watched key aborts")
+	})
+
+	t.Run("expiry set inside transaction", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		later := time.Now().Add(time.Hour).UnixMilli()
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.SetWithOptions("k", "v", SetOptions{ExpireAt: later})
+		})
+		assertEqual(t, db.ExpireTime("k"), later, "deadline after commit")
+		assertEqual(t, db.Len(), 1, "Len after commit")
+	})
+
+	t.Run("AfterCommit runs only after a commit", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		assertFalse(t, db.AfterCommit(func() {}), "AfterCommit outside a transaction")
+		ran := false
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.Set("k", "v")
+			assertTrue(t, tx.AfterCommit(func() { ran = true }), "AfterCommit inside a transaction")
+			assertFalse(t, ran, "deferred until the commit")
+		})
+		assertTrue(t, ran, "run after the commit")
+
+		ran = false
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.Set("k", "w")
+			tx.AfterCommit(func() { ran = true })
+			// Closing storage makes the commit fail
+			db.Storage.Close()
+		})
+		assertFalse(t, ran, "dropped when the commit fails")
+	})
+}
+
+func TestLograDB_Hash(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fields are set, read and deleted", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+		db.Set("s", "x")
+
+		n, err := db.HSet("h", []FieldValue{{"a", "1"}, {"b", "2"}, {"a", "3"}})
+		assertNoError(t, err, "HSet")
+		assertEqual(t, n, 2, "fields added")
+		n, _ = db.HSet("h", []FieldValue{{"b", "4"}, {"c", "5"}})
+		assertEqual(t, n, 1, "fields added on update")
+		v, ok, _ := db.HGet("h", "a")
+		assertTrue(t, ok, "HGet found")
+		assertEqual(t, v, "3", "later pair wins")
+		assertEqual(t, db.Type("h"), "hash", "Type")
+		assertEqual(t, db.Len(), 2, "Len counts the hash once")
+
+		_, err = db.Get("h")
+		assertTrue(t, err == ErrWrongType, "Get on a hash")
+		_, err = db.HSet("s", []FieldValue{{"a", "1"}})
+		assertTrue(t, err == ErrWrongType, "HSet on a string")
+
+		removed, _ := db.HDel("h", []string{"a", "missing", "a"})
+		assertEqual(t, removed, 1, "fields removed")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		fields, err := db.HGetAll("h")
+		assertNoError(t, err, "HGetAll after reopen")
+		assertEqual(t, len(fields), 2, "fields after reopen")
+		assertEqual(t, fields[0].Field+fields[0].Value+fields[1].Field+fields[1].Value, "b4c5", "fields")
+
+		db.HDel("h", []string{"b", "c"})
+		assertFalse(t, db.Has("h"), "hash deleted with its last field")
+		assertEqual(t, db.Len(), 1, "Len after hash emptied")
+	})
+
+	t.Run("counters", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		n, err := db.HIncrBy("h", "n", 5)
+		assertNoError(t, err, "HIncrBy")
+		assertEqual(t, n, int64(5), "HIncrBy on missing field")
+		f, _ := db.HIncrByFloat("h", "n", 0.5)
+		assertEqual(t, f, "5.5", "HIncrByFloat")
+		_, err = db.HIncrBy("h", "n", 1)
+		assertTrue(t, err == ErrHashNotInteger, "HIncrBy on a float")
+	})
+
+	t.Run("set and delete replace every field", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.HSet("h", []FieldValue{{"a", "1"}, {"b", "2"}})
+		db.Set("h", "plain")
+		assertEqual(t, db.Type("h"), "string", "Type after Set")
+		assertEqual(t, db.Len(), 1, "Len after Set")
+		db.HSet("h2", []FieldValue{{"a", "1"}})
+		db.Delete("h2")
+		db.HSet("h2", []FieldValue{{"b", "1"}})
+		fields, _ := db.HKeys("h2")
+		assertEqual(t, len(fields), 1, "no fields left from the deleted hash")
+	})
+
+	t.Run("expired hash starts empty", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.HSet("h", []FieldValue{{"a", "1"}})
+		db.Expire("h", time.Now().Add(10*time.Millisecond).UnixMilli(), ExpireAlways)
+		time.Sleep(20 * time.Millisecond)
+		_, ok, _ := db.HGet("h", "a")
+		assertFalse(t, ok, "field of expired hash")
+		db.HSet("h", []FieldValue{{"b", "1"}})
+		n, _ := db.HLen("h")
+		assertEqual(t, n, 1, "HLen of recreated hash")
+		assertEqual(t, db.ExpireTime("h"), int64(-1), "deadline dropped")
+	})
+
+	t.Run("scan visits every field", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		for i := 0; i < 100; i++ {
+			db.HSet("h", []FieldValue{{"f" + itoa(i), itoa(i)}})
+		}
+		seen := make(map[string]string)
+		cursor, calls := uint64(0), 0
+		for {
+			var err error
+			cursor, err = db.HScan("h", cursor, 10, func(field, value string) { seen[field] = value })
+			assertNoError(t, err, "HScan")
+			calls++
+			if cursor == 0 {
+				break
+			}
+		}
+		assertEqual(t, len(seen), 100, "fields seen")
+		assertTrue(t, calls > 1, "scan took several calls")
+		assertEqual(t, seen["f42"], "42", "value")
+	})
+
+	t.Run("transaction rollback restores fields", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.HSet("h", []FieldValue{{"a", "1"}})
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.HSet("h", []FieldValue{{"b", "2"}})
+			tx.HDel("h", []string{"a"})
+			v, ok, _ := tx.HGet("h", "b")
+			assertTrue(t, ok && v == "2", "read own field")
+			// Closing storage makes the commit fail
+			db.Storage.Close()
+		})
+		n, _ := db.HLen("h")
+		assertEqual(t, n, 1, "fields after failed commit")
+		_, ok, _ := db.HGet("h", "a")
+		assertTrue(t, ok, "field restored")
+	})
+
+	t.Run("empty values", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		_, err := db.HSet("h", []FieldValue{{"a", ""}, {"b", "1"}})
+		assertNoError(t, err, "HSet")
+		db.HSetNX("h", "c", "")
+		v, ok, err := db.HGet("h", "a")
+		assertNoError(t, err, "HGet")
+		assertTrue(t, ok && v == "", "empty value")
+		_, err = db.HIncrBy("h", "a", 1)
+		assertTrue(t, err == ErrHashNotInteger, "HIncrBy on an empty value")
+		_, err = db.HIncrByFloat("h", "a", 1)
+		assertTrue(t, err == ErrHashNotFloat, "HIncrByFloat on an empty value")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		fields, err := db.HGetAll("h")
+		assertNoError(t, err, "HGetAll after reopen")
+		assertEqual(t, len(fields), 3, "fields after reopen")
+		for _, fv := range fields {
+			if fv.Field != "b" {
+				assertEqual(t, fv.Value, "", "empty value after reopen")
+			}
+		}
+	})
+}
+
+func TestLograDB_List(t *testing.T) {
+	t.Parallel()
+
+	join := func(values []string) string {
+		s := ""
+		for _, v := range values {
+			s += v
+		}
+		return s
+	}
+
+	t.Run("queue survives a restart", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		n, err := db.RPush("q", []string{"a", "b", "c"})
+		assertNoError(t, err, "RPush")
+		assertEqual(t, n, 3, "length after RPush")
+		n, _ = db.LPush("q", []string{"y", "z"})
+		assertEqual(t, n, 5, "length after LPush")
+		values, _ := db.LRange("q", 0, -1)
+		assertEqual(t, join(values), "zyabc", "LRange")
+		assertEqual(t, db.Type("q"), "list", "Type")
+
+		popped, _ := db.LPop("q", 1)
+		assertEqual(t, join(popped), "z", "LPop")
+		popped, _ = db.RPop("q", 2)
+		assertEqual(t, join(popped), "cb", "RPop")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		values, _ = db.LRange("q", 0, -1)
+		assertEqual(t, join(values), "ya", "LRange after reopen")
+		db.RPush("q", []string{"d"})
+		v, ok, _ := db.LIndex("q", -1)
+		assertTrue(t, ok, "LIndex found")
+		assertEqual(t, v, "d", "LIndex")
+
+		popped, _ = db.LPop("q", 10)
+		assertEqual(t, join(popped), "yad", "LPop more than the length")
+		assertFalse(t, db.Has("q"), "list deleted with its last element")
+		popped, _ = db.LPop("q", 1)
+		assertTrue(t, popped == nil, "LPop on missing list")
+	})
+
+	t.Run("trim", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.RPush("l", []string{"a", "b", "c", "d", "e"})
+		assertNoError(t, db.LTrim("l", 1, -2), "LTrim")
+		values, _ := db.LRange("l", 0, -1)
+		assertEqual(t, join(values), "bcd", "after LTrim")
+		db.RPush("l", []string{"f"})
+		db.LPush("l", []string{"a"})
+		values, _ = db.LRange("l", 0, -1)
+		assertEqual(t, join(values), "abcdf", "pushes after LTrim")
+		db.LTrim("l", 5, 10)
+		assertFalse(t, db.Has("l"), "empty trim deletes the list")
+	})
+
+	t.Run("move", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.RPush("src", []string{"a", "b", "c"})
+		v, ok, err := db.LMove("src", "dst", true, false)
+		assertNoError(t, err, "LMove")
+		assertTrue(t, ok && v == "a", "moved the head")
+		db.LMove("src", "src", false, true)
+		values, _ := db.LRange("src", 0, -1)
+		assertEqual(t, join(values), "cb", "rotated list")
+		db.LMove("src", "dst", true, true)
+		db.LMove("src", "dst", true, true)
+		values, _ = db.LRange("dst", 0, -1)
+		assertEqual(t, join(values), "bca", "destination")
+		assertFalse(t, db.Has("src"), "source emptied")
+		_, ok, _ = db.LMove("src", "dst", true, true)
+		assertFalse(t, ok, "LMove from missing list")
+
+		db.Set("s", "x")
+		_, _, err = db.LMove("dst", "s", true, true)
+		assertTrue(t, err == ErrWrongType, "LMove onto a string")
+		n, _ := db.LLen("dst")
+		assertEqual(t, n, 3, "source untouched after failed move")
+	})
+
+	t.Run("empty elements", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		_, err := db.RPush("l", []string{"a", "", "b"})
+		assertNoError(t, err, "RPush")
+		values, err := db.LRange("l", 0, -1)
+		assertNoError(t, err, "LRange")
+		assertEqual(t, strings.Join(values, ","), "a,,b", "LRange")
+		db.LMove("l", "l", true, false)
+		db.LMove("l", "l", true, false)
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		n, _ := db.LLen("l")
+		assertEqual(t, n, 3, "length after reopen")
+		v, ok, err := db.LIndex("l", -1)
+		assertNoError(t, err, "LIndex")
+		assertTrue(t, ok && v == "", "empty element after reopen")
+		popped, _ := db.LPop("l", 3)
+		assertEqual(t, strings.Join(popped, ","), "b,a,", "LPop after reopen")
+	})
+}
+
+func TestLograDB_SetType(t *testing.T) {
+	t.Parallel()
+
+	t.Run("members survive a restart", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		n, err := db.SAdd("s", []string{"a", "b", "a", "c"})
+		assertNoError(t, err, "SAdd")
+		assertEqual(t, n, 3, "members added")
+		n, _ = db.SAdd("s", []string{"c", "d"})
+		assertEqual(t, n, 1, "members added again")
+		n, _ = db.SRem("s", []string{"a", "x"})
+		assertEqual(t, n, 1, "members removed")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		members, _ := db.SMembers("s")
+		assertEqual(t, len(members), 3, "members after reopen")
+		assertEqual(t, members[0]+members[1]+members[2], "bcd", "members")
+		ok, _ := db.SIsMember("s", "b")
+		assertTrue(t, ok, "SIsMember")
+		assertEqual(t, db.Type("s"), "set", "Type")
+
+		db.SRem("s", []string{"b", "c", "d"})
+		assertFalse(t, db.Has("s"), "set deleted with its last member")
+	})
+
+	t.Run("algebra", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.SAdd("a", []string{"1", "2", "3"})
+		db.SAdd("b", []string{"2", "3", "4"})
+		concat := func(op SetOp, keys ...string) string {
+			members, err := db.SetAlgebra(op, keys)
+			assertNoError(t, err, "SetAlgebra")
+			s := ""
+			for _, m := range members {
+				s += m
+			}
+			return s
+		}
+		assertEqual(t, concat(SetInter, "a", "b"), "23", "inter")
+		assertEqual(t, concat(SetUnion, "a", "b"), "1234", "union")
+		assertEqual(t, concat(SetDiff, "a", "b"), "1", "diff")
+		assertEqual(t, concat(SetInter, "a", "missing"), "", "inter with a missing key")
+
+		db.Set("str", "x")
+		_, err := db.SetAlgebra(SetUnion, []string{"a", "str"})
+		assertTrue(t, err == ErrWrongType, "algebra over a string")
+
+		n, _ := db.SetAlgebraStore(SetUnion, "str", []string{"a", "b"})
+		assertEqual(t, n, 4, "stored members")
+		assertEqual(t, db.Type("str"), "set", "store replaces a string")
+		n, _ = db.SetAlgebraStore(SetInter, "a", []string{"a", "b"})
+		assertEqual(t, n, 2, "store into a source")
+		assertEqual(t, concat(SetUnion, "a"), "23", "source replaced")
+		db.SetAlgebraStore(SetDiff, "a", []string{"a", "b"})
+		assertFalse(t, db.Has("a"), "empty result deletes the destination")
+	})
+
+	t.Run("random members", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.SAdd("s", []string{"a", "b", "c"})
+		members, _ := db.SRandMember("s", 5)
+		assertEqual(t, len(members), 3, "distinct members")
+		members, _ = db.SRandMember("s", -5)
+		assertEqual(t, len(members), 5, "repeated members")
+		members, _ = db.SRandMember("s", math.MinInt)
+		assertEqual(t, len(members), maxRandomPicks, "repeated members are capped")
+
+		popped, _ := db.SPop("s", 2)
+		assertEqual(t, len(popped), 2, "popped")
+		n, _ := db.SCard("s")
+		assertEqual(t, n, 1, "left after SPop")
+		db.SPop("s", 1)
+		assertFalse(t, db.Has("s"), "set deleted with its last member")
+	})
+}
+
+func TestLograDB_ZSet(t *testing.T) {
+	t.Parallel()
+
+	members := func(ms []ScoredMember) string {
+		s := ""
+		for _, m := range ms {
+			s += m.Member
+		}
+		return s
+	}
+
+	t.Run("order survives a restart", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		n, err := db.ZAdd("z", []ScoredMember{{"c", 3}, {"a", 1}, {"b", 2}, {"x", 2}}, ZAddOptions{})
+		assertNoError(t, err, "ZAdd")
+		assertEqual(t, n, 4, "members added")
+		n, _ = db.ZAdd("z", []ScoredMember{{"x", 1.5}}, ZAddOptions{})
+		assertEqual(t, n, 0, "score update adds nothing")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		all, _ := db.ZRange("z", ZRangeSpec{Start: 0, Stop: -1})
+		assertEqual(t, members(all), "axbc", "order after reopen")
+		assertEqual(t, all[1].Score, 1.5, "updated score")
+		rank, _, ok, _ := db.ZRank("z", "b", true)
+		assertTrue(t, ok, "ZRank found")
+		assertEqual(t, rank, 1, "reverse rank")
+		assertEqual(t, db.Type("z"), "zset", "Type")
+		assertEqual(t, db.Len(), 1, "Len counts the sorted set once")
+
+		n, _ = db.ZRem("z", []string{"a", "b", "c", "x", "missing"})
+		assertEqual(t, n, 4, "members removed")
+		assertFalse(t, db.Has("z"), "sorted set deleted with its last member")
+	})
+
+	t.Run("add options", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.ZAdd("z", []ScoredMember{{"a", 5}}, ZAddOptions{})
+		n, _ := db.ZAdd("z", []ScoredMember{{"a", 1}, {"b", 1}}, ZAddOptions{XX: true, CH: true})
+		assertEqual(t, n, 1, "XX updates only")
+		n, _ = db.ZAdd("z", []ScoredMember{{"a", 9}, {"b", 9}}, ZAddOptions{NX: true})
+		assertEqual(t, n, 1, "NX adds only")
+		score, _, _ := db.ZScore("z", "a")
+		assertEqual(t, score, 1.0, "score kept by NX")
+		n, _ = db.ZAdd("z", []ScoredMember{{"a", 0}, {"c", 0}}, ZAddOptions{GT: true, CH: true})
+		assertEqual(t, n, 1, "GT keeps the higher score but adds")
+		score, _, _ = db.ZScore("z", "a")
+		assertEqual(t, score, 1.0, "score kept by GT")
+
+		score, ok, _ := db.ZIncrBy("z", "a", 2.5, ZAddOptions{})
+		assertTrue(t, ok && score == 3.5, "ZIncrBy")
+		_, ok, _ = db.ZIncrBy("z", "a", -1, ZAddOptions{GT: true})
+		assertFalse(t, ok, "GT refuses a lower score")
+		db.ZAdd("z", []ScoredMember{{"inf", math.Inf(1)}}, ZAddOptions{})
+		_, _, err := db.ZIncrBy("z", "inf", math.Inf(-1), ZAddOptions{})
+		assertTrue(t, err == ErrScoreNaN, "NaN score")
+	})
+
+	t.Run("ranges", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		var ms []ScoredMember
+		for i, m := range []string{"a", "b", "c", "d", "e"} {
+			ms = append(ms, ScoredMember{m, float64(i)})
+		}
+		db.ZAdd("z", ms, ZAddOptions{})
+		zrange := func(spec ZRangeSpec) string {
+			ms, err := db.ZRange("z", spec)
+			assertNoError(t, err, "ZRange")
+			return members(ms)
+		}
+		assertEqual(t, zrange(ZRangeSpec{Start: 1, Stop: -2}), "bcd", "by rank")
+		assertEqual(t, zrange(ZRangeSpec{Start: 0, Stop: 1, Rev: true}), "ed", "by rank reversed")
+		assertEqual(t, zrange(ZRangeSpec{By: ZRangeByScore, Min: ScoreBound{Score: 1, Exclusive: true}, Max: ScoreBound{Score: 3}}), "cd", "by score")
+		assertEqual(t, zrange(ZRangeSpec{By: ZRangeByScore, Min: ScoreBound{Score: math.Inf(-1)}, Max: ScoreBound{Score: math.Inf(1)}, Rev: true, Limit: true, Offset: 1, Count: 2}), "dc", "by score reversed with a limit")
+		assertEqual(t, zrange(ZRangeSpec{By: ZRangeByScore, Min: ScoreBound{Score: 9}, Max: ScoreBound{Score: 10}}), "", "empty score range")
+
+		db.ZAdd("lex", []ScoredMember{{"apple", 0}, {"banana", 0}, {"cherry", 0}}, ZAddOptions{})
+		lex, _ := db.ZRange("lex", ZRangeSpec{By: ZRangeByLex, LexMin: LexBound{Member: "b"}, LexMax: LexBound{Inf: 1}})
+		assertEqual(t, members(lex), "bananacherry", "by lex")
+		lex, _ = db.ZRange("lex", ZRangeSpec{By: ZRangeByLex, LexMin: LexBound{Inf: -1}, LexMax: LexBound{Member: "banana", Exclusive: true}, Rev: true})
+		assertEqual(t, members(lex), "apple", "by lex reversed")
+
+		n, _ := db.ZRangeStore("dst", "z", ZRangeSpec{Start: 0, Stop: 2, Rev: true})
+		assertEqual(t, n, 3, "stored members")
+		stored, _ := db.ZRange("dst", ZRangeSpec{Start: 0, Stop: -1})
+		assertEqual(t, members(stored), "cde", "stored range keeps scores")
+
+		popped, _ := db.ZPopMin("z", 2)
+		assertEqual(t, members(popped), "ab", "ZPopMin")
+		popped, _ = db.ZPopMax("z", 5)
+		assertEqual(t, members(popped), "edc", "ZPopMax")
+		assertFalse(t, db.Has("z"), "popped empty")
+	})
+
+	t.Run("transaction rollback restores scores", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.ZAdd("z", []ScoredMember{{"a", 1}, {"b", 2}}, ZAddOptions{})
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.ZAdd("z", []ScoredMember{{"a", 3}}, ZAddOptions{})
+			tx.ZRem("z", []string{"b"})
+			// Closing storage makes the commit fail
+			db.Storage.Close()
+		})
+		all, _ := db.ZRange("z", ZRangeSpec{Start: 0, Stop: -1})
+		assertEqual(t, members(all), "ab", "order after failed commit")
+		assertEqual(t, all[0].Score, 1.0, "score restored")
+	})
+}
+
+func TestLograDB_Stream(t *testing.T) {
+	t.Parallel()
+
+	ids := func(entries []StreamEntry) string {
+		s := ""
+		for _, e := range entries {
+			s += e.ID.String() + " "
+		}
+		return s
+	}
+
+	t.Run("entries and top ID survive a restart", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		for _, id := range []string{"1-1", "1-*", "2-5"} {
+			_, _, err := db.XAdd("s", id, []FieldValue{{"f", id}}, XAddOptions{})
+			assertNoError(t, err, "XAdd "+id)
+		}
+		_, _, err := db.XAdd("s", "2-5", []FieldValue{{"f", "v"}}, XAddOptions{})
+		assertTrue(t, err == ErrStreamIDTooSmall, "XAdd with an old ID")
+		_, _, err = db.XAdd("new", "0-0", []FieldValue{{"f", "v"}}, XAddOptions{})
+		assertTrue(t, err == ErrStreamIDZero, "XAdd 0-0")
+		_, ok, _ := db.XAdd("missing", "*", []FieldValue{{"f", "v"}}, XAddOptions{NoMkStream: true})
+		assertFalse(t, ok, "NOMKSTREAM")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		all, _ := db.XRange("s", StreamID{}, MaxStreamID, 0, false)
+		assertEqual(t, ids(all), "1-1 1-2 2-5 ", "entries after reopen")
+		assertEqual(t, all[1].Fields[0].Value, "1-*", "fields")
+		rev, _ := db.XRange("s", StreamID{}, MaxStreamID, 2, true)
+		assertEqual(t, ids(rev), "2-5 1-2 ", "reverse range")
+		after, _ := db.XRead("s", StreamID{1, 1}, 0)
+		assertEqual(t, ids(after), "1-2 2-5 ", "XRead")
+		assertEqual(t, db.Type("s"), "stream", "Type")
+
+		n, _ := db.XTrim("s", StreamTrim{Strategy: TrimMaxLen, MaxLen: 0})
+		assertEqual(t, n, 3, "entries trimmed")
+		assertTrue(t, db.Has("s"), "an empty stream stays")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		last, _ := db.XLastID("s")
+		assertEqual(t, last.String(), "2-5", "top ID kept after trimming everything")
+		_, _, err = db.XAdd("s", "2-5", []FieldValue{{"f", "v"}}, XAddOptions{})
+		assertTrue(t, err == ErrStreamIDTooSmall, "IDs keep growing after a trim")
+	})
+
+	t.Run("XADD trims in the same batch", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		trim := XAddOptions{Trim: StreamTrim{Strategy: TrimMaxLen, MaxLen: 2}}
+		db.XAdd("s", "1-1", []FieldValue{{"f", "v"}}, XAddOptions{})
+		db.XAdd("s", "1-2", []FieldValue{{"f", "v"}}, trim)
+		db.XAdd("s", "1-3", []FieldValue{{"f", "v"}}, trim)
+		all, _ := db.XRange("s", StreamID{}, MaxStreamID, 0, false)
+		assertEqual(t, ids(all), "1-2 1-3 ", "trimmed on add")
+		db.XAdd("z", "5-1", []FieldValue{{"f", "v"}}, XAddOptions{Trim: StreamTrim{Strategy: TrimMaxLen}})
+		n, _ := db.XLen("z")
+		assertEqual(t, n, 0, "new entry trimmed away")
+		last, _ := db.XLastID("z")
+		assertEqual(t, last.String(), "5-1", "top ID of a stream trimmed on creation")
+		db.XAdd("s", "1-4", []FieldValue{{"f", "v"}}, trim)
+		activePath := db.Storage.ActiveFile.Name()
+		db.Close()
+
+		// Chop the last batch: neither the entry nor the trim is applied
+		info, _ := os.Stat(activePath)
+		os.Truncate(activePath, info.Size()-1)
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		all, _ = db.XRange("s", StreamID{}, MaxStreamID, 0, false)
+		assertEqual(t, ids(all), "1-2 1-3 ", "torn add with trim")
+	})
+
+	t.Run("consumer groups", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		err := db.XGroupCreate("s", "g", StreamID{}, XGroupCreateOptions{})
+		assertTrue(t, err == ErrNoStream, "XGroupCreate on a missing stream")
+		assertNoError(t, db.XGroupCreate("s", "g", StreamID{}, XGroupCreateOptions{MkStream: true}), "XGroupCreate")
+		err = db.XGroupCreate("s", "g", StreamID{}, XGroupCreateOptions{})
+		assertTrue(t, err == ErrGroupExists, "XGroupCreate twice")
+		for i := 1; i <= 3; i++ {
+			db.XAdd("s", itoa(i)+"-0", []FieldValue{{"n", itoa(i)}}, XAddOptions{})
+		}
+
+		got, err := db.XReadGroup("s", GroupRead{Group: "g", Consumer: "alice", Count: 2})
+		assertNoError(t, err, "XReadGroup")
+		assertEqual(t, ids(got), "1-0 2-0 ", "delivered to alice")
+		got, _ = db.XReadGroup("s", GroupRead{Group: "g", Consumer: "bob"})
+		assertEqual(t, ids(got), "3-0 ", "delivered to bob")
+		_, err = db.XReadGroup("s", GroupRead{Group: "missing", Consumer: "bob"})
+		assertTrue(t, err == ErrNoGroup, "missing group")
+		n, _ := db.XAck("s", "g", []StreamID{{1, 0}, {1, 0}, {9, 0}})
+		assertEqual(t, n, 1, "acknowledged")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		pending, _ := db.XPending("s", "g")
+		assertEqual(t, len(pending), 2, "pending after reopen")
+		assertEqual(t, pending[0].Consumer, "alice", "pending owner")
+		assertEqual(t, pending[1].Deliveries, 1, "deliveries")
+		got, _ = db.XReadGroup("s", GroupRead{Group: "g", Consumer: "alice"})
+		assertEqual(t, len(got), 0, "nothing new after reopen")
+		got, _ = db.XReadGroup("s", GroupRead{Group: "g", Consumer: "alice", History: true})
+		assertEqual(t, ids(got), "2-0 ", "alice's history")
+
+		claimed, _ := db.XClaim("s", "g", "bob", time.Hour, []StreamID{{2, 0}}, XClaimOptions{})
+		assertEqual(t, len(claimed), 0, "entry not idle long enough")
+		claimed, _ = db.XClaim("s", "g", "bob", 0, []StreamID{{2, 0}}, XClaimOptions{})
+		assertEqual(t, ids(claimed), "2-0 ", "claimed")
+		pending, _ = db.XPending("s", "g")
+		assertEqual(t, pending[0].Consumer, "bob", "new owner")
+		assertEqual(t, pending[0].Deliveries, 2, "claim counts as a delivery")
+
+		db.XTrim("s", StreamTrim{Strategy: TrimMinID, MinID: StreamID{3, 0}})
+		claimed, _ = db.XClaim("s", "g", "alice", 0, []StreamID{{2, 0}}, XClaimOptions{})
+		assertEqual(t, len(claimed), 0, "trimmed entry is not claimed")
+		pending, _ = db.XPending("s", "g")
+		assertEqual(t, len(pending), 1, "trimmed entry dropped from pending")
+
+		ok, _ := db.XGroupDestroy("s", "g")
+		assertTrue(t, ok, "XGroupDestroy")
+		_, err = db.XPending("s", "g")
+		assertTrue(t, err == ErrNoGroup, "group destroyed")
+	})
+
+	t.Run("transaction rollback restores group state", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.XAdd("s", "1-0", []FieldValue{{"f", "v"}}, XAddOptions{})
+		db.XGroupCreate("s", "g", StreamID{}, XGroupCreateOptions{})
+		db.XReadGroup("s", GroupRead{Group: "g", Consumer: "c"})
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.XAdd("s", "2-0", []FieldValue{{"f", "v"}}, XAddOptions{})
+			tx.XGroupDestroy("s", "g")
+			// Closing storage makes the commit fail
+			db.Storage.Close()
+		})
+		last, _ := db.XLastID("s")
+		assertEqual(t, last.String(), "1-0", "top ID after failed commit")
+		pending, err := db.XPending("s", "g")
+		assertNoError(t, err, "group restored")
+		assertEqual(t, len(pending), 1, "pending entries restored")
+	})
+}
+
+// recordingNotifier collects events as "class event key".
+type recordingNotifier struct {
+	events []string
+}
+
+func (n *recordingNotifier) Notify(class byte, event, key string) {
+	n.events = append(n.events, string(class)+" "+event+" "+key)
+}
+
+func TestLograDB_Bitmap(t *testing.T) {
+	t.Parallel()
+
+	t.Run("SetBit writes one chunk and survives a restart", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		old, err := db.SetBit("b", 7, 1)
+		assertNoError(t, err, "SetBit")
+		assertEqual(t, old, 0, "previous bit")
+		// A bit far out only writes the chunk holding it
+		first, _ := db.Index.Lookup(elemKey("b", chunkElem(0)))
+		db.SetBit("b", 8*3*bitmapChunkSize+1, 1)
+		entry, _ := db.Index.Lookup(elemKey("b", chunkElem(0)))
+		assertEqual(t, entry.Offset, first.Offset, "first chunk not rewritten")
+		assertFalse(t, db.Index.Has(elemKey("b", chunkElem(1))), "chunks of zeros are not written")
+		old, _ = db.SetBit("b", 7, 0)
+		assertEqual(t, old, 1, "previous bit")
+		db.SetBit("b", 6, 1)
+		assertEqual(t, db.StrLen("b"), 3*bitmapChunkSize+1, "StrLen")
+		assertEqual(t, db.Type("b"), "string", "Type")
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		assertEqual(t, db.StrLen("b"), 3*bitmapChunkSize+1, "StrLen after reopen")
+		rec, _ := db.Get("b")
+		assertEqual(t, len(rec.Value), 3*bitmapChunkSize+1, "assembled length")
+		assertEqual(t, rec.Value[0], byte(0x02), "first byte")
+		assertEqual(t, rec.Value[len(rec.Value)-1], byte(0x40), "last byte")
+		bit, _ := db.GetBit("b", 8*3*bitmapChunkSize+1)
+		assertEqual(t, bit, 1, "GetBit")
+		bit, _ = db.GetBit("b", 1<<30)
+		assertEqual(t, bit, 0, "GetBit past the end")
+		assertEqual(t, db.Len(), 1, "chunks are not keys")
+	})
+
+	t.Run("plain strings convert both ways", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("s", "a")
+		at := time.Now().Add(time.Hour).UnixMilli()
+		db.Expire("s", at, ExpireAlways)
+		old, _ := db.SetBit("s", 6, 1)
+		assertEqual(t, old, 0, "previous bit")
+		rec, _ := db.Get("s")
+		assertEqual(t, rec.Value, "c", "value after SetBit")
+		assertEqual(t, db.ExpireTime("s"), at, "SetBit keeps the deadline")
+
+		n, err := db.Append("s", "d")
+		assertNoError(t, err, "Append")
+		assertEqual(t, n, 2, "Append length")
+		rec, _ = db.Get("s")
+		assertEqual(t, rec.Value, "cd", "value after Append")
+		assertEqual(t, db.Len(), 1, "chunks dropped")
+
+		_, err = db.SetBit("h", 0, 1)
+		assertNoError(t, err, "SetBit on a new key")
+		db.HSet("hash", []FieldValue{{"f", "v"}})
+		_, err = db.SetBit("hash", 0, 1)
+		assertTrue(t, err == ErrWrongType, "SetBit on a hash")
+		_, err = db.SetBit("s", MaxStringSize*8, 1)
+		assertTrue(t, err == ErrBitOffset, "offset out of range")
+	})
+
+	t.Run("BitCount and BitPos", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("s", "\xff\xf0\x00")
+		n, _ := db.BitCount("s", AllBits)
+		assertEqual(t, n, int64(12), "whole string")
+		n, _ = db.BitCount("s", BitRange{Start: 1, End: 1})
+		assertEqual(t, n, int64(4), "byte range")
+		n, _ = db.BitCount("s", BitRange{Start: 5, End: 9, Bits: true})
+		assertEqual(t, n, int64(5), "bit range")
+		n, _ = db.BitCount("s", BitRange{Start: -1, End: -2})
+		assertEqual(t, n, int64(0), "empty range")
+
+		pos, _ := db.BitPos("s", 0, AllBits)
+		assertEqual(t, pos, int64(12), "first 0")
+		pos, _ = db.BitPos("s", 1, BitRange{Start: 2, End: -1, OpenEnd: true})
+		assertEqual(t, pos, int64(-1), "no 1 in range")
+		pos, _ = db.BitPos("s", 1, BitRange{Start: 3, End: 10, Bits: true})
+		assertEqual(t, pos, int64(3), "bit range")
+
+		db.Set("ones", "\xff")
+		pos, _ = db.BitPos("ones", 0, AllBits)
+		assertEqual(t, pos, int64(8), "0 past the end")
+		pos, _ = db.BitPos("ones", 0, BitRange{Start: 0, End: 0})
+		assertEqual(t, pos, int64(-1), "no 0 before a given end")
+		pos, _ = db.BitPos("missing", 0, AllBits)
+		assertEqual(t, pos, int64(0), "missing key")
+	})
+
+	t.Run("BitOp", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.Set("a", "\x0f\xff")
+		db.SetBit("b", 0, 1)
+		n, err := db.BitOp(BitOr, "dest", []string{"a", "b", "missing"})
+		assertNoError(t, err, "BitOp OR")
+		assertEqual(t, n, int64(2), "result length")
+		rec, _ := db.Get("dest")
+		assertEqual(t, rec.Value, "\x8f\xff", "OR")
+		db.BitOp(BitAnd, "dest", []string{"a", "b"})
+		rec, _ = db.Get("dest")
+		assertEqual(t, rec.Value, "\x00\x00", "AND pads with zeros")
+		db.BitOp(BitNot, "dest", []string{"a"})
+		rec, _ = db.Get("dest")
+		assertEqual(t, rec.Value, "\xf0\x00", "NOT")
+		n, _ = db.BitOp(BitXor, "dest", []string{"missing"})
+		assertEqual(t, n, int64(0), "empty result")
+		assertFalse(t, db.Has("dest"), "empty result deletes dest")
+	})
+
+	t.Run("BitField", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		results, err := db.BitField("f", []BitFieldOp{
+			{Action: BitFieldSet, Width: 8, Offset: 0, Value: 200},
+			{Action: BitFieldGet, Signed: true, Width: 8, Offset: 0},
+			{Action: BitFieldIncrBy, Width: 8, Offset: 0, Value: 100},
+			{Action: BitFieldIncrBy, Width: 8, Offset: 0, Value: 100, Overflow: OverflowSat},
+			{Action: BitFieldIncrBy, Width: 8, Offset: 0, Value: 200, Overflow: OverflowFail},
+			{Action: BitFieldIncrBy, Signed: true, Width: 4, Offset: 8, Value: 9},
+			{Action: BitFieldSet, Signed: true, Width: 64, Offset: 16, Value: math.MinInt64},
+			{Action: BitFieldIncrBy, Signed: true, Width: 64, Offset: 16, Value: -1, Overflow: OverflowSat},
+		})
+		assertNoError(t, err, "BitField")
+		want := []int64{0, -56, 44, 144, 0, -7, 0, math.MinInt64}
+		for i, w := range want {
+			if i == 4 {
+				assertTrue(t, results[i] == nil, "FAIL overflow yields nil")
+				continue
+			}
+			assertEqual(t, *results[i], w, "result "+itoa(i))
+		}
+		rec, _ := db.Get("f")
+		assertEqual(t, rec.Value[:2], "\x90\x90", "fields written")
+
+		results, _ = db.BitField("missing", []BitFieldOp{{Action: BitFieldGet, Width: 8}})
+		assertEqual(t, *results[0], int64(0), "GET on a missing key")
+		assertFalse(t, db.Has("missing"), "GET creates nothing")
+	})
+}
+
+func TestLograDB_HyperLogLog(t *testing.T) {
+	t.Parallel()
+
+	t.Run("a new HyperLogLog is sparse", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		changed, err := db.PFAdd("h", nil)
+		assertNoError(t, err, "PFAdd")
+		assertTrue(t, changed, "creating the key counts as a change")
+		rec, _ := db.Get("h")
+		assertEqual(t, rec.Value, "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xff", "empty sparse encoding")
+
+		changed, _ = db.PFAdd("h", []string{"a", "b", "c", "d", "e", "f", "g"})
+		assertTrue(t, changed, "registers changed")
+		changed, _ = db.PFAdd("h", []string{"a", "b"})
+		assertFalse(t, changed, "nothing new")
+		n, _ := db.PFCount([]string{"h"})
+		assertEqual(t, n, int64(7), "PFCount")
+		rec, _ = db.Get("h")
+		assertEqual(t, rec.Value[4], byte(1), "still sparse")
+		assertEqual(t, rec.Value[15]&0x80, byte(0x80), "cached count invalidated")
+	})
+
+	t.Run("many elements turn it dense", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		elems := make([]string, 20000)
+		for i := range elems {
+			elems[i] = "elem-" + itoa(i)
+		}
+		db.PFAdd("big", elems[:10000])
+		db.PFAdd("other", elems[5000:])
+		db.Close()
+
+		db, _ = Open(path, "1.0.0")
+		defer db.Close()
+		rec, _ := db.Get("big")
+		assertEqual(t, len(rec.Value), hllDenseSize, "dense length")
+		assertEqual(t, rec.Value[4], byte(0), "dense encoding")
+		n, _ := db.PFCount([]string{"big"})
+		assertTrue(t, n > 9800 && n < 10200, "estimate within 2%, got "+itoa(int(n)))
+		n, _ = db.PFCount([]string{"big", "other", "missing"})
+		assertTrue(t, n > 19600 && n < 20400, "union estimate, got "+itoa(int(n)))
+
+		assertNoError(t, db.PFMerge("merged", []string{"big", "other"}), "PFMerge")
+		merged, _ := db.PFCount([]string{"merged"})
+		assertEqual(t, merged, n, "merged count")
+	})
+
+	t.Run("decoding", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		for _, elems := range [][]string{{"x"}, {"x", "y", "z", "w"}} {
+			h := newHLL()
+			for _, e := range elems {
+				h.add(e)
+			}
+			for _, sparse := range []bool{true, false} {
+				h.sparse = sparse
+				back, err := parseHLL(h.encode())
+				assertNoError(t, err, "parseHLL")
+				assertTrue(t, back.regs == h.regs, "registers survive encoding")
+			}
+		}
+
+		db.Set("s", "not a HyperLogLog")
+		_, err := db.PFAdd("s", []string{"a"})
+		assertTrue(t, err == ErrNotHLL, "PFAdd on another string")
+		db.Set("s", "HYLL\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x7f\xfe")
+		_, err = db.PFCount([]string{"s"})
+		assertTrue(t, err == ErrCorruptHLL, "short sparse encoding")
+		db.HSet("hash", []FieldValue{{"f", "v"}})
+		_, err = db.PFCount([]string{"hash"})
+		assertTrue(t, err == ErrWrongType, "PFCount on a hash")
+	})
+}
+
+func TestLograDB_Geo(t *testing.T) {
+	t.Parallel()
+
+	t.Run("distance and positions", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		n, err := db.GeoAdd("sicily", []GeoMember{
+			{"Palermo", GeoPoint{13.361389, 38.115556}},
+			{"Catania", GeoPoint{15.087269, 37.502669}},
+		}, ZAddOptions{})
+		assertNoError(t, err, "GeoAdd")
+		assertEqual(t, n, 2, "members added")
+		assertEqual(t, db.Type("sicily"), "zset", "Type")
+
+		dist, ok, _ := db.GeoDist("sicily", "Palermo", "Catania")
+		assertTrue(t, ok, "GeoDist")
+		assertEqual(t, strconv.FormatFloat(dist, 'f', 4, 64), "166274.1516", "distance")
+		_, ok, _ = db.GeoDist("sicily", "Palermo", "Rome")
+		assertFalse(t, ok, "GeoDist to a missing member")
+
+		points, _ := db.GeoPos("sicily", []string{"Palermo", "Rome"})
+		assertTrue(t, math.Abs(points[0].Lon-13.361389) < 1e-5 && math.Abs(points[0].Lat-38.115556) < 1e-5, "GeoPos")
+		assertTrue(t, points[1] == nil, "GeoPos of a missing member")
+		score, _, _ := db.ZScore("sicily", "Palermo")
+		assertEqual(t, uint64(score), uint64(3479099956230698), "score is Redis's geohash")
+
+		_, err = db.GeoAdd("sicily", []GeoMember{{"North Pole", GeoPoint{0, 90}}}, ZAddOptions{})
+		assertTrue(t, err != nil, "latitude out of range")
+	})
+
+	t.Run("search finds what a full scan finds", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		rng := rand.New(rand.NewSource(1))
+		var members []GeoMember
+		for i := 0; i < 2000; i++ {
+			p := GeoPoint{rng.Float64()*360 - 180, rng.Float64()*170 - 85}
+			if i%4 == 0 {
+				// Crowd some around the antimeridian and the poles
+				p = GeoPoint{179 + rng.Float64()*2, rng.Float64()*170 - 85}
+				if p.Lon > 180 {
+					p.Lon -= 360
+				}
+			} else if i%4 == 1 {
+				p.Lat = 80 + rng.Float64()*5
+			}
+			members = append(members, GeoMember{"m" + itoa(i), p})
+		}
+		db.GeoAdd("points", members, ZAddOptions{})
+		stored, _ := db.GeoPos("points", func() []string {
+			names := make([]string, len(members))
+			for i, m := range members {
+				names[i] = m.Member
+			}
+			return names
+		}())
+
+		for i := 0; i < 200; i++ {
+			q := GeoQuery{From: members[rng.Intn(len(members))].GeoPoint, Sort: GeoAsc}
+			if i%2 == 0 {
+				q.Radius = math.Pow(10, 3+rng.Float64()*4)
+			} else {
+				q.ByBox = true
+				q.Width, q.Height = math.Pow(10, 3+rng.Float64()*4), math.Pow(10, 3+rng.Float64()*4)
+			}
+			want := 0
+			for _, p := range stored {
+				if _, ok := geoWithin(q, q.From, *p); ok {
+					want++
+				}
+			}
+			results, err := db.GeoSearch("points", q)
+			assertNoError(t, err, "GeoSearch")
+			assertEqual(t, len(results), want, "results of query "+itoa(i))
+			for j := 1; j < len(results); j++ {
+				assertTrue(t, results[j-1].Dist <= results[j].Dist, "nearest first")
+			}
+		}
+	})
+
+	t.Run("count and any", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		db.GeoAdd("g", []GeoMember{
+			{"a", GeoPoint{0, 0}}, {"b", GeoPoint{0.01, 0}}, {"c", GeoPoint{0.02, 0}},
+		}, ZAddOptions{})
+		results, _ := db.GeoSearch("g", GeoQuery{FromMember: "c", Radius: 5000, Count: 2})
+		assertEqual(t, len(results), 2, "Count")
+		assertEqual(t, results[0].Member+results[1].Member, "cb", "Count keeps the nearest")
+		results, _ = db.GeoSearch("g", GeoQuery{FromMember: "c", Radius: 5000, Sort: GeoDesc})
+		assertEqual(t, results[0].Member, "a", "farthest first")
+		results, _ = db.GeoSearch("g", GeoQuery{FromMember: "c", Radius: 5000, Count: 1, Any: true})
+		assertEqual(t, len(results), 1, "Any")
+		_, err := db.GeoSearch("g", GeoQuery{FromMember: "missing", Radius: 1})
+		assertTrue(t, err == ErrGeoMember, "missing center member")
+	})
+}
+
+func TestLograDB_JSON(t *testing.T) {
+	t.Parallel()
+
+	t.Run("set, get and delete by path", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		_, err := db.JSONSet("doc", "$.a", `1`, JSONSetOptions{})
+		assertTrue(t, errors.Is(err, ErrJSONRoot), "a new key needs the root")
+		_, err = db.JSONSet("doc", "$", `{"a":1,`, JSONSetOptions{})
+		assertTrue(t, err != nil, "invalid JSON is rejected")
+		assertFalse(t, db.Has("doc"), "nothing stored for invalid JSON")
+
+		ok, err := db.JSONSet("doc", "$", `{"name":"logra","tags":["kv"],"stats":{"hits":1}}`, JSONSetOptions{})
+		assertNoError(t, err, "JSONSet root")
+		assertTrue(t, ok, "JSONSet root")
+		assertEqual(t, db.Type("doc"), "ReJSON-RL", "Type")
+
+		ok, _ = db.JSONSet("doc", "$.name", `"x"`, JSONSetOptions{NX: true})
+		assertFalse(t, ok, "NX on an existing path")
+		ok, _ = db.JSONSet("doc", "$.new", `null`, JSONSetOptions{XX: true})
+		assertFalse(t, ok, "XX on a missing path")
+		ok, _ = db.JSONSet("doc", "$.stats.misses", `0`, JSONSetOptions{NX: true})
+		assertTrue(t, ok, "NX adds a member")
+		ok, _ = db.JSONSet("doc", "$.missing.deeper", `0`, JSONSetOptions{})
+		assertFalse(t, ok, "no parent to add to")
+
+		doc, ok, err := db.JSONGet("doc", nil)
+		assertNoError(t, err, "JSONGet")
+		assertTrue(t, ok, "JSONGet")
+		assertEqual(t, doc, `{"name":"logra","tags":["kv"],"stats":{"hits":1,"misses":0}}`, "document")
+		doc, _, _ = db.JSONGet("doc", []string{"$..hits"})
+		assertEqual(t, doc, `[1]`, "JSONPath get")
+		doc, _, _ = db.JSONGet("doc", []string{".name"})
+		assertEqual(t, doc, `"logra"`, "legacy get")
+		doc, _, _ = db.JSONGet("doc", []string{"$.name", "$.tags[0]"})
+		assertEqual(t, doc, `{"$.name":["logra"],"$.tags[0]":["kv"]}`, "get of several paths")
+		_, _, err = db.JSONGet("doc", []string{".nope"})
+		assertTrue(t, err != nil, "legacy get of a missing path")
+		_, ok, _ = db.JSONGet("missing", nil)
+		assertFalse(t, ok, "JSONGet of a missing key")
+
+		n, err := db.JSONDel("doc", "$.stats.*")
+		assertNoError(t, err, "JSONDel")
+		assertEqual(t, n, 2, "members deleted")
+		doc, _, _ = db.JSONGet("doc", []string{"$.stats"})
+		assertEqual(t, doc, `[{}]`, "emptied object")
+		n, _ = db.JSONDel("doc", "$")
+		assertEqual(t, n, 1, "root deleted")
+		assertFalse(t, db.Has("doc"), "deleting the root deletes the key")
+
+		assertNoError(t, db.Set("str", "v"), "Set")
+		_, _, err = db.JSONGet("str", nil)
+		assertTrue(t, errors.Is(err, ErrWrongType), "JSONGet of a string")
+	})
+
+	t.Run("numbers, arrays and types", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		_, err := db.JSONNumIncrBy("doc", "$.n", "1")
+		assertTrue(t, errors.Is(err, ErrJSONNoKey), "NUMINCRBY on a missing key")
+		_, err = db.JSONSet("doc", ".", `{"a":{"n":1},"b":{"n":2.5},"c":{"n":"x"},"list":[1]}`, JSONSetOptions{})
+		assertNoError(t, err, "JSONSet")
+
+		res, err := db.JSONNumIncrBy("doc", "$..n", "2")
+		assertNoError(t, err, "JSONNumIncrBy")
+		assertEqual(t, res, `[3,4.5,null]`, "incremented values")
+		res, _ = db.JSONNumIncrBy("doc", ".a.n", "-3")
+		assertEqual(t, res, `0`, "legacy increment")
+		_, err = db.JSONNumIncrBy("doc", ".c.n", "1")
+		assertTrue(t, err != nil, "legacy increment of a string")
+
+		lens, err := db.JSONArrAppend("doc", "$.*", []string{`2`, `{"k":[]}`})
+		assertNoError(t, err, "JSONArrAppend")
+		assertEqual(t, len(lens), 4, "one result per match")
+		assertTrue(t, lens[0] == nil && lens[3] != nil && *lens[3] == 3, "only arrays grow")
+		_, err = db.JSONArrAppend("doc", "$.list", []string{`[`})
+		assertTrue(t, err != nil, "appending invalid JSON")
+
+		types, ok, err := db.JSONType("doc", "$..*")
+		assertNoError(t, err, "JSONType")
+		assertTrue(t, ok, "JSONType")
+		assertEqual(t, strings.Join(types, ","), "object,object,object,array,integer,number,string,integer,integer,object,array", "types")
+		_, ok, _ = db.JSONType("missing", "$")
+		assertFalse(t, ok, "JSONType of a missing key")
+	})
+
+	t.Run("documents survive reopen", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "Open")
+		_, err = db.JSONSet("doc", "$", `{"a":[1,2]}`, JSONSetOptions{})
+		assertNoError(t, err, "JSONSet")
+		_, err = db.JSONArrAppend("doc", "$.a", []string{`3`})
+		assertNoError(t, err, "JSONArrAppend")
+		assertNoError(t, db.Close(), "Close")
+
+		db, err = Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		doc, _, _ := db.JSONGet("doc", nil)
+		assertEqual(t, doc, `{"a":[1,2,3]}`, "document after reopen")
+		assertEqual(t, db.Type("doc"), "ReJSON-RL", "Type after reopen")
+	})
+}
+
+func TestLograDB_Filters(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bloom filter scales and has no false negatives", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		assertNoError(t, db.BFReserve("bf", BloomOptions{ErrorRate: 0.01, Capacity: 100, Expansion: 2}), "BFReserve")
+		assertTrue(t, errors.Is(db.BFReserve("bf", DefaultBloomOptions), ErrFilterExists), "reserving twice")
+		assertTrue(t, db.BFReserve("bad", BloomOptions{ErrorRate: 1, Capacity: 100, Expansion: 2}) != nil, "error rate of 1")
+		assertEqual(t, db.Type("bf"), "MBbloom--", "Type")
+
+		items := make([]string, 1000)
+		for i := range items {
+			items[i] = "item:" + itoa(i)
+		}
+		added, err := db.BFAdd("bf", items)
+		assertNoError(t, err, "BFAdd")
+		assertTrue(t, added[0], "first item added")
+		added, _ = db.BFAdd("bf", items[:1])
+		assertFalse(t, added[0], "item added twice")
+
+		found, err := db.BFExists("bf", items)
+		assertNoError(t, err, "BFExists")
+		for i, ok := range found {
+			if !ok {
+				t.Fatalf("%s not found", items[i])
+			}
+		}
+		others := make([]string, 10000)
+		for i := range others {
+			others[i] = "other:" + itoa(i)
+		}
+		found, _ = db.BFExists("bf", others)
+		positives := 0
+		for _, ok := range found {
+			if ok {
+				positives++
+			}
+		}
+		assertTrue(t, positives < 200, "false positive rate near the error rate")
+
+		info, ok, err := db.BFInfo("bf")
+		assertNoError(t, err, "BFInfo")
+		assertTrue(t, ok, "BFInfo")
+		assertTrue(t, info.Filters == 4 && info.Capacity == 1500, "grew by doubling layers")
+		assertTrue(t, info.Items <= 1000 && info.Items > 990, "items inserted")
+		_, ok, _ = db.BFInfo("missing")
+		assertFalse(t, ok, "BFInfo of a missing key")
+
+		assertNoError(t, db.BFReserve("small", BloomOptions{ErrorRate: 0.01, Capacity: 2, Expansion: 2, NonScaling: true}), "BFReserve")
+		_, err = db.BFAdd("small", []string{"a", "b", "c"})
+		assertTrue(t, errors.Is(err, ErrBloomFull), "non-scaling filter fills up")
+
+		assertNoError(t, db.Set("str", "v"), "Set")
+		_, err = db.BFAdd("str", []string{"a"})
+		assertTrue(t, errors.Is(err, ErrWrongType), "BFAdd on a string")
+	})
+
+	t.Run("cuckoo filter counts and deletes", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		_, err := db.CFDel("cf", "a")
+		assertTrue(t, errors.Is(err, ErrFilterNotFound), "CFDel of a missing key")
+		ok, err := db.CFAdd("cf", "a", false)
+		assertNoError(t, err, "CFAdd")
+		assertTrue(t, ok, "CFAdd")
+		db.CFAdd("cf", "a", false)
+		ok, _ = db.CFAdd("cf", "a", true)
+		assertFalse(t, ok, "CFAdd NX of a present item")
+		n, _ := db.CFCount("cf", "a")
+		assertEqual(t, n, int64(2), "copies of a")
+
+		ok, _ = db.CFDel("cf", "a")
+		assertTrue(t, ok, "CFDel")
+		n, _ = db.CFCount("cf", "a")
+		assertEqual(t, n, int64(1), "copies after a delete")
+		db.CFDel("cf", "a")
+		ok, _ = db.CFDel("cf", "a")
+		assertFalse(t, ok, "CFDel of an absent item")
+		found, _ := db.CFExists("cf", []string{"a"})
+		assertFalse(t, found[0], "deleted item")
+
+		info, _, _ := db.CFInfo("cf")
+		assertEqual(t, info, FilterInfo{Capacity: 1024, Size: 1024, Filters: 1, Deleted: 2, Buckets: 512, BucketSize: 2, Expansion: 1, MaxIterations: 20}, "CFInfo")
+	})
+
+	t.Run("cuckoo filter grows when full", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		assertTrue(t, db.CFReserve("cf", CuckooOptions{Capacity: 100, BucketSize: 0, MaxIterations: 20}) != nil, "bucket size 0")
+		assertNoError(t, db.CFReserve("cf", CuckooOptions{Capacity: 64, BucketSize: 4, MaxIterations: 50, Expansion: 2}), "CFReserve")
+		for i := 0; i < 500; i++ {
+			_, err := db.CFAdd("cf", "item:"+itoa(i), false)
+			assertNoError(t, err, "CFAdd")
+		}
+		found, _ := db.CFExists("cf", []string{"item:0", "item:250", "item:499"})
+		assertTrue(t, found[0] && found[1] && found[2], "items found after growing")
+		info, _, _ := db.CFInfo("cf")
+		assertTrue(t, info.Filters > 1 && info.Items == 500, "more layers")
+
+		assertNoError(t, db.CFReserve("fixed", CuckooOptions{Capacity: 4, BucketSize: 1, MaxIterations: 5}), "CFReserve")
+		var err error
+		for i := 0; err == nil && i < 100; i++ {
+			_, err = db.CFAdd("fixed", "item:"+itoa(i), false)
+		}
+		assertTrue(t, errors.Is(err, ErrCuckooFull), "expansion 0 fills up")
+	})
+
+	t.Run("inserts append one block and survive reopen", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		assertNoError(t, db.BFReserve("bf", BloomOptions{ErrorRate: 0.001, Capacity: 100000, Expansion: 2}), "BFReserve")
+		assertEqual(t, len(db.colls["bf"].elems), 1, "an empty filter has only its metadata")
+		db.BFAdd("bf", []string{"a"})
+		assertEqual(t, len(db.colls["bf"].elems), 2, "one block written")
+		db.BFAdd("bf", []string{"b"})
+		assertTrue(t, len(db.colls["bf"].elems) <= 3, "one more block at most")
+		db.CFAdd("cf", "x", false)
+		db.CFAdd("cf", "y", false)
+		db.CFDel("cf", "y")
+		assertNoError(t, db.Close(), "Close")
+
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		found, _ := db.BFExists("bf", []string{"a", "b", "c"})
+		assertTrue(t, found[0] && found[1] && !found[2], "bloom filter after reopen")
+		info, _, _ := db.BFInfo("bf")
+		assertEqual(t, info.Items, int64(2), "items after reopen")
+		found, _ = db.CFExists("cf", []string{"x", "y"})
+		assertTrue(t, found[0] && !found[1], "cuckoo filter after reopen")
+		cinfo, _, _ := db.CFInfo("cf")
+		assertTrue(t, cinfo.Items == 1 && cinfo.Deleted == 1, "cuckoo counts after reopen")
+	})
+}
+
+func TestLograDB_Vectors(t *testing.T) {
+	t.Parallel()
+
+	t.Run("add, search and delete", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		isNew, err := db.VAdd("v", "a", []float32{1, 0}, VectorOptions{Metric: VectorL2})
+		assertNoError(t, err, "VAdd")
+		assertTrue(t, isNew, "new id")
+		db.VAdd("v", "b", []float32{0, 1}, VectorOptions{})
+		db.VAdd("v", "c", []float32{3, 4}, VectorOptions{})
+		isNew, _ = db.VAdd("v", "c", []float32{2, 2}, VectorOptions{})
+		assertFalse(t, isNew, "replaced id")
+		assertEqual(t, db.Type("v"), "vectorset", "Type")
+
+		_, err = db.VAdd("v", "d", []float32{1, 2, 3}, VectorOptions{})
+		assertTrue(t, err != nil, "dimension mismatch")
+		_, err = db.VAdd("v", "d", []float32{float32(math.NaN()), 0}, VectorOptions{})
+		assertTrue(t, errors.Is(err, ErrVectorValue), "NaN")
+		for _, opts := range []VectorOptions{{Metric: VectorDot}, {HNSW: true}, {M: 8}, {EF: 10}} {
+			_, err = db.VAdd("v", "d", []float32{1, 1}, opts)
+			assertTrue(t, errors.Is(err, ErrVectorOptions), "conflicting options")
+		}
+		_, err = db.VAdd("v", "d", []float32{1, 1}, VectorOptions{Metric: VectorL2, M: 16, EF: 200})
+		assertNoError(t, err, "VAdd with the options of the set")
+		db.VDel("v", "d")
+
+		matches, err := db.VSim("v", []float32{1, 0.1}, 2, VSimOptions{})
+		assertNoError(t, err, "VSim")
+		assertEqual(t, len(matches), 2, "k matches")
+		assertEqual(t, matches[0].ID, "a", "nearest")
+		assertEqual(t, matches[1].ID, "b", "second nearest")
+		assertTrue(t, math.Abs(matches[0].Distance-0.1) < 1e-6, "L2 distance")
+		matches, _ = db.VSim("v", []float32{2, 1.9}, 10, VSimOptions{})
+		assertEqual(t, len(matches), 3, "k larger than the set")
+		assertEqual(t, matches[0].ID, "c", "nearest after replacing")
+
+		ok, err := db.VDel("v", "a")
+		assertNoError(t, err, "VDel")
+		assertTrue(t, ok, "VDel")
+		ok, _ = db.VDel("v", "a")
+		assertFalse(t, ok, "VDel of a missing id")
+		n, _ := db.VCard("v")
+		assertEqual(t, n, 2, "VCard")
+		db.VDel("v", "b")
+		db.VDel("v", "c")
+		assertFalse(t, db.Has("v"), "set deleted with its last vector")
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+
+		for _, tc := range []struct {
+			metric VectorMetric
+			key    string
+			want   string
+			dist   float64
+		}{
+			{VectorCosine, "cos", "small", 0},
+			{VectorL2, "l2", "small", math.Sqrt2},
+			{VectorDot, "dot", "large", -20},
+		} {
+			db.VAdd(tc.key, "small", []float32{1, 1}, VectorOptions{Metric: tc.metric})
+			db.VAdd(tc.key, "large", []float32{10, 0}, VectorOptions{})
+			matches, err := db.VSim(tc.key, []float32{2, 2}, 1, VSimOptions{})
+			assertNoError(t, err, "VSim")
+			assertEqual(t, matches[0].ID, tc.want, tc.key+" nearest")
+			assertTrue(t, math.Abs(matches[0].Distance-tc.dist) < 1e-5, tc.key+" distance")
+		}
+	})
+
+	t.Run("HNSW index is rebuilt on open", func(t *testing.T) {
+		t.Parallel()
+		path := filepath.Join(t.TempDir(), "testdb")
+		db, _ := Open(path, "1.0.0")
+
+		rng := rand.New(rand.NewSource(1))
+		vec := func() []float32 {
+			v := make([]float32, 8)
+			for i := range v {
+				v[i] = rng.Float32()
+			}
+			return v
+		}
+		for i := 0; i < 300; i++ {
+			_, err := db.VAdd("emb", "id:"+itoa(i), vec(), VectorOptions{Metric: VectorCosine, HNSW: true, M: 8, EF: 50})
+			assertNoError(t, err, "VAdd")
+		}
+		assertTrue(t, db.colls["emb"].vectors.graph.Len() == 300, "graph holds every vector")
+		db.VDel("emb", "id:0")
+		queries := make([][]float32, 20)
+		for i := range queries {
+			queries[i] = vec()
+		}
+		assertNoError(t, db.Close(), "Close")
+
+		db, err := Open(path, "1.0.0")
+		assertNoError(t, err, "reopen")
+		defer db.Close()
+		g := db.colls["emb"].vectors.graph
+		assertTrue(t, g != nil && g.Len() == 299, "graph rebuilt on open")
+
+		hits := 0
+		for _, q := range queries {
+			approx, err := db.VSim("emb", q, 5, VSimOptions{EF: 50})
+			assertNoError(t, err, "VSim")
+			exact, _ := db.VSim("emb", q, 5, VSimOptions{Exact: true})
+			found := make(map[string]bool)
+			for _, m := range approx {
+				found[m.ID] = true
+				assertTrue(t, m.ID != "id:0", "deleted vector not found")
+			}
+			for _, m := range exact {
+				if found[m.ID] {
+					hits++
+				}
+			}
+		}
+		assertTrue(t, hits >= 90, "HNSW finds most exact neighbours")
+	})
+}
+
+func TestLograDB_Notify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("reports writes", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+		n := &recordingNotifier{}
+		db.SetNotifier(n)
+
+		db.Set("a", "1")
+		db.Set("a", "2")
+		db.IncrBy("a", 1)
+		db.Expire("a", time.Now().Add(time.Hour).UnixMilli(), ExpireAlways)
+		db.Persist("a")
+		db.Delete("a")
+		db.MultiSet([]KeyValue{{"b", "1"}, {"b", "2"}})
+
+		want := []string{
+			"n new a", "$ set a", "$ set a", "$ incrby a", "g expire a",
+			"g persist a", "g del a", "n new b", "$ set b", "$ set b",
+		}
+		assertEqual(t, len(n.events), len(want), "event count")
+		for i := range want {
+			assertEqual(t, n.events[i], want[i], "event "+itoa(i))
+		}
+	})
+
+	t.Run("reports expiry", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+		n := &recordingNotifier{}
+		db.Set("a", "1")
+		db.Expire("a", time.Now().Add(10*time.Millisecond).UnixMilli(), ExpireAlways)
+		db.SetNotifier(n)
+
+		time.Sleep(20 * time.Millisecond)
+		assertFalse(t, db.Has("a"), "expired key")
+		assertEqual(t, len(n.events), 1, "event count")
+		assertEqual(t, n.events[0], "x expired a", "expired event")
+	})
+
+	t.Run("holds back transaction events until commit", func(t *testing.T) {
+		t.Parallel()
+		db, cleanup := setupTestDB(t)
+		defer cleanup()
+		n := &recordingNotifier{}
+		db.SetNotifier(n)
+
+		db.Transaction(nil, func(tx *LograDB) {
+			tx.Set("a", "1")
+			tx.Append("a", "2")
+			assertEqual(t, len(n.events), 0, "events before commit")
+		})
+		assertEqual(t, len(n.events), 3, "events after commit")
+		assertEqual(t, n.events[2], "$ append a", "last event")
+	})
+}
+
 func TestLograDB_Persistence(t *testing.T) {
 	t.Parallel()
 
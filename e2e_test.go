@@ -671,12 +671,18 @@ func TestE2E_WriteDuringCompact(t *testing.T) {
 		}
 	}
 
-	// Close merge file, delete old, rename, scan new, swap index
+	// Close merge file, then install merge output and reconcile the index
 	c.CloseMergeFile()
-	c.DeleteOldFiles()
-	c.RenameMergeFiles()
-	c.ScanNewFiles()
-	c.SwapAndCleanup()
+	if err := c.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	// Keys written during compaction must be readable before reopening
+	for i := 0; i < 5; i++ {
+		if _, err := db.Get(fmt.Sprintf("new%d", i)); err != nil {
+			t.Errorf("Get(new%d) before reopen error = %v", i, err)
+		}
+	}
 
 	db.Close()
 
@@ -0,0 +1,332 @@
+package logra
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+**
+Expiry
+A key's deadline is stored as a record of its own under an internal key, in
+Unix milliseconds:
+
+	"\x00logra:expire:<key>" -> "1718000000000"
+
+Writes that touch a value and its deadline go out as one batch. The index
+tracks these records like any other key, so compaction and recovery need
+nothing special; db.expires mirrors them in memory and is rebuilt on Open.
+**
+*/
+const expireKeyPrefix = internalKeyPrefix + "expire:"
+
+func expireKey(key string) string {
+	return expireKeyPrefix + key
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixMilli()
+}
+
+// SetOptions are the conditions and expiry of a SetWithOptions call.
+type SetOptions struct {
+	NX      bool // only set the key if it does not exist
+	XX      bool // only set the key if it already exists
+	Get     bool // return the previous value
+	KeepTTL bool // keep the existing deadline instead of clearing it
+
+	// ExpireAt is the deadline in Unix milliseconds; 0 means none.
+	ExpireAt int64
+}
+
+// expired reports whether key has a deadline that has passed. The caller must
+// hold the lock.
+func (db *LograDB) expired(key string) bool {
+	at, ok := db.expires[key]
+	return ok && at <= nowMillis()
+}
+
+// loadExpires reads the deadline records found by loadIndex.
+func (db *LograDB) loadExpires() error {
+	for _, key := range db.Index.Keys() {
+		if !strings.HasPrefix(key, expireKeyPrefix) {
+			continue
+		}
+		rec, err := db.readEntry(key)
+		if err != nil {
+			return err
+		}
+		at, err := strconv.ParseInt(rec.Value, 10, 64)
+		if err != nil {
+			return err
+		}
+		db.expires[strings.TrimPrefix(key, expireKeyPrefix)] = at
+	}
+	return nil
+}
+
+// trackExpire keeps db.expires in step with a write to a deadline record.
+func (db *LograDB) trackExpire(op writeOp) {
+	if !strings.HasPrefix(op.key, expireKeyPrefix) {
+		return
+	}
+	key := strings.TrimPrefix(op.key, expireKeyPrefix)
+	if db.tx != nil {
+		db.tx.saveExpire(key, db.expires)
+	}
+	if op.del {
+		delete(db.expires, key)
+		return
+	}
+	at, _ := strconv.ParseInt(op.value, 10, 64)
+	db.expires[key] = at
+}
+
+// setExpireOp returns the write that gives key the deadline at.
+func setExpireOp(key string, at int64) writeOp {
+	return writeOp{key: expireKey(key), value: strconv.FormatInt(at, 10)}
+}
+
+// clearExpireOps returns the write that drops key's deadline, if it has one.
+func (db *LograDB) clearExpireOps(key string) []writeOp {
+	if _, ok := db.expires[key]; !ok {
+		return nil
+	}
+	return []writeOp{{key: expireKey(key), del: true}}
+}
+
+// SetWithOptions sets key like Set, subject to opts. It reports whether the
+// value was written and, with opts.Get, returns the previous record, or nil
+// if the key did not exist.
+func (db *LograDB) SetWithOptions(key, value string, opts SetOptions) (*Record, bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	exists := db.has(key)
+	var old *Record
+	if opts.Get && exists {
+		rec, err := db.get(key)
+		if err != nil {
+			return nil, false, err
+		}
+		old = &rec
+	}
+	if (opts.NX && exists) || (opts.XX && !exists) {
+		return old, false, nil
+	}
+
+	ops := append([]writeOp{{key: key, value: value}}, db.dropCollectionOps(key)...)
+	switch {
+	case opts.ExpireAt > 0:
+		ops = append(ops, setExpireOp(key, opts.ExpireAt))
+	case opts.KeepTTL && exists:
+	default:
+		ops = append(ops, db.clearExpireOps(key)...)
+	}
+	if err := db.write(ops); err != nil {
+		return nil, false, err
+	}
+	db.notify(EventString, "set", key)
+	if opts.ExpireAt > 0 {
+		db.notify(EventGeneric, "expire", key)
+	}
+	return old, true, nil
+}
+
+// GetDel returns the value of key and deletes it.
+func (db *LograDB) GetDel(key string) (Record, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	rec, err := db.get(key)
+	if err != nil {
+		return Record{}, err
+	}
+	if err := db.write(db.deleteOps(key)); err != nil {
+		return Record{}, err
+	}
+	db.notify(EventGeneric, "del", key)
+	return rec, nil
+}
+
+// GetEx returns the value of key and sets its deadline to expireAt, in Unix
+// milliseconds. With persist the deadline is removed instead; with neither
+// it is left as is.
+func (db *LograDB) GetEx(key string, expireAt int64, persist bool) (Record, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	rec, err := db.get(key)
+	if err != nil {
+		return Record{}, err
+	}
+	var ops []writeOp
+	event := ""
+	if expireAt > 0 {
+		ops, event = append(ops, setExpireOp(key, expireAt)), "expire"
+	} else if persist {
+		ops, event = db.clearExpireOps(key), "persist"
+	}
+	if err := db.write(ops); err != nil {
+		return Record{}, err
+	}
+	if len(ops) > 0 {
+		db.notify(EventGeneric, event, key)
+	}
+	return rec, nil
+}
+
+// ExpireCondition restricts when Expire changes a deadline, like the NX, XX,
+// GT and LT options of Redis's EXPIRE. Conditions combine with |, as XX does
+// with GT or LT.
+type ExpireCondition int
+
+const ExpireAlways ExpireCondition = 0
+
+const (
+	ExpireNX ExpireCondition = 1 << iota // only if the key has no deadline
+	ExpireXX                             // only if the key has a deadline
+	ExpireGT                             // only if the new deadline is later
+	ExpireLT                             // only if the new deadline is earlier
+)
+
+// Expire gives key the deadline at, in Unix milliseconds. A deadline in the
+// past deletes the key. It reports false if the key does not exist or cond
+// was not met.
+func (db *LograDB) Expire(key string, at int64, cond ExpireCondition) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if !db.has(key) {
+		return false, nil
+	}
+	current, volatile := db.expires[key]
+	switch {
+	case cond&ExpireNX != 0 && volatile,
+		cond&ExpireXX != 0 && !volatile,
+		// A key without a deadline never expires, so nothing is later
+		cond&ExpireGT != 0 && (!volatile || at <= current),
+		cond&ExpireLT != 0 && volatile && at >= current:
+		return false, nil
+	}
+
+	if at <= nowMillis() {
+		if err := db.write(db.deleteOps(key)); err != nil {
+			return false, err
+		}
+		db.notify(EventGeneric, "del", key)
+		return true, nil
+	}
+	if err := db.write([]writeOp{setExpireOp(key, at)}); err != nil {
+		return false, err
+	}
+	db.notify(EventGeneric, "expire", key)
+	return true, nil
+}
+
+// ExpireTime returns key's deadline in Unix milliseconds, -1 if it has none
+// and -2 if the key does not exist.
+func (db *LograDB) ExpireTime(key string) int64 {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if !db.has(key) {
+		return -2
+	}
+	if at, ok := db.expires[key]; ok {
+		return at
+	}
+	return -1
+}
+
+// Persist removes key's deadline. It reports false if the key does not exist
+// or had none.
+func (db *LograDB) Persist(key string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if !db.has(key) {
+		return false, nil
+	}
+	ops := db.clearExpireOps(key)
+	if len(ops) == 0 {
+		return false, nil
+	}
+	if err := db.write(ops); err != nil {
+		return false, err
+	}
+	db.notify(EventGeneric, "persist", key)
+	return true, nil
+}
+
+// expiredKeys counts the indexed keys whose deadline has passed. The caller
+// must hold the lock.
+func (db *LograDB) expiredKeys() int {
+	now := nowMillis()
+	n := 0
+	for key, at := range db.expires {
+		if at <= now && db.indexed(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// deleteExpired removes key if its deadline has passed. Reads only hide
+// expired keys, as they hold the read lock; they call this afterwards so the
+// key is also dropped from the log.
+func (db *LograDB) deleteExpired(key string) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	if db.indexed(key) && db.expired(key) {
+		if db.write(db.deleteOps(key)) == nil {
+			db.notify(EventExpired, "expired", key)
+		}
+	}
+}
+
+const (
+	expireSampleSize  = 20
+	expireCycleBudget = 25 * time.Millisecond
+)
+
+// ActiveExpireCycle deletes expired keys that nobody reads, in the manner of
+// Redis's active expiry: it samples keys with a deadline and deletes the
+// expired ones, and samples again while more than a quarter of a sample had
+// expired and the time budget lasts. It returns how many keys were deleted.
+func (db *LograDB) ActiveExpireCycle() (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	start := time.Now()
+	deleted := 0
+	for time.Since(start) < expireCycleBudget {
+		now := nowMillis()
+		sampled := 0
+		var ops []writeOp
+		var expired []string
+		// Map iteration order is random, which makes this a random sample
+		for key, at := range db.expires {
+			if sampled == expireSampleSize {
+				break
+			}
+			sampled++
+			if at <= now {
+				ops = append(ops, db.deleteOps(key)...)
+				expired = append(expired, key)
+			}
+		}
+		if err := db.write(ops); err != nil {
+			return deleted, err
+		}
+		for _, key := range expired {
+			db.notify(EventExpired, "expired", key)
+		}
+		deleted += len(expired)
+		if sampled == 0 || len(expired)*4 <= sampled {
+			break
+		}
+	}
+	return deleted, nil
+}
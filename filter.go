@@ -0,0 +1,766 @@
+package logra
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+	"sort"
+)
+
+/*
+**
+Filters
+Bloom and Cuckoo filters are collections, of the types RedisBloom reports,
+made of a metadata record and the filter's bytes in blocks:
+
+	"\x00logra:elem:<len>:<key>m"                  -> options and counts
+	"\x00logra:elem:<len>:<key>b<layer><block>"    -> bytes of a block
+
+An insert changes a single block, so it appends that block and the counts
+rather than the filter. Blocks never written are all zero. A filter that
+fills up grows by a layer of Expansion times the capacity of the last, as in
+RedisBloom.
+
+Bloom filters are blocked: an item's hash picks one block and all its bits
+are set within that block. Cuckoo filters keep one-byte fingerprints in
+buckets of BucketSize slots, with whole buckets in each block; an insert
+that has to relocate fingerprints may touch a few more.
+**
+*/
+const (
+	bloomType        = "MBbloom--"
+	cuckooType       = "MBbloomCF"
+	filterMetaElem   = "m"
+	filterBlockElem  = 'b'
+	filterBlockSize  = 512
+	filterBlockBits  = filterBlockSize * 8
+	filterMetaFields = 9
+	bloomSeed        = 0xc6a4a7935bd1e995
+)
+
+var (
+	ErrFilterExists   = errors.New("item exists")
+	ErrFilterNotFound = errors.New("not found")
+	ErrBloomFull      = errors.New("non scaling filter is full")
+	ErrCuckooFull     = errors.New("Filter is full")
+)
+
+// BloomOptions configure a Bloom filter. Each layer after the first has
+// Expansion times the capacity of the one before and half its error rate.
+type BloomOptions struct {
+	ErrorRate  float64
+	Capacity   int64
+	Expansion  int64
+	NonScaling bool
+}
+
+// DefaultBloomOptions are those of a filter created by BFAdd.
+var DefaultBloomOptions = BloomOptions{ErrorRate: 0.01, Capacity: 100, Expansion: 2}
+
+// CuckooOptions configure a Cuckoo filter. Expansion 0 keeps it from
+// growing.
+type CuckooOptions struct {
+	Capacity      int64
+	BucketSize    int64
+	MaxIterations int64
+	Expansion     int64
+}
+
+// DefaultCuckooOptions are those of a filter created by CFAdd.
+var DefaultCuckooOptions = CuckooOptions{Capacity: 1024, BucketSize: 2, MaxIterations: 20, Expansion: 1}
+
+// FilterInfo describes a filter, as BF.INFO and CF.INFO report it. Size is
+// the number of bytes of all layers.
+type FilterInfo struct {
+	Capacity      int64
+	Size          int64
+	Filters       int64
+	Items         int64
+	Deleted       int64
+	Buckets       int64
+	BucketSize    int64
+	Expansion     int64
+	MaxIterations int64
+}
+
+// filterMeta is the metadata record of a filter, kept in memory.
+type filterMeta struct {
+	capacity   uint64 // of the first layer
+	errorRate  float64
+	bucketSize uint64
+	maxIter    uint64
+	expansion  uint64
+	nonScaling bool
+	layers     uint64
+	items      uint64
+	deleted    uint64
+}
+
+func (m *filterMeta) encode() string {
+	var nonScaling uint64
+	if m.nonScaling {
+		nonScaling = 1
+	}
+	buf := make([]byte, 0, filterMetaFields*8)
+	for _, v := range []uint64{m.capacity, math.Float64bits(m.errorRate), m.bucketSize, m.maxIter,
+		m.expansion, nonScaling, m.layers, m.items, m.deleted} {
+		buf = binary.BigEndian.AppendUint64(buf, v)
+	}
+	return string(buf)
+}
+
+func parseFilterMeta(value string) *filterMeta {
+	var f [filterMetaFields]uint64
+	for i := range f {
+		if len(value) >= (i+1)*8 {
+			f[i] = binary.BigEndian.Uint64([]byte(value[i*8:]))
+		}
+	}
+	return &filterMeta{capacity: f[0], errorRate: math.Float64frombits(f[1]), bucketSize: f[2], maxIter: f[3],
+		expansion: f[4], nonScaling: f[5] != 0, layers: f[6], items: f[7], deleted: f[8]}
+}
+
+func blockElem(layer int, block uint64) string {
+	var b [7]byte
+	b[0] = filterBlockElem
+	binary.BigEndian.PutUint16(b[1:], uint16(layer))
+	binary.BigEndian.PutUint32(b[3:], uint32(block))
+	return string(b[:])
+}
+
+// layerCapacity returns the capacity of layer i.
+func (m *filterMeta) layerCapacity(i int) uint64 {
+	capacity := m.capacity
+	for ; i > 0; i-- {
+		capacity *= m.expansion
+	}
+	return capacity
+}
+
+// bloomLayer returns the number of hashes and blocks of layer i of a Bloom
+// filter, sized for its capacity at an error rate halved with each layer.
+func (m *filterMeta) bloomLayer(i int) (int, uint64) {
+	errorRate := m.errorRate * math.Pow(0.5, float64(i))
+	bitsPerItem := -math.Log(errorRate) / (math.Ln2 * math.Ln2)
+	hashes := int(math.Ceil(math.Ln2 * bitsPerItem))
+	bits := uint64(math.Ceil(float64(m.layerCapacity(i)) * bitsPerItem))
+	return hashes, (bits + filterBlockBits - 1) / filterBlockBits
+}
+
+// cuckooBuckets returns the number of buckets of layer i of a Cuckoo filter,
+// a power of two so that alternate buckets pair up.
+func (m *filterMeta) cuckooBuckets(i int) uint64 {
+	n := (m.layerCapacity(i) + m.bucketSize - 1) / m.bucketSize
+	if n <= 1 {
+		return 1
+	}
+	return 1 << bits.Len64(n-1)
+}
+
+// blockBuckets is the number of buckets in a block of a Cuckoo filter.
+func (m *filterMeta) blockBuckets() uint64 {
+	return filterBlockSize / m.bucketSize
+}
+
+// blockSize is the length of a block.
+func (m *filterMeta) blockSize(kind string) int {
+	if kind == cuckooType {
+		return int(m.blockBuckets() * m.bucketSize)
+	}
+	return filterBlockSize
+}
+
+// filterEdit reads and changes the blocks of the filter at key, reading each
+// block once and writing back only those it changed.
+type filterEdit struct {
+	db      *LograDB
+	key     string
+	kind    string
+	c       *collection // nil for a new filter
+	meta    filterMeta
+	blocks  map[string][]byte
+	changed map[string]bool
+}
+
+// loadFilter returns an edit of the filter of type kind at key, or nil if
+// the key does not exist. The caller must hold the lock.
+func (db *LograDB) loadFilter(key, kind string) (*filterEdit, error) {
+	c, err := db.collectionOf(key, kind)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	e := newFilterEdit(db, key, kind)
+	e.c = c
+	if c.filter != nil {
+		e.meta = *c.filter
+	}
+	return e, nil
+}
+
+func newFilterEdit(db *LograDB, key, kind string) *filterEdit {
+	return &filterEdit{db: db, key: key, kind: kind, blocks: make(map[string][]byte), changed: make(map[string]bool)}
+}
+
+// block returns block i of layer, which changes are made to in place.
+func (e *filterEdit) block(layer int, i uint64) ([]byte, error) {
+	elem := blockElem(layer, i)
+	if b, ok := e.blocks[elem]; ok {
+		return b, nil
+	}
+	b := make([]byte, e.meta.blockSize(e.kind))
+	if e.c != nil {
+		if _, ok := e.c.elems[elem]; ok {
+			rec, err := e.db.readEntry(elemKey(e.key, elem))
+			if err != nil {
+				return nil, err
+			}
+			copy(b, rec.Value)
+		}
+	}
+	e.blocks[elem] = b
+	return b, nil
+}
+
+func (e *filterEdit) markChanged(layer int, i uint64) {
+	e.changed[blockElem(layer, i)] = true
+}
+
+// ops returns the writes of the changed blocks and the metadata.
+func (e *filterEdit) ops() []writeOp {
+	var ops []writeOp
+	if e.c == nil {
+		ops = e.db.createOps(e.key, e.kind)
+	}
+	elems := make([]string, 0, len(e.changed))
+	for elem := range e.changed {
+		elems = append(elems, elem)
+	}
+	sort.Strings(elems)
+	for _, elem := range elems {
+		ops = append(ops, writeOp{key: elemKey(e.key, elem), value: string(e.blocks[elem])})
+	}
+	return append(ops, writeOp{key: elemKey(e.key, filterMetaElem), value: e.meta.encode()})
+}
+
+// bloomHash returns the hash that picks the block of an item and the two
+// its bits within the block are derived from.
+func bloomHash(item string) (uint64, uint32, uint32) {
+	h := murmurHash64A(item, bloomSeed)
+	h2 := murmurHash64A(item, h)
+	return h, uint32(h2), uint32(h2>>32) | 1
+}
+
+// bloomTest reports whether item is in layer, setting its bits if set is
+// true.
+func (e *filterEdit) bloomTest(layer int, item string, set bool) (bool, error) {
+	hashes, blocks := e.meta.bloomLayer(layer)
+	h, a, b := bloomHash(item)
+	block, err := e.block(layer, h%blocks)
+	if err != nil {
+		return false, err
+	}
+	found := true
+	for j := 0; j < hashes; j++ {
+		bit := (a + uint32(j)*b) % filterBlockBits
+		if block[bit/8]&(1<<(bit%8)) == 0 {
+			found = false
+			if !set {
+				break
+			}
+			block[bit/8] |= 1 << (bit % 8)
+		}
+	}
+	if set && !found {
+		e.markChanged(layer, h%blocks)
+	}
+	return found, nil
+}
+
+// bloomContains reports whether item may be in any layer.
+func (e *filterEdit) bloomContains(item string) (bool, error) {
+	for layer := 0; layer < int(e.meta.layers); layer++ {
+		found, err := e.bloomTest(layer, item, false)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
+
+// bloomAdd adds item to the last layer, growing the filter if that layer is
+// full, and reports whether it may not have been there.
+func (e *filterEdit) bloomAdd(item string) (bool, error) {
+	found, err := e.bloomContains(item)
+	if err != nil || found {
+		return false, err
+	}
+	last := int(e.meta.layers) - 1
+	// Every layer but the last holds as many items as it was sized for
+	inLast := e.meta.items
+	for i := 0; i < last; i++ {
+		inLast -= e.meta.layerCapacity(i)
+	}
+	if inLast >= e.meta.layerCapacity(last) {
+		if e.meta.nonScaling {
+			return false, ErrBloomFull
+		}
+		last++
+		e.meta.layers++
+	}
+	if _, err := e.bloomTest(last, item, true); err != nil {
+		return false, err
+	}
+	e.meta.items++
+	return true, nil
+}
+
+// cuckooHash returns the fingerprint of item and the hash its first bucket
+// is taken from, as RedisBloom computes them.
+func cuckooHash(item string) (uint8, uint64) {
+	h := murmurHash64A(item, 0)
+	return uint8(h%255 + 1), h
+}
+
+// altBucket returns the other bucket of fingerprint fp in bucket i, of n.
+func altBucket(i uint64, fp uint8, n uint64) uint64 {
+	return (i ^ uint64(fp)*0x5bd1e995) % n
+}
+
+// cuckooSlot is a slot of a bucket: a block and an offset in it.
+type cuckooSlot struct {
+	layer int
+	block uint64
+	off   int
+}
+
+// bucket returns the slots of bucket i of layer.
+func (e *filterEdit) bucket(layer int, i uint64) ([]byte, cuckooSlot, error) {
+	per := e.meta.blockBuckets()
+	block, err := e.block(layer, i/per)
+	if err != nil {
+		return nil, cuckooSlot{}, err
+	}
+	off := int(i%per) * int(e.meta.bucketSize)
+	return block[off : off+int(e.meta.bucketSize)], cuckooSlot{layer, i / per, off}, nil
+}
+
+// cuckooBucketsOf returns the two buckets of item in layer and its
+// fingerprint.
+func (e *filterEdit) cuckooBucketsOf(layer int, item string) (uint64, uint64, uint8) {
+	fp, h := cuckooHash(item)
+	n := e.meta.cuckooBuckets(layer)
+	i1 := h % n
+	return i1, altBucket(i1, fp, n), fp
+}
+
+// cuckooCount counts the copies of item's fingerprint in the filter.
+func (e *filterEdit) cuckooCount(item string) (int64, error) {
+	var count int64
+	for layer := 0; layer < int(e.meta.layers); layer++ {
+		i1, i2, fp := e.cuckooBucketsOf(layer, item)
+		for _, i := range []uint64{i1, i2} {
+			slots, _, err := e.bucket(layer, i)
+			if err != nil {
+				return 0, err
+			}
+			for _, s := range slots {
+				if s == fp {
+					count++
+				}
+			}
+			if i1 == i2 {
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// cuckooAdd adds a copy of item to the filter, in a free slot of one of its
+// buckets in any layer, or else by relocating fingerprints of the last
+// layer. If that fails the filter grows by a layer.
+func (e *filterEdit) cuckooAdd(item string) error {
+	for layer := 0; layer < int(e.meta.layers); layer++ {
+		ok, err := e.cuckooPlace(layer, item)
+		if err != nil || ok {
+			return err
+		}
+	}
+	last := int(e.meta.layers) - 1
+	ok, err := e.cuckooKick(last, item)
+	if err != nil || ok {
+		return err
+	}
+	if e.meta.expansion == 0 {
+		return ErrCuckooFull
+	}
+	e.meta.layers++
+	if _, err := e.cuckooPlace(last+1, item); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cuckooPlace puts item in a free slot of one of its buckets in layer.
+func (e *filterEdit) cuckooPlace(layer int, item string) (bool, error) {
+	i1, i2, fp := e.cuckooBucketsOf(layer, item)
+	for _, i := range []uint64{i1, i2} {
+		ok, err := e.cuckooInsert(layer, i, fp)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (e *filterEdit) cuckooInsert(layer int, i uint64, fp uint8) (bool, error) {
+	slots, at, err := e.bucket(layer, i)
+	if err != nil {
+		return false, err
+	}
+	for j, s := range slots {
+		if s == 0 {
+			slots[j] = fp
+			e.markChanged(layer, at.block)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// cuckooKick makes room for item in layer by moving fingerprints to their
+// other bucket, up to maxIter times. If no room is found the moves are
+// undone.
+func (e *filterEdit) cuckooKick(layer int, item string) (bool, error) {
+	i1, i2, fp := e.cuckooBucketsOf(layer, item)
+	n := e.meta.cuckooBuckets(layer)
+	i := i1
+	if rand.IntN(2) == 1 {
+		i = i2
+	}
+	type move struct {
+		slot []byte
+		j    int
+		old  uint8
+	}
+	var moves []move
+	changed := make(map[uint64]bool)
+	for k := uint64(0); k < e.meta.maxIter; k++ {
+		slots, at, err := e.bucket(layer, i)
+		if err != nil {
+			return false, err
+		}
+		j := rand.IntN(len(slots))
+		moves = append(moves, move{slots, j, slots[j]})
+		slots[j], fp = fp, slots[j]
+		changed[at.block] = true
+		i = altBucket(i, fp, n)
+		ok, err := e.cuckooInsert(layer, i, fp)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			for block := range changed {
+				e.markChanged(layer, block)
+			}
+			return true, nil
+		}
+	}
+	for k := len(moves) - 1; k >= 0; k-- {
+		moves[k].slot[moves[k].j] = moves[k].old
+	}
+	return false, nil
+}
+
+// cuckooDelete removes a copy of item, newest layers first, and reports
+// whether there was one.
+func (e *filterEdit) cuckooDelete(item string) (bool, error) {
+	for layer := int(e.meta.layers) - 1; layer >= 0; layer-- {
+		i1, i2, fp := e.cuckooBucketsOf(layer, item)
+		for _, i := range []uint64{i1, i2} {
+			slots, at, err := e.bucket(layer, i)
+			if err != nil {
+				return false, err
+			}
+			for j, s := range slots {
+				if s == fp {
+					slots[j] = 0
+					e.markChanged(layer, at.block)
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// reserveFilter creates the filter at key with meta. The caller must hold
+// the write lock.
+func (db *LograDB) reserveFilter(key, kind string, meta filterMeta) error {
+	if db.has(key) {
+		return ErrFilterExists
+	}
+	e := newFilterEdit(db, key, kind)
+	e.meta = meta
+	return db.write(e.ops())
+}
+
+func bloomMeta(opts BloomOptions) (filterMeta, error) {
+	if !(opts.ErrorRate > 0 && opts.ErrorRate < 1) {
+		return filterMeta{}, errors.New("(0 < error rate range < 1)")
+	}
+	if opts.Capacity <= 0 {
+		return filterMeta{}, errors.New("(capacity should be larger than 0)")
+	}
+	if opts.Expansion < 1 {
+		return filterMeta{}, errors.New("expansion should be greater or equal to 1")
+	}
+	return filterMeta{capacity: uint64(opts.Capacity), errorRate: opts.ErrorRate, expansion: uint64(opts.Expansion),
+		nonScaling: opts.NonScaling, layers: 1}, nil
+}
+
+func cuckooMeta(opts CuckooOptions) (filterMeta, error) {
+	if opts.Capacity <= 0 {
+		return filterMeta{}, errors.New("Capacity must be larger than 0")
+	}
+	if opts.BucketSize < 1 || opts.BucketSize > 255 {
+		return filterMeta{}, errors.New("Bucket size must be between 1 and 255")
+	}
+	if opts.MaxIterations < 1 || opts.MaxIterations > 65535 {
+		return filterMeta{}, errors.New("Max iterations must be between 1 and 65535")
+	}
+	if opts.Expansion < 0 || opts.Expansion > 32768 {
+		return filterMeta{}, errors.New("Expansion must be between 0 and 32768")
+	}
+	return filterMeta{capacity: uint64(opts.Capacity), bucketSize: uint64(opts.BucketSize), maxIter: uint64(opts.MaxIterations),
+		expansion: uint64(opts.Expansion), layers: 1}, nil
+}
+
+// BFReserve creates an empty Bloom filter at key. It fails with
+// ErrFilterExists if key exists.
+func (db *LograDB) BFReserve(key string, opts BloomOptions) error {
+	meta, err := bloomMeta(opts)
+	if err != nil {
+		return err
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if err := db.reserveFilter(key, bloomType, meta); err != nil {
+		return err
+	}
+	db.notify(EventModule, "bf.reserve", key)
+	return nil
+}
+
+// BFAdd adds items to the Bloom filter at key, creating it with
+// DefaultBloomOptions if missing. It reports for each item whether it was
+// added, which it was not if the filter may already hold it.
+func (db *LograDB) BFAdd(key string, items []string) ([]bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	e, err := db.loadFilter(key, bloomType)
+	if err != nil {
+		return nil, err
+	}
+	if e == nil {
+		e = newFilterEdit(db, key, bloomType)
+		e.meta, _ = bloomMeta(DefaultBloomOptions)
+	}
+	added := make([]bool, len(items))
+	for i, item := range items {
+		if added[i], err = e.bloomAdd(item); err != nil {
+			return nil, err
+		}
+	}
+	if e.c != nil && len(e.changed) == 0 {
+		return added, nil
+	}
+	if err := db.write(e.ops()); err != nil {
+		return nil, err
+	}
+	db.notify(EventModule, "bf.add", key)
+	return added, nil
+}
+
+// BFExists reports for each item whether the Bloom filter at key may hold
+// it. A missing key holds nothing.
+func (db *LograDB) BFExists(key string, items []string) ([]bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	e, err := db.loadFilter(key, bloomType)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(items))
+	if e == nil {
+		return found, nil
+	}
+	for i, item := range items {
+		if found[i], err = e.bloomContains(item); err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
+
+// BFInfo describes the Bloom filter at key, or reports false if the key
+// does not exist.
+func (db *LograDB) BFInfo(key string) (FilterInfo, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	e, err := db.loadFilter(key, bloomType)
+	if err != nil || e == nil {
+		return FilterInfo{}, false, err
+	}
+	m := &e.meta
+	info := FilterInfo{Filters: int64(m.layers), Items: int64(m.items), Expansion: int64(m.expansion)}
+	for i := 0; i < int(m.layers); i++ {
+		_, blocks := m.bloomLayer(i)
+		info.Capacity += int64(m.layerCapacity(i))
+		info.Size += int64(blocks * filterBlockSize)
+	}
+	return info, true, nil
+}
+
+// CFReserve creates an empty Cuckoo filter at key. It fails with
+// ErrFilterExists if key exists.
+func (db *LograDB) CFReserve(key string, opts CuckooOptions) error {
+	meta, err := cuckooMeta(opts)
+	if err != nil {
+		return err
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if err := db.reserveFilter(key, cuckooType, meta); err != nil {
+		return err
+	}
+	db.notify(EventModule, "cf.reserve", key)
+	return nil
+}
+
+// CFAdd adds item to the Cuckoo filter at key, creating it with
+// DefaultCuckooOptions if missing. An item may be added more than once,
+// unless nx is set, in which case it is only added if the filter does not
+// seem to hold it. It reports whether it added the item.
+func (db *LograDB) CFAdd(key, item string, nx bool) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	e, err := db.loadFilter(key, cuckooType)
+	if err != nil {
+		return false, err
+	}
+	if e == nil {
+		e = newFilterEdit(db, key, cuckooType)
+		e.meta, _ = cuckooMeta(DefaultCuckooOptions)
+	} else if nx {
+		n, err := e.cuckooCount(item)
+		if err != nil || n > 0 {
+			return false, err
+		}
+	}
+	if err := e.cuckooAdd(item); err != nil {
+		return false, err
+	}
+	e.meta.items++
+	if err := db.write(e.ops()); err != nil {
+		return false, err
+	}
+	db.notify(EventModule, "cf.add", key)
+	return true, nil
+}
+
+// CFCount returns how many times the Cuckoo filter at key seems to hold
+// item.
+func (db *LograDB) CFCount(key, item string) (int64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	e, err := db.loadFilter(key, cuckooType)
+	if err != nil || e == nil {
+		return 0, err
+	}
+	return e.cuckooCount(item)
+}
+
+// CFExists reports for each item whether the Cuckoo filter at key may hold
+// it. A missing key holds nothing.
+func (db *LograDB) CFExists(key string, items []string) ([]bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	e, err := db.loadFilter(key, cuckooType)
+	if err != nil {
+		return nil, err
+	}
+	found := make([]bool, len(items))
+	if e == nil {
+		return found, nil
+	}
+	for i, item := range items {
+		n, err := e.cuckooCount(item)
+		if err != nil {
+			return nil, err
+		}
+		found[i] = n > 0
+	}
+	return found, nil
+}
+
+// CFDel removes one copy of item from the Cuckoo filter at key and reports
+// whether it found one. It fails with ErrFilterNotFound if the key does not
+// exist.
+func (db *LograDB) CFDel(key, item string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	e, err := db.loadFilter(key, cuckooType)
+	if err != nil {
+		return false, err
+	}
+	if e == nil {
+		return false, ErrFilterNotFound
+	}
+	ok, err := e.cuckooDelete(item)
+	if err != nil || !ok {
+		return false, err
+	}
+	e.meta.items--
+	e.meta.deleted++
+	if err := db.write(e.ops()); err != nil {
+		return false, err
+	}
+	db.notify(EventModule, "cf.del", key)
+	return true, nil
+}
+
+// CFInfo describes the Cuckoo filter at key, or reports false if the key
+// does not exist.
+func (db *LograDB) CFInfo(key string) (FilterInfo, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	e, err := db.loadFilter(key, cuckooType)
+	if err != nil || e == nil {
+		return FilterInfo{}, false, err
+	}
+	m := &e.meta
+	info := FilterInfo{Filters: int64(m.layers), Items: int64(m.items), Deleted: int64(m.deleted),
+		BucketSize: int64(m.bucketSize), Expansion: int64(m.expansion), MaxIterations: int64(m.maxIter)}
+	for i := 0; i < int(m.layers); i++ {
+		buckets := m.cuckooBuckets(i)
+		info.Capacity += int64(m.layerCapacity(i))
+		info.Buckets += int64(buckets)
+		info.Size += int64(buckets * m.bucketSize)
+	}
+	return info, true, nil
+}
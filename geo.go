@@ -0,0 +1,376 @@
+package logra
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+/*
+**
+Geo
+A geo index is a sorted set whose scores are 52-bit geohashes, as in Redis:
+the longitude and latitude are each cut into 2^26 steps and their bits
+interleaved, latitude first, so that nearby places tend to have close scores
+and a geohash cell of any precision is one score range. A search looks up
+the cell holding the centre and its eight neighbours, at the finest precision
+where they cover the whole area, and then filters by exact distance.
+**
+*/
+const (
+	geoStep      = 26
+	geoLonMin    = -180.0
+	geoLonMax    = 180.0
+	geoLatMin    = -85.05112878
+	geoLatMax    = 85.05112878
+	earthRadius  = 6372797.560856 // meters, as Redis uses
+	mercatorMax  = 20037726.37
+	geoHashWidth = 2 * geoStep
+)
+
+var ErrGeoMember = errors.New("could not decode requested zset member")
+
+// GeoPoint is a place on Earth, in degrees.
+type GeoPoint struct {
+	Lon, Lat float64
+}
+
+// valid reports whether p can be indexed: latitudes beyond about 85 degrees
+// are left out, as in Redis.
+func (p GeoPoint) valid() bool {
+	return p.Lon >= geoLonMin && p.Lon <= geoLonMax && p.Lat >= geoLatMin && p.Lat <= geoLatMax
+}
+
+func (p GeoPoint) check() error {
+	if !p.valid() {
+		return fmt.Errorf("invalid longitude,latitude pair %f,%f", p.Lon, p.Lat)
+	}
+	return nil
+}
+
+// GeoMember is a member of a geo index and its place.
+type GeoMember struct {
+	Member string
+	GeoPoint
+}
+
+// geoCell returns the indexes of the cell holding p among the 2^step steps
+// of latitude and of longitude.
+func geoCell(p GeoPoint, step uint) (lat, lon uint64) {
+	n := float64(uint64(1) << step)
+	lat = uint64((p.Lat - geoLatMin) / (geoLatMax - geoLatMin) * n)
+	lon = uint64((p.Lon - geoLonMin) / (geoLonMax - geoLonMin) * n)
+	// The upper edges belong to the last cell
+	return min(lat, uint64(n)-1), min(lon, uint64(n)-1)
+}
+
+// interleave spreads the bits of lat over the even bits of the result and
+// those of lon over the odd bits.
+func interleave(lat, lon uint64) uint64 {
+	var h uint64
+	for i := 0; i < 32; i++ {
+		h |= (lat>>i&1)<<(2*i) | (lon>>i&1)<<(2*i+1)
+	}
+	return h
+}
+
+func deinterleave(h uint64) (lat, lon uint64) {
+	for i := 0; i < 32; i++ {
+		lat |= (h >> (2 * i) & 1) << i
+		lon |= (h >> (2*i + 1) & 1) << i
+	}
+	return lat, lon
+}
+
+// GeoHash returns the 52-bit geohash of p, which is its score in a geo
+// index.
+func GeoHash(p GeoPoint) uint64 {
+	return interleave(geoCell(p, geoStep))
+}
+
+// GeoDecode returns the centre of the cell a 52-bit geohash stands for.
+func GeoDecode(h uint64) GeoPoint {
+	lat, lon := deinterleave(h)
+	n := float64(uint64(1) << geoStep)
+	latStep, lonStep := (geoLatMax-geoLatMin)/n, (geoLonMax-geoLonMin)/n
+	p := GeoPoint{
+		Lon: geoLonMin + (float64(lon)+0.5)*lonStep,
+		Lat: geoLatMin + (float64(lat)+0.5)*latStep,
+	}
+	p.Lon = max(geoLonMin, min(geoLonMax, p.Lon))
+	p.Lat = max(geoLatMin, min(geoLatMax, p.Lat))
+	return p
+}
+
+func degRad(d float64) float64 {
+	return d * math.Pi / 180
+}
+
+// GeoDistance returns the distance in meters between a and b on a sphere,
+// by the haversine formula.
+func GeoDistance(a, b GeoPoint) float64 {
+	lat1, lat2 := degRad(a.Lat), degRad(b.Lat)
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin(degRad(b.Lon-a.Lon) / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// GeoAdd adds members to the geo index at key, or moves them, subject to
+// opts as in ZAdd, and returns what ZAdd would.
+func (db *LograDB) GeoAdd(key string, members []GeoMember, opts ZAddOptions) (int, error) {
+	scored := make([]ScoredMember, len(members))
+	for i, m := range members {
+		if err := m.check(); err != nil {
+			return 0, err
+		}
+		scored[i] = ScoredMember{Member: m.Member, Score: float64(GeoHash(m.GeoPoint))}
+	}
+	return db.ZAdd(key, scored, opts)
+}
+
+// GeoPos returns the place of each of members in the geo index at key, or
+// nil for a member that is missing.
+func (db *LograDB) GeoPos(key string, members []string) ([]*GeoPoint, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*GeoPoint, len(members))
+	if c == nil {
+		return points, nil
+	}
+	for i, member := range members {
+		if score, ok := c.scores[member]; ok {
+			p := GeoDecode(uint64(score))
+			points[i] = &p
+		}
+	}
+	return points, nil
+}
+
+// GeoDist returns the distance in meters between two members of the geo
+// index at key, or false if either is missing.
+func (db *LograDB) GeoDist(key, member1, member2 string) (float64, bool, error) {
+	points, err := db.GeoPos(key, []string{member1, member2})
+	if err != nil || points[0] == nil || points[1] == nil {
+		return 0, false, err
+	}
+	return GeoDistance(*points[0], *points[1]), true, nil
+}
+
+// GeoSort is the order of GeoSearch results.
+type GeoSort int
+
+const (
+	GeoUnsorted GeoSort = iota
+	GeoAsc              // nearest first
+	GeoDesc             // farthest first
+)
+
+// GeoQuery is the area GeoSearch looks in and how it reports what it finds.
+// The area is centred on the member FromMember or, if it is empty, on From,
+// and is a circle of Radius meters or, with ByBox, a box of Width by Height
+// meters.
+type GeoQuery struct {
+	FromMember string
+	From       GeoPoint
+
+	ByBox         bool
+	Radius        float64
+	Width, Height float64
+
+	Sort  GeoSort
+	Count int  // at most this many results, if positive
+	Any   bool // stop at the first Count matches rather than the nearest
+}
+
+// GeoResult is a member found by GeoSearch.
+type GeoResult struct {
+	GeoMember
+	Dist float64 // from the centre, in meters
+	Hash uint64
+}
+
+// GeoSearch returns the members of the geo index at key in the area of q.
+func (db *LograDB) GeoSearch(key string, q GeoQuery) ([]GeoResult, error) {
+	if q.FromMember == "" {
+		if err := q.From.check(); err != nil {
+			return nil, err
+		}
+	}
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		if q.FromMember != "" {
+			return nil, ErrGeoMember
+		}
+		return nil, nil
+	}
+	return c.geoSearch(q)
+}
+
+// geoSearch finds the members of the geo index c in the area of q.
+func (c *collection) geoSearch(q GeoQuery) ([]GeoResult, error) {
+	center := q.From
+	if q.FromMember != "" {
+		score, ok := c.scores[q.FromMember]
+		if !ok {
+			return nil, ErrGeoMember
+		}
+		center = GeoDecode(uint64(score))
+	}
+	radius := q.Radius
+	if q.ByBox {
+		radius = math.Hypot(q.Width/2, q.Height/2)
+	}
+	dLat, dLon := q.reach(center)
+
+	var results []GeoResult
+	for _, r := range geoRanges(center, radius, dLat, dLon) {
+		spec := ZRangeSpec{By: ZRangeByScore, Min: ScoreBound{Score: r[0]}, Max: ScoreBound{Score: r[1], Exclusive: true}}
+		for _, m := range c.zrange(spec) {
+			p := GeoDecode(uint64(m.Score))
+			dist, ok := geoWithin(q, center, p)
+			if !ok {
+				continue
+			}
+			results = append(results, GeoResult{GeoMember: GeoMember{m.Member, p}, Dist: dist, Hash: uint64(m.Score)})
+			if q.Any && len(results) == q.Count {
+				break
+			}
+		}
+		if q.Any && len(results) == q.Count {
+			break
+		}
+	}
+
+	order := q.Sort
+	if order == GeoUnsorted && q.Count > 0 && !q.Any {
+		order = GeoAsc
+	}
+	sortGeoResults(results, order)
+	if q.Count > 0 && len(results) > q.Count {
+		results = results[:q.Count]
+	}
+	return results, nil
+}
+
+// reach returns how far the area of q extends from center in degrees of
+// latitude and of longitude. The reach in longitude grows towards the poles,
+// up to the whole circle.
+func (q GeoQuery) reach(center GeoPoint) (dLat, dLon float64) {
+	lat := degRad(center.Lat)
+	if q.ByBox {
+		dLat = q.Height / 2 / earthRadius
+		dLon = math.Pi
+		if cos := math.Cos(min(math.Abs(lat)+dLat, math.Pi/2)); cos > 0 {
+			dLon = min(dLon, q.Width/2/earthRadius/cos)
+		}
+	} else {
+		dLat = q.Radius / earthRadius
+		dLon = math.Pi
+		if sin := math.Sin(min(dLat, math.Pi/2)) / math.Cos(lat); dLat < math.Pi/2 && sin < 1 {
+			dLon = math.Asin(sin)
+		}
+	}
+	return dLat * 180 / math.Pi, dLon * 180 / math.Pi
+}
+
+// geoWithin reports whether p is in the area of q around center, and how
+// far from center it is.
+func geoWithin(q GeoQuery, center, p GeoPoint) (float64, bool) {
+	if !q.ByBox {
+		dist := GeoDistance(center, p)
+		return dist, dist <= q.Radius
+	}
+	if earthRadius*math.Abs(degRad(p.Lat)-degRad(center.Lat)) > q.Height/2 {
+		return 0, false
+	}
+	if GeoDistance(GeoPoint{center.Lon, p.Lat}, p) > q.Width/2 {
+		return 0, false
+	}
+	return GeoDistance(center, p), true
+}
+
+func sortGeoResults(results []GeoResult, order GeoSort) {
+	switch order {
+	case GeoAsc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist < results[j].Dist })
+	case GeoDesc:
+		sort.SliceStable(results, func(i, j int) bool { return results[i].Dist > results[j].Dist })
+	}
+}
+
+// geoRanges returns the score ranges, each [min, max), of the cells that
+// cover every place within dLat and dLon degrees of center: the cell holding
+// center and its neighbours, at the finest precision where that is enough,
+// starting from the precision suited to radius meters.
+func geoRanges(center GeoPoint, radius, dLat, dLon float64) [][2]float64 {
+	// Nothing is indexed past the poles
+	latLo := max(center.Lat-dLat, geoLatMin)
+	latHi := min(center.Lat+dLat, geoLatMax)
+
+	for step := geoEstimateStep(radius, center.Lat); ; step-- {
+		n := uint64(1) << step
+		latStep := (geoLatMax - geoLatMin) / float64(n)
+		lonStep := (geoLonMax - geoLonMin) / float64(n)
+		lat, lon := geoCell(center, step)
+		cellLat := geoLatMin + float64(lat)*latStep
+		cellLon := geoLonMin + float64(lon)*lonStep
+		covered := latLo >= cellLat-latStep && latHi <= cellLat+2*latStep &&
+			center.Lon-dLon >= cellLon-lonStep && center.Lon+dLon <= cellLon+2*lonStep
+		if !covered && step > 1 {
+			continue
+		}
+
+		// Cells past the poles do not exist, and longitude wraps around
+		seen := make(map[uint64]bool)
+		var ranges [][2]float64
+		for dy := -1; dy <= 1; dy++ {
+			y := int64(lat) + int64(dy)
+			if y < 0 || y >= int64(n) {
+				continue
+			}
+			for dx := -1; dx <= 1; dx++ {
+				x := (int64(lon) + int64(dx) + int64(n)) % int64(n)
+				cell := interleave(uint64(y), uint64(x))
+				if seen[cell] {
+					continue
+				}
+				seen[cell] = true
+				shift := geoHashWidth - 2*step
+				ranges = append(ranges, [2]float64{float64(cell << shift), float64((cell + 1) << shift)})
+			}
+		}
+		return ranges
+	}
+}
+
+// geoEstimateStep returns the precision at which a geohash cell is about as
+// large as radius, as Redis estimates it.
+func geoEstimateStep(radius, lat float64) uint {
+	if radius == 0 {
+		return geoStep
+	}
+	step := 1
+	for ; radius < mercatorMax; radius *= 2 {
+		step++
+	}
+	step -= 2
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	return uint(max(1, min(step, geoStep)))
+}
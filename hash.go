@@ -0,0 +1,305 @@
+package logra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// A hash is a collection whose elements are its fields. Field records hold
+// the value after a one-byte prefix, as an empty value would read as a
+// deletion.
+const (
+	hashType        = "hash"
+	hashValuePrefix = "v"
+)
+
+var (
+	ErrHashNotInteger = errors.New("hash value is not an integer")
+	ErrHashNotFloat   = errors.New("hash value is not a float")
+)
+
+// FieldValue is one field of a hash.
+type FieldValue struct {
+	Field string
+	Value string
+}
+
+// hashField reads one field of the hash at key. The caller must hold the lock.
+func (db *LograDB) hashField(key, field string) (string, bool, error) {
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return "", false, err
+	}
+	if _, ok := c.elems[field]; !ok {
+		return "", false, nil
+	}
+	rec, err := db.readEntry(elemKey(key, field))
+	if err != nil {
+		return "", false, err
+	}
+	return rec.Value[len(hashValuePrefix):], true, nil
+}
+
+// hashWrite writes fields of the hash at key, creating it if needed, and
+// returns how many fields are new. The caller must hold the write lock.
+func (db *LograDB) hashWrite(key string, fields []FieldValue) (int, error) {
+	c, err := db.collectionOf(key, hashType)
+	if err != nil {
+		return 0, err
+	}
+	var ops []writeOp
+	if c == nil {
+		ops = db.createOps(key, hashType)
+	}
+	added := make(map[string]bool)
+	for _, fv := range fields {
+		ops = append(ops, writeOp{key: elemKey(key, fv.Field), value: hashValuePrefix + fv.Value})
+		if c == nil {
+			added[fv.Field] = true
+		} else if _, ok := c.elems[fv.Field]; !ok {
+			added[fv.Field] = true
+		}
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	return len(added), nil
+}
+
+// HSet sets fields of the hash at key, creating it if missing, and returns
+// how many fields were added rather than updated.
+func (db *LograDB) HSet(key string, fields []FieldValue) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	n, err := db.hashWrite(key, fields)
+	if err != nil {
+		return 0, err
+	}
+	db.notify(EventHash, "hset", key)
+	return n, nil
+}
+
+// HSetNX sets field of the hash at key only if it does not exist yet.
+func (db *LograDB) HSetNX(key, field, value string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	_, exists, err := db.hashField(key, field)
+	if err != nil || exists {
+		return false, err
+	}
+	if _, err := db.hashWrite(key, []FieldValue{{field, value}}); err != nil {
+		return false, err
+	}
+	db.notify(EventHash, "hset", key)
+	return true, nil
+}
+
+// HGet returns the value of field in the hash at key, and false if either is
+// missing.
+func (db *LograDB) HGet(key, field string) (string, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.hashField(key, field)
+}
+
+// HMGet returns the values of fields in the hash at key. Missing fields
+// yield nil.
+func (db *LograDB) HMGet(key string, fields []string) ([]*string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	values := make([]*string, len(fields))
+	for i, field := range fields {
+		value, ok, err := db.hashField(key, field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			values[i] = &value
+		}
+	}
+	return values, nil
+}
+
+// HGetAll returns every field of the hash at key, ordered by field.
+func (db *LograDB) HGetAll(key string) ([]FieldValue, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	fields := make([]FieldValue, 0, len(c.elems))
+	for _, field := range c.sortedElems() {
+		rec, err := db.readEntry(elemKey(key, field))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, FieldValue{field, rec.Value[len(hashValuePrefix):]})
+	}
+	return fields, nil
+}
+
+// HKeys returns the field names of the hash at key in order. Values are not
+// read.
+func (db *LograDB) HKeys(key string) ([]string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.sortedElems(), nil
+}
+
+// HLen returns the number of fields in the hash at key.
+func (db *LograDB) HLen(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	return len(c.elems), nil
+}
+
+// HExists reports whether field exists in the hash at key.
+func (db *LograDB) HExists(key, field string) (bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return false, err
+	}
+	_, ok := c.elems[field]
+	return ok, nil
+}
+
+// HDel removes fields from the hash at key and returns how many existed. The
+// key is deleted with its last field.
+func (db *LograDB) HDel(key string, fields []string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	removed := make(map[string]bool)
+	var ops []writeOp
+	for _, field := range fields {
+		if _, ok := c.elems[field]; ok && !removed[field] {
+			removed[field] = true
+			ops = append(ops, writeOp{key: elemKey(key, field), del: true})
+		}
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	emptied := len(removed) == len(c.elems)
+	if emptied {
+		ops = db.deleteOps(key)
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	db.notify(EventHash, "hdel", key)
+	if emptied {
+		db.notify(EventGeneric, "del", key)
+	}
+	return len(removed), nil
+}
+
+// HIncrBy adds delta to the integer in field of the hash at key, treating a
+// missing field as 0, and returns the new value.
+func (db *LograDB) HIncrBy(key, field string, delta int64) (int64, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	value, exists, err := db.hashField(key, field)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if exists {
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, ErrHashNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	if _, err := db.hashWrite(key, []FieldValue{{field, strconv.FormatInt(n, 10)}}); err != nil {
+		return 0, err
+	}
+	db.notify(EventHash, "hincrby", key)
+	return n, nil
+}
+
+// HIncrByFloat adds delta to the number in field of the hash at key, treating
+// a missing field as 0, and returns the new value as stored.
+func (db *LograDB) HIncrByFloat(key, field string, delta float64) (string, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	value, exists, err := db.hashField(key, field)
+	if err != nil {
+		return "", err
+	}
+	var f float64
+	if exists {
+		f, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", ErrHashNotFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", ErrNaN
+	}
+	result := strconv.FormatFloat(f, 'f', -1, 64)
+	if _, err := db.hashWrite(key, []FieldValue{{field, result}}); err != nil {
+		return "", err
+	}
+	db.notify(EventHash, "hincrbyfloat", key)
+	return result, nil
+}
+
+// HScan calls fn for about count fields of the hash at key from cursor on and
+// returns the cursor to continue from, or 0 when the scan is complete. Like
+// ScanKeys, every field present throughout the scan is reported at least
+// once. fn runs under the read lock and must not call back into db.
+func (db *LograDB) HScan(key string, cursor uint64, count int, fn func(field, value string)) (uint64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, hashType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	var readErr error
+	next := c.scan(cursor, count, func(field string) {
+		if readErr != nil {
+			return
+		}
+		rec, err := db.readEntry(elemKey(key, field))
+		if err != nil {
+			readErr = err
+			return
+		}
+		fn(field, rec.Value[len(hashValuePrefix):])
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+	return next, nil
+}
@@ -0,0 +1,397 @@
+package logra
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+/*
+**
+HyperLogLog
+A HyperLogLog is a plain string in the layout Redis uses, so values move
+between the two unchanged: a 16-byte header ("HYLL", the encoding, three
+unused bytes and a cached cardinality) followed by 16384 registers, either
+dense at 6 bits each or sparse as runs of equal registers:
+
+	00xxxxxx           ZERO:  1 to 64 zero registers
+	01xxxxxx xxxxxxxx  XZERO: 1 to 16384 zero registers
+	1vvvvvxx           VAL:   1 to 4 registers of value 1 to 32
+
+Registers are decoded, changed and encoded again as a whole. A new
+HyperLogLog is sparse and turns dense once a register exceeds 32 or the
+sparse form outgrows hllSparseMaxBytes, as in Redis.
+**
+*/
+const (
+	hllP              = 14
+	hllRegisters      = 1 << hllP
+	hllQ              = 64 - hllP
+	hllBits           = 6
+	hllHeaderSize     = 16
+	hllDenseSize      = hllHeaderSize + (hllRegisters*hllBits+7)/8
+	hllDense          = 0
+	hllSparse         = 1
+	hllSparseMaxVal   = 32
+	hllSparseMaxBytes = 3000
+)
+
+var (
+	ErrNotHLL     = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrCorruptHLL = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// hll is a decoded HyperLogLog.
+type hll struct {
+	regs   [hllRegisters]uint8
+	sparse bool
+	card   [8]byte // cached cardinality, little-endian; the top bit marks it stale
+}
+
+func newHLL() *hll {
+	return &hll{sparse: true}
+}
+
+// parseHLL decodes a HyperLogLog value.
+func parseHLL(value string) (*hll, error) {
+	if len(value) < hllHeaderSize || value[:4] != "HYLL" || value[4] > hllSparse {
+		return nil, ErrNotHLL
+	}
+	h := &hll{sparse: value[4] == hllSparse}
+	copy(h.card[:], value[8:hllHeaderSize])
+	data := value[hllHeaderSize:]
+	if !h.sparse {
+		if len(value) != hllDenseSize {
+			return nil, ErrNotHLL
+		}
+		for i := range h.regs {
+			h.regs[i] = denseRegister(data, i)
+		}
+		return h, nil
+	}
+
+	i := 0
+	for p := 0; p < len(data); p++ {
+		b := data[p]
+		n, v := 0, uint8(0)
+		switch {
+		case b&0xc0 == 0x00:
+			n = int(b&0x3f) + 1
+		case b&0xc0 == 0x40:
+			if p++; p == len(data) {
+				return nil, ErrCorruptHLL
+			}
+			n = int(b&0x3f)<<8 | int(data[p]) + 1
+		default:
+			n, v = int(b&3)+1, (b>>2)&0x1f+1
+		}
+		if i+n > hllRegisters {
+			return nil, ErrCorruptHLL
+		}
+		for ; n > 0; n-- {
+			h.regs[i] = v
+			i++
+		}
+	}
+	if i != hllRegisters {
+		return nil, ErrCorruptHLL
+	}
+	return h, nil
+}
+
+func denseRegister(data string, i int) uint8 {
+	b0, fb := i*hllBits/8, uint(i*hllBits%8)
+	v := data[b0] >> fb
+	if b0+1 < len(data) {
+		v |= data[b0+1] << (8 - fb)
+	}
+	return v & 0x3f
+}
+
+// encode returns h as a value, sparse if it was and still fits.
+func (h *hll) encode() string {
+	if h.sparse {
+		if s, ok := h.encodeSparse(); ok {
+			return s
+		}
+		h.sparse = false
+	}
+	buf := make([]byte, hllDenseSize)
+	h.header(buf, hllDense)
+	data := buf[hllHeaderSize:]
+	for i, v := range h.regs {
+		b0, fb := i*hllBits/8, uint(i*hllBits%8)
+		data[b0] |= v << fb
+		if b0+1 < len(data) {
+			data[b0+1] |= v >> (8 - fb)
+		}
+	}
+	return string(buf)
+}
+
+func (h *hll) encodeSparse() (string, bool) {
+	buf := make([]byte, hllHeaderSize, hllSparseMaxBytes)
+	h.header(buf, hllSparse)
+	for i := 0; i < hllRegisters; {
+		v := h.regs[i]
+		if v > hllSparseMaxVal {
+			return "", false
+		}
+		run := 1
+		for i+run < hllRegisters && h.regs[i+run] == v {
+			run++
+		}
+		i += run
+		for run > 0 {
+			switch n := run; {
+			case v != 0:
+				n = min(n, 4)
+				buf = append(buf, 0x80|(v-1)<<2|byte(n-1))
+				run -= n
+			case n > 64:
+				n = min(n, hllRegisters)
+				buf = append(buf, 0x40|byte((n-1)>>8), byte(n-1))
+				run -= n
+			default:
+				buf = append(buf, byte(n-1))
+				run -= n
+			}
+		}
+		if len(buf) > hllSparseMaxBytes {
+			return "", false
+		}
+	}
+	return string(buf), true
+}
+
+func (h *hll) header(buf []byte, encoding byte) {
+	copy(buf, "HYLL")
+	buf[4] = encoding
+	copy(buf[8:hllHeaderSize], h.card[:])
+}
+
+// hllPatLen returns the register elem falls in and the length of the run of
+// zero bits its hash starts with, plus one, as Redis computes them.
+func hllPatLen(elem string) (int, uint8) {
+	hash := murmurHash64A(elem, 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	hash >>= hllP
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+// add counts elem, reporting whether a register changed.
+func (h *hll) add(elem string) bool {
+	i, count := hllPatLen(elem)
+	if h.regs[i] >= count {
+		return false
+	}
+	h.regs[i] = count
+	h.invalidate()
+	return true
+}
+
+// merge makes every register of h at least that of o.
+func (h *hll) merge(o *hll) {
+	for i, v := range o.regs {
+		h.regs[i] = max(h.regs[i], v)
+	}
+	if !o.sparse {
+		h.sparse = false
+	}
+	h.invalidate()
+}
+
+func (h *hll) invalidate() {
+	h.card[7] |= 0x80
+}
+
+// cached returns the cardinality stored in the header, if it is current.
+func (h *hll) cached() (uint64, bool) {
+	if h.card[7]&0x80 != 0 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(h.card[:]), true
+}
+
+// count estimates the cardinality with the estimator of Ertl's "New
+// cardinality estimation algorithms for HyperLogLog sketches", as Redis does.
+func (h *hll) count() uint64 {
+	var histo [hllQ + 2]int
+	for _, v := range h.regs {
+		histo[v]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	const alphaInf = 0.721347520444481703680 // 0.5/ln(2)
+	return uint64(math.Round(alphaInf * m * m / z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is MurmurHash2, 64-bit version, reading words little-endian
+// as Redis does on every platform.
+func murmurHash64A(s string, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(s))*m
+	for len(s) >= 8 {
+		k := binary.LittleEndian.Uint64([]byte(s[:8]))
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		s = s[8:]
+	}
+	if len(s) > 0 {
+		for i := len(s) - 1; i >= 0; i-- {
+			h ^= uint64(s[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// loadHLL returns the HyperLogLog at key, or nil if the key is missing. The
+// caller must hold the lock.
+func (db *LograDB) loadHLL(key string) (*hll, error) {
+	value, ok, err := db.current(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return parseHLL(value)
+}
+
+// PFAdd counts elements in the HyperLogLog at key, creating it if missing.
+// It reports whether the estimate may have changed, which includes creating
+// the key.
+func (db *LograDB) PFAdd(key string, elements []string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	h, err := db.loadHLL(key)
+	if err != nil {
+		return false, err
+	}
+	changed := h == nil
+	if h == nil {
+		h = newHLL()
+	}
+	for _, elem := range elements {
+		if h.add(elem) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	if err := db.update(key, h.encode()); err != nil {
+		return false, err
+	}
+	db.notify(EventString, "pfadd", key)
+	return true, nil
+}
+
+// PFCount estimates the number of distinct elements counted by the
+// HyperLogLogs at keys together. Missing keys count as empty. Unlike Redis
+// it does not write the estimate back to the header, so counting stays a
+// read.
+func (db *LograDB) PFCount(keys []string) (int64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	var union *hll
+	for _, key := range keys {
+		h, err := db.loadHLL(key)
+		if err != nil {
+			return 0, err
+		}
+		if h == nil {
+			continue
+		}
+		if len(keys) == 1 {
+			if n, ok := h.cached(); ok {
+				return int64(n), nil
+			}
+		}
+		if union == nil {
+			union = h
+		} else {
+			union.merge(h)
+		}
+	}
+	if union == nil {
+		return 0, nil
+	}
+	return int64(union.count()), nil
+}
+
+// PFMerge stores at dest the union of the HyperLogLogs at dest and keys. A
+// dest that exists keeps its deadline.
+func (db *LograDB) PFMerge(dest string, keys []string) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	h, err := db.loadHLL(dest)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		h = newHLL()
+	}
+	for _, key := range keys {
+		src, err := db.loadHLL(key)
+		if err != nil {
+			return err
+		}
+		if src != nil {
+			h.merge(src)
+		}
+	}
+	h.invalidate()
+	if err := db.update(dest, h.encode()); err != nil {
+		return err
+	}
+	db.notify(EventString, "pfadd", dest)
+	return nil
+}
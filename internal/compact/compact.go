@@ -1,14 +1,13 @@
 package compact
 
 import (
-	"bufio"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"sync/atomic"
 
 	"sakthirathinam/logra"
 	"sakthirathinam/logra/internal/index"
@@ -21,8 +20,20 @@ type Compact struct {
 	sortedFileObjs []*os.File
 	compactStatus  CompactStatus
 	compactIndex   *index.Index
-	mergeFile      *os.File
+	sources        map[string]index.Entry
 	mergeFileId    int
+	workers        int
+	writers        []*mergeWriter
+	finalized      bool
+
+	// progress is read by other goroutines (e.g. the server's INFO command)
+	// while Execute runs.
+	progressMu sync.Mutex
+	filesTotal int
+	filesDone  int
+	bytesTotal int64
+	bytesDone  int64
+	canceled   atomic.Bool
 }
 
 type CompactStatus string
@@ -31,12 +42,19 @@ const (
 	CompactInitialized CompactStatus = "initialized"
 	CompactInProgress  CompactStatus = "in_progress"
 	CompactCompleted   CompactStatus = "completed"
+	CompactCanceled    CompactStatus = "canceled"
+	CompactFailed      CompactStatus = "failed"
 )
 
-type mergeState struct {
-	Status         string `json:"status"`
-	MaxFileId      int    `json:"maxFileId"`
-	MergeFileCount int    `json:"mergeFileCount"`
+var ErrCanceled = errors.New("compaction canceled")
+
+// Progress is a point-in-time view of a running compaction.
+type Progress struct {
+	Status     CompactStatus
+	FilesTotal int
+	FilesDone  int
+	BytesTotal int64
+	BytesDone  int64
 }
 
 func NewCompact(lograDb *logra.LograDB) *Compact {
@@ -44,254 +62,267 @@ func NewCompact(lograDb *logra.LograDB) *Compact {
 		dbObj:         lograDb,
 		compactStatus: CompactInitialized,
 		compactIndex:  index.New(),
+		sources:       make(map[string]index.Entry),
+		mergeFileId:   -1,
+		workers:       1,
 	}
 }
 
+// SetWorkers sets how many goroutines Execute uses. Segments are split into
+// that many contiguous ranges, each merged into its own output files.
+func (m *Compact) SetWorkers(n int) {
+	if n < 1 {
+		n = 1
+	}
+	m.workers = n
+}
+
+// Execute runs a full compaction while the database stays online. Reads and
+// writes continue throughout; only the final install step takes the write lock.
+// Cancel may be called from another goroutine until the merge is committed.
 func (m *Compact) Execute() error {
 	if err := m.Prepare(); err != nil {
+		m.setStatus(CompactFailed)
 		return err
 	}
 
-	for _, fileObj := range m.sortedFileObjs {
-		if err := m.processFile(fileObj); err != nil {
-			return err
-		}
+	if err := m.mergeRanges(); err != nil {
+		return m.abort(err)
 	}
 
-	if m.mergeFile != nil {
-		m.mergeFile.Close()
-		m.mergeFile = nil
+	m.CloseMergeFile()
+	if m.canceled.Load() {
+		return m.abort(ErrCanceled)
 	}
 
-	// Delete old .dat files (0 through maxFileId)
-	if err := m.deleteOldFiles(); err != nil {
+	if err := m.Commit(); err != nil {
+		m.setStatus(CompactFailed)
 		return err
 	}
+	return nil
+}
 
-	// Rename merge files to regular .dat files
-	if err := m.renameMergeFiles(); err != nil {
-		return err
+// mergeRanges runs one worker per segment range and waits for all of them.
+func (m *Compact) mergeRanges() error {
+	ranges := splitRanges(m.sortedFileObjs, m.workers)
+	m.writers = make([]*mergeWriter, len(ranges))
+	errs := make([]error, len(ranges))
+
+	var wg sync.WaitGroup
+	for i, files := range ranges {
+		w := newMergeWriter(m.dbObj.Storage.Dir, i)
+		m.writers[i] = w
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, fileObj := range files {
+				if m.canceled.Load() {
+					errs[i] = ErrCanceled
+					return
+				}
+				if err := m.processFile(w, fileObj); err != nil {
+					errs[i] = err
+					return
+				}
+				m.fileDone(fileObj)
+			}
+		}()
 	}
+	wg.Wait()
 
-	// Build final index: start with compactIndex, then scan files after maxFileId
-	if err := m.scanNewFiles(); err != nil {
-		return err
-	}
+	return errors.Join(errs...)
+}
 
-	// Swap the index
-	m.dbObj.SwapIndex(m.compactIndex)
+// Cancel asks a running Execute to stop before it commits. The partial merge
+// output is rolled back and the database is left untouched.
+func (m *Compact) Cancel() {
+	m.canceled.Store(true)
+}
 
-	m.compactStatus = CompactCompleted
+func (m *Compact) Progress() Progress {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	return Progress{
+		Status:     m.compactStatus,
+		FilesTotal: m.filesTotal,
+		FilesDone:  m.filesDone,
+		BytesTotal: m.bytesTotal,
+		BytesDone:  m.bytesDone,
+	}
+}
 
-	// Clean up state file
-	os.Remove(filepath.Join(m.dbObj.Storage.Dir, "merge.json"))
+func (m *Compact) setStatus(status CompactStatus) {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	m.compactStatus = status
+}
 
-	return nil
+func (m *Compact) fileDone(fileObj *os.File) {
+	var size int64
+	if info, err := fileObj.Stat(); err == nil {
+		size = info.Size()
+	}
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	m.filesDone++
+	m.bytesDone += size
 }
 
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil || !os.IsNotExist(err)
+// abort discards the merge output. The journal is still in the merging
+// phase, so recovery rolls it back and leaves the old segments in place.
+func (m *Compact) abort(cause error) error {
+	m.CloseMergeFile()
+	status := CompactFailed
+	if errors.Is(cause, ErrCanceled) {
+		status = CompactCanceled
+		cause = ErrCanceled
+	}
+	m.setStatus(status)
+	if err := storage.RecoverMerge(m.dbObj.Storage.Dir); err != nil {
+		return fmt.Errorf("%w (rollback failed: %v)", cause, err)
+	}
+	return cause
 }
 
 func (m *Compact) Prepare() error {
 	// check for any ongoing compaction
-	if fileExists(filepath.Join(m.dbObj.Storage.Dir, "merge.json")) {
+	if _, found, _ := storage.ReadMergeState(m.dbObj.Storage.Dir); found {
 		return errors.New("compaction in progress")
 	}
 
-	datFiles, err := m.dbObj.Storage.GetAllDatFiles()
-	if err != nil {
+	// Rotate the active file so every segment up to maxFileId becomes
+	// immutable; writes made while compacting land in maxFileId+1 onwards.
+	if err := rotateActiveFile(m.dbObj); err != nil {
 		return err
 	}
-	if len(datFiles) == 0 {
-		return nil
-	}
-	lastFileId, err := storage.ParseFileIDFromName(filepath.Base(datFiles[len(datFiles)-1].Name()))
+	m.maxFileId = m.dbObj.Storage.ActiveFileID() - 1
+
+	datFiles, err := m.dbObj.Storage.GetAllDatFiles()
 	if err != nil {
 		return err
 	}
-	m.maxFileId = lastFileId
-	m.sortedFileObjs = datFiles
-	m.compactStatus = CompactInProgress
-
-	// Write state before starting
-	if err := m.writeState(CompactInProgress); err != nil {
-		return err
+	for _, f := range datFiles {
+		fileID, err := storage.ParseFileIDFromName(filepath.Base(f.Name()))
+		if err != nil || fileID > m.maxFileId {
+			f.Close()
+			continue
+		}
+		m.sortedFileObjs = append(m.sortedFileObjs, f)
 	}
 
-	// Switch active file so new writes go to maxFileId+1
-	if err := changeActiveFile(m.dbObj, m.maxFileId+1); err != nil {
-		return err
+	m.progressMu.Lock()
+	m.compactStatus = CompactInProgress
+	m.filesTotal = len(m.sortedFileObjs)
+	for _, f := range m.sortedFileObjs {
+		if info, err := f.Stat(); err == nil {
+			m.bytesTotal += info.Size()
+		}
 	}
+	m.progressMu.Unlock()
 
-	// Create the first merge file
-	return m.createMergeFile(0)
+	// Journal the merge before any merge file exists
+	return m.writeState(storage.MergePhaseMerging)
 }
 
-func (m *Compact) createMergeFile(id int) error {
-	path := filepath.Join(m.dbObj.Storage.Dir, fmt.Sprintf("merge_%d.dat", id))
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-	if m.mergeFile != nil {
-		m.mergeFile.Close()
+// reconcileIndex points live keys at their merged copies. A key is only
+// updated if it still references the exact record that was copied; anything
+// written or deleted while the merge ran keeps its newer index entry. The
+// returned map holds, per merge file, the bytes of copies that lost that race.
+func (m *Compact) reconcileIndex() map[int]int64 {
+	staleBytes := make(map[int]int64)
+	for key, source := range m.sources {
+		merged, _ := m.compactIndex.Lookup(key)
+		current, exists := m.dbObj.Index.Lookup(key)
+		if !exists || current.FileID != source.FileID || current.Offset != source.Offset {
+			staleBytes[merged.FileID] += int64(storage.HeaderSize + merged.KeySize + merged.ValueSize)
+			continue
+		}
+		m.dbObj.Index.Add(key, merged)
 	}
-	m.mergeFile = f
-	m.mergeFileId = id
-	return nil
+	return staleBytes
 }
 
-func (m *Compact) rotateMergeFileIfNeeded() error {
-	info, err := m.mergeFile.Stat()
-	if err != nil {
-		return err
-	}
-	if info.Size() >= storage.MaxDataFileSize*4 {
-		return m.createMergeFile(m.mergeFileId + 1)
-	}
-	return nil
+func (m *Compact) writeState(phase storage.MergePhase) error {
+	return storage.WriteMergeState(m.dbObj.Storage.Dir, storage.MergeState{
+		Status:         phase,
+		MaxFileId:      m.maxFileId,
+		MergeFileCount: m.mergeFileId + 1,
+	})
 }
 
-func (m *Compact) appendToMergeFile(key, value []byte) (int64, storage.Header, error) {
-	offset, err := m.mergeFile.Seek(0, io.SeekEnd)
-	if err != nil {
-		return 0, storage.Header{}, err
-	}
-
-	data := storage.EncodeRecord(key, value)
-	writer := bufio.NewWriter(m.mergeFile)
-	if _, err := writer.Write(data); err != nil {
-		return 0, storage.Header{}, err
-	}
-	if err := writer.Flush(); err != nil {
-		return 0, storage.Header{}, err
-	}
-
-	header, err := storage.DecodeHeader(data[:storage.HeaderSize])
-	if err != nil {
-		return 0, storage.Header{}, err
-	}
-
-	if err := m.rotateMergeFileIfNeeded(); err != nil {
-		return 0, storage.Header{}, err
+// finalizeMergeOutputs renames every worker's files to merge_<id> in range
+// order and folds the per-worker indexes into compactIndex with final IDs.
+func (m *Compact) finalizeMergeOutputs() error {
+	if m.finalized {
+		return nil
 	}
-
-	return offset, header, nil
-}
-
-func (m *Compact) processFile(fileObj *os.File) error {
-	onAppend := func(offset int64, key []byte, header storage.Header, fileID int, reader io.Reader) error {
-		existingEntry, exists := m.dbObj.Index.Lookup(string(key))
-
-		if exists && existingEntry.FileID == fileID && existingEntry.Offset == offset {
-			// This is the live record — read value from reader and write to merge file
-			value := make([]byte, header.ValueSize)
-			if _, err := io.ReadFull(reader, value); err != nil {
+	dir := m.dbObj.Storage.Dir
+	nextID := 0
+	for _, w := range m.writers {
+		base := nextID
+		for seq := 0; seq < w.fileCount(); seq++ {
+			if err := os.Rename(filepath.Join(dir, workerFileName(w.worker, seq, ".dat")), filepath.Join(dir, storage.MergeFileName(nextID))); err != nil {
 				return err
 			}
-
-			newOffset, newHeader, err := m.appendToMergeFile(key, value)
-			if err != nil {
+			if err := os.Rename(filepath.Join(dir, workerFileName(w.worker, seq, ".hint")), filepath.Join(dir, storage.MergeHintFileName(nextID))); err != nil {
 				return err
 			}
-
-			m.compactIndex.Add(string(key), index.Entry{
-				Offset:    newOffset,
-				CRC:       newHeader.CRC,
-				Timestamp: newHeader.Timestamp,
-				KeySize:   newHeader.KeySize,
-				ValueSize: newHeader.ValueSize,
-				FileID:    m.mergeFileId,
-			})
-			return nil
+			nextID++
 		}
-
-		// Stale record — skip value bytes
-		if _, err := io.CopyN(io.Discard, reader, int64(header.ValueSize)); err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return nil
-			}
-			return err
-		}
-		return nil
-	}
-
-	onDelete := func(key []byte, header storage.Header) {
-		// Tombstones are dropped — not written to merge files
-	}
-
-	return m.dbObj.Storage.ScanFile(fileObj, false, onAppend, onDelete)
-}
-
-func (m *Compact) deleteOldFiles() error {
-	for i := 0; i <= m.maxFileId; i++ {
-		path := filepath.Join(m.dbObj.Storage.Dir, strconv.Itoa(i)+".dat")
-		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-			return err
+		for key, source := range w.sources {
+			entry, _ := w.compactIndex.Lookup(key)
+			entry.FileID += base
+			m.compactIndex.Add(key, entry)
+			m.sources[key] = source
 		}
 	}
+	m.mergeFileId = nextID - 1
+	m.finalized = true
 	return nil
 }
 
-func (m *Compact) renameMergeFiles() error {
+// syncMergeFiles fsyncs every merge output and hint so the merged phase can
+// be journaled as the commit point.
+func (m *Compact) syncMergeFiles() error {
 	for i := 0; i <= m.mergeFileId; i++ {
-		src := filepath.Join(m.dbObj.Storage.Dir, fmt.Sprintf("merge_%d.dat", i))
-		dst := filepath.Join(m.dbObj.Storage.Dir, fmt.Sprintf("%d.dat", i))
-		if err := os.Rename(src, dst); err != nil {
-			return err
+		for _, name := range []string{storage.MergeFileName(i), storage.MergeHintFileName(i)} {
+			f, err := os.Open(filepath.Join(m.dbObj.Storage.Dir, name))
+			if err != nil {
+				return err
+			}
+			err = f.Sync()
+			f.Close()
+			if err != nil {
+				return err
+			}
 		}
 	}
-
-	// Update compactIndex file IDs — they currently reference mergeFileId,
-	// but after rename the file IDs are the same numbers, so no change needed.
-	return nil
+	return storage.SyncDir(m.dbObj.Storage.Dir)
 }
 
-func (m *Compact) scanNewFiles() error {
-	onAppend := func(offset int64, key []byte, header storage.Header, fileID int, reader io.Reader) error {
-		m.compactIndex.Add(string(key), index.Entry{
-			Offset:    offset,
-			CRC:       header.CRC,
-			Timestamp: header.Timestamp,
-			KeySize:   header.KeySize,
-			ValueSize: header.ValueSize,
-			FileID:    fileID,
+// mergedSegments describes the renamed merge output for the manifest.
+func (m *Compact) mergedSegments(staleBytes map[int]int64) ([]storage.Segment, error) {
+	segments := make([]storage.Segment, 0, m.mergeFileId+1)
+	for i := 0; i <= m.mergeFileId; i++ {
+		info, err := os.Stat(filepath.Join(m.dbObj.Storage.Dir, strconv.Itoa(i)+".dat"))
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, storage.Segment{
+			ID:            i,
+			Size:          info.Size(),
+			LiveBytes:     info.Size() - staleBytes[i],
+			FormatVersion: storage.SegmentFormatVersion,
+			Hint:          true,
 		})
-		return nil
-	}
-
-	onDelete := func(key []byte, header storage.Header) {
-		m.compactIndex.Remove(string(key))
 	}
-
-	return m.dbObj.Storage.ScanFilesAfter(m.maxFileId, onAppend, onDelete)
+	return segments, nil
 }
 
-func (m *Compact) writeState(status CompactStatus) error {
-	state := mergeState{
-		Status:    string(status),
-		MaxFileId: m.maxFileId,
-	}
-	data, err := json.Marshal(state)
-	if err != nil {
-		return err
-	}
-	return os.WriteFile(filepath.Join(m.dbObj.Storage.Dir, "merge.json"), data, 0644)
-}
-
-func changeActiveFile(lograDb *logra.LograDB, newFileId int) error {
+func rotateActiveFile(lograDb *logra.LograDB) error {
 	lograDb.Mutex.Lock()
 	defer lograDb.Mutex.Unlock()
-	newDataFilePath := filepath.Join(lograDb.Storage.Dir, strconv.Itoa(newFileId)+".dat")
-	datFile, err := os.OpenFile(newDataFilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return err
-	}
-
-	lograDb.Storage.ActiveFile = datFile
-	return nil
+	return lograDb.Storage.SwitchNewDatFile()
 }
 
 // GetSortedFileObjs returns the sorted file objects prepared for compaction.
@@ -299,78 +330,82 @@ func (m *Compact) GetSortedFileObjs() []*os.File {
 	return m.sortedFileObjs
 }
 
-// ProcessFile processes a single file during compaction.
+// ProcessFile processes a single file during compaction. Files processed this
+// way share a single merge writer, as with one worker.
 func (m *Compact) ProcessFile(fileObj *os.File) error {
-	return m.processFile(fileObj)
+	if len(m.writers) == 0 {
+		m.writers = []*mergeWriter{newMergeWriter(m.dbObj.Storage.Dir, 0)}
+	}
+	if err := m.processFile(m.writers[0], fileObj); err != nil {
+		return err
+	}
+	m.fileDone(fileObj)
+	return nil
 }
 
-// CloseMergeFile closes the current merge file.
+// CloseMergeFile closes the merge outputs and the source segments.
 func (m *Compact) CloseMergeFile() {
-	if m.mergeFile != nil {
-		m.mergeFile.Close()
-		m.mergeFile = nil
+	for _, w := range m.writers {
+		w.close()
+	}
+	for _, f := range m.sortedFileObjs {
+		f.Close()
 	}
 }
 
-// DeleteOldFiles removes old dat files up to maxFileId.
-func (m *Compact) DeleteOldFiles() error {
-	return m.deleteOldFiles()
-}
-
-// RenameMergeFiles renames merge files to regular dat files.
-func (m *Compact) RenameMergeFiles() error {
-	return m.renameMergeFiles()
-}
+// Commit installs the merge output. Each step is journaled so a crash can be
+// rolled forward by RecoverIfNeeded. Old segments are replaced by the merge
+// files and the index is reconciled under the write lock, so readers never
+// observe an entry pointing at a removed file.
+func (m *Compact) Commit() error {
+	if err := m.finalizeMergeOutputs(); err != nil {
+		return err
+	}
+	if m.mergeFileId > m.maxFileId {
+		return fmt.Errorf("merge output %d exceeds compacted range %d", m.mergeFileId, m.maxFileId)
+	}
 
-// ScanNewFiles scans files written after maxFileId into compactIndex.
-func (m *Compact) ScanNewFiles() error {
-	return m.scanNewFiles()
-}
+	if err := m.syncMergeFiles(); err != nil {
+		return err
+	}
+	if err := m.writeState(storage.MergePhaseMerged); err != nil {
+		return err
+	}
 
-// SwapAndCleanup swaps the index and cleans up the state file.
-func (m *Compact) SwapAndCleanup() {
-	m.dbObj.SwapIndex(m.compactIndex)
-	m.compactStatus = CompactCompleted
-	os.Remove(filepath.Join(m.dbObj.Storage.Dir, "merge.json"))
-}
+	m.dbObj.Mutex.Lock()
+	defer m.dbObj.Mutex.Unlock()
 
-// RecoverIfNeeded checks for a half-baked merge and cleans up merge files.
-func RecoverIfNeeded(dir string) error {
-	stateFile := filepath.Join(dir, "merge.json")
-	data, err := os.ReadFile(stateFile)
-	if os.IsNotExist(err) {
-		return nil
+	dir := m.dbObj.Storage.Dir
+	if err := storage.DeleteSegmentsUpTo(dir, m.maxFileId); err != nil {
+		return err
+	}
+	if err := m.writeState(storage.MergePhaseOldDeleted); err != nil {
+		return err
 	}
+	if err := storage.RenameMergeFiles(dir, m.mergeFileId+1); err != nil {
+		return err
+	}
+	staleBytes := m.reconcileIndex()
+	segments, err := m.mergedSegments(staleBytes)
 	if err != nil {
 		return err
 	}
-
-	var state mergeState
-	if err := json.Unmarshal(data, &state); err != nil {
-		// Corrupted state file, clean up
-		return cleanupMergeFiles(dir, stateFile)
+	if err := m.dbObj.Storage.InstallMerged(m.maxFileId, segments); err != nil {
+		return err
 	}
-
-	if state.Status != string(CompactCompleted) {
-		return cleanupMergeFiles(dir, stateFile)
+	if err := m.writeState(storage.MergePhaseRenamed); err != nil {
+		return err
 	}
 
-	// Completed state file left behind, just remove it
-	return os.Remove(stateFile)
-}
-
-func cleanupMergeFiles(dir, stateFile string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
+	if err := m.writeState(storage.MergePhaseDone); err != nil {
 		return err
 	}
-	for _, entry := range entries {
-		name := entry.Name()
-		if len(name) > 6 && name[:6] == "merge_" && filepath.Ext(name) == ".dat" {
-			if err := os.Remove(filepath.Join(dir, name)); err != nil {
-				return err
-			}
-		}
-	}
-	return os.Remove(stateFile)
+	m.setStatus(CompactCompleted)
+	return storage.RemoveMergeState(dir)
+}
+
+// RecoverIfNeeded settles a half-finished merge left behind by a crash.
+// logra.Open runs the same recovery automatically.
+func RecoverIfNeeded(dir string) error {
+	return storage.RecoverMerge(dir)
 }
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 
 	"sakthirathinam/logra"
@@ -162,6 +163,92 @@ func TestCompact_Execute_PreservesAllLiveKeys(t *testing.T) {
 	}
 }
 
+func TestCompact_Execute_KeepsBatchedWrites(t *testing.T) {
+	db, path := openTestDB(t)
+
+	db.MultiSet([]logra.KeyValue{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}, {Key: "c", Value: "3"}})
+	db.MultiSet([]logra.KeyValue{{Key: "b", Value: "22"}})
+	db.Delete("c")
+
+	if err := NewCompact(db).Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	records, err := db.MultiGet([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("MultiGet() error = %v", err)
+	}
+	if records[0] == nil || records[0].Value != "1" || records[1] == nil || records[1].Value != "22" || records[2] != nil {
+		t.Errorf("MultiGet() after compaction = %+v %+v %+v", records[0], records[1], records[2])
+	}
+}
+
+func TestCompact_ScanCursorSurvivesCompaction(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+
+	for i := 0; i < 500; i++ {
+		db.Set(keyN(i), valN(i))
+	}
+	for i := 0; i < 500; i += 2 {
+		db.Set(keyN(i), "updated")
+	}
+
+	seen := make(map[string]bool)
+	cursor := db.ScanKeys(0, 100, func(key string) { seen[key] = true })
+	if cursor == 0 {
+		t.Fatal("scan finished in one call")
+	}
+
+	if err := NewCompact(db).Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	for cursor != 0 {
+		cursor = db.ScanKeys(cursor, 100, func(key string) { seen[key] = true })
+	}
+	for i := 0; i < 500; i++ {
+		if !seen[keyN(i)] {
+			t.Fatalf("scan missed %s across compaction", keyN(i))
+		}
+	}
+}
+
+func TestCompact_Execute_KeepsHashes(t *testing.T) {
+	db, path := openTestDB(t)
+
+	for i := 0; i < 50; i++ {
+		db.HSet("h", []logra.FieldValue{{Field: keyN(i), Value: valN(i)}})
+	}
+	db.HDel("h", []string{keyN(0), keyN(1)})
+	db.HSet("h", []logra.FieldValue{{Field: keyN(2), Value: "updated"}})
+	db.HSet("gone", []logra.FieldValue{{Field: "f", Value: "v"}})
+	db.Delete("gone")
+
+	if err := NewCompact(db).Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	if n, _ := db.HLen("h"); n != 48 {
+		t.Fatalf("HLen = %d, want 48", n)
+	}
+	if v, _, _ := db.HGet("h", keyN(2)); v != "updated" {
+		t.Fatalf("HGet = %q, want updated", v)
+	}
+	if db.Type("h") != "hash" || db.Has("gone") {
+		t.Fatal("hash types not preserved by compaction")
+	}
+	if db.Len() != 1 {
+		t.Fatalf("Len = %d, want 1", db.Len())
+	}
+}
+
 func TestCompact_Execute_EmptyDB(t *testing.T) {
 	db, _ := openTestDB(t)
 	defer db.Close()
@@ -224,6 +311,404 @@ func TestCompact_MergeFileRotation(t *testing.T) {
 	}
 }
 
+func TestCompact_Execute_WritesHintsAndManifest(t *testing.T) {
+	db, path := openTestDB(t)
+
+	for i := 0; i < 50; i++ {
+		db.Set(keyN(i), valN(i))
+	}
+	db.Delete(keyN(0))
+
+	c := NewCompact(db)
+	if err := c.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	manifest := db.Storage.Manifest()
+	merged, ok := manifest.Lookup(0)
+	if !ok {
+		t.Fatalf("manifest missing merged segment: %+v", manifest.Segments)
+	}
+	if !merged.Hint || merged.LiveBytes != merged.Size || merged.Size == 0 {
+		t.Errorf("merged segment = %+v, want hint and fully live", merged)
+	}
+	if _, err := os.Stat(filepath.Join(path, storage.HintFileName(0))); err != nil {
+		t.Errorf("hint file missing: %v", err)
+	}
+	last, _ := manifest.Last()
+	if last.ID != db.Storage.ActiveFileID() {
+		t.Errorf("last manifest segment = %d, want active %d", last.ID, db.Storage.ActiveFileID())
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+
+	if db.Has(keyN(0)) {
+		t.Errorf("deleted key %s came back", keyN(0))
+	}
+	for i := 1; i < 50; i++ {
+		rec, err := db.Get(keyN(i))
+		if err != nil || rec.Value != valN(i) {
+			t.Errorf("Get(%s) = %q, %v; want %q", keyN(i), rec.Value, err, valN(i))
+		}
+	}
+}
+
+func populateSegments(t *testing.T, db *logra.LograDB) {
+	t.Helper()
+	bigVal := strings.Repeat("P", 20*1024)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 200; i++ {
+			if err := db.Set(keyN(i), fmt.Sprintf("%d-%s", round, bigVal)); err != nil {
+				t.Fatalf("Set(%s) error = %v", keyN(i), err)
+			}
+		}
+	}
+	for i := 0; i < 200; i += 7 {
+		db.Delete(keyN(i))
+	}
+}
+
+func TestCompact_Execute_ParallelWorkers(t *testing.T) {
+	db, path := openTestDB(t)
+	populateSegments(t, db)
+
+	c := NewCompact(db)
+	c.SetWorkers(4)
+	if len(c.sortedFileObjs) != 0 {
+		t.Fatal("sortedFileObjs should be empty before Prepare")
+	}
+	if err := c.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if len(c.writers) < 2 {
+		t.Fatalf("expected several workers, got %d", len(c.writers))
+	}
+
+	check := func(db *logra.LograDB) {
+		t.Helper()
+		for i := 0; i < 200; i++ {
+			rec, err := db.Get(keyN(i))
+			if i%7 == 0 {
+				if err == nil {
+					t.Errorf("Get(%s) should be deleted", keyN(i))
+				}
+				continue
+			}
+			if err != nil || !strings.HasPrefix(rec.Value, "1-") {
+				t.Errorf("Get(%s) = %.8q, %v; want latest round", keyN(i), rec.Value, err)
+			}
+		}
+	}
+	check(db)
+
+	// Merge outputs are numbered contiguously from 0 ahead of the active file.
+	manifest := db.Storage.Manifest()
+	for i, seg := range manifest.Segments[:len(manifest.Segments)-1] {
+		if seg.ID != i || !seg.Hint {
+			t.Errorf("segment %d = %+v, want merged segment %d", i, seg, i)
+		}
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	check(db)
+}
+
+func TestCompact_Execute_ParallelIsDeterministic(t *testing.T) {
+	layout := func() []storage.Segment {
+		db, _ := openTestDB(t)
+		defer db.Close()
+		populateSegments(t, db)
+
+		c := NewCompact(db)
+		c.SetWorkers(3)
+		if err := c.Execute(); err != nil {
+			t.Fatalf("Execute() error = %v", err)
+		}
+		return db.Storage.Manifest().Segments
+	}
+
+	first, second := layout(), layout()
+	if len(first) != len(second) {
+		t.Fatalf("segment count differs: %d vs %d", len(first), len(second))
+	}
+	for i := range first {
+		if first[i].ID != second[i].ID || first[i].Size != second[i].Size {
+			t.Errorf("segment %d differs: %+v vs %+v", i, first[i], second[i])
+		}
+	}
+}
+
+func TestCompact_Execute_ParallelWithConcurrentWrites(t *testing.T) {
+	db, path := openTestDB(t)
+	populateSegments(t, db)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			db.Set(keyN(i%200), "live")
+		}
+	}()
+
+	c := NewCompact(db)
+	c.SetWorkers(4)
+	err := c.Execute()
+	close(stop)
+	<-done
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	want := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		if rec, err := db.Get(keyN(i)); err == nil {
+			want[keyN(i)] = rec.Value
+		} else if db.Has(keyN(i)) {
+			t.Errorf("Get(%s) error = %v but key is indexed", keyN(i), err)
+		}
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	for key, value := range want {
+		if rec, err := db.Get(key); err != nil || rec.Value != value {
+			t.Errorf("Get(%s) after reopen = %.8q, %v; want %.8q", key, rec.Value, err, value)
+		}
+	}
+}
+
+func TestSplitRanges(t *testing.T) {
+	dir := t.TempDir()
+	var files []*os.File
+	for i, size := range []int{100, 100, 100, 100, 400, 100} {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("%d.dat", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(strings.Repeat("x", size)))
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	tests := []struct {
+		workers int
+		want    []int
+	}{
+		{workers: 1, want: []int{6}},
+		{workers: 2, want: []int{5, 1}},
+		{workers: 3, want: []int{3, 2, 1}},
+		{workers: 10, want: []int{1, 1, 1, 1, 1, 1}},
+	}
+	for _, tt := range tests {
+		ranges := splitRanges(files, tt.workers)
+		var got []int
+		total := 0
+		for _, r := range ranges {
+			got = append(got, len(r))
+			total += len(r)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("splitRanges(%d) sizes = %v, want %v", tt.workers, got, tt.want)
+		}
+		if total != len(files) {
+			t.Errorf("splitRanges(%d) covers %d files, want %d", tt.workers, total, len(files))
+		}
+	}
+}
+
+func TestCompact_Execute_Canceled(t *testing.T) {
+	db, path := openTestDB(t)
+
+	for i := 0; i < 20; i++ {
+		db.Set(keyN(i), valN(i))
+	}
+
+	c := NewCompact(db)
+	c.Cancel()
+	if err := c.Execute(); err != ErrCanceled {
+		t.Fatalf("Execute() error = %v, want %v", err, ErrCanceled)
+	}
+	if p := c.Progress(); p.Status != CompactCanceled {
+		t.Errorf("Progress().Status = %q, want %q", p.Status, CompactCanceled)
+	}
+
+	entries, _ := os.ReadDir(path)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), "merge") {
+			t.Errorf("merge artifact left behind: %s", e.Name())
+		}
+	}
+
+	// A canceled run must not block the next one.
+	if err := NewCompact(db).Execute(); err != nil {
+		t.Fatalf("second Execute() error = %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if rec, err := db.Get(keyN(i)); err != nil || rec.Value != valN(i) {
+			t.Errorf("Get(%s) = %q, %v; want %q", keyN(i), rec.Value, err, valN(i))
+		}
+	}
+	db.Close()
+}
+
+func TestCompact_Progress(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+
+	for i := 0; i < 20; i++ {
+		db.Set(keyN(i), valN(i))
+	}
+
+	c := NewCompact(db)
+	if err := c.Execute(); err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	p := c.Progress()
+	if p.Status != CompactCompleted {
+		t.Errorf("Status = %q, want %q", p.Status, CompactCompleted)
+	}
+	if p.FilesTotal == 0 || p.FilesDone != p.FilesTotal || p.BytesDone != p.BytesTotal {
+		t.Errorf("Progress() = %+v, want all files and bytes done", p)
+	}
+}
+
+func TestCompact_Commit_KeepsWritesMadeAfterCopy(t *testing.T) {
+	db, path := openTestDB(t)
+
+	db.Set("kept", "v1")
+	db.Set("updated", "v1")
+	db.Set("removed", "v1")
+
+	c := NewCompact(db)
+	if err := c.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	for _, f := range c.GetSortedFileObjs() {
+		if err := c.ProcessFile(f); err != nil {
+			t.Fatalf("ProcessFile() error = %v", err)
+		}
+	}
+	c.CloseMergeFile()
+
+	// All three keys were copied; now race the install with new writes.
+	db.Set("updated", "v2")
+	db.Delete("removed")
+
+	if err := c.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	check := func(db *logra.LograDB) {
+		t.Helper()
+		if rec, err := db.Get("kept"); err != nil || rec.Value != "v1" {
+			t.Errorf("Get(kept) = %q, %v; want v1", rec.Value, err)
+		}
+		if rec, err := db.Get("updated"); err != nil || rec.Value != "v2" {
+			t.Errorf("Get(updated) = %q, %v; want v2", rec.Value, err)
+		}
+		if db.Has("removed") {
+			t.Error("key removed should stay deleted")
+		}
+	}
+
+	check(db)
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+	check(db)
+}
+
+func TestCompact_Execute_ConcurrentReadsAndWrites(t *testing.T) {
+	db, path := openTestDB(t)
+
+	bigVal := strings.Repeat("X", 10*1024)
+	for i := 0; i < 300; i++ {
+		db.Set(keyN(i), bigVal)
+	}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			n := i % 300
+			if n%3 == 0 {
+				db.Delete(keyN(n))
+			} else {
+				db.Set(keyN(n), valN(n))
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			db.Get(keyN(i % 300))
+		}
+	}()
+
+	c := NewCompact(db)
+	err := c.Execute()
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+
+	want := make(map[string]string)
+	for i := 0; i < 300; i++ {
+		rec, err := db.Get(keyN(i))
+		if err != nil {
+			if db.Has(keyN(i)) {
+				t.Errorf("Get(%s) error = %v but key is indexed", keyN(i), err)
+			}
+			continue
+		}
+		want[keyN(i)] = rec.Value
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+
+	if db.Index.Len() != len(want) {
+		t.Errorf("Len() after reopen = %d, want %d", db.Index.Len(), len(want))
+	}
+	for key, value := range want {
+		rec, err := db.Get(key)
+		if err != nil {
+			t.Errorf("Get(%s) after reopen error = %v", key, err)
+			continue
+		}
+		if rec.Value != value {
+			t.Errorf("Get(%s) after reopen value length = %d, want %d", key, len(rec.Value), len(value))
+		}
+	}
+}
+
 func TestRecoverIfNeeded_NoStateFile(t *testing.T) {
 	dir := t.TempDir()
 	if err := RecoverIfNeeded(dir); err != nil {
@@ -234,7 +719,7 @@ func TestRecoverIfNeeded_NoStateFile(t *testing.T) {
 func TestRecoverIfNeeded_InProgressState(t *testing.T) {
 	dir := t.TempDir()
 
-	state := mergeState{Status: string(CompactInProgress), MaxFileId: 2}
+	state := storage.MergeState{Status: storage.MergePhaseMerging, MaxFileId: 2}
 	data, _ := json.Marshal(state)
 	os.WriteFile(filepath.Join(dir, "merge.json"), data, 0644)
 
@@ -257,7 +742,7 @@ func TestRecoverIfNeeded_InProgressState(t *testing.T) {
 func TestRecoverIfNeeded_CompletedState(t *testing.T) {
 	dir := t.TempDir()
 
-	state := mergeState{Status: string(CompactCompleted), MaxFileId: 1}
+	state := storage.MergeState{Status: storage.MergePhaseDone, MaxFileId: 1}
 	data, _ := json.Marshal(state)
 	os.WriteFile(filepath.Join(dir, "merge.json"), data, 0644)
 
@@ -287,6 +772,62 @@ func TestRecoverIfNeeded_CorruptedStateFile(t *testing.T) {
 	}
 }
 
+func TestOpen_RollsForwardAfterOldFilesDeleted(t *testing.T) {
+	db, path := openTestDB(t)
+
+	for i := 0; i < 20; i++ {
+		db.Set(keyN(i), valN(i))
+	}
+	db.Delete(keyN(0))
+
+	c := NewCompact(db)
+	if err := c.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	for _, f := range c.GetSortedFileObjs() {
+		if err := c.ProcessFile(f); err != nil {
+			t.Fatalf("ProcessFile() error = %v", err)
+		}
+	}
+	c.CloseMergeFile()
+
+	// Simulate a crash right after the old segments were removed: the merge
+	// files are the only copy of the data and must not be discarded.
+	if err := c.finalizeMergeOutputs(); err != nil {
+		t.Fatalf("finalizeMergeOutputs() error = %v", err)
+	}
+	if err := c.syncMergeFiles(); err != nil {
+		t.Fatalf("syncMergeFiles() error = %v", err)
+	}
+	if err := c.writeState(storage.MergePhaseMerged); err != nil {
+		t.Fatalf("writeState() error = %v", err)
+	}
+	if err := storage.DeleteSegmentsUpTo(path, c.maxFileId); err != nil {
+		t.Fatalf("DeleteSegmentsUpTo() error = %v", err)
+	}
+	db.Close()
+
+	db = reopenTestDB(t, path)
+	defer db.Close()
+
+	if db.Has(keyN(0)) {
+		t.Errorf("deleted key %s came back", keyN(0))
+	}
+	for i := 1; i < 20; i++ {
+		rec, err := db.Get(keyN(i))
+		if err != nil {
+			t.Errorf("Get(%s) error = %v", keyN(i), err)
+			continue
+		}
+		if rec.Value != valN(i) {
+			t.Errorf("Get(%s) = %q, want %q", keyN(i), rec.Value, valN(i))
+		}
+	}
+	if _, found, _ := storage.ReadMergeState(path); found {
+		t.Error("merge.json should have been removed by recovery")
+	}
+}
+
 // helpers
 
 func keyN(i int) string {
@@ -297,6 +838,8 @@ func valN(i int) string {
 	return fmt.Sprintf("val-%d", i)
 }
 
+// dirSize sums the data files in dir; hint files and the manifest are
+// metadata and excluded.
 func dirSize(t *testing.T, dir string) int64 {
 	t.Helper()
 	var size int64
@@ -305,6 +848,9 @@ func dirSize(t *testing.T, dir string) int64 {
 		t.Fatalf("ReadDir error: %v", err)
 	}
 	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".dat" {
+			continue
+		}
 		info, err := e.Info()
 		if err != nil {
 			continue
@@ -0,0 +1,223 @@
+package compact
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"sakthirathinam/logra/internal/index"
+	"sakthirathinam/logra/internal/storage"
+)
+
+// mergeWriter owns the merge output of one worker. It writes to temporary
+// merge_<worker>_<seq>.dat/.hint files; once every worker is done the files
+// are renumbered to merge_<id> in range order, so file IDs do not depend on
+// how the workers were scheduled. Entries in compactIndex carry the local seq
+// as FileID until then.
+type mergeWriter struct {
+	dir          string
+	worker       int
+	seq          int
+	mergeFile    *os.File
+	hintFile     *os.File
+	compactIndex *index.Index
+	sources      map[string]index.Entry
+
+	// err is the first write failure. ScanFile only logs callback errors, so
+	// it is kept here and returned once the scan is over.
+	err error
+}
+
+func newMergeWriter(dir string, worker int) *mergeWriter {
+	return &mergeWriter{
+		dir:          dir,
+		worker:       worker,
+		seq:          -1,
+		compactIndex: index.New(),
+		sources:      make(map[string]index.Entry),
+	}
+}
+
+func workerFileName(worker, seq int, ext string) string {
+	return fmt.Sprintf("merge_%d_%d%s", worker, seq, ext)
+}
+
+// fileCount is the number of merge files this writer produced.
+func (w *mergeWriter) fileCount() int {
+	return w.seq + 1
+}
+
+func (w *mergeWriter) createMergeFile() error {
+	seq := w.seq + 1
+	f, err := os.OpenFile(filepath.Join(w.dir, workerFileName(w.worker, seq, ".dat")), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	hf, err := os.OpenFile(filepath.Join(w.dir, workerFileName(w.worker, seq, ".hint")), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.close()
+	w.mergeFile = f
+	w.hintFile = hf
+	w.seq = seq
+	return nil
+}
+
+func (w *mergeWriter) close() {
+	if w.mergeFile != nil {
+		w.mergeFile.Close()
+		w.mergeFile = nil
+	}
+	if w.hintFile != nil {
+		w.hintFile.Close()
+		w.hintFile = nil
+	}
+}
+
+func (w *mergeWriter) rotateMergeFileIfNeeded() error {
+	info, err := w.mergeFile.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() >= storage.MaxDataFileSize*4 {
+		return w.createMergeFile()
+	}
+	return nil
+}
+
+// appendToMergeFile writes the record and its hint entry, returning where the
+// record landed. The merge file may rotate afterwards, so callers must use the
+// returned seq rather than w.seq.
+func (w *mergeWriter) appendToMergeFile(key, value []byte) (int, int64, storage.Header, error) {
+	if w.mergeFile == nil {
+		if err := w.createMergeFile(); err != nil {
+			return 0, 0, storage.Header{}, err
+		}
+	}
+
+	seq := w.seq
+	offset, err := w.mergeFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+
+	data := storage.EncodeRecord(key, value)
+	writer := bufio.NewWriter(w.mergeFile)
+	if _, err := writer.Write(data); err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+
+	header, err := storage.DecodeHeader(data[:storage.HeaderSize])
+	if err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+
+	if _, err := w.hintFile.Write(storage.EncodeHint(offset, key, header)); err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+
+	if err := w.rotateMergeFileIfNeeded(); err != nil {
+		return 0, 0, storage.Header{}, err
+	}
+
+	return seq, offset, header, nil
+}
+
+func (m *Compact) processFile(w *mergeWriter, fileObj *os.File) error {
+	onAppend := func(offset int64, key []byte, header storage.Header, fileID int, reader io.Reader) error {
+		m.dbObj.Mutex.RLock()
+		existingEntry, exists := m.dbObj.Index.Lookup(string(key))
+		m.dbObj.Mutex.RUnlock()
+
+		if exists && existingEntry.FileID == fileID && existingEntry.Offset == offset && w.err == nil {
+			// This is the live record — read value from reader and write to merge file
+			value := make([]byte, header.ValueSize)
+			if _, err := io.ReadFull(reader, value); err != nil {
+				w.err = err
+				return err
+			}
+
+			seq, newOffset, newHeader, err := w.appendToMergeFile(key, value)
+			if err != nil {
+				w.err = err
+				return err
+			}
+
+			w.compactIndex.Add(string(key), index.Entry{
+				Offset:    newOffset,
+				CRC:       newHeader.CRC,
+				Timestamp: newHeader.Timestamp,
+				KeySize:   newHeader.KeySize,
+				ValueSize: newHeader.ValueSize,
+				FileID:    seq,
+			})
+			w.sources[string(key)] = existingEntry
+			return nil
+		}
+
+		// Stale record — skip value bytes
+		if _, err := io.CopyN(io.Discard, reader, int64(header.ValueSize)); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return err
+		}
+		return nil
+	}
+
+	onDelete := func(key []byte, header storage.Header) {
+		// Tombstones are dropped — not written to merge files
+	}
+
+	if err := m.dbObj.Storage.ScanFile(fileObj, false, onAppend, onDelete); err != nil {
+		return err
+	}
+	return w.err
+}
+
+// splitRanges divides the sorted segments into at most n contiguous ranges of
+// roughly equal size. The split only depends on the segment sizes, so the
+// same input always yields the same ranges.
+func splitRanges(files []*os.File, n int) [][]*os.File {
+	if n < 1 {
+		n = 1
+	}
+	if n > len(files) {
+		n = len(files)
+	}
+	if n <= 1 {
+		return [][]*os.File{files}
+	}
+
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, f := range files {
+		if info, err := f.Stat(); err == nil {
+			sizes[i] = info.Size()
+		}
+		total += sizes[i]
+	}
+
+	ranges := make([][]*os.File, 0, n)
+	start := 0
+	var acc int64
+	for i := range files {
+		acc += sizes[i]
+		remainingFiles := len(files) - i - 1
+		remainingRanges := n - len(ranges) - 1
+		// Close the range once it holds its share of bytes, keeping at least
+		// one file for every range still to come.
+		if remainingRanges > 0 && (acc*int64(n) >= total*int64(len(ranges)+1) || remainingFiles == remainingRanges) {
+			ranges = append(ranges, files[start:i+1])
+			start = i + 1
+		}
+	}
+	return append(ranges, files[start:])
+}
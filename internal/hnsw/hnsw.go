@@ -0,0 +1,259 @@
+// Package hnsw is the approximate nearest-neighbour index of a vector set: a
+// Hierarchical Navigable Small World graph, after Malkov and Yashunin. Each
+// node is linked to its closest neighbours on every level it reaches, and a
+// search walks greedily down from the sparse top levels to the full bottom
+// one.
+package hnsw
+
+import (
+	"container/heap"
+	"math"
+	"math/rand/v2"
+	"sort"
+)
+
+// Result is a vector found by a search, with its distance to the query.
+type Result struct {
+	ID   string
+	Dist float32
+}
+
+type node struct {
+	id      string
+	vec     []float32
+	links   [][]*node // neighbours on each level the node reaches
+	deleted bool
+}
+
+type Graph struct {
+	m              int // links per node above level 0, twice that at level 0
+	efConstruction int // candidates considered when linking a new node
+	dist           func(a, b []float32) float32
+	nodes          map[string]*node
+	entry          *node
+	rng            *rand.Rand
+}
+
+// New returns an empty graph comparing vectors with dist, where smaller is
+// closer.
+func New(m, efConstruction int, dist func(a, b []float32) float32) *Graph {
+	return &Graph{
+		m:              max(m, 2),
+		efConstruction: max(efConstruction, 1),
+		dist:           dist,
+		nodes:          make(map[string]*node),
+		rng:            rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+func (g *Graph) Len() int {
+	return len(g.nodes)
+}
+
+func (g *Graph) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * g.m
+	}
+	return g.m
+}
+
+// randomLevel picks the top level of a new node, each level 1/m as likely as
+// the one below.
+func (g *Graph) randomLevel() int {
+	return int(-math.Log(1-g.rng.Float64()) / math.Log(float64(g.m)))
+}
+
+// candidate is a node with its distance to the vector being searched for.
+type candidate struct {
+	n *node
+	d float32
+}
+
+// queue is a heap of candidates, nearest first or, with far set, farthest
+// first.
+type queue struct {
+	items []candidate
+	far   bool
+}
+
+func (q *queue) Len() int { return len(q.items) }
+func (q *queue) Less(i, j int) bool {
+	if q.far {
+		return q.items[i].d > q.items[j].d
+	}
+	return q.items[i].d < q.items[j].d
+}
+func (q *queue) Swap(i, j int) { q.items[i], q.items[j] = q.items[j], q.items[i] }
+func (q *queue) Push(x any)    { q.items = append(q.items, x.(candidate)) }
+func (q *queue) Pop() any {
+	c := q.items[len(q.items)-1]
+	q.items = q.items[:len(q.items)-1]
+	return c
+}
+
+// Insert adds the vector id, replacing any vector of the same id.
+func (g *Graph) Insert(id string, vec []float32) {
+	g.Delete(id)
+	n := &node{id: id, vec: vec, links: make([][]*node, g.randomLevel()+1)}
+	g.nodes[id] = n
+	if g.entry == nil {
+		g.entry = n
+		return
+	}
+
+	ep := candidate{g.entry, g.dist(vec, g.entry.vec)}
+	top := len(g.entry.links) - 1
+	for l := top; l >= len(n.links); l-- {
+		ep = g.greedy(vec, ep, l)
+	}
+	eps := []candidate{ep}
+	for l := min(top, len(n.links)-1); l >= 0; l-- {
+		eps = g.searchLayer(vec, eps, g.efConstruction, l)
+		for _, c := range eps[:min(len(eps), g.m)] {
+			n.links[l] = append(n.links[l], c.n)
+			g.link(c.n, n, l)
+		}
+	}
+	if len(n.links) > len(g.entry.links) {
+		g.entry = n
+	}
+}
+
+// link adds to to the neighbours of from on level l, keeping only the
+// closest if from has too many.
+func (g *Graph) link(from, to *node, l int) {
+	from.links[l] = append(from.links[l], to)
+	if len(from.links[l]) > g.maxLinks(l) {
+		from.links[l] = g.closest(from, from.links[l], g.maxLinks(l))
+	}
+}
+
+// closest returns the up to k live nodes of ns closest to n.
+func (g *Graph) closest(n *node, ns []*node, k int) []*node {
+	cands := make([]candidate, 0, len(ns))
+	for _, o := range ns {
+		if !o.deleted {
+			cands = append(cands, candidate{o, g.dist(n.vec, o.vec)})
+		}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].d < cands[j].d })
+	out := make([]*node, 0, min(k, len(cands)))
+	for _, c := range cands[:min(k, len(cands))] {
+		out = append(out, c.n)
+	}
+	return out
+}
+
+// greedy moves from ep to ever closer neighbours on level l until none is
+// closer to vec.
+func (g *Graph) greedy(vec []float32, ep candidate, l int) candidate {
+	for moved := true; moved; {
+		moved = false
+		for _, nb := range ep.n.links[l] {
+			if nb.deleted {
+				continue
+			}
+			if d := g.dist(vec, nb.vec); d < ep.d {
+				ep, moved = candidate{nb, d}, true
+			}
+		}
+	}
+	return ep
+}
+
+// searchLayer returns the up to ef nodes closest to vec found on level l
+// from eps, nearest first.
+func (g *Graph) searchLayer(vec []float32, eps []candidate, ef, l int) []candidate {
+	visited := make(map[*node]bool)
+	near := &queue{}
+	found := &queue{far: true}
+	for _, ep := range eps {
+		visited[ep.n] = true
+		heap.Push(near, ep)
+		heap.Push(found, ep)
+	}
+	for near.Len() > 0 {
+		c := heap.Pop(near).(candidate)
+		if found.Len() >= ef && c.d > found.items[0].d {
+			break
+		}
+		for _, nb := range c.n.links[l] {
+			if visited[nb] || nb.deleted {
+				continue
+			}
+			visited[nb] = true
+			d := g.dist(vec, nb.vec)
+			if found.Len() < ef || d < found.items[0].d {
+				heap.Push(near, candidate{nb, d})
+				heap.Push(found, candidate{nb, d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+	out := found.items
+	sort.Slice(out, func(i, j int) bool { return out[i].d < out[j].d })
+	return out
+}
+
+// Delete removes the vector id and reports whether it was there. Its
+// neighbours are linked to each other in its place.
+func (g *Graph) Delete(id string) bool {
+	n := g.nodes[id]
+	if n == nil {
+		return false
+	}
+	delete(g.nodes, id)
+	n.deleted = true
+	for l, links := range n.links {
+		for _, nb := range links {
+			if nb.deleted {
+				continue
+			}
+			cands := append([]*node(nil), nb.links[l]...)
+			for _, o := range links {
+				if o != nb && len(o.links) > l && !contains(cands, o) {
+					cands = append(cands, o)
+				}
+			}
+			nb.links[l] = g.closest(nb, cands, g.maxLinks(l))
+		}
+	}
+	if g.entry == n {
+		g.entry = nil
+		for _, o := range g.nodes {
+			if g.entry == nil || len(o.links) > len(g.entry.links) || len(o.links) == len(g.entry.links) && o.id < g.entry.id {
+				g.entry = o
+			}
+		}
+	}
+	return true
+}
+
+func contains(ns []*node, n *node) bool {
+	for _, o := range ns {
+		if o == n {
+			return true
+		}
+	}
+	return false
+}
+
+// Search returns the up to k vectors closest to vec, nearest first,
+// considering at least ef candidates on the bottom level.
+func (g *Graph) Search(vec []float32, k, ef int) []Result {
+	if g.entry == nil || k <= 0 {
+		return nil
+	}
+	ep := candidate{g.entry, g.dist(vec, g.entry.vec)}
+	for l := len(g.entry.links) - 1; l > 0; l-- {
+		ep = g.greedy(vec, ep, l)
+	}
+	found := g.searchLayer(vec, []candidate{ep}, max(ef, k), 0)
+	results := make([]Result, 0, min(k, len(found)))
+	for _, c := range found[:min(k, len(found))] {
+		results = append(results, Result{ID: c.n.id, Dist: c.d})
+	}
+	return results
+}
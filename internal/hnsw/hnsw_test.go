@@ -0,0 +1,128 @@
+package hnsw
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func l2(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func randomVectors(rng *rand.Rand, n, dim int) [][]float32 {
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		vecs[i] = make([]float32, dim)
+		for j := range vecs[i] {
+			vecs[i][j] = rng.Float32()
+		}
+	}
+	return vecs
+}
+
+// exact returns the ids of the k vectors closest to q.
+func exact(vecs map[string][]float32, q []float32, k int) []string {
+	ids := make([]string, 0, len(vecs))
+	for id := range vecs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return l2(vecs[ids[i]], q) < l2(vecs[ids[j]], q) })
+	return ids[:min(k, len(ids))]
+}
+
+// recall returns the share of the exact k nearest neighbours of the queries
+// that the graph finds.
+func recall(g *Graph, vecs map[string][]float32, queries [][]float32, k int) float64 {
+	hits := 0
+	for _, q := range queries {
+		found := make(map[string]bool)
+		for _, r := range g.Search(q, k, 64) {
+			found[r.ID] = true
+		}
+		for _, id := range exact(vecs, q, k) {
+			if found[id] {
+				hits++
+			}
+		}
+	}
+	return float64(hits) / float64(len(queries)*k)
+}
+
+func TestGraph_Recall(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(7, 7))
+	g := New(16, 100, l2)
+	vecs := make(map[string][]float32)
+	for i, v := range randomVectors(rng, 2000, 16) {
+		id := strconv.Itoa(i)
+		vecs[id] = v
+		g.Insert(id, v)
+	}
+	queries := randomVectors(rng, 50, 16)
+	if r := recall(g, vecs, queries, 10); r < 0.9 {
+		t.Fatalf("recall %.2f, want at least 0.9", r)
+	}
+
+	results := g.Search(vecs["42"], 3, 10)
+	if len(results) != 3 || results[0].ID != "42" || results[0].Dist != 0 {
+		t.Fatalf("a vector is its own nearest neighbour, got %+v", results)
+	}
+	for i := 1; i < len(results); i++ {
+		if results[i].Dist < results[i-1].Dist {
+			t.Fatalf("results not nearest first: %+v", results)
+		}
+	}
+}
+
+func TestGraph_Delete(t *testing.T) {
+	t.Parallel()
+
+	rng := rand.New(rand.NewPCG(3, 3))
+	g := New(8, 50, l2)
+	vecs := make(map[string][]float32)
+	for i, v := range randomVectors(rng, 1000, 8) {
+		id := strconv.Itoa(i)
+		vecs[id] = v
+		g.Insert(id, v)
+	}
+	for i := 0; i < 1000; i += 2 {
+		id := strconv.Itoa(i)
+		if !g.Delete(id) {
+			t.Fatalf("Delete(%s) = false", id)
+		}
+		delete(vecs, id)
+	}
+	if g.Delete("0") {
+		t.Fatal("deleted a missing vector")
+	}
+	if g.Len() != 500 {
+		t.Fatalf("Len() = %d, want 500", g.Len())
+	}
+
+	queries := randomVectors(rng, 50, 8)
+	for _, q := range queries {
+		for _, r := range g.Search(q, 10, 64) {
+			if _, ok := vecs[r.ID]; !ok {
+				t.Fatalf("found deleted vector %s", r.ID)
+			}
+		}
+	}
+	if r := recall(g, vecs, queries, 10); r < 0.85 {
+		t.Fatalf("recall after deletes %.2f, want at least 0.85", r)
+	}
+
+	for id := range vecs {
+		g.Delete(id)
+	}
+	if results := g.Search(queries[0], 5, 10); len(results) != 0 {
+		t.Fatalf("empty graph found %+v", results)
+	}
+}
@@ -1,5 +1,7 @@
 package index
 
+import "sort"
+
 type Entry struct {
 	Offset    int64
 	CRC       uint32
@@ -9,47 +11,146 @@ type Entry struct {
 	FileID    int
 }
 
+// Keys are spread over a fixed number of buckets by the top bits of their
+// hash, so bucket order is hash order. Scan cursors are positions in that
+// order, which only depend on the keys themselves.
+const (
+	bucketBits  = 10
+	numBuckets  = 1 << bucketBits
+	bucketShift = 64 - bucketBits
+)
+
 type Index struct {
-	entries map[string]Entry
+	buckets [numBuckets]map[string]Entry
+	n       int
 }
 
 func New() *Index {
-	return &Index{
-		entries: make(map[string]Entry),
+	return &Index{}
+}
+
+// hash is 64-bit FNV-1a.
+func hash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
 	}
+	return h
+}
+
+// Hash returns the position of key in Scan order.
+func Hash(key string) uint64 {
+	return hash(key)
+}
+
+func (idx *Index) bucket(key string) *map[string]Entry {
+	return &idx.buckets[hash(key)>>bucketShift]
 }
 
 func (idx *Index) Add(key string, entry Entry) {
-	idx.entries[key] = entry
+	b := idx.bucket(key)
+	if *b == nil {
+		*b = make(map[string]Entry)
+	}
+	if _, exists := (*b)[key]; !exists {
+		idx.n++
+	}
+	(*b)[key] = entry
 }
 
 func (idx *Index) Lookup(key string) (Entry, bool) {
-	entry, exists := idx.entries[key]
+	entry, exists := (*idx.bucket(key))[key]
 	return entry, exists
 }
 
 func (idx *Index) Has(key string) bool {
-	_, exists := idx.entries[key]
+	_, exists := (*idx.bucket(key))[key]
 	return exists
 }
 
 func (idx *Index) Remove(key string) bool {
-	_, exists := idx.entries[key]
+	b := idx.bucket(key)
+	_, exists := (*b)[key]
 	if !exists {
 		return false
 	}
-	delete(idx.entries, key)
+	delete(*b, key)
+	idx.n--
 	return true
 }
 
 func (idx *Index) Keys() []string {
-	keys := make([]string, 0, len(idx.entries))
-	for k := range idx.entries {
-		keys = append(keys, k)
-	}
+	keys := make([]string, 0, idx.n)
+	idx.Range(func(key string, entry Entry) bool {
+		keys = append(keys, key)
+		return true
+	})
 	return keys
 }
 
 func (idx *Index) Len() int {
-	return len(idx.entries)
+	return idx.n
+}
+
+// Range calls fn for every entry until fn returns false. fn must not modify
+// the index.
+func (idx *Index) Range(fn func(key string, entry Entry) bool) {
+	for _, b := range idx.buckets {
+		for key, entry := range b {
+			if !fn(key, entry) {
+				return
+			}
+		}
+	}
+}
+
+// Scan calls fn for the entries from cursor on, in hash order, until fn
+// reported at least count of them as counted, and returns the cursor to
+// continue from, or 0 once the whole index was visited. Keys with the same hash are always visited
+// together. A key present from the first call to the last is visited at least
+// once however the index changes in between, as its position only depends on
+// its hash.
+func (idx *Index) Scan(cursor uint64, count int, fn func(key string, entry Entry) bool) uint64 {
+	type item struct {
+		hash uint64
+		key  string
+	}
+
+	if count < 1 {
+		count = 1
+	}
+	visited := 0
+	for b := cursor >> bucketShift; b < numBuckets; b++ {
+		items := make([]item, 0, len(idx.buckets[b]))
+		for key := range idx.buckets[b] {
+			if h := hash(key); h >= cursor {
+				items = append(items, item{h, key})
+			}
+		}
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].hash != items[j].hash {
+				return items[i].hash < items[j].hash
+			}
+			return items[i].key < items[j].key
+		})
+
+		for i, it := range items {
+			if visited >= count && it.hash != items[i-1].hash {
+				return it.hash
+			}
+			if fn(it.key, idx.buckets[b][it.key]) {
+				visited++
+			}
+		}
+
+		if b+1 == numBuckets {
+			break
+		}
+		cursor = (b + 1) << bucketShift
+		if visited >= count {
+			return cursor
+		}
+	}
+	return 0
 }
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"sort"
 	"testing"
 )
@@ -291,3 +292,124 @@ func TestIndex_Len(t *testing.T) {
 		t.Errorf("Len() after remove = %d, want 2", idx.Len())
 	}
 }
+
+func TestIndex_Range(t *testing.T) {
+	t.Parallel()
+
+	idx := New()
+	for i := 0; i < 10; i++ {
+		idx.Add(string(rune('a'+i)), Entry{Offset: int64(i)})
+	}
+
+	seen := 0
+	idx.Range(func(key string, entry Entry) bool {
+		seen++
+		return true
+	})
+	if seen != 10 {
+		t.Errorf("Range() visited %d keys, want 10", seen)
+	}
+
+	seen = 0
+	idx.Range(func(key string, entry Entry) bool {
+		seen++
+		return seen < 3
+	})
+	if seen != 3 {
+		t.Errorf("Range() did not stop early, visited %d keys", seen)
+	}
+}
+
+func TestIndex_Scan(t *testing.T) {
+	t.Parallel()
+
+	t.Run("visits every key once", func(t *testing.T) {
+		t.Parallel()
+		idx := New()
+		for i := 0; i < 5000; i++ {
+			idx.Add(fmt.Sprintf("key%d", i), Entry{Offset: int64(i)})
+		}
+
+		seen := make(map[string]int)
+		cursor, calls := uint64(0), 0
+		for {
+			cursor = idx.Scan(cursor, 100, func(key string, entry Entry) bool {
+				seen[key]++
+				return true
+			})
+			calls++
+			if cursor == 0 {
+				break
+			}
+		}
+		if len(seen) != 5000 {
+			t.Errorf("Scan() visited %d keys, want 5000", len(seen))
+		}
+		for key, n := range seen {
+			if n != 1 {
+				t.Errorf("Scan() visited %q %d times", key, n)
+			}
+		}
+		if calls < 10 {
+			t.Errorf("Scan() took %d calls, want at least 10 with count 100", calls)
+		}
+	})
+
+	t.Run("stable under mutation", func(t *testing.T) {
+		t.Parallel()
+		idx := New()
+		for i := 0; i < 1000; i++ {
+			idx.Add(fmt.Sprintf("key%d", i), Entry{})
+		}
+
+		seen := make(map[string]bool)
+		cursor, round := uint64(0), 0
+		for {
+			cursor = idx.Scan(cursor, 50, func(key string, entry Entry) bool {
+				seen[key] = true
+				return true
+			})
+			if cursor == 0 {
+				break
+			}
+			// Churn between calls: add new keys and drop ones that are
+			// not part of the original set.
+			round++
+			idx.Add(fmt.Sprintf("new%d", round), Entry{})
+			idx.Remove(fmt.Sprintf("new%d", round-1))
+		}
+		for i := 0; i < 1000; i++ {
+			if !seen[fmt.Sprintf("key%d", i)] {
+				t.Fatalf("Scan() missed key%d", i)
+			}
+		}
+	})
+
+	t.Run("empty index", func(t *testing.T) {
+		t.Parallel()
+		if cursor := New().Scan(0, 10, func(string, Entry) bool { return true }); cursor != 0 {
+			t.Errorf("Scan() on empty index = %d, want 0", cursor)
+		}
+	})
+
+	t.Run("only counted entries use up count", func(t *testing.T) {
+		t.Parallel()
+		idx := New()
+		for i := 0; i < 5000; i++ {
+			idx.Add(fmt.Sprintf("key%d", i), Entry{})
+		}
+
+		// One entry in 50 counts, as a user key among internal records
+		counted := 0
+		idx.Scan(0, 10, func(key string, entry Entry) bool {
+			if hash(key)%50 == 0 {
+				counted++
+				return true
+			}
+			return false
+		})
+		if counted < 10 {
+			t.Errorf("Scan() counted %d entries, want at least 10", counted)
+		}
+	})
+}
@@ -0,0 +1,525 @@
+// Package jsonpath holds JSON documents in memory and finds and changes
+// values in them by the subset of JSONPath that RedisJSON commands take:
+//
+//	$            the root
+//	.name        a member of an object, also ['name'] or ["name"]
+//	[n]          an element of an array; negative n counts from the end
+//	.* [*]       every member or element
+//	..name ..*   the same, at any depth
+//
+// A path without the leading "$" is a legacy path, such as "." or "a.b[0]",
+// which RedisJSON answers with a single value rather than every match.
+//
+// Documents are made of nil, bool, json.Number, string, *Array and *Object,
+// which keeps its members in order.
+package jsonpath
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Object is a JSON object whose members keep the order they were added in.
+type Object struct {
+	keys   []string
+	values map[string]any
+}
+
+func NewObject() *Object {
+	return &Object{values: make(map[string]any)}
+}
+
+// Get returns the member key.
+func (o *Object) Get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+// Set adds the member key, or replaces it in place.
+func (o *Object) Set(key string, v any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// Delete removes the member key.
+func (o *Object) Delete(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the member names in order.
+func (o *Object) Keys() []string {
+	return o.keys
+}
+
+// Array is a JSON array, by reference so that it can grow in place.
+type Array struct {
+	Elems []any
+}
+
+// Parse reads a single JSON value.
+func Parse(s string) (any, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	v, err := parseValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("trailing characters after JSON value")
+	}
+	return v, nil
+}
+
+func parseValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, errors.New("unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := NewObject()
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := parseValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(key.(string), v)
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := &Array{Elems: []any{}}
+		for dec.More() {
+			v, err := parseValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr.Elems = append(arr.Elems, v)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	// Numbers are kept as text, but must still fit a float64
+	if n, ok := tok.(json.Number); ok {
+		if _, err := strconv.ParseFloat(string(n), 64); err != nil {
+			return nil, fmt.Errorf("number %s is out of range", n)
+		}
+	}
+	return tok, nil
+}
+
+// Marshal renders v as compact JSON.
+func Marshal(v any) string {
+	var b strings.Builder
+	write(&b, v)
+	return b.String()
+}
+
+func write(b *strings.Builder, v any) {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		b.WriteString(string(v))
+	case string:
+		writeString(b, v)
+	case *Array:
+		b.WriteByte('[')
+		for i, e := range v.Elems {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			write(b, e)
+		}
+		b.WriteByte(']')
+	case *Object:
+		b.WriteByte('{')
+		for i, k := range v.keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeString(b, k)
+			b.WriteByte(':')
+			write(b, v.values[k])
+		}
+		b.WriteByte('}')
+	}
+}
+
+func writeString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// Clone returns a deep copy of v.
+func Clone(v any) any {
+	switch v := v.(type) {
+	case *Array:
+		arr := &Array{Elems: make([]any, len(v.Elems))}
+		for i, e := range v.Elems {
+			arr.Elems[i] = Clone(e)
+		}
+		return arr
+	case *Object:
+		obj := NewObject()
+		for _, k := range v.keys {
+			obj.Set(k, Clone(v.values[k]))
+		}
+		return obj
+	}
+	return v
+}
+
+// TypeName names the type of v as JSON.TYPE does.
+func TypeName(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		if isInteger(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case *Array:
+		return "array"
+	}
+	return "object"
+}
+
+func isInteger(n json.Number) bool {
+	return !strings.ContainsAny(string(n), ".eE")
+}
+
+// Add returns a + b, an integer if both are and the sum fits.
+func Add(a, b json.Number) (json.Number, error) {
+	if isInteger(a) && isInteger(b) {
+		x, err1 := a.Int64()
+		y, err2 := b.Int64()
+		if err1 == nil && err2 == nil && !(y > 0 && x > math.MaxInt64-y) && !(y < 0 && x < math.MinInt64-y) {
+			return json.Number(strconv.FormatInt(x+y, 10)), nil
+		}
+	}
+	x, err1 := a.Float64()
+	y, err2 := b.Float64()
+	sum := x + y
+	if err1 != nil || err2 != nil || math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", errors.New("result is not a number")
+	}
+	s := strconv.FormatFloat(sum, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".e") {
+		s += ".0"
+	}
+	return json.Number(s), nil
+}
+
+// segment is one step of a path.
+type segment struct {
+	wildcard bool
+	name     string
+	index    int
+	isIndex  bool
+	descend  bool // match at any depth below, as with ".."
+}
+
+// Path is a parsed path.
+type Path struct {
+	segs   []segment
+	Legacy bool
+}
+
+// IsLegacy reports whether s is a legacy path rather than a JSONPath.
+func IsLegacy(s string) bool {
+	return !strings.HasPrefix(s, "$")
+}
+
+// ParsePath parses a JSONPath or legacy path.
+func ParsePath(s string) (*Path, error) {
+	p := &Path{Legacy: IsLegacy(s)}
+	src := s
+	if p.Legacy {
+		switch {
+		case s == "" || s == ".":
+			s = "$"
+		case s[0] == '.' || s[0] == '[':
+			s = "$" + s
+		default:
+			s = "$." + s
+		}
+	}
+	bad := fmt.Errorf("invalid JSONPath %q", src)
+
+	for i := 1; i < len(s); {
+		var seg segment
+		switch s[i] {
+		case '.':
+			i++
+			if i < len(s) && s[i] == '.' {
+				seg.descend = true
+				i++
+			}
+			switch {
+			case i < len(s) && s[i] == '*':
+				seg.wildcard = true
+				i++
+			case i < len(s) && s[i] == '[' && seg.descend:
+				n, err := parseBracket(s[i:], &seg)
+				if err != nil {
+					return nil, bad
+				}
+				i += n
+			default:
+				j := i
+				for j < len(s) && s[j] != '.' && s[j] != '[' {
+					j++
+				}
+				if j == i {
+					return nil, bad
+				}
+				seg.name = s[i:j]
+				i = j
+			}
+		case '[':
+			n, err := parseBracket(s[i:], &seg)
+			if err != nil {
+				return nil, bad
+			}
+			i += n
+		default:
+			return nil, bad
+		}
+		p.segs = append(p.segs, seg)
+	}
+	return p, nil
+}
+
+// parseBracket parses "[*]", "[n]", "['name']" or "[\"name\"]" at the start
+// of s into seg and returns its length.
+func parseBracket(s string, seg *segment) (int, error) {
+	end := strings.IndexByte(s, ']')
+	if len(s) > 1 && (s[1] == '\'' || s[1] == '"') {
+		// A quoted name may hold "]"
+		close := strings.IndexByte(s[2:], s[1])
+		if close < 0 || 2+close+1 >= len(s) || s[2+close+1] != ']' {
+			return 0, errors.New("unterminated name")
+		}
+		seg.name = s[2 : 2+close]
+		return 2 + close + 2, nil
+	}
+	if end < 0 {
+		return 0, errors.New("unterminated bracket")
+	}
+	inner := strings.TrimSpace(s[1:end])
+	if inner == "*" {
+		seg.wildcard = true
+		return end + 1, nil
+	}
+	n, err := strconv.Atoi(inner)
+	if err != nil {
+		return 0, err
+	}
+	seg.index, seg.isIndex = n, true
+	return end + 1, nil
+}
+
+// IsRoot reports whether p is the root itself.
+func (p *Path) IsRoot() bool {
+	return len(p.segs) == 0
+}
+
+// Match is a value a path found, with where it is in the document.
+type Match struct {
+	Value  any
+	parent any // *Object or *Array holding the value, or nil for the root
+	key    string
+	index  int
+}
+
+// Find returns the values p matches in root, in document order.
+func (p *Path) Find(root any) []Match {
+	return find(p.segs, root)
+}
+
+func find(segs []segment, root any) []Match {
+	cur := []Match{{Value: root}}
+	for _, seg := range segs {
+		var next []Match
+		for _, m := range cur {
+			if !seg.descend {
+				next = append(next, seg.step(m.Value)...)
+				continue
+			}
+			walk(m, func(m Match) {
+				next = append(next, seg.step(m.Value)...)
+			})
+		}
+		cur = next
+	}
+	return cur
+}
+
+// walk calls fn for m and every value below it.
+func walk(m Match, fn func(Match)) {
+	fn(m)
+	for _, child := range children(m.Value) {
+		walk(child, fn)
+	}
+}
+
+func children(v any) []Match {
+	var ms []Match
+	switch v := v.(type) {
+	case *Object:
+		for _, k := range v.keys {
+			ms = append(ms, Match{Value: v.values[k], parent: v, key: k})
+		}
+	case *Array:
+		for i, e := range v.Elems {
+			ms = append(ms, Match{Value: e, parent: v, index: i})
+		}
+	}
+	return ms
+}
+
+// step returns the children of v that seg selects.
+func (seg segment) step(v any) []Match {
+	if seg.wildcard {
+		return children(v)
+	}
+	switch v := v.(type) {
+	case *Object:
+		if e, ok := v.values[seg.name]; ok && !seg.isIndex {
+			return []Match{{Value: e, parent: v, key: seg.name}}
+		}
+	case *Array:
+		i := seg.index
+		if i < 0 {
+			i += len(v.Elems)
+		}
+		if seg.isIndex && i >= 0 && i < len(v.Elems) {
+			return []Match{{Value: v.Elems[i], parent: v, index: i}}
+		}
+	}
+	return nil
+}
+
+// IsRoot reports whether m is the whole document.
+func (m Match) IsRoot() bool {
+	return m.parent == nil
+}
+
+// Replace puts v where m was found, in the document *root.
+func Replace(root *any, m Match, v any) {
+	switch parent := m.parent.(type) {
+	case nil:
+		*root = v
+	case *Object:
+		parent.Set(m.key, v)
+	case *Array:
+		parent.Elems[m.index] = v
+	}
+}
+
+// Set puts a copy of v at every match of p in *root and returns how many
+// values it set. If p matches nothing but ends in a member name, the member
+// is added to every object its parent path matches.
+func Set(root *any, p *Path, v any) int {
+	matches := p.Find(*root)
+	for _, m := range matches {
+		Replace(root, m, Clone(v))
+	}
+	if len(matches) > 0 || len(p.segs) == 0 {
+		return len(matches)
+	}
+	last := p.segs[len(p.segs)-1]
+	if last.wildcard || last.isIndex || last.descend {
+		return 0
+	}
+	n := 0
+	for _, m := range find(p.segs[:len(p.segs)-1], *root) {
+		if obj, ok := m.Value.(*Object); ok {
+			obj.Set(last.name, Clone(v))
+			n++
+		}
+	}
+	return n
+}
+
+// Delete removes every match of p from root, which must not be matched
+// itself, and returns how many values it removed.
+func Delete(root any, p *Path) int {
+	n := 0
+	indexes := make(map[*Array][]int)
+	for _, m := range p.Find(root) {
+		switch parent := m.parent.(type) {
+		case *Object:
+			if _, ok := parent.values[m.key]; ok {
+				parent.Delete(m.key)
+				n++
+			}
+		case *Array:
+			indexes[parent] = append(indexes[parent], m.index)
+		}
+	}
+	// Remove from the end so the indexes still hold
+	for arr, idx := range indexes {
+		sort.Sort(sort.Reverse(sort.IntSlice(idx)))
+		for i, j := range idx {
+			if i > 0 && j == idx[i-1] {
+				continue
+			}
+			arr.Elems = append(arr.Elems[:j], arr.Elems[j+1:]...)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,139 @@
+package jsonpath
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testDoc = `{"a":1,"b":{"a":[1,2.5,"x"],"c":null},"d":[{"a":true},{"e":"<&>"}]}`
+
+func mustParse(t *testing.T, s string) any {
+	t.Helper()
+	v, err := Parse(s)
+	if err != nil {
+		t.Fatalf("Parse(%q): %v", s, err)
+	}
+	return v
+}
+
+func TestParseMarshal(t *testing.T) {
+	t.Parallel()
+
+	for _, s := range []string{testDoc, `[]`, `{}`, `"a\"\\\n\u0001"`, `-1.5e10`, `null`} {
+		if got := Marshal(mustParse(t, s)); got != s {
+			t.Errorf("Marshal(Parse(%q)) = %q", s, got)
+		}
+	}
+	if got := Marshal(mustParse(t, ` { "b" : 1 , "a" : [ 2 ] } `)); got != `{"b":1,"a":[2]}` {
+		t.Errorf("got %s, want members in order", got)
+	}
+	for _, s := range []string{``, `{`, `[1,]`, `{"a"}`, `1 2`, `nul`, `{'a':1}`, `1e400`, `[-1e309]`} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("Parse(%q) succeeded", s)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+
+	doc := mustParse(t, testDoc)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"$", `[` + testDoc + `]`},
+		{"$.a", `[1]`},
+		{"$.b.a[1]", `[2.5]`},
+		{"$.b.a[-1]", `["x"]`},
+		{"$['b']['c']", `[null]`},
+		{`$["b"].a[*]`, `[1,2.5,"x"]`},
+		{"$.d[*].a", `[true]`},
+		{"$..a", `[1,[1,2.5,"x"],true]`},
+		{"$.b.*", `[[1,2.5,"x"],null]`},
+		{"$..[0]", `[1,{"a":true}]`},
+		{"$.missing", `[]`},
+		{"$.b.a[3]", `[]`},
+		{"b.a", `[[1,2.5,"x"]]`},
+		{".d[1].e", `["<&>"]`},
+	}
+	for _, tt := range tests {
+		p, err := ParsePath(tt.path)
+		if err != nil {
+			t.Fatalf("ParsePath(%q): %v", tt.path, err)
+		}
+		got := &Array{Elems: []any{}}
+		for _, m := range p.Find(doc) {
+			got.Elems = append(got.Elems, m.Value)
+		}
+		if s := Marshal(got); s != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.path, s, tt.want)
+		}
+	}
+
+	for _, path := range []string{"$.", "$a", "$[", "$[x]", "$['a'", "a..", "$..", "$.a[1"} {
+		if _, err := ParsePath(path); err == nil {
+			t.Errorf("ParsePath(%q) succeeded", path)
+		}
+	}
+}
+
+func TestSetDelete(t *testing.T) {
+	t.Parallel()
+
+	apply := func(path string, fn func(doc *any, p *Path) int) (string, int) {
+		doc := mustParse(t, testDoc)
+		p, err := ParsePath(path)
+		if err != nil {
+			t.Fatalf("ParsePath(%q): %v", path, err)
+		}
+		n := fn(&doc, p)
+		return Marshal(doc), n
+	}
+	set := func(doc *any, p *Path) int { return Set(doc, p, &Array{Elems: []any{}}) }
+	del := func(doc *any, p *Path) int { return Delete(*doc, p) }
+
+	if got, n := apply("$.b.c", set); n != 1 || got != `{"a":1,"b":{"a":[1,2.5,"x"],"c":[]},"d":[{"a":true},{"e":"<&>"}]}` {
+		t.Errorf("set existing: %d %s", n, got)
+	}
+	if got, n := apply("$.d[*].z", set); n != 2 || got != `{"a":1,"b":{"a":[1,2.5,"x"],"c":null},"d":[{"a":true,"z":[]},{"e":"<&>","z":[]}]}` {
+		t.Errorf("set new members: %d %s", n, got)
+	}
+	if _, n := apply("$.x.y", set); n != 0 {
+		t.Errorf("set under a missing parent: %d", n)
+	}
+	if got, n := apply("$", set); n != 1 || got != `[]` {
+		t.Errorf("set root: %d %s", n, got)
+	}
+
+	if got, n := apply("$..a", del); n != 3 || got != `{"b":{"c":null},"d":[{},{"e":"<&>"}]}` {
+		t.Errorf("delete recursive: %d %s", n, got)
+	}
+	if got, n := apply("$.b.a[*]", del); n != 3 || got != `{"a":1,"b":{"a":[],"c":null},"d":[{"a":true},{"e":"<&>"}]}` {
+		t.Errorf("delete elements: %d %s", n, got)
+	}
+	if got, n := apply("$.b.a[-1]", del); n != 1 || got != `{"a":1,"b":{"a":[1,2.5],"c":null},"d":[{"a":true},{"e":"<&>"}]}` {
+		t.Errorf("delete last element: %d %s", n, got)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct{ a, b, want string }{
+		{"1", "2", "3"},
+		{"1", "-5", "-4"},
+		{"1.5", "1", "2.5"},
+		{"1", "1.0", "2.0"},
+		{"9223372036854775807", "1", "9.223372036854776e+18"},
+	}
+	for _, tt := range tests {
+		got, err := Add(mustParse(t, tt.a).(json.Number), mustParse(t, tt.b).(json.Number))
+		if err != nil || string(got) != tt.want {
+			t.Errorf("Add(%s, %s) = %s, %v; want %s", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	if _, err := Add("1e308", "1e308"); err == nil {
+		t.Error("Add overflowing to infinity succeeded")
+	}
+}
@@ -0,0 +1,206 @@
+// Package skiplist is the ordered index of a sorted set: members ordered by
+// score, then by member, with the rank of every node, as in Redis's zskiplist.
+package skiplist
+
+import "math/rand/v2"
+
+const (
+	maxLevel = 32
+	// p is the chance of a node reaching each next level
+	p = 0.25
+)
+
+type Element struct {
+	Member string
+	Score  float64
+}
+
+// Less orders elements by score, then by member.
+func (e Element) Less(o Element) bool {
+	if e.Score != o.Score {
+		return e.Score < o.Score
+	}
+	return e.Member < o.Member
+}
+
+type Node struct {
+	Element
+	backward *Node
+	levels   []level
+}
+
+type level struct {
+	forward *Node
+	// span is how many nodes forward skips, counting the one it lands on.
+	span int
+}
+
+// Next returns the following node, or nil at the end.
+func (n *Node) Next() *Node {
+	return n.levels[0].forward
+}
+
+// Prev returns the preceding node, or nil at the start.
+func (n *Node) Prev() *Node {
+	return n.backward
+}
+
+type List struct {
+	head   *Node
+	tail   *Node
+	length int
+	level  int
+}
+
+func New() *List {
+	return &List{head: &Node{levels: make([]level, maxLevel)}, level: 1}
+}
+
+func (l *List) Len() int {
+	return l.length
+}
+
+func randomLevel() int {
+	lvl := 1
+	for lvl < maxLevel && rand.Float64() < p {
+		lvl++
+	}
+	return lvl
+}
+
+// Insert adds e, which must not be in the list already.
+func (l *List) Insert(e Element) {
+	var update [maxLevel]*Node
+	var rank [maxLevel]int
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.levels[i].forward != nil && x.levels[i].forward.Less(e) {
+			rank[i] += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+
+	lvl := randomLevel()
+	if lvl > l.level {
+		for i := l.level; i < lvl; i++ {
+			rank[i] = 0
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+		l.level = lvl
+	}
+
+	x = &Node{Element: e, levels: make([]level, lvl)}
+	for i := 0; i < lvl; i++ {
+		x.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = x
+		x.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := lvl; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+
+	if update[0] != l.head {
+		x.backward = update[0]
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x
+	} else {
+		l.tail = x
+	}
+	l.length++
+}
+
+// Delete removes e and reports whether it was in the list.
+func (l *List) Delete(e Element) bool {
+	var update [maxLevel]*Node
+
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && x.levels[i].forward.Less(e) {
+			x = x.levels[i].forward
+		}
+		update[i] = x
+	}
+	x = x.levels[0].forward
+	if x == nil || x.Element != e {
+		return false
+	}
+
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].forward == x {
+			update[i].levels[i].span += x.levels[i].span - 1
+			update[i].levels[i].forward = x.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if x.levels[0].forward != nil {
+		x.levels[0].forward.backward = x.backward
+	} else {
+		l.tail = x.backward
+	}
+	for l.level > 1 && l.head.levels[l.level-1].forward == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+// Seek returns the rank of the first element for which before is false, or
+// Len if there is none. before must be true for a prefix of the list, such as
+// the elements below a score.
+func (l *List) Seek(before func(Element) bool) int {
+	rank := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && before(x.levels[i].forward.Element) {
+			rank += x.levels[i].span
+			x = x.levels[i].forward
+		}
+	}
+	return rank
+}
+
+// Rank returns the 0-based rank of e, or -1 if it is not in the list.
+func (l *List) Rank(e Element) int {
+	rank := l.Seek(func(o Element) bool { return o.Less(e) })
+	if n := l.At(rank); n != nil && n.Element == e {
+		return rank
+	}
+	return -1
+}
+
+// At returns the node at the 0-based rank, or nil if it is out of range.
+func (l *List) At(rank int) *Node {
+	if rank < 0 || rank >= l.length {
+		return nil
+	}
+	traversed := 0
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// First and Last return the ends of the list, or nil if it is empty.
+func (l *List) First() *Node {
+	return l.head.levels[0].forward
+}
+
+func (l *List) Last() *Node {
+	return l.tail
+}
@@ -0,0 +1,71 @@
+package skiplist
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestList_MatchesSortedSlice(t *testing.T) {
+	l := New()
+	var want []Element
+	r := rand.New(rand.NewPCG(1, 2))
+
+	for i := 0; i < 2000; i++ {
+		if len(want) > 0 && r.IntN(3) == 0 {
+			j := r.IntN(len(want))
+			if !l.Delete(want[j]) {
+				t.Fatalf("Delete(%v) = false", want[j])
+			}
+			want = append(want[:j], want[j+1:]...)
+			continue
+		}
+		e := Element{Member: fmt.Sprintf("m%d", i), Score: float64(r.IntN(50))}
+		l.Insert(e)
+		want = append(want, e)
+	}
+	sort.Slice(want, func(i, j int) bool { return want[i].Less(want[j]) })
+
+	if l.Len() != len(want) {
+		t.Fatalf("Len() = %d, want %d", l.Len(), len(want))
+	}
+	i := 0
+	for n := l.First(); n != nil; n = n.Next() {
+		if n.Element != want[i] {
+			t.Fatalf("node %d = %v, want %v", i, n.Element, want[i])
+		}
+		i++
+	}
+	for rank, e := range want {
+		if got := l.Rank(e); got != rank {
+			t.Fatalf("Rank(%v) = %d, want %d", e, got, rank)
+		}
+		if n := l.At(rank); n == nil || n.Element != e {
+			t.Fatalf("At(%d) = %v, want %v", rank, n, e)
+		}
+	}
+	if l.Last().Element != want[len(want)-1] || l.Last().Next() != nil {
+		t.Fatal("Last() is not the largest element")
+	}
+	if l.Rank(Element{Member: "missing"}) != -1 {
+		t.Fatal("Rank of a missing element should be -1")
+	}
+}
+
+func TestList_Seek(t *testing.T) {
+	l := New()
+	for i := 0; i < 100; i++ {
+		l.Insert(Element{Member: fmt.Sprintf("m%03d", i), Score: float64(i / 10)})
+	}
+	rank := l.Seek(func(e Element) bool { return e.Score < 5 })
+	if rank != 50 {
+		t.Fatalf("Seek(score < 5) = %d, want 50", rank)
+	}
+	if n := l.At(rank); n.Member != "m050" || n.Prev().Member != "m049" {
+		t.Fatalf("unexpected node at %d: %v", rank, n.Element)
+	}
+	if rank := l.Seek(func(e Element) bool { return true }); rank != 100 {
+		t.Fatalf("Seek past the end = %d, want 100", rank)
+	}
+}
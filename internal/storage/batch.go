@@ -0,0 +1,167 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+/*
+**
+Batches
+A batch is written as a marker record followed by its member records in one
+write call. The marker uses a reserved key and its value describes the batch:
+
+	[Count (4)] [Length (8)] [CRC32 of the member records (4)]
+
+Scanning applies the members only when all Length bytes are present and the
+checksum matches, so a torn write never exposes part of a batch.
+**
+*/
+var BatchMarkerKey = []byte("\x00logra:batch")
+
+const batchMarkerValueSize = 16
+
+// MaxBatchSize is the largest batch body, in bytes. Scan takes a longer one
+// for a torn write, so AppendBatch refuses to write it.
+const MaxBatchSize = MaxDataFileSize * 64
+
+var (
+	errBadBatch      = errors.New("invalid batch")
+	ErrBatchTooLarge = errors.New("batch too large")
+)
+
+// BatchRecord is one member of a batch. An empty Value is a tombstone.
+type BatchRecord struct {
+	Key   []byte
+	Value []byte
+}
+
+func IsBatchMarker(key []byte) bool {
+	return bytes.Equal(key, BatchMarkerKey)
+}
+
+// EncodeBatch returns the bytes of a whole batch, the offset of every member
+// relative to the start of the batch, and the member headers. It fails with
+// ErrBatchTooLarge if the members take more than MaxBatchSize bytes.
+func EncodeBatch(records []BatchRecord) ([]byte, []int64, []Header, error) {
+	size := 0
+	for _, rec := range records {
+		size += HeaderSize + len(rec.Key) + len(rec.Value)
+	}
+	if size > MaxBatchSize {
+		return nil, nil, nil, fmt.Errorf("%w: %d bytes, at most %d", ErrBatchTooLarge, size, MaxBatchSize)
+	}
+
+	body := bytes.Buffer{}
+	offsets := make([]int64, len(records))
+	headers := make([]Header, len(records))
+	for i, rec := range records {
+		data := EncodeRecord(rec.Key, rec.Value)
+		header, err := DecodeHeader(data[:HeaderSize])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		offsets[i] = int64(body.Len())
+		headers[i] = header
+		body.Write(data)
+	}
+
+	markerValue := make([]byte, batchMarkerValueSize)
+	binary.LittleEndian.PutUint32(markerValue[0:4], uint32(len(records)))
+	binary.LittleEndian.PutUint64(markerValue[4:12], uint64(body.Len()))
+	binary.LittleEndian.PutUint32(markerValue[12:16], crc32.ChecksumIEEE(body.Bytes()))
+	marker := EncodeRecord(BatchMarkerKey, markerValue)
+
+	shift := int64(len(marker))
+	for i := range offsets {
+		offsets[i] += shift
+	}
+	return append(marker, body.Bytes()...), offsets, headers, nil
+}
+
+// AppendBatch writes all records to the active file with a single write and
+// returns their offsets and headers. Every record lands in the same file;
+// rotation, if due, happens afterwards.
+func (s *Storage) AppendBatch(records []BatchRecord) ([]int64, []Header, error) {
+	data, offsets, headers, err := EncodeBatch(records)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	start, err := s.ActiveFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.ActiveFile.Write(data); err != nil {
+		return nil, nil, err
+	}
+	for i := range offsets {
+		offsets[i] += start
+	}
+
+	activeFileInfo, err := s.ActiveFile.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if activeFileInfo.Size() >= MaxDataFileSize {
+		if err := s.SwitchNewDatFile(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return offsets, headers, nil
+}
+
+// readBatch consumes the body of a batch whose marker value has been read and
+// replays its members. It returns the number of body bytes consumed, or
+// errBadBatch when the batch is torn or corrupt.
+func readBatch(reader io.Reader, markerValue []byte, start int64, fileID int, onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error, onDelete func(key []byte, header Header)) (int64, error) {
+	if len(markerValue) != batchMarkerValueSize {
+		return 0, errBadBatch
+	}
+	count := binary.LittleEndian.Uint32(markerValue[0:4])
+	length := binary.LittleEndian.Uint64(markerValue[4:12])
+	crc := binary.LittleEndian.Uint32(markerValue[12:16])
+	if length > MaxBatchSize {
+		return 0, errBadBatch
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, errBadBatch
+		}
+		return 0, err
+	}
+	if crc32.ChecksumIEEE(body) != crc {
+		return 0, errBadBatch
+	}
+
+	pos := 0
+	for i := uint32(0); i < count; i++ {
+		if len(body)-pos < HeaderSize {
+			return 0, errBadBatch
+		}
+		header, err := DecodeHeader(body[pos : pos+HeaderSize])
+		if err != nil {
+			return 0, err
+		}
+		size := int(header.RecordSize())
+		if len(body)-pos < size {
+			return 0, errBadBatch
+		}
+		key := body[pos+HeaderSize : pos+HeaderSize+int(header.KeySize)]
+		value := body[pos+HeaderSize+int(header.KeySize) : pos+size]
+
+		if header.ValueSize == 0 {
+			onDelete(key, header)
+		} else if err := onAppend(start+int64(pos), key, header, fileID, bytes.NewReader(value)); err != nil {
+			fmt.Printf("Error in scan function%s: for this key %s\n", err, string(key))
+		}
+		pos += size
+	}
+	return int64(length), nil
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorage_AppendBatch(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+
+	s.Append([]byte("solo"), []byte("1"))
+	records := []BatchRecord{
+		{Key: []byte("a"), Value: []byte("alpha")},
+		{Key: []byte("b"), Value: []byte("beta")},
+		{Key: []byte("solo"), Value: nil},
+	}
+	offsets, headers, err := s.AppendBatch(records)
+	if err != nil {
+		t.Fatalf("AppendBatch() error = %v", err)
+	}
+
+	for i, rec := range records[:2] {
+		got, err := s.ReadAtFile(offsets[i], headers[i], s.ActiveFileID())
+		if err != nil {
+			t.Fatalf("ReadAtFile(%s) error = %v", rec.Key, err)
+		}
+		if string(got.Value) != string(rec.Value) {
+			t.Errorf("ReadAtFile(%s) = %q, want %q", rec.Key, got.Value, rec.Value)
+		}
+	}
+
+	appended := map[string]int64{}
+	var deleted []string
+	err = s.Scan(func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error {
+		appended[string(key)] = offset
+		return nil
+	}, func(key []byte, header Header) {
+		deleted = append(deleted, string(key))
+	})
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if _, ok := appended[string(BatchMarkerKey)]; ok {
+		t.Error("batch marker must not be reported to callbacks")
+	}
+	if appended["a"] != offsets[0] || appended["b"] != offsets[1] {
+		t.Errorf("Scan() offsets = %v, want a=%d b=%d", appended, offsets[0], offsets[1])
+	}
+	if len(deleted) != 1 || deleted[0] != "solo" {
+		t.Errorf("Scan() deletes = %v, want [solo]", deleted)
+	}
+}
+
+func TestStorage_Scan_DropsCorruptBatch(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s.Append([]byte("before"), []byte("1"))
+	offsets, _, _ := s.AppendBatch([]BatchRecord{{Key: []byte("a"), Value: []byte("alpha")}})
+	name := s.ActiveFile.Name()
+	s.Close()
+
+	// Flip a byte inside the batch body so its checksum no longer matches.
+	f, _ := os.OpenFile(name, os.O_RDWR, 0644)
+	f.WriteAt([]byte{'X'}, offsets[0]+HeaderSize+1)
+	f.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	defer s.Close()
+
+	var keys []string
+	s.Scan(func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error {
+		keys = append(keys, string(key))
+		return nil
+	}, func(key []byte, header Header) {})
+	if len(keys) != 1 || keys[0] != "before" {
+		t.Errorf("Scan() keys = %v, want [before]", keys)
+	}
+}
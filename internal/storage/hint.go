@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+/*
+**
+Hint file
+Written by compaction next to each merged segment so startup can rebuild the
+index without reading values. One entry per live record:
+
+	[CRC (4)] [KeySize (4)] [ValueSize (4)] [Timestamp (8)] [Offset (8)] [Key]
+
+CRC, sizes and timestamp are copied from the data record header.
+**
+*/
+const HintHeaderSize = 28
+
+var errTruncatedHint = errors.New("truncated hint file")
+
+func HintFileName(id int) string {
+	return fmt.Sprintf("%d.hint", id)
+}
+
+func MergeHintFileName(id int) string {
+	return fmt.Sprintf("merge_%d.hint", id)
+}
+
+func EncodeHint(offset int64, key []byte, header Header) []byte {
+	buf := make([]byte, HintHeaderSize+len(key))
+	binary.LittleEndian.PutUint32(buf[0:4], header.CRC)
+	binary.LittleEndian.PutUint32(buf[4:8], header.KeySize)
+	binary.LittleEndian.PutUint32(buf[8:12], header.ValueSize)
+	binary.LittleEndian.PutUint64(buf[12:20], uint64(header.Timestamp))
+	binary.LittleEndian.PutUint64(buf[20:28], uint64(offset))
+	copy(buf[HintHeaderSize:], key)
+	return buf
+}
+
+// ScanHint replays a hint file through onAppend. The reader handed to the
+// callback is always empty: hint files carry no values.
+func ScanHint(file io.Reader, fileID int, onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error) error {
+	reader := bufio.NewReader(file)
+	empty := bytes.NewReader(nil)
+	for {
+		buf := make([]byte, HintHeaderSize)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if err == io.ErrUnexpectedEOF {
+				return errTruncatedHint
+			}
+			return err
+		}
+
+		header := Header{
+			CRC:       binary.LittleEndian.Uint32(buf[0:4]),
+			KeySize:   binary.LittleEndian.Uint32(buf[4:8]),
+			ValueSize: binary.LittleEndian.Uint32(buf[8:12]),
+			Timestamp: int64(binary.LittleEndian.Uint64(buf[12:20])),
+		}
+		offset := int64(binary.LittleEndian.Uint64(buf[20:28]))
+
+		key := make([]byte, header.KeySize)
+		if _, err := io.ReadFull(reader, key); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return errTruncatedHint
+			}
+			return err
+		}
+
+		if err := onAppend(offset, key, header, fileID, empty); err != nil {
+			fmt.Printf("Error in scan function%s: for this key %s\n", err, string(key))
+		}
+	}
+}
+
+// scanHintFile reads the hint for fileID. false means the hint was missing
+// or unreadable and the data file must be scanned instead. Entries are
+// buffered so a truncated hint never leaves a half-applied segment behind.
+func (s *Storage) scanHintFile(fileID int, onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error) bool {
+	f, err := os.Open(s.Dir + "/" + HintFileName(fileID))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	type hintEntry struct {
+		offset int64
+		key    []byte
+		header Header
+	}
+	var entries []hintEntry
+	collect := func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error {
+		entries = append(entries, hintEntry{offset: offset, key: key, header: header})
+		return nil
+	}
+	if err := ScanHint(f, fileID, collect); err != nil {
+		return false
+	}
+
+	empty := bytes.NewReader(nil)
+	for _, e := range entries {
+		if err := onAppend(e.offset, e.key, e.header, fileID, empty); err != nil {
+			fmt.Printf("Error in scan function%s: for this key %s\n", err, string(e.key))
+		}
+	}
+	return true
+}
@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+/*
+**
+MANIFEST
+JSON list of the live segments in id order. It is the source of truth for
+which <id>.dat files belong to the database; the last segment is the active
+file. A directory without a MANIFEST (created by an older release) is
+globbed once and the result is written out.
+**
+*/
+const (
+	ManifestFile         = "MANIFEST"
+	ManifestVersion      = 1
+	SegmentFormatVersion = 1
+)
+
+type Segment struct {
+	ID            int   `json:"id"`
+	Size          int64 `json:"size"`
+	LiveBytes     int64 `json:"liveBytes"`
+	FormatVersion int   `json:"formatVersion"`
+	Hint          bool  `json:"hint"`
+}
+
+type Manifest struct {
+	Version  int       `json:"version"`
+	Segments []Segment `json:"segments"`
+}
+
+func LoadManifest(dir string) (*Manifest, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, true, err
+	}
+	return &m, true, nil
+}
+
+// BuildManifest derives a manifest from the *.dat files present in dir.
+func BuildManifest(dir string) (*Manifest, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{Version: ManifestVersion}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".dat" {
+			continue
+		}
+		id, err := ParseFileIDFromName(entry.Name())
+		if err != nil {
+			continue
+		}
+		m.Segments = append(m.Segments, statSegment(dir, id))
+	}
+	sort.Slice(m.Segments, func(i, j int) bool {
+		return m.Segments[i].ID < m.Segments[j].ID
+	})
+	return m, nil
+}
+
+// statSegment describes an on-disk segment whose live bytes are unknown,
+// so every byte is assumed live until a compaction measures it.
+func statSegment(dir string, id int) Segment {
+	seg := Segment{ID: id, FormatVersion: SegmentFormatVersion}
+	if info, err := os.Stat(filepath.Join(dir, strconv.Itoa(id)+".dat")); err == nil {
+		seg.Size = info.Size()
+		seg.LiveBytes = info.Size()
+	}
+	seg.Hint = fileExists(filepath.Join(dir, HintFileName(id)))
+	return seg
+}
+
+func (m *Manifest) Save(dir string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, ManifestFile, data)
+}
+
+func (m *Manifest) Last() (Segment, bool) {
+	if len(m.Segments) == 0 {
+		return Segment{}, false
+	}
+	return m.Segments[len(m.Segments)-1], true
+}
+
+func (m *Manifest) Lookup(id int) (Segment, bool) {
+	for _, seg := range m.Segments {
+		if seg.ID == id {
+			return seg, true
+		}
+	}
+	return Segment{}, false
+}
+
+// ReplaceMerged drops segments 0..maxFileId and puts the merged segments in
+// their place, keeping everything written after the compaction started.
+func (m *Manifest) ReplaceMerged(maxFileId int, merged []Segment) {
+	segments := append([]Segment{}, merged...)
+	for _, seg := range m.Segments {
+		if seg.ID > maxFileId {
+			segments = append(segments, seg)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].ID < segments[j].ID
+	})
+	m.Segments = segments
+}
+
+func (m *Manifest) clone() *Manifest {
+	return &Manifest{
+		Version:  m.Version,
+		Segments: append([]Segment{}, m.Segments...),
+	}
+}
+
+// installMergedManifest rewrites the on-disk manifest after merge files were
+// renamed into place. Used by crash recovery, which has no live Storage.
+func installMergedManifest(dir string, maxFileId, mergeFileCount int) error {
+	m, found, err := LoadManifest(dir)
+	if err != nil {
+		return err
+	}
+	if !found {
+		m, err = BuildManifest(dir)
+		if err != nil {
+			return err
+		}
+		return m.Save(dir)
+	}
+
+	merged := make([]Segment, 0, mergeFileCount)
+	for i := 0; i < mergeFileCount; i++ {
+		merged = append(merged, statSegment(dir, i))
+	}
+	m.ReplaceMerged(maxFileId, merged)
+	return m.Save(dir)
+}
+
+func writeFileAtomic(dir, name string, data []byte) error {
+	tmpPath := filepath.Join(dir, name+".tmp")
+	f, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		return err
+	}
+	return SyncDir(dir)
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpen_WritesManifest(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+
+	m, found, err := LoadManifest(path)
+	if err != nil || !found {
+		t.Fatalf("LoadManifest() = %v, %v", found, err)
+	}
+	if len(m.Segments) != 1 || m.Segments[0].ID != 0 {
+		t.Errorf("segments = %+v, want [0]", m.Segments)
+	}
+	if m.Segments[0].FormatVersion != SegmentFormatVersion {
+		t.Errorf("format version = %d, want %d", m.Segments[0].FormatVersion, SegmentFormatVersion)
+	}
+}
+
+func TestStorage_GetAllDatFiles_TrustsManifest(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	s.Append([]byte("key"), []byte("value"))
+	if err := s.SwitchNewDatFile(); err != nil {
+		t.Fatalf("SwitchNewDatFile() error = %v", err)
+	}
+	s.Close()
+
+	// A stray segment that the manifest does not know about must be ignored.
+	os.WriteFile(filepath.Join(path, "7.dat"), []byte("garbage"), 0644)
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Reopen() error = %v", err)
+	}
+	defer s.Close()
+
+	if s.ActiveFileID() != 1 {
+		t.Errorf("ActiveFileID() = %d, want 1", s.ActiveFileID())
+	}
+
+	files, err := s.GetAllDatFiles()
+	if err != nil {
+		t.Fatalf("GetAllDatFiles() error = %v", err)
+	}
+	var ids []int
+	for _, f := range files {
+		id, _ := ParseFileIDFromName(f.Name())
+		ids = append(ids, id)
+		f.Close()
+	}
+	if len(ids) != 2 || ids[0] != 0 || ids[1] != 1 {
+		t.Errorf("GetAllDatFiles() ids = %v, want [0 1]", ids)
+	}
+
+	sealed, _ := s.Manifest().Lookup(0)
+	if sealed.Size == 0 || sealed.LiveBytes != sealed.Size {
+		t.Errorf("sealed segment = %+v, want size and live bytes recorded", sealed)
+	}
+}
+
+func TestStorage_Scan_UsesHintFile(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+
+	offset, header, err := s.Append([]byte("hinted"), []byte("value"))
+	if err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	// The hint names a key the data file does not contain, so seeing it
+	// proves the scan was served from the hint.
+	hint := EncodeHint(offset, []byte("from-hint"), Header{CRC: header.CRC, KeySize: 9, ValueSize: header.ValueSize, Timestamp: header.Timestamp})
+	os.WriteFile(filepath.Join(path, HintFileName(0)), hint, 0644)
+	s.manifest.Segments[0].Hint = true
+
+	var keys []string
+	err = s.Scan(func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error {
+		keys = append(keys, string(key))
+		return nil
+	}, func(key []byte, header Header) {})
+	if err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0] != "from-hint" {
+		t.Errorf("Scan() keys = %v, want [from-hint]", keys)
+	}
+}
+
+func TestStorage_Scan_FallsBackOnTruncatedHint(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "testdb")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer s.Close()
+
+	offset, header, _ := s.Append([]byte("key"), []byte("value"))
+	hint := EncodeHint(offset, []byte("key"), header)
+	os.WriteFile(filepath.Join(path, HintFileName(0)), hint[:len(hint)-1], 0644)
+	s.manifest.Segments[0].Hint = true
+
+	var keys []string
+	s.Scan(func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error {
+		keys = append(keys, string(key))
+		return nil
+	}, func(key []byte, header Header) {})
+	if len(keys) != 1 || keys[0] != "key" {
+		t.Errorf("Scan() keys = %v, want [key]", keys)
+	}
+}
+
+func TestHint_RoundTrip(t *testing.T) {
+	t.Parallel()
+	header := Header{CRC: 42, KeySize: 3, ValueSize: 10, Timestamp: 1700000000}
+	data := EncodeHint(128, []byte("abc"), header)
+
+	var gotOffset int64
+	var gotKey string
+	var gotHeader Header
+	err := ScanHint(bytes.NewReader(data), 5, func(offset int64, key []byte, h Header, fileID int, reader io.Reader) error {
+		gotOffset, gotKey, gotHeader = offset, string(key), h
+		if fileID != 5 {
+			t.Errorf("fileID = %d, want 5", fileID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ScanHint() error = %v", err)
+	}
+	if gotOffset != 128 || gotKey != "abc" || gotHeader != header {
+		t.Errorf("ScanHint() = %d %q %+v, want 128 abc %+v", gotOffset, gotKey, gotHeader, header)
+	}
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+**
+Merge journal
+merge.json records how far a compaction got so an interrupted merge can be
+rolled back (before the merge output is durable) or rolled forward (after).
+
+	merging      -> merge_*.dat being written, old segments untouched
+	merged       -> merge output fsynced, commit point
+	old_deleted  -> segments 0..MaxFileId removed
+	renamed      -> merge_<i>.dat/.hint renamed to <i>.dat/.hint, MANIFEST updated
+	done         -> nothing left to do
+**
+*/
+const MergeStateFile = "merge.json"
+
+type MergePhase string
+
+const (
+	MergePhaseMerging    MergePhase = "merging"
+	MergePhaseMerged     MergePhase = "merged"
+	MergePhaseOldDeleted MergePhase = "old_deleted"
+	MergePhaseRenamed    MergePhase = "renamed"
+	MergePhaseDone       MergePhase = "done"
+)
+
+type MergeState struct {
+	Status         MergePhase `json:"status"`
+	MaxFileId      int        `json:"maxFileId"`
+	MergeFileCount int        `json:"mergeFileCount"`
+}
+
+func MergeFileName(id int) string {
+	return fmt.Sprintf("merge_%d.dat", id)
+}
+
+func isMergeFileName(name string) bool {
+	ext := filepath.Ext(name)
+	return strings.HasPrefix(name, "merge_") && (ext == ".dat" || ext == ".hint")
+}
+
+// WriteMergeState durably replaces the journal: the new state is written to a
+// temporary file, fsynced, renamed over merge.json and the directory fsynced.
+func WriteMergeState(dir string, state MergeState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(dir, MergeStateFile, data)
+}
+
+// ReadMergeState returns the journal, or ok=false when no merge is recorded.
+func ReadMergeState(dir string) (MergeState, bool, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MergeStateFile))
+	if os.IsNotExist(err) {
+		return MergeState{}, false, nil
+	}
+	if err != nil {
+		return MergeState{}, false, err
+	}
+
+	var state MergeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return MergeState{}, true, err
+	}
+	return state, true, nil
+}
+
+// RemoveMergeState deletes the journal once a merge is fully settled.
+func RemoveMergeState(dir string) error {
+	os.Remove(filepath.Join(dir, MergeStateFile+".tmp"))
+	if err := os.Remove(filepath.Join(dir, MergeStateFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return SyncDir(dir)
+}
+
+func SyncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// DeleteSegmentsUpTo removes data and hint files 0..maxFileId. Missing files
+// are ignored so the step can be repeated during recovery.
+func DeleteSegmentsUpTo(dir string, maxFileId int) error {
+	for i := 0; i <= maxFileId; i++ {
+		for _, name := range []string{strconv.Itoa(i) + ".dat", HintFileName(i)} {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return SyncDir(dir)
+}
+
+// RenameMergeFiles renames merge_<i>.dat to <i>.dat (and merge_<i>.hint to
+// <i>.hint) for the first count merge files. Files already renamed by an
+// earlier attempt are skipped.
+func RenameMergeFiles(dir string, count int) error {
+	for i := 0; i < count; i++ {
+		hintSrc := filepath.Join(dir, MergeHintFileName(i))
+		if err := os.Rename(hintSrc, filepath.Join(dir, HintFileName(i))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		src := filepath.Join(dir, MergeFileName(i))
+		dst := filepath.Join(dir, strconv.Itoa(i)+".dat")
+		if err := os.Rename(src, dst); err != nil {
+			if os.IsNotExist(err) && fileExists(dst) {
+				continue
+			}
+			return err
+		}
+	}
+	return SyncDir(dir)
+}
+
+func removeMergeFiles(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if isMergeFileName(entry.Name()) {
+			if err := os.Remove(filepath.Join(dir, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// RecoverMerge settles an interrupted compaction found in dir. Merges that
+// never reached the merged phase are rolled back; later phases are rolled
+// forward from wherever the crash happened.
+func RecoverMerge(dir string) error {
+	state, found, err := ReadMergeState(dir)
+	if !found {
+		return err
+	}
+	if err != nil {
+		// Corrupted journal: the commit point was never durably recorded,
+		// so the old segments are still authoritative.
+		state = MergeState{Status: MergePhaseMerging}
+	}
+
+	switch state.Status {
+	case MergePhaseMerged:
+		if err := DeleteSegmentsUpTo(dir, state.MaxFileId); err != nil {
+			return err
+		}
+		state.Status = MergePhaseOldDeleted
+		if err := WriteMergeState(dir, state); err != nil {
+			return err
+		}
+		fallthrough
+
+	case MergePhaseOldDeleted:
+		if err := RenameMergeFiles(dir, state.MergeFileCount); err != nil {
+			return err
+		}
+		if err := installMergedManifest(dir, state.MaxFileId, state.MergeFileCount); err != nil {
+			return err
+		}
+		state.Status = MergePhaseRenamed
+		if err := WriteMergeState(dir, state); err != nil {
+			return err
+		}
+		fallthrough
+
+	case MergePhaseRenamed, MergePhaseDone:
+		return RemoveMergeState(dir)
+
+	default:
+		if err := removeMergeFiles(dir); err != nil {
+			return err
+		}
+		return RemoveMergeState(dir)
+	}
+}
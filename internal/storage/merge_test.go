@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("WriteFile(%s) error = %v", name, err)
+		}
+	}
+}
+
+func readDirNames(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error = %v", err)
+	}
+	got := make(map[string]string)
+	for _, e := range entries {
+		if e.Name() == ManifestFile {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error = %v", e.Name(), err)
+		}
+		got[e.Name()] = string(data)
+	}
+	return got
+}
+
+func TestRecoverMerge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		state *MergeState
+		raw   string
+		files map[string]string
+		want  map[string]string
+		// segments lists the manifest ids expected after a roll forward
+		segments []int
+	}{
+		{
+			name:  "no journal leaves directory alone",
+			files: map[string]string{"0.dat": "old", "merge_0.dat": "stray"},
+			want:  map[string]string{"0.dat": "old", "merge_0.dat": "stray"},
+		},
+		{
+			name:  "merging rolls back",
+			state: &MergeState{Status: MergePhaseMerging, MaxFileId: 1},
+			files: map[string]string{"0.dat": "old0", "1.dat": "old1", "2.dat": "new", "merge_0.dat": "partial"},
+			want:  map[string]string{"0.dat": "old0", "1.dat": "old1", "2.dat": "new"},
+		},
+		{
+			name:  "corrupted journal rolls back",
+			raw:   "not json{{{",
+			files: map[string]string{"0.dat": "old0", "merge_0.dat": "partial"},
+			want:  map[string]string{"0.dat": "old0"},
+		},
+		{
+			name:     "merged with partial delete rolls forward",
+			state:    &MergeState{Status: MergePhaseMerged, MaxFileId: 2, MergeFileCount: 1},
+			files:    map[string]string{"1.dat": "old1", "2.dat": "old2", "3.dat": "new", "merge_0.dat": "merged"},
+			want:     map[string]string{"0.dat": "merged", "3.dat": "new"},
+			segments: []int{0, 3},
+		},
+		{
+			name:     "old deleted with partial rename rolls forward",
+			state:    &MergeState{Status: MergePhaseOldDeleted, MaxFileId: 2, MergeFileCount: 2},
+			files:    map[string]string{"0.dat": "merged0", "3.dat": "new", "merge_1.dat": "merged1"},
+			want:     map[string]string{"0.dat": "merged0", "1.dat": "merged1", "3.dat": "new"},
+			segments: []int{0, 1, 3},
+		},
+		{
+			name:  "renamed only drops journal",
+			state: &MergeState{Status: MergePhaseRenamed, MaxFileId: 1, MergeFileCount: 1},
+			files: map[string]string{"0.dat": "merged0", "2.dat": "new"},
+			want:  map[string]string{"0.dat": "merged0", "2.dat": "new"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			if tt.state != nil {
+				if err := WriteMergeState(dir, *tt.state); err != nil {
+					t.Fatalf("WriteMergeState() error = %v", err)
+				}
+			}
+			if tt.raw != "" {
+				writeFiles(t, dir, map[string]string{MergeStateFile: tt.raw})
+			}
+
+			if err := RecoverMerge(dir); err != nil {
+				t.Fatalf("RecoverMerge() error = %v", err)
+			}
+
+			got := readDirNames(t, dir)
+			if len(got) != len(tt.want) {
+				t.Errorf("files = %v, want %v", got, tt.want)
+			}
+			for name, content := range tt.want {
+				if got[name] != content {
+					t.Errorf("%s = %q, want %q", name, got[name], content)
+				}
+			}
+
+			if tt.segments != nil {
+				manifest, found, err := LoadManifest(dir)
+				if err != nil || !found {
+					t.Fatalf("LoadManifest() = %v, %v", found, err)
+				}
+				if len(manifest.Segments) != len(tt.segments) {
+					t.Fatalf("manifest segments = %+v, want ids %v", manifest.Segments, tt.segments)
+				}
+				for i, id := range tt.segments {
+					if manifest.Segments[i].ID != id {
+						t.Errorf("manifest segment %d id = %d, want %d", i, manifest.Segments[i].ID, id)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergeState_RoundTrip(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+
+	want := MergeState{Status: MergePhaseOldDeleted, MaxFileId: 4, MergeFileCount: 2}
+	if err := WriteMergeState(dir, want); err != nil {
+		t.Fatalf("WriteMergeState() error = %v", err)
+	}
+
+	got, found, err := ReadMergeState(dir)
+	if err != nil || !found {
+		t.Fatalf("ReadMergeState() = %v, %v", found, err)
+	}
+	if got != want {
+		t.Errorf("ReadMergeState() = %+v, want %+v", got, want)
+	}
+}
@@ -2,22 +2,24 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 /*
 **
 Data Storage Layer
 key value pairs will be stored in multiple files if they exceed a certain size limit (e.g., 250mb per file).
-Data file format - logra-<file_number>.dat
-Hint file format - logra-<file_number>.hint
+Data file format - <file_number>.dat
+Hint file format - <file_number>.hint (written by compaction, see hint.go)
+Live segments are listed in MANIFEST (see manifest.go)
 **
 */
 const MaxDataFileSize = 1 * 1024 * 1024 // 250 MB
@@ -25,13 +27,24 @@ const MaxDataFileSize = 1 * 1024 * 1024 // 250 MB
 type Storage struct {
 	ActiveFile *os.File
 	Dir        string
+
+	// mu guards manifest, which is read by compaction outside the DB lock.
+	mu       sync.Mutex
+	manifest *Manifest
 }
 
 func Open(dirPath string) (*Storage, error) {
-	var activeFile *os.File
-	var err error
+	if err := os.MkdirAll(dirPath, 0755); err != nil {
+		return nil, err
+	}
+
+	manifest, err := openManifest(dirPath)
+	if err != nil {
+		return nil, err
+	}
 
-	activeFile, err = getActiveFile(dirPath)
+	active, _ := manifest.Last()
+	activeFile, err := os.OpenFile(filepath.Join(dirPath, strconv.Itoa(active.ID)+".dat"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return nil, err
 	}
@@ -39,88 +52,57 @@ func Open(dirPath string) (*Storage, error) {
 	return &Storage{
 		ActiveFile: activeFile,
 		Dir:        dirPath,
+		manifest:   manifest,
 	}, nil
 }
 
-func getActiveFile(path string) (*os.File, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		err := os.MkdirAll(path, 0755)
-		if err != nil {
-			return nil, err
-		}
-		activeFile, err := os.OpenFile(path+"/0.dat", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			return nil, err
-		}
-		return activeFile, nil
+// openManifest loads the MANIFEST, building it from the directory contents
+// the first time a database is opened by a release that keeps one.
+func openManifest(dirPath string) (*Manifest, error) {
+	manifest, found, err := LoadManifest(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
 	}
-	return findActiveFileInDir(path)
-}
-
-func (s *Storage) Close() error {
-	return s.ActiveFile.Close()
-}
-
-func findActiveFileInDir(path string) (*os.File, error) {
-	files, err := os.ReadDir(path)
-
-	if len(files) == 0 {
-		activeFile, err := os.OpenFile(path+"/0.dat", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			return nil, err
-		}
-		return activeFile, nil
+	if found && len(manifest.Segments) > 0 {
+		return manifest, nil
 	}
 
+	manifest, err = BuildManifest(dirPath)
 	if err != nil {
 		return nil, err
 	}
-
-	datExtFiles := []os.DirEntry{}
-	for _, file := range files {
-		baseName := filepath.Base(file.Name())
-		if !file.IsDir() && len(baseName) > 4 && baseName[len(baseName)-4:] == ".dat" {
-			datExtFiles = append(datExtFiles, file)
-		}
+	if len(manifest.Segments) == 0 {
+		manifest.Segments = append(manifest.Segments, Segment{ID: 0, FormatVersion: SegmentFormatVersion})
 	}
-	fmt.Println("Data files found:", len(datExtFiles))
-	if len(datExtFiles) == 0 {
-		fmt.Println("No .dat files found, creating new data file.")
-		activeFile, err := os.OpenFile(path+"/0.dat", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-		if err != nil {
-			return nil, err
-		}
-		return activeFile, nil
+	if err := manifest.Save(dirPath); err != nil {
+		return nil, err
 	}
+	return manifest, nil
+}
 
-	currentMaxFileEntry := struct {
-		file       os.DirEntry
-		segmentNum int
-	}{segmentNum: -1}
-
-	for _, file := range datExtFiles {
-		baseName := filepath.Base(file.Name())
-		fmt.Println("Found data file:", baseName)
-		fmt.Println("Parsing segment number from file name:", baseName)
-		segmentSplit := strings.Split(baseName, ".")
-		if len(segmentSplit) != 2 {
-			continue
-		}
-		segmentNum, err := strconv.Atoi(segmentSplit[0])
-		if err != nil {
-			continue
-		}
+func (s *Storage) Close() error {
+	return s.ActiveFile.Close()
+}
 
-		if segmentNum > currentMaxFileEntry.segmentNum {
-			currentMaxFileEntry.file = file
-			currentMaxFileEntry.segmentNum = segmentNum
-		}
-	}
+// Manifest returns a copy of the current segment list.
+func (s *Storage) Manifest() *Manifest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.manifest.clone()
+}
 
-	if currentMaxFileEntry.segmentNum == -1 {
-		return nil, fmt.Errorf("no valid data files found")
+// InstallMerged records that segments 0..maxFileId were replaced by merged.
+// The caller must already have renamed the merge files into place.
+func (s *Storage) InstallMerged(maxFileId int, merged []Segment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.manifest.clone()
+	next.ReplaceMerged(maxFileId, merged)
+	if err := next.Save(s.Dir); err != nil {
+		return err
 	}
-	return os.OpenFile(path+"/"+filepath.Base(currentMaxFileEntry.file.Name()), os.O_RDWR|os.O_APPEND, 0666)
+	s.manifest = next
+	return nil
 }
 
 func (s *Storage) ActiveFileID() int {
@@ -143,6 +125,12 @@ func (s *Storage) SwitchNewDatFile() error {
 		newSegmentNum = currentSegmentNum + 1
 	}
 
+	// Seal the current segment and register the new one before creating it,
+	// so the manifest never misses a file that holds data.
+	if err := s.addSegment(newSegmentNum); err != nil {
+		return err
+	}
+
 	createDatFile, err := os.OpenFile(s.Dir+"/"+strconv.Itoa(newSegmentNum)+".dat", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
 		return err
@@ -152,6 +140,26 @@ func (s *Storage) SwitchNewDatFile() error {
 	return nil
 }
 
+func (s *Storage) addSegment(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	next := s.manifest.clone()
+	if info, err := s.ActiveFile.Stat(); err == nil {
+		for i := range next.Segments {
+			if next.Segments[i].ID == s.ActiveFileID() {
+				next.Segments[i].Size = info.Size()
+				next.Segments[i].LiveBytes = info.Size()
+			}
+		}
+	}
+	next.Segments = append(next.Segments, Segment{ID: id, FormatVersion: SegmentFormatVersion})
+	if err := next.Save(s.Dir); err != nil {
+		return err
+	}
+	s.manifest = next
+	return nil
+}
+
 func (s *Storage) MarkDeleted(key []byte) error {
 	_, _, err := s.Append(key, []byte{})
 	return err
@@ -224,31 +232,21 @@ func (s *Storage) ReadAtFile(offset int64, header Header, fileID int) (Record, e
 	return DecodeRecord(data)
 }
 
+// GetAllDatFiles opens every segment listed in the manifest, in id order.
 func (s *Storage) GetAllDatFiles() ([]*os.File, error) {
-	files, err := os.ReadDir(s.Dir)
-	if err != nil {
-		return nil, err
-	}
+	manifest := s.Manifest()
 
 	datFiles := []*os.File{}
-	for _, file := range files {
-		baseName := filepath.Base(file.Name())
-		if !file.IsDir() && len(baseName) > 4 && baseName[len(baseName)-4:] == ".dat" {
-			f, err := os.OpenFile(s.Dir+"/"+baseName, os.O_RDWR, 0666)
-			if err != nil {
-				return nil, err
+	for _, seg := range manifest.Segments {
+		f, err := os.OpenFile(s.Dir+"/"+strconv.Itoa(seg.ID)+".dat", os.O_RDWR, 0666)
+		if err != nil {
+			for _, opened := range datFiles {
+				opened.Close()
 			}
-			datFiles = append(datFiles, f)
+			return nil, err
 		}
+		datFiles = append(datFiles, f)
 	}
-	sort.Slice(datFiles, func(i, j int) bool {
-		id1, err1 := ParseFileIDFromName(filepath.Base(datFiles[i].Name()))
-		id2, err2 := ParseFileIDFromName(filepath.Base(datFiles[j].Name()))
-		if err1 != nil || err2 != nil {
-			return false
-		}
-		return id1 < id2
-	})
 	return datFiles, nil
 }
 
@@ -266,19 +264,32 @@ func ParseFileIDFromName(fileName string) (int, error) {
 }
 
 func (s *Storage) ScanFile(file *os.File, skipValBytes bool, onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error, onDelete func(key []byte, header Header)) error {
+	_, _, err := s.scanFile(file, skipValBytes, onAppend, onDelete)
+	return err
+}
+
+// scanFile replays one data file and reports where its last complete record
+// ends. torn is true when the file ends in an incomplete record or batch.
+// With skipValBytes the value is skipped before onAppend runs, so a torn
+// record is never reported; onAppend then gets an empty reader.
+func (s *Storage) scanFile(file *os.File, skipValBytes bool, onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error, onDelete func(key []byte, header Header)) (int64, bool, error) {
 	reader := bufio.NewReader(file)
 	offset := int64(0)
 	fileID, err := ParseFileIDFromName(filepath.Base(file.Name()))
 	if err != nil {
-		return err
+		return 0, false, err
 	}
+	empty := bytes.NewReader(nil)
 	for {
 		headerBytes := make([]byte, HeaderSize)
 		if _, err := io.ReadFull(reader, headerBytes); err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return nil
+			if err == io.EOF {
+				return offset, false, nil
 			}
-			return err
+			if err == io.ErrUnexpectedEOF {
+				return offset, true, nil
+			}
+			return offset, false, err
 		}
 
 		keySize := binary.LittleEndian.Uint32(headerBytes[4:8])
@@ -287,48 +298,93 @@ func (s *Storage) ScanFile(file *os.File, skipValBytes bool, onAppend func(offse
 		key := make([]byte, keySize)
 		if _, err := io.ReadFull(reader, key); err != nil {
 			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				return nil
+				return offset, true, nil
 			}
-			return err
+			return offset, false, err
 		}
 
 		header, err := DecodeHeader(headerBytes)
 		if err != nil {
-			return err
+			return offset, false, err
 		}
 
-		if valueSize == 0 {
-			onDelete(key, header)
-		} else {
-			err := onAppend(offset, key, header, fileID, reader)
+		if IsBatchMarker(key) {
+			markerValue := make([]byte, valueSize)
+			if _, err := io.ReadFull(reader, markerValue); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return offset, true, nil
+				}
+				return offset, false, err
+			}
+			bodyStart := offset + int64(HeaderSize+keySize+valueSize)
+			n, err := readBatch(reader, markerValue, bodyStart, fileID, onAppend, onDelete)
+			if err == errBadBatch {
+				// Torn or corrupt batch at the tail: none of it is applied
+				return offset, true, nil
+			}
 			if err != nil {
-				fmt.Printf("Error in scan function%s: for this key %s\n", err, string(key))
+				return offset, false, err
 			}
+			offset = bodyStart + n
+			continue
 		}
 
 		if skipValBytes {
 			if _, err := io.CopyN(io.Discard, reader, int64(valueSize)); err != nil {
 				if err == io.EOF || err == io.ErrUnexpectedEOF {
-					return nil
+					return offset, true, nil
 				}
-				return err
+				return offset, false, err
 			}
 		}
+
+		if valueSize == 0 {
+			onDelete(key, header)
+		} else {
+			valueReader := io.Reader(reader)
+			if skipValBytes {
+				valueReader = empty
+			}
+			err := onAppend(offset, key, header, fileID, valueReader)
+			if err != nil {
+				fmt.Printf("Error in scan function%s: for this key %s\n", err, string(key))
+			}
+		}
+
 		offset += int64(HeaderSize + keySize + valueSize)
 	}
 
 }
 
+// Scan replays every segment. Segments with a hint file are loaded from the
+// hint instead of the data file, so onAppend must not read from reader. A torn
+// record or batch at the end of the active file is truncated away so later
+// appends are not hidden behind it.
 func (s *Storage) Scan(onAppend func(offset int64, key []byte, header Header, fileID int, reader io.Reader) error, onDelete func(key []byte, header Header)) error {
+	manifest := s.Manifest()
 	files, err := s.GetAllDatFiles()
 	if err != nil {
 		return err
 	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
 
-	for _, f := range files {
-		if err := s.ScanFile(f, true, onAppend, onDelete); err != nil {
+	for i, f := range files {
+		if manifest.Segments[i].Hint && s.scanHintFile(manifest.Segments[i].ID, onAppend) {
+			continue
+		}
+		validEnd, torn, err := s.scanFile(f, true, onAppend, onDelete)
+		if err != nil {
 			return err
 		}
+		if torn && manifest.Segments[i].ID == s.ActiveFileID() {
+			if err := s.ActiveFile.Truncate(validEnd); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -0,0 +1,365 @@
+package logra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"sakthirathinam/logra/internal/jsonpath"
+)
+
+/*
+**
+JSON
+A JSON document is a collection, of the type RedisJSON reports, holding the
+whole document as compact JSON in a single element named after the root.
+Every command parses the document, changes it and writes it back as one
+record under the write lock, so values are validated before they are stored
+and a document is never seen half-changed. Paths are the JSONPath subset of
+internal/jsonpath; a legacy path, without the leading "$", answers with one
+value where a JSONPath answers with every match.
+**
+*/
+const (
+	jsonType    = "ReJSON-RL"
+	jsonDocElem = "$"
+)
+
+var (
+	ErrJSONNoKey = errors.New("could not perform this operation on a key that doesn't exist")
+	ErrJSONRoot  = errors.New("new objects must be created at the root")
+)
+
+// JSONSetOptions are the conditions of JSON.SET.
+type JSONSetOptions struct {
+	NX bool // only set paths that do not exist
+	XX bool // only set paths that exist
+}
+
+func errJSONPath(path string) error {
+	return fmt.Errorf("Path '%s' does not exist", path)
+}
+
+func errJSONType(want string, got any) error {
+	return fmt.Errorf("wrong type of path value - expected %s but found %s", want, jsonpath.TypeName(got))
+}
+
+func parseJSON(value string) (any, error) {
+	v, err := jsonpath.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	return v, nil
+}
+
+// jsonDoc returns the document at key, or false if the key does not exist.
+// The caller must hold the lock.
+func (db *LograDB) jsonDoc(key string) (any, bool, error) {
+	c, err := db.collectionOf(key, jsonType)
+	if err != nil || c == nil {
+		return nil, false, err
+	}
+	rec, err := db.readEntry(elemKey(key, jsonDocElem))
+	if err != nil {
+		return nil, false, err
+	}
+	doc, err := jsonpath.Parse(rec.Value)
+	if err != nil {
+		return nil, false, err
+	}
+	return doc, true, nil
+}
+
+// writeJSON stores doc at key, creating the key unless it exists. The caller
+// must hold the write lock.
+func (db *LograDB) writeJSON(key string, exists bool, doc any) error {
+	var ops []writeOp
+	if !exists {
+		ops = db.createOps(key, jsonType)
+	}
+	ops = append(ops, writeOp{key: elemKey(key, jsonDocElem), value: jsonpath.Marshal(doc)})
+	return db.write(ops)
+}
+
+// JSONSet sets the values at path in the document at key to value. A key
+// that does not exist can only be created at the root. It reports whether
+// anything was set, which the options or a path that cannot be created may
+// prevent.
+func (db *LograDB) JSONSet(key, path, value string, opts JSONSetOptions) (bool, error) {
+	v, err := parseJSON(value)
+	if err != nil {
+		return false, err
+	}
+	p, err := jsonpath.ParsePath(path)
+	if err != nil {
+		return false, err
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	doc, exists, err := db.jsonDoc(key)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		if !p.IsRoot() {
+			return false, ErrJSONRoot
+		}
+		if opts.XX {
+			return false, nil
+		}
+		doc = v
+	} else {
+		found := len(p.Find(doc)) > 0
+		if opts.NX && found || opts.XX && !found {
+			return false, nil
+		}
+		if jsonpath.Set(&doc, p, v) == 0 {
+			return false, nil
+		}
+	}
+	if err := db.writeJSON(key, exists, doc); err != nil {
+		return false, err
+	}
+	db.notify(EventModule, "json.set", key)
+	return true, nil
+}
+
+// JSONGet returns the values at paths in the document at key, as JSON, or
+// false if the key does not exist. With no paths it returns the document.
+// One path yields its value for a legacy path and an array of its matches
+// otherwise; several yield an object keyed by path.
+func (db *LograDB) JSONGet(key string, paths []string) (string, bool, error) {
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	parsed := make([]*jsonpath.Path, len(paths))
+	for i, path := range paths {
+		p, err := jsonpath.ParsePath(path)
+		if err != nil {
+			return "", false, err
+		}
+		parsed[i] = p
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	doc, ok, err := db.jsonDoc(key)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	get := func(i int) (any, error) {
+		matches := parsed[i].Find(doc)
+		if parsed[i].Legacy {
+			if len(matches) == 0 {
+				return nil, errJSONPath(paths[i])
+			}
+			return matches[0].Value, nil
+		}
+		values := &jsonpath.Array{Elems: []any{}}
+		for _, m := range matches {
+			values.Elems = append(values.Elems, m.Value)
+		}
+		return values, nil
+	}
+	if len(paths) == 1 {
+		v, err := get(0)
+		if err != nil {
+			return "", false, err
+		}
+		return jsonpath.Marshal(v), true, nil
+	}
+	result := jsonpath.NewObject()
+	for i, path := range paths {
+		v, err := get(i)
+		if err != nil {
+			return "", false, err
+		}
+		result.Set(path, v)
+	}
+	return jsonpath.Marshal(result), true, nil
+}
+
+// JSONDel deletes the values at path in the document at key, and the key
+// itself if path is the root. It returns how many values it deleted.
+func (db *LograDB) JSONDel(key, path string) (int, error) {
+	p, err := jsonpath.ParsePath(path)
+	if err != nil {
+		return 0, err
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	doc, ok, err := db.jsonDoc(key)
+	if err != nil || !ok {
+		return 0, err
+	}
+	if p.IsRoot() {
+		if err := db.write(db.deleteOps(key)); err != nil {
+			return 0, err
+		}
+		db.notify(EventModule, "json.del", key)
+		db.notify(EventGeneric, "del", key)
+		return 1, nil
+	}
+	n := jsonpath.Delete(doc, p)
+	if n == 0 {
+		return 0, nil
+	}
+	if err := db.writeJSON(key, true, doc); err != nil {
+		return 0, err
+	}
+	db.notify(EventModule, "json.del", key)
+	return n, nil
+}
+
+// matchJSON finds the values at path in the document at key for a command
+// that changes them. A legacy path must match, and its first match must be
+// of type want. The caller must hold the write lock.
+func (db *LograDB) matchJSON(key, path string, want string, ok func(any) bool) (any, []jsonpath.Match, *jsonpath.Path, error) {
+	p, err := jsonpath.ParsePath(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	doc, exists, err := db.jsonDoc(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !exists {
+		return nil, nil, nil, ErrJSONNoKey
+	}
+	matches := p.Find(doc)
+	if p.Legacy {
+		if len(matches) == 0 {
+			return nil, nil, nil, errJSONPath(path)
+		}
+		if !ok(matches[0].Value) {
+			return nil, nil, nil, errJSONType(want, matches[0].Value)
+		}
+	}
+	return doc, matches, p, nil
+}
+
+// JSONNumIncrBy adds by to the numbers at path in the document at key. It
+// returns, as JSON, the new value for a legacy path and otherwise an array
+// with the new value of each match, or null where a match is not a number.
+func (db *LograDB) JSONNumIncrBy(key, path, by string) (string, error) {
+	v, err := parseJSON(by)
+	if err != nil {
+		return "", err
+	}
+	incr, ok := v.(json.Number)
+	if !ok {
+		return "", errJSONType("a number", v)
+	}
+	isNumber := func(v any) bool {
+		_, ok := v.(json.Number)
+		return ok
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	doc, matches, p, err := db.matchJSON(key, path, "a number", isNumber)
+	if err != nil {
+		return "", err
+	}
+	results := &jsonpath.Array{Elems: make([]any, len(matches))}
+	changed := false
+	for i, m := range matches {
+		n, ok := m.Value.(json.Number)
+		if !ok {
+			continue
+		}
+		sum, err := jsonpath.Add(n, incr)
+		if err != nil {
+			return "", err
+		}
+		jsonpath.Replace(&doc, m, sum)
+		results.Elems[i] = sum
+		changed = true
+	}
+	if changed {
+		if err := db.writeJSON(key, true, doc); err != nil {
+			return "", err
+		}
+		db.notify(EventModule, "json.numincrby", key)
+	}
+	if p.Legacy {
+		return jsonpath.Marshal(results.Elems[0]), nil
+	}
+	return jsonpath.Marshal(results), nil
+}
+
+// JSONArrAppend appends values to the arrays at path in the document at key.
+// It returns the new length of each match, or nil where a match is not an
+// array.
+func (db *LograDB) JSONArrAppend(key, path string, values []string) ([]*int64, error) {
+	elems := make([]any, len(values))
+	for i, value := range values {
+		v, err := parseJSON(value)
+		if err != nil {
+			return nil, err
+		}
+		elems[i] = v
+	}
+	isArray := func(v any) bool {
+		_, ok := v.(*jsonpath.Array)
+		return ok
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	doc, matches, _, err := db.matchJSON(key, path, "array", isArray)
+	if err != nil {
+		return nil, err
+	}
+	lens := make([]*int64, len(matches))
+	changed := false
+	for i, m := range matches {
+		arr, ok := m.Value.(*jsonpath.Array)
+		if !ok {
+			continue
+		}
+		for _, e := range elems {
+			arr.Elems = append(arr.Elems, jsonpath.Clone(e))
+		}
+		n := int64(len(arr.Elems))
+		lens[i] = &n
+		changed = true
+	}
+	if changed && len(elems) > 0 {
+		if err := db.writeJSON(key, true, doc); err != nil {
+			return nil, err
+		}
+		db.notify(EventModule, "json.arrappend", key)
+	}
+	return lens, nil
+}
+
+// JSONType returns the type of each value at path in the document at key,
+// or false if the key does not exist.
+func (db *LograDB) JSONType(key, path string) ([]string, bool, error) {
+	p, err := jsonpath.ParsePath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	doc, ok, err := db.jsonDoc(key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	matches := p.Find(doc)
+	types := make([]string, len(matches))
+	for i, m := range matches {
+		types[i] = jsonpath.TypeName(m.Value)
+	}
+	return types, true, nil
+}
@@ -0,0 +1,91 @@
+package logra
+
+import (
+	"strings"
+
+	"sakthirathinam/logra/internal/index"
+)
+
+// internalKeyPrefix starts every key logra keeps for itself, such as expiry
+// records. They are hidden from key listings.
+const internalKeyPrefix = "\x00logra:"
+
+func isInternalKey(key string) bool {
+	return strings.HasPrefix(key, internalKeyPrefix)
+}
+
+// Type returns the type of the value at key as Redis names it, or "none" if
+// the key does not exist.
+func (db *LograDB) Type(key string) string {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	if !db.has(key) {
+		return "none"
+	}
+	if c := db.colls[key]; c != nil {
+		return c.kind
+	}
+	return "string"
+}
+
+// listedKey returns the key an index entry stands for in key listings: the
+// key itself, or for a type record the key of its collection. Other internal
+// records are not listed.
+func listedKey(key string) (string, bool) {
+	if strings.HasPrefix(key, typeKeyPrefix) {
+		return key[len(typeKeyPrefix):], true
+	}
+	return key, !isInternalKey(key)
+}
+
+// RangeKeys calls fn for every live key until fn returns false. It holds the
+// read lock throughout, so fn must not call back into db.
+func (db *LograDB) RangeKeys(fn func(key string) bool) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	done := false
+	db.Index.Range(func(key string, entry index.Entry) bool {
+		key, listed := listedKey(key)
+		if !listed || !db.has(key) {
+			return true
+		}
+		done = !fn(key)
+		return !done
+	})
+	if db.tx == nil || done {
+		return
+	}
+	for key, op := range db.tx.pending {
+		if op.del || db.Index.Has(key) {
+			continue
+		}
+		key, listed := listedKey(key)
+		if !listed || db.expired(key) {
+			continue
+		}
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// ScanKeys calls fn for about count live keys from cursor on and returns the
+// cursor to continue from, or 0 when the scan is complete. Starting from 0,
+// every key that exists throughout the scan is reported at least once, even
+// if keys are written or the database is compacted between calls. fn runs
+// under the read lock and must not call back into db. Inside a transaction,
+// keys the transaction created are not reported.
+func (db *LograDB) ScanKeys(cursor uint64, count int, fn func(key string)) uint64 {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	// Only the keys reported count, so that internal records, such as the
+	// elements of collections, do not use up count
+	return db.Index.Scan(cursor, count, func(key string, entry index.Entry) bool {
+		key, listed := listedKey(key)
+		if !listed || !db.has(key) {
+			return false
+		}
+		fn(key)
+		return true
+	})
+}
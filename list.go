@@ -0,0 +1,347 @@
+package logra
+
+import "encoding/binary"
+
+/*
+**
+Lists
+A list is a collection whose elements are named by their position, encoded so
+that byte order is numeric order. Pushes and pops only touch the ends, so the
+positions of a list are always the contiguous range [head, tail]: a push
+writes one record at head-1 or tail+1 and never moves the others, and every
+element survives a restart as its own record. Element records hold the value
+after a one-byte prefix, as an empty value would read as a deletion.
+**
+*/
+const (
+	listType        = "list"
+	listValuePrefix = "v"
+)
+
+func listElem(pos int64) string {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(pos)^1<<63)
+	return string(b[:])
+}
+
+func listPos(elem string) int64 {
+	if len(elem) != 8 {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64([]byte(elem)) ^ 1<<63)
+}
+
+// listRange clamps the inclusive range [start, stop], where negative indexes
+// count from the end, to a list of n elements. ok is false if it is empty.
+func listRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	start = max(start, 0)
+	stop = min(stop, n-1)
+	return start, stop, start <= stop
+}
+
+// listValue reads the element at index i of the list c at key.
+func (db *LograDB) listValue(key string, c *collection, i int) (string, error) {
+	rec, err := db.readEntry(elemKey(key, listElem(c.head+int64(i))))
+	if err != nil {
+		return "", err
+	}
+	return rec.Value[len(listValuePrefix):], nil
+}
+
+// listPushOps returns the writes that push values onto the list c at key,
+// which is nil if the list does not exist yet.
+func (db *LograDB) listPushOps(key string, c *collection, values []string, left bool) []writeOp {
+	var ops []writeOp
+	head, tail := int64(0), int64(-1)
+	if c == nil {
+		ops = db.createOps(key, listType)
+	} else {
+		head, tail = c.head, c.tail
+	}
+	for _, value := range values {
+		pos := tail + 1
+		if left {
+			head--
+			pos = head
+		} else {
+			tail++
+		}
+		ops = append(ops, writeOp{key: elemKey(key, listElem(pos)), value: listValuePrefix + value})
+	}
+	return ops
+}
+
+func pushEvent(left bool) string {
+	if left {
+		return "lpush"
+	}
+	return "rpush"
+}
+
+func popEvent(left bool) string {
+	if left {
+		return "lpop"
+	}
+	return "rpop"
+}
+
+// push pushes values onto the list at key; only is set by LPUSHX and RPUSHX,
+// which do nothing if the list does not exist. It returns the new length.
+func (db *LograDB) push(key string, values []string, left, only bool) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, listType)
+	if err != nil || (c == nil && only) {
+		return 0, err
+	}
+	n := len(values)
+	if c != nil {
+		n += len(c.elems)
+	}
+	if err := db.write(db.listPushOps(key, c, values, left)); err != nil {
+		return 0, err
+	}
+	db.notify(EventList, pushEvent(left), key)
+	return n, nil
+}
+
+// LPush inserts values at the head of the list at key, creating it if
+// missing, one after the other, and returns the new length.
+func (db *LograDB) LPush(key string, values []string) (int, error) {
+	return db.push(key, values, true, false)
+}
+
+// RPush appends values to the list at key, creating it if missing, and
+// returns the new length.
+func (db *LograDB) RPush(key string, values []string) (int, error) {
+	return db.push(key, values, false, false)
+}
+
+// LPushX is LPush for a list that already exists; it returns 0 otherwise.
+func (db *LograDB) LPushX(key string, values []string) (int, error) {
+	return db.push(key, values, true, true)
+}
+
+// RPushX is RPush for a list that already exists; it returns 0 otherwise.
+func (db *LograDB) RPushX(key string, values []string) (int, error) {
+	return db.push(key, values, false, true)
+}
+
+// pop removes up to count elements from one end of the list at key, deleting
+// the key with its last element. The caller must hold the write lock.
+func (db *LograDB) pop(key string, count int, left bool) ([]string, error) {
+	c, err := db.collectionOf(key, listType)
+	if err != nil || c == nil || count <= 0 {
+		return nil, err
+	}
+	count = min(count, len(c.elems))
+	values := make([]string, 0, count)
+	var ops []writeOp
+	for i := 0; i < count; i++ {
+		pos := c.head + int64(i)
+		if !left {
+			pos = c.tail - int64(i)
+		}
+		rec, err := db.readEntry(elemKey(key, listElem(pos)))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, rec.Value[len(listValuePrefix):])
+		ops = append(ops, writeOp{key: elemKey(key, listElem(pos)), del: true})
+	}
+	emptied := count == len(c.elems)
+	if emptied {
+		ops = db.deleteOps(key)
+	}
+	if err := db.write(ops); err != nil {
+		return nil, err
+	}
+	db.notify(EventList, popEvent(left), key)
+	if emptied {
+		db.notify(EventGeneric, "del", key)
+	}
+	return values, nil
+}
+
+// LPop removes and returns up to count elements from the head of the list at
+// key. It returns nil if the list does not exist.
+func (db *LograDB) LPop(key string, count int) ([]string, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	return db.pop(key, count, true)
+}
+
+// RPop removes and returns up to count elements from the tail of the list at
+// key. It returns nil if the list does not exist.
+func (db *LograDB) RPop(key string, count int) ([]string, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	return db.pop(key, count, false)
+}
+
+// LLen returns the length of the list at key.
+func (db *LograDB) LLen(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, listType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	return len(c.elems), nil
+}
+
+// LIndex returns the element at index of the list at key; negative indexes
+// count from the end. It returns false if there is no such element.
+func (db *LograDB) LIndex(key string, index int) (string, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, listType)
+	if err != nil || c == nil {
+		return "", false, err
+	}
+	if index < 0 {
+		index += len(c.elems)
+	}
+	if index < 0 || index >= len(c.elems) {
+		return "", false, nil
+	}
+	value, err := db.listValue(key, c, index)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// LRange returns the elements from start to stop inclusive of the list at
+// key; negative indexes count from the end.
+func (db *LograDB) LRange(key string, start, stop int) ([]string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, listType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	start, stop, ok := listRange(start, stop, len(c.elems))
+	if !ok {
+		return nil, nil
+	}
+	values := make([]string, 0, stop-start+1)
+	for i := start; i <= stop; i++ {
+		value, err := db.listValue(key, c, i)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// LTrim keeps only the elements from start to stop inclusive of the list at
+// key, deleting the key if none are left.
+func (db *LograDB) LTrim(key string, start, stop int) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, listType)
+	if err != nil || c == nil {
+		return err
+	}
+	n := len(c.elems)
+	start, stop, ok := listRange(start, stop, n)
+	if !ok {
+		if err := db.write(db.deleteOps(key)); err != nil {
+			return err
+		}
+		db.notify(EventList, "ltrim", key)
+		db.notify(EventGeneric, "del", key)
+		return nil
+	}
+	// Elements are removed from the ends inwards, keeping the list contiguous
+	var ops []writeOp
+	for i := 0; i < start; i++ {
+		ops = append(ops, writeOp{key: elemKey(key, listElem(c.head+int64(i))), del: true})
+	}
+	for i := n - 1; i > stop; i-- {
+		ops = append(ops, writeOp{key: elemKey(key, listElem(c.head+int64(i))), del: true})
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	if err := db.write(ops); err != nil {
+		return err
+	}
+	db.notify(EventList, "ltrim", key)
+	return nil
+}
+
+// LMove pops an element from one end of the list at src and pushes it onto
+// one end of the list at dst, in one write. It returns false if src does not
+// exist. src and dst may be the same list, which rotates it.
+func (db *LograDB) LMove(src, dst string, fromLeft, toLeft bool) (string, bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	from, err := db.collectionOf(src, listType)
+	if err != nil || from == nil {
+		return "", false, err
+	}
+	to, err := db.collectionOf(dst, listType)
+	if err != nil {
+		return "", false, err
+	}
+	i := 0
+	if !fromLeft {
+		i = len(from.elems) - 1
+	}
+	value, err := db.listValue(src, from, i)
+	if err != nil {
+		return "", false, err
+	}
+
+	emptied := false
+	var ops []writeOp
+	switch {
+	case src == dst && len(from.elems) == 1:
+		// Rotating a single element leaves the list as it is
+	case src == dst:
+		ops = append(ops, writeOp{key: elemKey(src, listElem(from.head+int64(i))), del: true})
+		// The push goes to the end the pop did not shrink
+		head, tail := from.head, from.tail
+		if fromLeft {
+			head++
+		} else {
+			tail--
+		}
+		pos := tail + 1
+		if toLeft {
+			pos = head - 1
+		}
+		ops = append(ops, writeOp{key: elemKey(dst, listElem(pos)), value: listValuePrefix + value})
+	default:
+		if len(from.elems) == 1 {
+			ops, emptied = db.deleteOps(src), true
+		} else {
+			ops = append(ops, writeOp{key: elemKey(src, listElem(from.head+int64(i))), del: true})
+		}
+		ops = append(ops, db.listPushOps(dst, to, []string{value}, toLeft)...)
+	}
+	if err := db.write(ops); err != nil {
+		return "", false, err
+	}
+	db.notify(EventList, popEvent(fromLeft), src)
+	if emptied {
+		db.notify(EventGeneric, "del", src)
+	}
+	db.notify(EventList, pushEvent(toLeft), dst)
+	return value, true, nil
+}
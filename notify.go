@@ -0,0 +1,51 @@
+package logra
+
+// Event classes, named by the characters Redis uses for them in
+// notify-keyspace-events.
+const (
+	EventGeneric byte = 'g' // del, expire, persist, ...
+	EventString  byte = '$' // set, incrby, append, ...
+	EventList    byte = 'l'
+	EventSet     byte = 's'
+	EventHash    byte = 'h'
+	EventZSet    byte = 'z'
+	EventStream  byte = 't'
+	EventModule  byte = 'd' // JSON documents
+	EventExpired byte = 'x' // a key was deleted because its deadline passed
+	EventEvicted byte = 'e' // reserved: logra has no eviction policy yet
+	EventNew     byte = 'n' // a key was created
+)
+
+// Notifier receives keyspace events, such as ("$", "set", key) after a SET.
+// Notify is called with the database lock held, so it must return quickly
+// and must not call back into the database.
+type Notifier interface {
+	Notify(class byte, event, key string)
+}
+
+// event is a notification held back until a transaction commits.
+type event struct {
+	class byte
+	name  string
+	key   string
+}
+
+// SetNotifier installs n to receive keyspace events; nil turns them off.
+func (db *LograDB) SetNotifier(n Notifier) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+	db.notifier = n
+}
+
+// notify reports an event to the notifier. Inside a transaction it is held
+// back until the transaction commits. The caller must hold the lock.
+func (db *LograDB) notify(class byte, name, key string) {
+	if db.notifier == nil {
+		return
+	}
+	if db.tx != nil {
+		db.tx.events = append(db.tx.events, event{class, name, key})
+		return
+	}
+	db.notifier.Notify(class, name, key)
+}
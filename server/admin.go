@@ -0,0 +1,248 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"sakthirathinam/logra"
+	"sakthirathinam/logra/internal/compact"
+	"sakthirathinam/logra/internal/glob"
+)
+
+// compactionJob tracks the server's background compaction, in the spirit of
+// Redis's BGREWRITEAOF: at most one runs at a time against the server's own
+// LograDB, and the outcome of the last run is kept for INFO.
+type compactionJob struct {
+	mu           sync.Mutex
+	workers      int
+	running      *compact.Compact
+	startedAt    time.Time
+	lastStatus   string
+	lastError    string
+	lastDuration time.Duration
+	lastFinished time.Time
+}
+
+// SetCompactionWorkers sets the worker count used by COMPACT.
+func (s *Server) SetCompactionWorkers(n int) {
+	s.compaction.mu.Lock()
+	defer s.compaction.mu.Unlock()
+	s.compaction.workers = n
+}
+
+func (s *Server) startCompaction() error {
+	job := &s.compaction
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.running != nil {
+		return fmt.Errorf("Background compaction already in progress")
+	}
+
+	c := compact.NewCompact(s.db)
+	c.SetWorkers(job.workers)
+	job.running = c
+	job.startedAt = time.Now()
+
+	go func() {
+		err := c.Execute()
+
+		job.mu.Lock()
+		defer job.mu.Unlock()
+		job.running = nil
+		job.lastFinished = time.Now()
+		job.lastDuration = job.lastFinished.Sub(job.startedAt)
+		job.lastStatus = "ok"
+		job.lastError = ""
+		if err != nil {
+			job.lastStatus = "err"
+			if err == compact.ErrCanceled {
+				job.lastStatus = "canceled"
+			}
+			job.lastError = err.Error()
+		}
+	}()
+	return nil
+}
+
+func (s *Server) cancelCompaction() bool {
+	job := &s.compaction
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.running == nil {
+		return false
+	}
+	job.running.Cancel()
+	return true
+}
+
+// compactionInfo renders the compaction fields shared by COMPACT STATUS and
+// the INFO compaction section.
+func (s *Server) compactionInfo() string {
+	job := &s.compaction
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	var b strings.Builder
+	if job.running != nil {
+		p := job.running.Progress()
+		fmt.Fprintf(&b, "compaction_in_progress:1\r\n")
+		fmt.Fprintf(&b, "compaction_status:%s\r\n", p.Status)
+		fmt.Fprintf(&b, "compaction_current_time_sec:%d\r\n", int64(time.Since(job.startedAt).Seconds()))
+		fmt.Fprintf(&b, "compaction_files_done:%d\r\n", p.FilesDone)
+		fmt.Fprintf(&b, "compaction_files_total:%d\r\n", p.FilesTotal)
+		fmt.Fprintf(&b, "compaction_bytes_done:%d\r\n", p.BytesDone)
+		fmt.Fprintf(&b, "compaction_bytes_total:%d\r\n", p.BytesTotal)
+	} else {
+		fmt.Fprintf(&b, "compaction_in_progress:0\r\n")
+	}
+
+	lastStatus := job.lastStatus
+	if lastStatus == "" {
+		lastStatus = "none"
+	}
+	fmt.Fprintf(&b, "compaction_last_status:%s\r\n", lastStatus)
+	if job.lastError != "" {
+		fmt.Fprintf(&b, "compaction_last_error:%s\r\n", job.lastError)
+	}
+	if !job.lastFinished.IsZero() {
+		fmt.Fprintf(&b, "compaction_last_finished_at:%d\r\n", job.lastFinished.Unix())
+		fmt.Fprintf(&b, "compaction_last_duration_ms:%d\r\n", job.lastDuration.Milliseconds())
+	}
+	return b.String()
+}
+
+func (s *Server) info(db *logra.LograDB, section string) string {
+	var b strings.Builder
+	all := section == "" || section == "all" || section == "everything"
+
+	if all || section == "server" {
+		b.WriteString("# Server\r\n")
+		fmt.Fprintf(&b, "logra_version:%s\r\n", db.Version())
+		fmt.Fprintf(&b, "tcp_port:%s\r\n", portOf(s.Addr().String()))
+		b.WriteString("\r\n")
+	}
+	if all || section == "keyspace" {
+		b.WriteString("# Keyspace\r\n")
+		fmt.Fprintf(&b, "db0:keys=%d\r\n", db.Len())
+		b.WriteString("\r\n")
+	}
+	if all || section == "compaction" {
+		b.WriteString("# Compaction\r\n")
+		b.WriteString(s.compactionInfo())
+		b.WriteString("\r\n")
+	}
+	return b.String()
+}
+
+func portOf(addr string) string {
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		return addr[i+1:]
+	}
+	return addr
+}
+
+// handleAdmin serves commands that need server state rather than just the
+// database. It reports whether cmd was one of them.
+func (s *Server) handleAdmin(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	switch cmd {
+	case "COMPACT":
+		sub := ""
+		if len(args) > 1 {
+			sub = strings.ToUpper(args[1].Str)
+		}
+		switch {
+		case len(args) == 1:
+			if err := s.startCompaction(); err != nil {
+				WriteError(w, "ERR "+err.Error())
+			} else {
+				WriteSimpleString(w, "Background compaction started")
+			}
+		case len(args) == 2 && sub == "STATUS":
+			WriteBulkString(w, s.compactionInfo())
+		case len(args) == 2 && sub == "CANCEL":
+			if s.cancelCompaction() {
+				WriteSimpleString(w, "OK")
+			} else {
+				WriteError(w, "ERR no compaction in progress")
+			}
+		default:
+			WriteError(w, "ERR syntax error")
+		}
+
+	case "INFO":
+		section := ""
+		if len(args) > 1 {
+			section = strings.ToLower(args[1].Str)
+		}
+		WriteBulkString(w, s.info(db, section))
+
+	case "PUBLISH":
+		if len(args) != 3 {
+			WriteError(w, "ERR wrong number of arguments for 'publish' command")
+			return true
+		}
+		channel, message := args[1].Str, args[2].Str
+		if db.AfterCommit(func() { s.pubsub.publish(channel, message) }) {
+			// Inside EXEC the message waits for the commit, so the reply
+			// counts the clients it would reach now
+			WriteInteger(w, int64(s.pubsub.receivers(channel)))
+		} else {
+			WriteInteger(w, int64(s.pubsub.publish(channel, message)))
+		}
+
+	case "PUBSUB":
+		s.pubsubInfo(args, w)
+
+	case "CONFIG":
+		return s.handleConfig(args, w)
+
+	case "UNWATCH":
+		// Only reached from EXEC; the keys are released once it finishes
+		WriteSimpleString(w, "OK")
+
+	default:
+		return false
+	}
+	return true
+}
+
+// handleConfig serves CONFIG GET and SET for the parameters the server
+// implements. Anything else falls through to the handler's lenient reply.
+func (s *Server) handleConfig(args []RESPValue, w *bufio.Writer) bool {
+	if len(args) < 2 {
+		return false
+	}
+	switch strings.ToUpper(args[1].Str) {
+	case "GET":
+		if len(args) != 3 {
+			WriteError(w, "ERR wrong number of arguments for 'config|get' command")
+			return true
+		}
+		if !glob.Match(strings.ToLower(args[2].Str), "notify-keyspace-events") {
+			WriteArray(w, 0)
+			return true
+		}
+		WriteArray(w, 2)
+		WriteBulkString(w, "notify-keyspace-events")
+		WriteBulkString(w, s.notifier.String())
+
+	case "SET":
+		if len(args) != 4 || strings.ToLower(args[2].Str) != "notify-keyspace-events" {
+			return false
+		}
+		flags, ok := parseNotifyFlags(args[3].Str)
+		if !ok {
+			WriteError(w, "ERR Invalid argument '"+args[3].Str+"' for CONFIG SET 'notify-keyspace-events'")
+			return true
+		}
+		s.notifier.flags.Store(flags)
+		WriteSimpleString(w, "OK")
+
+	default:
+		return false
+	}
+	return true
+}
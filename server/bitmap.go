@@ -0,0 +1,278 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+)
+
+// handleBitmap serves the bit commands, which work on string values. It
+// reports whether cmd was one of them.
+func handleBitmap(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "SETBIT":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		offset, ok := parseBitOffset(args[2].Str)
+		if !ok {
+			WriteError(w, "ERR "+logra.ErrBitOffset.Error())
+			return true
+		}
+		if args[3].Str != "0" && args[3].Str != "1" {
+			WriteError(w, "ERR bit is not an integer or out of range")
+			return true
+		}
+		old, err := db.SetBit(args[1].Str, offset, int(args[3].Str[0]-'0'))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(old))
+		}
+
+	case "GETBIT":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		offset, ok := parseBitOffset(args[2].Str)
+		if !ok {
+			WriteError(w, "ERR "+logra.ErrBitOffset.Error())
+			return true
+		}
+		bit, err := db.GetBit(args[1].Str, offset)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(bit))
+		}
+
+	case "BITCOUNT":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		r := logra.AllBits
+		if len(args) > 2 {
+			var errMsg string
+			if r, errMsg = parseBitRange(args[2:], false); errMsg != "" {
+				WriteError(w, errMsg)
+				return true
+			}
+		}
+		n, err := db.BitCount(args[1].Str, r)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, n)
+		}
+
+	case "BITPOS":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		if args[2].Str != "0" && args[2].Str != "1" {
+			WriteError(w, "ERR The bit argument must be 1 or 0.")
+			return true
+		}
+		r := logra.AllBits
+		if len(args) > 3 {
+			var errMsg string
+			if r, errMsg = parseBitRange(args[3:], true); errMsg != "" {
+				WriteError(w, errMsg)
+				return true
+			}
+		}
+		pos, err := db.BitPos(args[1].Str, int(args[2].Str[0]-'0'), r)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, pos)
+		}
+
+	case "BITOP":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		var op logra.BitOperator
+		switch strings.ToUpper(args[1].Str) {
+		case "AND":
+			op = logra.BitAnd
+		case "OR":
+			op = logra.BitOr
+		case "XOR":
+			op = logra.BitXor
+		case "NOT":
+			op = logra.BitNot
+		default:
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		if op == logra.BitNot && len(args) != 4 {
+			WriteError(w, "ERR BITOP NOT must be called with a single source key.")
+			return true
+		}
+		keys := make([]string, len(args)-3)
+		for i, arg := range args[3:] {
+			keys[i] = arg.Str
+		}
+		n, err := db.BitOp(op, args[2].Str, keys)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, n)
+		}
+
+	case "BITFIELD", "BITFIELD_RO":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		ops, errMsg := parseBitField(args[2:], cmd == "BITFIELD_RO")
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		results, err := db.BitField(args[1].Str, ops)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		WriteArray(w, len(results))
+		for _, v := range results {
+			if v == nil {
+				WriteNullBulk(w)
+			} else {
+				WriteInteger(w, *v)
+			}
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// parseBitOffset parses the bit offset of SETBIT and GETBIT.
+func parseBitOffset(s string) (int64, bool) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil && n >= 0 && n < logra.MaxStringSize*8
+}
+
+// parseBitRange parses "start end [BYTE | BIT]", the range of BITCOUNT and,
+// where end is optional, of BITPOS. It returns an error message if the
+// arguments are invalid.
+func parseBitRange(args []RESPValue, endOptional bool) (logra.BitRange, string) {
+	var r logra.BitRange
+	if len(args) > 3 || (len(args) == 1 && !endOptional) {
+		return r, "ERR syntax error"
+	}
+	var err error
+	if r.Start, err = strconv.ParseInt(args[0].Str, 10, 64); err != nil {
+		return r, "ERR value is not an integer or out of range"
+	}
+	r.End, r.OpenEnd = -1, len(args) == 1
+	if len(args) == 1 {
+		return r, ""
+	}
+	if r.End, err = strconv.ParseInt(args[1].Str, 10, 64); err != nil {
+		return r, "ERR value is not an integer or out of range"
+	}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2].Str) {
+		case "BIT":
+			r.Bits = true
+		case "BYTE":
+		default:
+			return r, "ERR syntax error"
+		}
+	}
+	return r, ""
+}
+
+// parseBitField parses the subcommands of BITFIELD: GET, SET and INCRBY on a
+// field, and OVERFLOW to change how the ones after it handle overflow. It
+// returns an error message if they are invalid.
+func parseBitField(args []RESPValue, readOnly bool) ([]logra.BitFieldOp, string) {
+	var ops []logra.BitFieldOp
+	overflow := logra.OverflowWrap
+	for i := 0; i < len(args); i++ {
+		sub := strings.ToUpper(args[i].Str)
+		if sub == "OVERFLOW" && i+1 < len(args) {
+			switch strings.ToUpper(args[i+1].Str) {
+			case "WRAP":
+				overflow = logra.OverflowWrap
+			case "SAT":
+				overflow = logra.OverflowSat
+			case "FAIL":
+				overflow = logra.OverflowFail
+			default:
+				return nil, "ERR Invalid OVERFLOW type specified"
+			}
+			i++
+			continue
+		}
+
+		op := logra.BitFieldOp{Overflow: overflow}
+		switch {
+		case sub == "GET" && i+2 < len(args):
+			op.Action = logra.BitFieldGet
+		case sub == "SET" && i+3 < len(args):
+			op.Action = logra.BitFieldSet
+		case sub == "INCRBY" && i+3 < len(args):
+			op.Action = logra.BitFieldIncrBy
+		default:
+			return nil, "ERR syntax error"
+		}
+		if readOnly && op.Action != logra.BitFieldGet {
+			return nil, "ERR BITFIELD_RO only supports the GET subcommand"
+		}
+		if !parseBitFieldType(args[i+1].Str, &op) {
+			return nil, "ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is."
+		}
+		offset := args[i+2].Str
+		scale := int64(1)
+		if strings.HasPrefix(offset, "#") {
+			offset, scale = offset[1:], int64(op.Width)
+		}
+		n, err := strconv.ParseInt(offset, 10, 64)
+		if err != nil || n < 0 || n > logra.MaxStringSize*8/scale {
+			return nil, "ERR " + logra.ErrBitOffset.Error()
+		}
+		op.Offset = n * scale
+		i += 2
+		if op.Action != logra.BitFieldGet {
+			i++
+			if op.Value, err = strconv.ParseInt(args[i].Str, 10, 64); err != nil {
+				return nil, "ERR value is not an integer or out of range"
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, ""
+}
+
+// parseBitFieldType parses a field type, i1 to i64 or u1 to u63, into op.
+func parseBitFieldType(s string, op *logra.BitFieldOp) bool {
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u' && s[0] != 'I' && s[0] != 'U') {
+		return false
+	}
+	op.Signed = s[0] == 'i' || s[0] == 'I'
+	width, err := strconv.Atoi(s[1:])
+	limit := 63
+	if op.Signed {
+		limit = 64
+	}
+	if err != nil || width < 1 || width > limit {
+		return false
+	}
+	op.Width = uint(width)
+	return true
+}
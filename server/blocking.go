@@ -0,0 +1,182 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"sakthirathinam/logra"
+)
+
+// waitQueues holds, per key, the clients blocked on it in the order they
+// blocked. A write that may give them something to read wakes them all;
+// clients that take what they read, such as BLPOP, only do so once they are
+// first in line, so they are served in the order they blocked.
+type waitQueues struct {
+	mu      sync.Mutex
+	waiters map[string][]*waiter
+}
+
+type waiter struct {
+	// ready is signalled, without blocking, when one of the keys changed or
+	// the waiter moved up a line
+	ready chan struct{}
+}
+
+func newWaitQueues() *waitQueues {
+	return &waitQueues{waiters: make(map[string][]*waiter)}
+}
+
+func (q *waitQueues) add(keys []string) *waiter {
+	wt := &waiter{ready: make(chan struct{}, 1)}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, key := range keys {
+		q.waiters[key] = append(q.waiters[key], wt)
+	}
+	return wt
+}
+
+// remove takes wt out of the lines for keys and wakes the clients left in
+// them, as the next one may now be first.
+func (q *waitQueues) remove(wt *waiter, keys []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, key := range keys {
+		waiters := q.waiters[key]
+		for i, other := range waiters {
+			if other == wt {
+				waiters = append(waiters[:i], waiters[i+1:]...)
+				break
+			}
+		}
+		if len(waiters) == 0 {
+			delete(q.waiters, key)
+			continue
+		}
+		q.waiters[key] = waiters
+		q.signal(key)
+	}
+}
+
+// first reports whether wt is first in line for key.
+func (q *waitQueues) first(wt *waiter, key string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waiters := q.waiters[key]
+	return len(waiters) > 0 && waiters[0] == wt
+}
+
+// wake signals every client blocked on key.
+func (q *waitQueues) wake(key string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.signal(key)
+}
+
+func (q *waitQueues) signal(key string) {
+	for _, wt := range q.waiters[key] {
+		select {
+		case wt.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// eventHook is the database's Notifier. It wakes the clients blocked on the
+// key of an event, then passes the event on to keyspace notifications.
+type eventHook struct {
+	waits    *waitQueues
+	keyspace *keyspaceNotifier
+}
+
+func (h eventHook) Notify(class byte, event, key string) {
+	switch {
+	case class == logra.EventStream && event == "xadd",
+		class == logra.EventList && (event == "lpush" || event == "rpush"):
+		h.waits.wake(key)
+	}
+	h.keyspace.Notify(class, event, key)
+}
+
+// handleBlocking serves the blocking commands: BLPOP, BRPOP, BLMOVE and
+// BRPOPLPUSH, and XREAD and XREADGROUP with BLOCK. It reports whether it
+// handled cmd; other commands, and reads without BLOCK, are left to dispatch,
+// as are invalid arguments. The caller holds c.mu.
+func (s *Server) handleBlocking(c *client, cmd string, args []RESPValue) bool {
+	switch cmd {
+	case "BLPOP", "BRPOP", "BLMOVE", "BRPOPLPUSH":
+		p, errMsg := parseBlockingPop(cmd, args)
+		if errMsg != "" {
+			return false
+		}
+		s.block(c, p.keys, p.timeout, func(wt *waiter) bool {
+			for _, key := range p.keys {
+				if s.waits.first(wt, key) && p.popFrom(s.db, key, c.bw) {
+					return true
+				}
+			}
+			return false
+		})
+
+	case "XREAD", "XREADGROUP":
+		r, errMsg := parseStreamRead(args, cmd == "XREADGROUP")
+		if errMsg != "" || r.block < 0 {
+			return false
+		}
+		resolved := false
+		s.block(c, r.keys, time.Duration(r.block)*time.Millisecond, func(*waiter) bool {
+			// "$" is resolved once the client waits, so that no entry added
+			// after it is missed
+			if !resolved {
+				if err := resolveLastIDs(s.db, &r); err != nil {
+					writeErr(c.bw, err)
+					return true
+				}
+				resolved = true
+			}
+			replies, err := readStreams(s.db, r)
+			if err != nil {
+				writeErr(c.bw, err)
+				return true
+			}
+			if len(replies) > 0 {
+				writeStreamReplies(c.bw, replies)
+				return true
+			}
+			return false
+		})
+
+	default:
+		return false
+	}
+	return true
+}
+
+// block calls try until it replies, retrying whenever one of keys is written
+// to. A zero timeout waits forever. If the timeout passes or the server closes
+// first, block replies with a null array; if the client hangs up, it returns
+// without a reply.
+func (s *Server) block(c *client, keys []string, timeout time.Duration, try func(wt *waiter) bool) {
+	wt := s.waits.add(keys)
+	defer s.waits.remove(wt, keys)
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for !try(wt) {
+		select {
+		case <-wt.ready:
+		case <-expired:
+			WriteNullArray(c.bw)
+			return
+		case <-s.done:
+			WriteNullArray(c.bw)
+			return
+		case <-c.hangup:
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"sync"
+)
+
+// client is the state of one connection.
+type client struct {
+	conn net.Conn
+
+	// mu guards bw: command replies and pushed messages are written from
+	// different goroutines.
+	mu sync.Mutex
+	bw *bufio.Writer
+
+	tx       txState
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	out      outbox
+	pushOnce sync.Once
+
+	// hangup is closed once the connection has nothing more to read
+	hangup    chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newClient(conn net.Conn) *client {
+	return &client{
+		conn:     conn,
+		bw:       bufio.NewWriter(conn),
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		out:      outbox{notify: make(chan struct{}, 1)},
+		hangup:   make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// close disconnects the client. It is safe to call from any goroutine.
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+// readAhead is how many requests are read from a connection ahead of the
+// command being run.
+const readAhead = 64
+
+// readRequests reads requests from the connection into requests, which should
+// hold readAhead of them, until it fails. Reading ahead of the command being
+// run lets a blocked command notice that the client hung up, even if it
+// pipelined more commands after it; hangup is closed as soon as the
+// connection fails, while the requests read before stay queued.
+func (c *client) readRequests(requests chan<- RESPValue) {
+	defer close(requests)
+	defer close(c.hangup)
+	br := bufio.NewReader(c.conn)
+	for {
+		val, err := ReadRESP(br)
+		if err != nil {
+			return
+		}
+		select {
+		case requests <- val:
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+)
+
+// handleFilter serves the Bloom and Cuckoo filter commands of RedisBloom. It
+// reports whether cmd was one of them.
+func handleFilter(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+	strs := func(args []RESPValue) []string {
+		s := make([]string, len(args))
+		for i, arg := range args {
+			s[i] = arg.Str
+		}
+		return s
+	}
+	writeBools := func(bs []bool) {
+		WriteArray(w, len(bs))
+		for _, b := range bs {
+			writeBool(w, b)
+		}
+	}
+
+	switch cmd {
+	case "BF.RESERVE":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		opts := logra.DefaultBloomOptions
+		var err1, err2 error
+		opts.ErrorRate, err1 = strconv.ParseFloat(args[2].Str, 64)
+		opts.Capacity, err2 = strconv.ParseInt(args[3].Str, 10, 64)
+		if err1 != nil {
+			WriteError(w, "ERR bad error rate")
+			return true
+		}
+		if err2 != nil {
+			WriteError(w, "ERR bad capacity")
+			return true
+		}
+		for i := 4; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i].Str); {
+			case opt == "NONSCALING":
+				opts.NonScaling = true
+			case opt == "EXPANSION" && i+1 < len(args):
+				n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+				if err != nil {
+					WriteError(w, "ERR bad expansion")
+					return true
+				}
+				opts.Expansion = n
+				i++
+			default:
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+		}
+		if err := db.BFReserve(args[1].Str, opts); err != nil {
+			writeErr(w, err)
+		} else {
+			WriteSimpleString(w, "OK")
+		}
+
+	case "BF.ADD", "BF.MADD":
+		if !arity(cmd == "BF.ADD" && len(args) == 3 || cmd == "BF.MADD" && len(args) >= 3) {
+			return true
+		}
+		added, err := db.BFAdd(args[1].Str, strs(args[2:]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case cmd == "BF.ADD":
+			writeBool(w, added[0])
+		default:
+			writeBools(added)
+		}
+
+	case "BF.EXISTS", "BF.MEXISTS":
+		if !arity(cmd == "BF.EXISTS" && len(args) == 3 || cmd == "BF.MEXISTS" && len(args) >= 3) {
+			return true
+		}
+		found, err := db.BFExists(args[1].Str, strs(args[2:]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case cmd == "BF.EXISTS":
+			writeBool(w, found[0])
+		default:
+			writeBools(found)
+		}
+
+	case "BF.INFO":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		info, ok, err := db.BFInfo(args[1].Str)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			writeErr(w, logra.ErrFilterNotFound)
+		default:
+			writeFilterInfo(w, []string{"Capacity", "Size", "Number of filters", "Number of items inserted", "Expansion rate"},
+				info.Capacity, info.Size, info.Filters, info.Items, info.Expansion)
+		}
+
+	case "CF.RESERVE":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		opts := logra.DefaultCuckooOptions
+		var err error
+		if opts.Capacity, err = strconv.ParseInt(args[2].Str, 10, 64); err != nil {
+			WriteError(w, "ERR Bad capacity")
+			return true
+		}
+		for i := 3; i < len(args); i += 2 {
+			var opt *int64
+			switch strings.ToUpper(args[i].Str) {
+			case "BUCKETSIZE":
+				opt = &opts.BucketSize
+			case "MAXITERATIONS":
+				opt = &opts.MaxIterations
+			case "EXPANSION":
+				opt = &opts.Expansion
+			}
+			if opt == nil || i+1 == len(args) {
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+			if *opt, err = strconv.ParseInt(args[i+1].Str, 10, 64); err != nil {
+				WriteError(w, "ERR value is not an integer or out of range")
+				return true
+			}
+		}
+		if err := db.CFReserve(args[1].Str, opts); err != nil {
+			writeErr(w, err)
+		} else {
+			WriteSimpleString(w, "OK")
+		}
+
+	case "CF.ADD", "CF.ADDNX":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		added, err := db.CFAdd(args[1].Str, args[2].Str, cmd == "CF.ADDNX")
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeBool(w, added)
+		}
+
+	case "CF.EXISTS", "CF.MEXISTS":
+		if !arity(cmd == "CF.EXISTS" && len(args) == 3 || cmd == "CF.MEXISTS" && len(args) >= 3) {
+			return true
+		}
+		found, err := db.CFExists(args[1].Str, strs(args[2:]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case cmd == "CF.EXISTS":
+			writeBool(w, found[0])
+		default:
+			writeBools(found)
+		}
+
+	case "CF.COUNT":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		n, err := db.CFCount(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, n)
+		}
+
+	case "CF.DEL":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		deleted, err := db.CFDel(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeBool(w, deleted)
+		}
+
+	case "CF.INFO":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		info, ok, err := db.CFInfo(args[1].Str)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			writeErr(w, logra.ErrFilterNotFound)
+		default:
+			writeFilterInfo(w, []string{"Size", "Number of buckets", "Number of filters", "Number of items inserted",
+				"Number of items deleted", "Bucket size", "Expansion rate", "Max iterations"},
+				info.Size, info.Buckets, info.Filters, info.Items, info.Deleted, info.BucketSize, info.Expansion, info.MaxIterations)
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+func writeBool(w *bufio.Writer, b bool) {
+	if b {
+		WriteInteger(w, 1)
+	} else {
+		WriteInteger(w, 0)
+	}
+}
+
+// writeFilterInfo replies with the names of a filter's properties, each
+// followed by its value.
+func writeFilterInfo(w *bufio.Writer, names []string, values ...int64) {
+	WriteArray(w, 2*len(names))
+	for i, name := range names {
+		WriteSimpleString(w, name)
+		WriteInteger(w, values[i])
+	}
+}
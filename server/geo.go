@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+)
+
+// geoUnits are the distance units of the geo commands, in meters.
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "ft": 0.3048, "mi": 1609.34}
+
+// handleGeo serves the geo commands, which work on sorted sets. It reports
+// whether cmd was one of them.
+func handleGeo(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "GEOADD":
+		if !arity(len(args) >= 5) {
+			return true
+		}
+		var opts logra.ZAddOptions
+		i := 2
+	flags:
+		for ; i < len(args); i++ {
+			switch strings.ToUpper(args[i].Str) {
+			case "NX":
+				opts.NX = true
+			case "XX":
+				opts.XX = true
+			case "CH":
+				opts.CH = true
+			default:
+				break flags
+			}
+		}
+		triples := args[i:]
+		if len(triples) == 0 || len(triples)%3 != 0 {
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		if opts.NX && opts.XX {
+			WriteError(w, "ERR XX and NX options at the same time are not compatible")
+			return true
+		}
+		members := make([]logra.GeoMember, 0, len(triples)/3)
+		for j := 0; j < len(triples); j += 3 {
+			p, ok := parseGeoPoint(triples[j].Str, triples[j+1].Str)
+			if !ok {
+				WriteError(w, "ERR value is not a valid float")
+				return true
+			}
+			members = append(members, logra.GeoMember{Member: triples[j+2].Str, GeoPoint: p})
+		}
+		n, err := db.GeoAdd(args[1].Str, members, opts)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "GEOPOS":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		members := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			members[i] = arg.Str
+		}
+		points, err := db.GeoPos(args[1].Str, members)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		WriteArray(w, len(points))
+		for _, p := range points {
+			if p == nil {
+				WriteNullArray(w)
+			} else {
+				writeGeoPoint(w, *p)
+			}
+		}
+
+	case "GEODIST":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		unit := 1.0
+		switch len(args) {
+		case 4:
+		case 5:
+			var ok bool
+			if unit, ok = geoUnits[strings.ToLower(args[4].Str)]; !ok {
+				WriteError(w, "ERR unsupported unit provided. please use M, KM, FT, MI")
+				return true
+			}
+		default:
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		dist, ok, err := db.GeoDist(args[1].Str, args[2].Str, args[3].Str)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, formatGeoDist(dist/unit))
+		}
+
+	case "GEOSEARCH":
+		if !arity(len(args) >= 7) {
+			return true
+		}
+		s, errMsg := parseGeoSearch(name, args[2:])
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		results, err := db.GeoSearch(args[1].Str, s.query)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		writeGeoResults(w, results, s)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// geoSearch is a parsed GEOSEARCH: the query and what to reply with.
+type geoSearch struct {
+	query                         logra.GeoQuery
+	unit                          float64
+	withCoord, withDist, withHash bool
+}
+
+// parseGeoSearch parses the arguments of GEOSEARCH after the key. It returns
+// an error message if they are invalid.
+func parseGeoSearch(name string, args []RESPValue) (geoSearch, string) {
+	s := geoSearch{unit: 1}
+	q := &s.query
+	from, by := 0, 0
+	parseUnit := func(arg string) bool {
+		u, ok := geoUnits[strings.ToLower(arg)]
+		s.unit = u
+		return ok
+	}
+	const badUnit = "ERR unsupported unit provided. please use M, KM, FT, MI"
+
+	for i := 0; i < len(args); i++ {
+		left := len(args) - i - 1
+		switch opt := strings.ToUpper(args[i].Str); {
+		case opt == "FROMMEMBER" && left >= 1:
+			q.FromMember = args[i+1].Str
+			from++
+			i++
+		case opt == "FROMLONLAT" && left >= 2:
+			p, ok := parseGeoPoint(args[i+1].Str, args[i+2].Str)
+			if !ok {
+				return s, "ERR value is not a valid float"
+			}
+			q.From = p
+			from++
+			i += 2
+		case opt == "BYRADIUS" && left >= 2:
+			r, err := strconv.ParseFloat(args[i+1].Str, 64)
+			if err != nil {
+				return s, "ERR need numeric radius"
+			}
+			if r < 0 {
+				return s, "ERR radius cannot be negative"
+			}
+			if !parseUnit(args[i+2].Str) {
+				return s, badUnit
+			}
+			q.Radius = r * s.unit
+			by++
+			i += 2
+		case opt == "BYBOX" && left >= 3:
+			width, err := strconv.ParseFloat(args[i+1].Str, 64)
+			if err != nil {
+				return s, "ERR need numeric width"
+			}
+			height, err := strconv.ParseFloat(args[i+2].Str, 64)
+			if err != nil {
+				return s, "ERR need numeric height"
+			}
+			if width < 0 || height < 0 {
+				return s, "ERR height or width cannot be negative"
+			}
+			if !parseUnit(args[i+3].Str) {
+				return s, badUnit
+			}
+			q.ByBox, q.Width, q.Height = true, width*s.unit, height*s.unit
+			by++
+			i += 3
+		case opt == "ASC":
+			q.Sort = logra.GeoAsc
+		case opt == "DESC":
+			q.Sort = logra.GeoDesc
+		case opt == "COUNT" && left >= 1:
+			n, err := strconv.Atoi(args[i+1].Str)
+			if err != nil || n <= 0 {
+				return s, "ERR COUNT must be > 0"
+			}
+			q.Count = n
+			i++
+			if i+1 < len(args) && strings.EqualFold(args[i+1].Str, "ANY") {
+				q.Any = true
+				i++
+			}
+		case opt == "ANY":
+			return s, "ERR the ANY argument requires COUNT argument"
+		case opt == "WITHCOORD":
+			s.withCoord = true
+		case opt == "WITHDIST":
+			s.withDist = true
+		case opt == "WITHHASH":
+			s.withHash = true
+		default:
+			return s, "ERR syntax error"
+		}
+	}
+	if from != 1 {
+		return s, "ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + name
+	}
+	if by != 1 {
+		return s, "ERR exactly one of BYRADIUS and BYBOX can be specified for " + name
+	}
+	return s, ""
+}
+
+func parseGeoPoint(lon, lat string) (logra.GeoPoint, bool) {
+	x, err1 := strconv.ParseFloat(lon, 64)
+	y, err2 := strconv.ParseFloat(lat, 64)
+	return logra.GeoPoint{Lon: x, Lat: y}, err1 == nil && err2 == nil
+}
+
+func formatGeoDist(d float64) string {
+	return strconv.FormatFloat(d, 'f', 4, 64)
+}
+
+func writeGeoPoint(w *bufio.Writer, p logra.GeoPoint) {
+	WriteArray(w, 2)
+	WriteBulkString(w, strconv.FormatFloat(p.Lon, 'f', -1, 64))
+	WriteBulkString(w, strconv.FormatFloat(p.Lat, 'f', -1, 64))
+}
+
+// writeGeoResults replies with the members found, each alone or, with any
+// of the WITH options, as an array of the member, its distance, hash and
+// place, in that order.
+func writeGeoResults(w *bufio.Writer, results []logra.GeoResult, s geoSearch) {
+	extra := 0
+	for _, with := range []bool{s.withDist, s.withHash, s.withCoord} {
+		if with {
+			extra++
+		}
+	}
+	WriteArray(w, len(results))
+	for _, r := range results {
+		if extra == 0 {
+			WriteBulkString(w, r.Member)
+			continue
+		}
+		WriteArray(w, 1+extra)
+		WriteBulkString(w, r.Member)
+		if s.withDist {
+			WriteBulkString(w, formatGeoDist(r.Dist/s.unit))
+		}
+		if s.withHash {
+			WriteInteger(w, int64(r.Hash))
+		}
+		if s.withCoord {
+			writeGeoPoint(w, r.GeoPoint)
+		}
+	}
+}
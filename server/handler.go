@@ -2,6 +2,7 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"strings"
 
 	"sakthirathinam/logra"
@@ -29,22 +30,143 @@ func HandleCommand(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
 			return
 		}
 		rec, err := db.Get(args[1].Str)
-		if err != nil {
+		if errors.Is(err, logra.ErrWrongType) {
+			writeErr(w, err)
+		} else if err != nil {
 			WriteNullBulk(w)
 		} else {
 			WriteBulkString(w, rec.Value)
 		}
 
 	case "SET":
+		handleSet(db, args, w)
+
+	case "SETNX":
+		if len(args) != 3 {
+			WriteError(w, "ERR wrong number of arguments for 'setnx' command")
+			return
+		}
+		_, written, err := db.SetWithOptions(args[1].Str, args[2].Str, logra.SetOptions{NX: true})
+		if err != nil {
+			writeErr(w, err)
+		} else if written {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case "SETEX", "PSETEX":
+		handleSetEx(db, cmd, args, w)
+
+	case "GETSET":
 		if len(args) != 3 {
-			WriteError(w, "ERR wrong number of arguments for 'set' command")
+			WriteError(w, "ERR wrong number of arguments for 'getset' command")
+			return
+		}
+		old, _, err := db.SetWithOptions(args[1].Str, args[2].Str, logra.SetOptions{Get: true})
+		if err != nil {
+			writeErr(w, err)
+		} else if old == nil {
+			WriteNullBulk(w)
+		} else {
+			WriteBulkString(w, old.Value)
+		}
+
+	case "GETDEL":
+		if len(args) != 2 {
+			WriteError(w, "ERR wrong number of arguments for 'getdel' command")
+			return
+		}
+		rec, err := db.GetDel(args[1].Str)
+		if errors.Is(err, logra.ErrWrongType) {
+			writeErr(w, err)
+		} else if err != nil {
+			WriteNullBulk(w)
+		} else {
+			WriteBulkString(w, rec.Value)
+		}
+
+	case "GETEX":
+		handleGetEx(db, args, w)
+
+	case "INCR", "DECR", "INCRBY", "DECRBY":
+		handleIncr(db, cmd, args, w)
+
+	case "INCRBYFLOAT":
+		handleIncrByFloat(db, args, w)
+
+	case "APPEND":
+		if len(args) != 3 {
+			WriteError(w, "ERR wrong number of arguments for 'append' command")
+			return
+		}
+		n, err := db.Append(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "STRLEN":
+		if len(args) != 2 {
+			WriteError(w, "ERR wrong number of arguments for 'strlen' command")
+			return
+		}
+		WriteInteger(w, int64(db.StrLen(args[1].Str)))
+
+	case "GETRANGE":
+		handleGetRange(db, args, w)
+
+	case "SETRANGE":
+		handleSetRange(db, args, w)
+
+	case "MGET":
+		if len(args) < 2 {
+			WriteError(w, "ERR wrong number of arguments for 'mget' command")
+			return
+		}
+		keys := make([]string, len(args)-1)
+		for i, arg := range args[1:] {
+			keys[i] = arg.Str
+		}
+		records, err := db.MultiGet(keys)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		WriteArray(w, len(records))
+		for _, rec := range records {
+			if rec == nil {
+				WriteNullBulk(w)
+			} else {
+				WriteBulkString(w, rec.Value)
+			}
+		}
+
+	case "MSET", "MSETNX":
+		if len(args) < 3 || len(args)%2 != 1 {
+			WriteError(w, "ERR wrong number of arguments for '"+strings.ToLower(cmd)+"' command")
+			return
+		}
+		pairs := make([]logra.KeyValue, 0, (len(args)-1)/2)
+		for i := 1; i < len(args); i += 2 {
+			pairs = append(pairs, logra.KeyValue{Key: args[i].Str, Value: args[i+1].Str})
+		}
+		if cmd == "MSET" {
+			if err := db.MultiSet(pairs); err != nil {
+				writeErr(w, err)
+			} else {
+				WriteSimpleString(w, "OK")
+			}
 			return
 		}
-		err := db.Set(args[1].Str, args[2].Str)
+		set, err := db.MultiSetNX(pairs)
 		if err != nil {
-			WriteError(w, "ERR "+err.Error())
+			writeErr(w, err)
+		} else if set {
+			WriteInteger(w, 1)
 		} else {
-			WriteSimpleString(w, "OK")
+			WriteInteger(w, 0)
 		}
 
 	case "DEL":
@@ -73,6 +195,39 @@ func HandleCommand(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
 		}
 		WriteInteger(w, count)
 
+	case "EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT":
+		handleExpire(db, cmd, args, w)
+
+	case "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME":
+		handleTTL(db, cmd, args, w)
+
+	case "PERSIST":
+		if len(args) != 2 {
+			WriteError(w, "ERR wrong number of arguments for 'persist' command")
+			return
+		}
+		removed, err := db.Persist(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if removed {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case "KEYS":
+		handleKeys(db, args, w)
+
+	case "SCAN":
+		handleScan(db, args, w)
+
+	case "TYPE":
+		if len(args) != 2 {
+			WriteError(w, "ERR wrong number of arguments for 'type' command")
+			return
+		}
+		WriteSimpleString(w, db.Type(args[1].Str))
+
 	case "COMMAND":
 		WriteSimpleString(w, "OK")
 
@@ -80,9 +235,42 @@ func HandleCommand(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
 		WriteSimpleString(w, "OK")
 
 	case "DBSIZE":
-		WriteInteger(w, int64(db.Index.Len()))
+		WriteInteger(w, int64(db.Len()))
 
 	default:
+		for _, handle := range typeHandlers {
+			if handle(db, cmd, args, w) {
+				return
+			}
+		}
 		WriteError(w, "ERR unknown command '"+cmd+"'")
 	}
 }
+
+// typeHandlers serve the commands of the data types other than strings, and
+// the bit commands. Each reports whether it handled cmd.
+var typeHandlers = []func(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool{
+	handleHash,
+	handleList,
+	handleSets,
+	handleZSet,
+	handleStream,
+	handleBitmap,
+	handleHyperLogLog,
+	handleGeo,
+	handleJSON,
+	handleFilter,
+	handleVector,
+}
+
+// writeErr replies with an error from the database. Type, consumer group and
+// HyperLogLog errors carry their own prefix, as in Redis.
+func writeErr(w *bufio.Writer, err error) {
+	switch {
+	case errors.Is(err, logra.ErrWrongType), errors.Is(err, logra.ErrNoGroup), errors.Is(err, logra.ErrGroupExists),
+		errors.Is(err, logra.ErrNotHLL), errors.Is(err, logra.ErrCorruptHLL):
+		WriteError(w, err.Error())
+		return
+	}
+	WriteError(w, "ERR "+err.Error())
+}
@@ -0,0 +1,238 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+	"sakthirathinam/logra/internal/glob"
+)
+
+// handleHash serves the hash commands. It reports whether cmd was one of
+// them.
+func handleHash(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "HSET", "HMSET":
+		if !arity(len(args) >= 4 && len(args)%2 == 0) {
+			return true
+		}
+		fields := make([]logra.FieldValue, 0, (len(args)-2)/2)
+		for i := 2; i < len(args); i += 2 {
+			fields = append(fields, logra.FieldValue{Field: args[i].Str, Value: args[i+1].Str})
+		}
+		n, err := db.HSet(args[1].Str, fields)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case cmd == "HMSET":
+			WriteSimpleString(w, "OK")
+		default:
+			WriteInteger(w, int64(n))
+		}
+
+	case "HSETNX":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		ok, err := db.HSetNX(args[1].Str, args[2].Str, args[3].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if ok {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case "HGET":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		value, ok, err := db.HGet(args[1].Str, args[2].Str)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, value)
+		}
+
+	case "HMGET":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		fields := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			fields[i] = arg.Str
+		}
+		values, err := db.HMGet(args[1].Str, fields)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		WriteArray(w, len(values))
+		for _, v := range values {
+			if v == nil {
+				WriteNullBulk(w)
+			} else {
+				WriteBulkString(w, *v)
+			}
+		}
+
+	case "HGETALL", "HVALS":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		fields, err := db.HGetAll(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		if cmd == "HVALS" {
+			WriteArray(w, len(fields))
+		} else {
+			WriteArray(w, 2*len(fields))
+		}
+		for _, fv := range fields {
+			if cmd == "HGETALL" {
+				WriteBulkString(w, fv.Field)
+			}
+			WriteBulkString(w, fv.Value)
+		}
+
+	case "HKEYS":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		fields, err := db.HKeys(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		WriteArray(w, len(fields))
+		for _, field := range fields {
+			WriteBulkString(w, field)
+		}
+
+	case "HLEN":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.HLen(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "HEXISTS":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		ok, err := db.HExists(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if ok {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case "HDEL":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		fields := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			fields[i] = arg.Str
+		}
+		n, err := db.HDel(args[1].Str, fields)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "HINCRBY":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		delta, err := strconv.ParseInt(args[3].Str, 10, 64)
+		if err != nil {
+			WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+			return true
+		}
+		n, err := db.HIncrBy(args[1].Str, args[2].Str, delta)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, n)
+		}
+
+	case "HINCRBYFLOAT":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		delta, err := strconv.ParseFloat(args[3].Str, 64)
+		if err != nil {
+			WriteError(w, "ERR "+logra.ErrNotFloat.Error())
+			return true
+		}
+		result, err := db.HIncrByFloat(args[1].Str, args[2].Str, delta)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteBulkString(w, result)
+		}
+
+	case "HSCAN":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		handleHScan(db, args, w)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// handleHScan serves HSCAN key cursor [MATCH pattern] [COUNT count].
+func handleHScan(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	cursor, err := strconv.ParseUint(args[2].Str, 10, 64)
+	if err != nil {
+		WriteError(w, "ERR invalid cursor")
+		return
+	}
+	pattern, _, count, errMsg := parseScanArgs(args[3:], false)
+	if errMsg != "" {
+		WriteError(w, errMsg)
+		return
+	}
+
+	var items []string
+	next, err := db.HScan(args[1].Str, cursor, count, func(field, value string) {
+		if pattern == "" || glob.Match(pattern, field) {
+			items = append(items, field, value)
+		}
+	})
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	WriteArray(w, 2)
+	WriteBulkString(w, strconv.FormatUint(next, 10))
+	WriteArray(w, len(items))
+	for _, item := range items {
+		WriteBulkString(w, item)
+	}
+}
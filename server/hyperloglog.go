@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+
+	"sakthirathinam/logra"
+)
+
+// handleHyperLogLog serves the HyperLogLog commands. It reports whether cmd
+// was one of them.
+func handleHyperLogLog(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+	strs := func(args []RESPValue) []string {
+		s := make([]string, len(args))
+		for i, arg := range args {
+			s[i] = arg.Str
+		}
+		return s
+	}
+
+	switch cmd {
+	case "PFADD":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		changed, err := db.PFAdd(args[1].Str, strs(args[2:]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case changed:
+			WriteInteger(w, 1)
+		default:
+			WriteInteger(w, 0)
+		}
+
+	case "PFCOUNT":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		n, err := db.PFCount(strs(args[1:]))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, n)
+		}
+
+	case "PFMERGE":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		if err := db.PFMerge(args[1].Str, strs(args[2:])); err != nil {
+			writeErr(w, err)
+		} else {
+			WriteSimpleString(w, "OK")
+		}
+
+	default:
+		return false
+	}
+	return true
+}
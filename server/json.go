@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"strings"
+
+	"sakthirathinam/logra"
+	"sakthirathinam/logra/internal/jsonpath"
+)
+
+// handleJSON serves the JSON commands of RedisJSON. It reports whether cmd
+// was one of them.
+func handleJSON(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+	strs := func(args []RESPValue) []string {
+		s := make([]string, len(args))
+		for i, arg := range args {
+			s[i] = arg.Str
+		}
+		return s
+	}
+	// path returns the optional path argument i, or def.
+	path := func(i int, def string) string {
+		if i < len(args) {
+			return args[i].Str
+		}
+		return def
+	}
+
+	switch cmd {
+	case "JSON.SET":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		var opts logra.JSONSetOptions
+		for _, arg := range args[4:] {
+			switch strings.ToUpper(arg.Str) {
+			case "NX":
+				opts.NX = true
+			case "XX":
+				opts.XX = true
+			default:
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+		}
+		if opts.NX && opts.XX {
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		ok, err := db.JSONSet(args[1].Str, args[2].Str, args[3].Str, opts)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case ok:
+			WriteSimpleString(w, "OK")
+		default:
+			WriteNullBulk(w)
+		}
+
+	case "JSON.GET":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		doc, ok, err := db.JSONGet(args[1].Str, strs(args[2:]))
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, doc)
+		}
+
+	case "JSON.DEL", "JSON.FORGET":
+		if !arity(len(args) == 2 || len(args) == 3) {
+			return true
+		}
+		n, err := db.JSONDel(args[1].Str, path(2, "$"))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "JSON.NUMINCRBY":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		result, err := db.JSONNumIncrBy(args[1].Str, args[2].Str, args[3].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteBulkString(w, result)
+		}
+
+	case "JSON.ARRAPPEND":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		lens, err := db.JSONArrAppend(args[1].Str, args[2].Str, strs(args[3:]))
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		if jsonpath.IsLegacy(args[2].Str) {
+			// A legacy path has matched an array
+			WriteInteger(w, *lens[0])
+			return true
+		}
+		WriteArray(w, len(lens))
+		for _, n := range lens {
+			if n == nil {
+				WriteNullBulk(w)
+			} else {
+				WriteInteger(w, *n)
+			}
+		}
+
+	case "JSON.TYPE":
+		if !arity(len(args) == 2 || len(args) == 3) {
+			return true
+		}
+		p := path(2, ".")
+		types, ok, err := db.JSONType(args[1].Str, p)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case jsonpath.IsLegacy(p):
+			if !ok || len(types) == 0 {
+				WriteNullBulk(w)
+			} else {
+				WriteSimpleString(w, types[0])
+			}
+		case !ok:
+			WriteNullArray(w)
+		default:
+			WriteArray(w, len(types))
+			for _, t := range types {
+				WriteSimpleString(w, t)
+			}
+		}
+
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"sakthirathinam/logra"
+	"sakthirathinam/logra/internal/glob"
+)
+
+// handleExpire serves EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, each taking an
+// optional NX, XX, GT or LT condition. A time in the past deletes the key.
+func handleExpire(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) {
+	name := strings.ToLower(cmd)
+	if len(args) < 3 {
+		WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+	n, err := strconv.ParseInt(args[2].Str, 10, 64)
+	if err != nil {
+		WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+		return
+	}
+
+	cond := logra.ExpireAlways
+	for _, arg := range args[3:] {
+		switch strings.ToUpper(arg.Str) {
+		case "NX":
+			cond |= logra.ExpireNX
+		case "XX":
+			cond |= logra.ExpireXX
+		case "GT":
+			cond |= logra.ExpireGT
+		case "LT":
+			cond |= logra.ExpireLT
+		default:
+			WriteError(w, "ERR Unsupported option "+arg.Str)
+			return
+		}
+	}
+	switch {
+	case cond&logra.ExpireNX != 0 && cond != logra.ExpireNX:
+		WriteError(w, "ERR NX and XX, GT or LT options at the same time are not compatible")
+		return
+	case cond&logra.ExpireGT != 0 && cond&logra.ExpireLT != 0:
+		WriteError(w, "ERR GT and LT options at the same time are not compatible")
+		return
+	}
+
+	invalid := "ERR invalid expire time in '" + name + "' command"
+	if cmd == "EXPIRE" || cmd == "EXPIREAT" {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			WriteError(w, invalid)
+			return
+		}
+		n *= 1000
+	}
+	if cmd == "EXPIRE" || cmd == "PEXPIRE" {
+		now := time.Now().UnixMilli()
+		if n > math.MaxInt64-now {
+			WriteError(w, invalid)
+			return
+		}
+		n += now
+	}
+
+	set, err := db.Expire(args[1].Str, n, cond)
+	if err != nil {
+		writeErr(w, err)
+	} else if set {
+		WriteInteger(w, 1)
+	} else {
+		WriteInteger(w, 0)
+	}
+}
+
+// handleTTL serves TTL, PTTL, EXPIRETIME and PEXPIRETIME. Like Redis they
+// reply -2 for a missing key and -1 for a key without a deadline.
+func handleTTL(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) {
+	if len(args) != 2 {
+		WriteError(w, "ERR wrong number of arguments for '"+strings.ToLower(cmd)+"' command")
+		return
+	}
+	at := db.ExpireTime(args[1].Str)
+	if at < 0 {
+		WriteInteger(w, at)
+		return
+	}
+
+	switch cmd {
+	case "EXPIRETIME":
+		WriteInteger(w, at/1000)
+	case "PEXPIRETIME":
+		WriteInteger(w, at)
+	default:
+		remaining := at - time.Now().UnixMilli()
+		if remaining < 0 {
+			remaining = 0
+		}
+		if cmd == "TTL" {
+			remaining = (remaining + 500) / 1000
+		}
+		WriteInteger(w, remaining)
+	}
+}
+
+// handleKeys serves KEYS pattern.
+func handleKeys(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) != 2 {
+		WriteError(w, "ERR wrong number of arguments for 'keys' command")
+		return
+	}
+	pattern := args[1].Str
+	var keys []string
+	db.RangeKeys(func(key string) bool {
+		if glob.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	WriteArray(w, len(keys))
+	for _, key := range keys {
+		WriteBulkString(w, key)
+	}
+}
+
+// handleScan serves SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+func handleScan(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) < 2 {
+		WriteError(w, "ERR wrong number of arguments for 'scan' command")
+		return
+	}
+	cursor, err := strconv.ParseUint(args[1].Str, 10, 64)
+	if err != nil {
+		WriteError(w, "ERR invalid cursor")
+		return
+	}
+
+	pattern, typ, count, errMsg := parseScanArgs(args[2:], true)
+	if errMsg != "" {
+		WriteError(w, errMsg)
+		return
+	}
+
+	var keys []string
+	next := db.ScanKeys(cursor, count, func(key string) {
+		if pattern == "" || glob.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+	})
+	if typ != "" {
+		// Type takes the lock itself, so filter once the scan is done
+		kept := keys[:0]
+		for _, key := range keys {
+			if db.Type(key) == typ {
+				kept = append(kept, key)
+			}
+		}
+		keys = kept
+	}
+
+	WriteArray(w, 2)
+	WriteBulkString(w, strconv.FormatUint(next, 10))
+	WriteArray(w, len(keys))
+	for _, key := range keys {
+		WriteBulkString(w, key)
+	}
+}
+
+// parseScanArgs parses the MATCH and COUNT options of the SCAN family, and
+// TYPE if withType is set.
+func parseScanArgs(args []RESPValue, withType bool) (pattern, typ string, count int, errMsg string) {
+	count = 10
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return "", "", 0, "ERR syntax error"
+		}
+		switch opt := strings.ToUpper(args[i].Str); {
+		case opt == "MATCH":
+			pattern = args[i+1].Str
+		case opt == "COUNT":
+			n, err := strconv.Atoi(args[i+1].Str)
+			if err != nil {
+				return "", "", 0, "ERR " + logra.ErrNotInteger.Error()
+			}
+			if n < 1 {
+				return "", "", 0, "ERR syntax error"
+			}
+			count = n
+		case opt == "TYPE" && withType:
+			typ = strings.ToLower(args[i+1].Str)
+		default:
+			return "", "", 0, "ERR syntax error"
+		}
+	}
+	return pattern, typ, count, ""
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"sakthirathinam/logra"
+)
+
+// handleList serves the list commands. It reports whether cmd was one of
+// them.
+func handleList(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "LPUSH", "RPUSH", "LPUSHX", "RPUSHX":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		values := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			values[i] = arg.Str
+		}
+		push := db.LPush
+		switch cmd {
+		case "RPUSH":
+			push = db.RPush
+		case "LPUSHX":
+			push = db.LPushX
+		case "RPUSHX":
+			push = db.RPushX
+		}
+		n, err := push(args[1].Str, values)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "LPOP", "RPOP":
+		if !arity(len(args) == 2 || len(args) == 3) {
+			return true
+		}
+		count := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2].Str)
+			if err != nil || n < 0 {
+				WriteError(w, "ERR value is out of range, must be positive")
+				return true
+			}
+			count = n
+		}
+		pop := db.LPop
+		if cmd == "RPOP" {
+			pop = db.RPop
+		}
+		values, err := pop(args[1].Str, count)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case len(args) == 2 && len(values) == 0:
+			WriteNullBulk(w)
+		case len(args) == 2:
+			WriteBulkString(w, values[0])
+		case values == nil && count > 0:
+			WriteNullArray(w)
+		default:
+			writeBulkArray(w, values)
+		}
+
+	case "LLEN":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.LLen(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "LINDEX":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		index, err := strconv.Atoi(args[2].Str)
+		if err != nil {
+			WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+			return true
+		}
+		value, ok, err := db.LIndex(args[1].Str, index)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, value)
+		}
+
+	case "LRANGE", "LTRIM":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		start, err1 := strconv.Atoi(args[2].Str)
+		stop, err2 := strconv.Atoi(args[3].Str)
+		if err1 != nil || err2 != nil {
+			WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+			return true
+		}
+		if cmd == "LTRIM" {
+			if err := db.LTrim(args[1].Str, start, stop); err != nil {
+				writeErr(w, err)
+			} else {
+				WriteSimpleString(w, "OK")
+			}
+			return true
+		}
+		values, err := db.LRange(args[1].Str, start, stop)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeBulkArray(w, values)
+		}
+
+	case "LMOVE", "RPOPLPUSH":
+		from, to := "RIGHT", "LEFT"
+		if cmd == "LMOVE" {
+			if !arity(len(args) == 5) {
+				return true
+			}
+			from, to = strings.ToUpper(args[3].Str), strings.ToUpper(args[4].Str)
+		} else if !arity(len(args) == 3) {
+			return true
+		}
+		if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		value, ok, err := db.LMove(args[1].Str, args[2].Str, from == "LEFT", to == "LEFT")
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, value)
+		}
+
+	case "BLPOP", "BRPOP", "BLMOVE", "BRPOPLPUSH":
+		// Blocking is up to the connection (see blocking.go); here, as inside
+		// MULTI, the pop returns at once.
+		p, errMsg := parseBlockingPop(cmd, args)
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		for _, key := range p.keys {
+			if p.popFrom(db, key, w) {
+				return true
+			}
+		}
+		WriteNullArray(w)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// blockingPop is a parsed BLPOP, BRPOP, BLMOVE or BRPOPLPUSH.
+type blockingPop struct {
+	keys    []string // the lists to pop from, in order of preference
+	left    bool     // pop from the head
+	move    bool     // push the element onto dst instead of replying with it
+	dst     string
+	toLeft  bool
+	timeout time.Duration
+}
+
+// parseBlockingPop parses the arguments of a blocking pop. It returns an
+// error message if they are invalid.
+func parseBlockingPop(cmd string, args []RESPValue) (p blockingPop, errMsg string) {
+	arity := "ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command"
+	switch cmd {
+	case "BLPOP", "BRPOP":
+		if len(args) < 3 {
+			return p, arity
+		}
+		for _, arg := range args[1 : len(args)-1] {
+			p.keys = append(p.keys, arg.Str)
+		}
+		p.left = cmd == "BLPOP"
+	case "BLMOVE":
+		if len(args) != 6 {
+			return p, arity
+		}
+		from, to := strings.ToUpper(args[3].Str), strings.ToUpper(args[4].Str)
+		if (from != "LEFT" && from != "RIGHT") || (to != "LEFT" && to != "RIGHT") {
+			return p, "ERR syntax error"
+		}
+		p.keys, p.move, p.dst = []string{args[1].Str}, true, args[2].Str
+		p.left, p.toLeft = from == "LEFT", to == "LEFT"
+	case "BRPOPLPUSH":
+		if len(args) != 4 {
+			return p, arity
+		}
+		p.keys, p.move, p.dst, p.toLeft = []string{args[1].Str}, true, args[2].Str, true
+	}
+
+	secs, err := strconv.ParseFloat(args[len(args)-1].Str, 64)
+	if err != nil || math.IsNaN(secs) || math.IsInf(secs, 0) {
+		return p, "ERR timeout is not a float or out of range"
+	}
+	if secs < 0 {
+		return p, "ERR timeout is negative"
+	}
+	// Like Redis, round up to whole milliseconds, so that a tiny timeout
+	// does not become zero and wait forever
+	ms := math.Ceil(secs * 1000)
+	if ms > float64(math.MaxInt64/int64(time.Millisecond)) {
+		return p, "ERR timeout is out of range"
+	}
+	p.timeout = time.Duration(ms) * time.Millisecond
+	return p, ""
+}
+
+// popFrom pops from the list at key, or moves its element, and replies if
+// there was one. It reports whether it replied, which it also does with an
+// error.
+func (p blockingPop) popFrom(db *logra.LograDB, key string, w *bufio.Writer) bool {
+	if p.move {
+		value, ok, err := db.LMove(key, p.dst, p.left, p.toLeft)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case ok:
+			WriteBulkString(w, value)
+		}
+		return err != nil || ok
+	}
+
+	pop := db.RPop
+	if p.left {
+		pop = db.LPop
+	}
+	values, err := pop(key, 1)
+	switch {
+	case err != nil:
+		writeErr(w, err)
+	case len(values) > 0:
+		writeBulkArray(w, []string{key, values[0]})
+	}
+	return err != nil || len(values) > 0
+}
+
+// writeBulkArray replies with values as an array of bulk strings.
+func writeBulkArray(w *bufio.Writer, values []string) {
+	WriteArray(w, len(values))
+	for _, v := range values {
+		WriteBulkString(w, v)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+
+	"sakthirathinam/logra"
+)
+
+// txState is the MULTI/EXEC state of one connection.
+type txState struct {
+	active  bool
+	queued  [][]RESPValue
+	watched []logra.WatchedKey
+}
+
+func (s *Server) resetTx(tx *txState) {
+	s.db.Unwatch(tx.watched)
+	*tx = txState{}
+}
+
+// handleTx serves MULTI, EXEC, DISCARD, WATCH and UNWATCH, and queues every
+// other command while a MULTI is open. It reports whether it handled cmd.
+func (s *Server) handleTx(tx *txState, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	switch cmd {
+	case "MULTI":
+		if tx.active {
+			WriteError(w, "ERR MULTI calls can not be nested")
+			return true
+		}
+		tx.active = true
+		WriteSimpleString(w, "OK")
+
+	case "EXEC":
+		if !tx.active {
+			WriteError(w, "ERR EXEC without MULTI")
+			return true
+		}
+		s.exec(tx, w)
+
+	case "DISCARD":
+		if !tx.active {
+			WriteError(w, "ERR DISCARD without MULTI")
+			return true
+		}
+		s.resetTx(tx)
+		WriteSimpleString(w, "OK")
+
+	case "WATCH":
+		if tx.active {
+			WriteError(w, "ERR WATCH inside MULTI is not allowed")
+			return true
+		}
+		if len(args) < 2 {
+			WriteError(w, "ERR wrong number of arguments for 'watch' command")
+			return true
+		}
+		for _, arg := range args[1:] {
+			tx.watched = append(tx.watched, s.db.Watch(arg.Str))
+		}
+		WriteSimpleString(w, "OK")
+
+	case "UNWATCH":
+		if tx.active {
+			// Queued like any other command; EXEC releases the keys anyway
+			tx.queued = append(tx.queued, args)
+			WriteSimpleString(w, "QUEUED")
+			return true
+		}
+		s.db.Unwatch(tx.watched)
+		tx.watched = nil
+		WriteSimpleString(w, "OK")
+
+	default:
+		if !tx.active {
+			return false
+		}
+		tx.queued = append(tx.queued, args)
+		WriteSimpleString(w, "QUEUED")
+	}
+	return true
+}
+
+// exec runs the queued commands as one transaction. Replies are collected
+// first, so a transaction that fails to persist answers with a single error.
+func (s *Server) exec(tx *txState, w *bufio.Writer) {
+	var replies bytes.Buffer
+	rw := bufio.NewWriter(&replies)
+	ok, err := s.db.Transaction(tx.watched, func(view *logra.LograDB) {
+		for _, args := range tx.queued {
+			s.dispatch(view, args, rw)
+		}
+	})
+	rw.Flush()
+	n := len(tx.queued)
+	s.resetTx(tx)
+
+	switch {
+	case err != nil:
+		WriteError(w, "ERR "+err.Error())
+	case !ok:
+		WriteNullArray(w)
+	default:
+		WriteArray(w, n)
+		w.Write(replies.Bytes())
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// Flags of notify-keyspace-events beyond the event classes, which use the
+// class character itself.
+const (
+	notifyKeyspace = 'K' // publish to __keyspace@0__:<key>
+	notifyKeyevent = 'E' // publish to __keyevent@0__:<event>
+	notifyKeyMiss  = 'm' // accepted for compatibility; never fires
+)
+
+// notifyClasses are the classes "A" stands for, in the order CONFIG GET
+// lists them.
+const notifyClasses = "g$lshzxetd"
+
+// notifyFlags are all accepted flags, in the order CONFIG GET lists them.
+const notifyFlags = notifyClasses + "KEmn"
+
+// keyspaceNotifier publishes database events to the keyspace channels, as
+// selected by notify-keyspace-events. It is disabled until configured.
+type keyspaceNotifier struct {
+	ps    *pubsub
+	flags atomic.Uint32
+}
+
+// parseNotifyFlags turns a notify-keyspace-events string into a bit set.
+func parseNotifyFlags(s string) (uint32, bool) {
+	var flags uint32
+	for i := 0; i < len(s); i++ {
+		chars := s[i : i+1]
+		if s[i] == 'A' {
+			chars = notifyClasses
+		}
+		for j := 0; j < len(chars); j++ {
+			bit := strings.IndexByte(notifyFlags, chars[j])
+			if bit < 0 {
+				return 0, false
+			}
+			flags |= 1 << bit
+		}
+	}
+	return flags, true
+}
+
+func notifyBit(flag byte) uint32 {
+	if bit := strings.IndexByte(notifyFlags, flag); bit >= 0 {
+		return 1 << bit
+	}
+	return 0
+}
+
+// String renders the flags as CONFIG GET reports them.
+func (n *keyspaceNotifier) String() string {
+	flags := n.flags.Load()
+	var b strings.Builder
+	all := true
+	for i := 0; i < len(notifyClasses); i++ {
+		all = all && flags&notifyBit(notifyClasses[i]) != 0
+	}
+	for i := 0; i < len(notifyFlags); i++ {
+		if all && i < len(notifyClasses) {
+			if i == 0 {
+				b.WriteByte('A')
+			}
+			continue
+		}
+		if flags&(1<<i) != 0 {
+			b.WriteByte(notifyFlags[i])
+		}
+	}
+	return b.String()
+}
+
+// Notify implements logra.Notifier.
+func (n *keyspaceNotifier) Notify(class byte, event, key string) {
+	flags := n.flags.Load()
+	if flags&notifyBit(class) == 0 {
+		return
+	}
+	if flags&notifyBit(notifyKeyspace) != 0 {
+		n.ps.publish("__keyspace@0__:"+key, event)
+	}
+	if flags&notifyBit(notifyKeyevent) != 0 {
+		n.ps.publish("__keyevent@0__:"+event, key)
+	}
+}
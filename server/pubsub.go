@@ -0,0 +1,340 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"sakthirathinam/logra/internal/glob"
+)
+
+// defaultPubSubBufferLimit caps the bytes queued for one subscriber, like
+// Redis's client-output-buffer-limit for pubsub clients. A subscriber that
+// falls further behind is disconnected.
+const defaultPubSubBufferLimit = 32 * 1024 * 1024
+
+// pubsub is the registry of channel and pattern subscriptions.
+type pubsub struct {
+	mu          sync.RWMutex
+	channels    map[string]map[*client]struct{}
+	patterns    map[string]map[*client]struct{}
+	bufferLimit int
+}
+
+func newPubSub() *pubsub {
+	return &pubsub{
+		channels:    make(map[string]map[*client]struct{}),
+		patterns:    make(map[string]map[*client]struct{}),
+		bufferLimit: defaultPubSubBufferLimit,
+	}
+}
+
+// SetPubSubBufferLimit sets how many bytes may be queued for a subscriber
+// before it is disconnected.
+func (s *Server) SetPubSubBufferLimit(n int) {
+	s.pubsub.mu.Lock()
+	defer s.pubsub.mu.Unlock()
+	s.pubsub.bufferLimit = n
+}
+
+func addSub(subs map[string]map[*client]struct{}, name string, c *client) {
+	if subs[name] == nil {
+		subs[name] = make(map[*client]struct{})
+	}
+	subs[name][c] = struct{}{}
+}
+
+func removeSub(subs map[string]map[*client]struct{}, name string, c *client) {
+	delete(subs[name], c)
+	if len(subs[name]) == 0 {
+		delete(subs, name)
+	}
+}
+
+// publish queues message for every subscriber of channel and every matching
+// pattern, and returns how many received it.
+func (ps *pubsub) publish(channel, message string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	n := 0
+	if subs := ps.channels[channel]; len(subs) > 0 {
+		msg := encodePush("message", channel, message)
+		for c := range subs {
+			c.push(msg, ps.bufferLimit)
+			n++
+		}
+	}
+	for pattern, subs := range ps.patterns {
+		if !glob.Match(pattern, channel) {
+			continue
+		}
+		msg := encodePush("pmessage", pattern, channel, message)
+		for c := range subs {
+			c.push(msg, ps.bufferLimit)
+			n++
+		}
+	}
+	return n
+}
+
+// receivers returns how many clients a message on channel would reach.
+func (ps *pubsub) receivers(channel string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	n := len(ps.channels[channel])
+	for pattern, subs := range ps.patterns {
+		if glob.Match(pattern, channel) {
+			n += len(subs)
+		}
+	}
+	return n
+}
+
+func encodePush(parts ...string) []byte {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	WriteArray(w, len(parts))
+	for _, p := range parts {
+		WriteBulkString(w, p)
+	}
+	w.Flush()
+	return buf.Bytes()
+}
+
+// outbox queues messages pushed to one client until its pusher writes them.
+type outbox struct {
+	mu     sync.Mutex
+	queue  [][]byte
+	bytes  int
+	notify chan struct{}
+}
+
+// push queues msg for the client, disconnecting it if that would take its
+// queue past limit. It never blocks on the client's socket.
+func (c *client) push(msg []byte, limit int) {
+	c.out.mu.Lock()
+	if c.out.bytes+len(msg) > limit {
+		c.out.mu.Unlock()
+		log.Printf("disconnecting slow subscriber %s: output buffer over %d bytes", c.conn.RemoteAddr(), limit)
+		c.close()
+		return
+	}
+	c.out.queue = append(c.out.queue, msg)
+	c.out.bytes += len(msg)
+	c.out.mu.Unlock()
+
+	select {
+	case c.out.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pushLoop writes queued messages to the client until it disconnects.
+func (c *client) pushLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.out.notify:
+		}
+
+		c.out.mu.Lock()
+		queue := c.out.queue
+		c.out.queue = nil
+		c.out.mu.Unlock()
+
+		c.mu.Lock()
+		size := 0
+		for _, msg := range queue {
+			c.bw.Write(msg)
+			size += len(msg)
+		}
+		err := c.bw.Flush()
+		c.mu.Unlock()
+
+		c.out.mu.Lock()
+		c.out.bytes -= size
+		c.out.mu.Unlock()
+		if err != nil {
+			c.close()
+			return
+		}
+	}
+}
+
+func (c *client) subscriptions() int {
+	return len(c.channels) + len(c.patterns)
+}
+
+// subscribeReply writes the confirmation Redis sends for each (un)subscribe.
+func (c *client) subscribeReply(w *bufio.Writer, kind, name string) {
+	WriteArray(w, 3)
+	WriteBulkString(w, kind)
+	WriteBulkString(w, name)
+	WriteInteger(w, int64(c.subscriptions()))
+}
+
+// unsubscribeAll drops every subscription of c, replying for each one when w
+// is not nil.
+func (s *Server) unsubscribeAll(c *client, patterns bool, w *bufio.Writer) {
+	names := c.channels
+	kind := "unsubscribe"
+	if patterns {
+		names, kind = c.patterns, "punsubscribe"
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		s.unsubscribe(c, patterns, name, w)
+	}
+	if len(sorted) == 0 && w != nil {
+		WriteArray(w, 3)
+		WriteBulkString(w, kind)
+		WriteNullBulk(w)
+		WriteInteger(w, int64(c.subscriptions()))
+	}
+}
+
+func (s *Server) subscribe(c *client, patterns bool, name string, w *bufio.Writer) {
+	ps := s.pubsub
+	ps.mu.Lock()
+	if patterns {
+		addSub(ps.patterns, name, c)
+		c.patterns[name] = struct{}{}
+	} else {
+		addSub(ps.channels, name, c)
+		c.channels[name] = struct{}{}
+	}
+	ps.mu.Unlock()
+
+	c.pushOnce.Do(func() { go c.pushLoop() })
+	kind := "subscribe"
+	if patterns {
+		kind = "psubscribe"
+	}
+	c.subscribeReply(w, kind, name)
+}
+
+func (s *Server) unsubscribe(c *client, patterns bool, name string, w *bufio.Writer) {
+	ps := s.pubsub
+	ps.mu.Lock()
+	if patterns {
+		removeSub(ps.patterns, name, c)
+		delete(c.patterns, name)
+	} else {
+		removeSub(ps.channels, name, c)
+		delete(c.channels, name)
+	}
+	ps.mu.Unlock()
+
+	if w != nil {
+		kind := "unsubscribe"
+		if patterns {
+			kind = "punsubscribe"
+		}
+		c.subscribeReply(w, kind, name)
+	}
+}
+
+// handleSubscribe serves the commands that change a connection's
+// subscriptions and enforces subscribed mode, in which only those, PING and
+// QUIT are allowed. It reports whether it handled cmd.
+func (s *Server) handleSubscribe(c *client, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	switch cmd {
+	case "SUBSCRIBE", "PSUBSCRIBE", "UNSUBSCRIBE", "PUNSUBSCRIBE":
+		if c.tx.active {
+			WriteError(w, "ERR Command not allowed inside a transaction")
+			return true
+		}
+		patterns := strings.HasPrefix(cmd, "P")
+		if cmd == "SUBSCRIBE" || cmd == "PSUBSCRIBE" {
+			if len(args) < 2 {
+				WriteError(w, "ERR wrong number of arguments for '"+strings.ToLower(cmd)+"' command")
+				return true
+			}
+			for _, arg := range args[1:] {
+				s.subscribe(c, patterns, arg.Str, w)
+			}
+			return true
+		}
+		if len(args) == 1 {
+			s.unsubscribeAll(c, patterns, w)
+			return true
+		}
+		for _, arg := range args[1:] {
+			s.unsubscribe(c, patterns, arg.Str, w)
+		}
+		return true
+
+	case "PING":
+		if c.subscriptions() == 0 {
+			return false
+		}
+		payload := ""
+		if len(args) > 1 {
+			payload = args[1].Str
+		}
+		WriteArray(w, 2)
+		WriteBulkString(w, "pong")
+		WriteBulkString(w, payload)
+		return true
+
+	case "QUIT":
+		return false
+
+	default:
+		if c.subscriptions() == 0 {
+			return false
+		}
+		WriteError(w, "ERR Can't execute '"+strings.ToLower(cmd)+"': only (P)SUBSCRIBE / (P)UNSUBSCRIBE / PING / QUIT are allowed in this context")
+		return true
+	}
+}
+
+// pubsubInfo serves PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel ...]
+// and PUBSUB NUMPAT.
+func (s *Server) pubsubInfo(args []RESPValue, w *bufio.Writer) {
+	if len(args) < 2 {
+		WriteError(w, "ERR wrong number of arguments for 'pubsub' command")
+		return
+	}
+	ps := s.pubsub
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	switch sub := strings.ToUpper(args[1].Str); {
+	case sub == "CHANNELS" && len(args) <= 3:
+		var names []string
+		for name := range ps.channels {
+			if len(args) == 2 || glob.Match(args[2].Str, name) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		WriteArray(w, len(names))
+		for _, name := range names {
+			WriteBulkString(w, name)
+		}
+
+	case sub == "NUMSUB":
+		WriteArray(w, 2*(len(args)-2))
+		for _, arg := range args[2:] {
+			WriteBulkString(w, arg.Str)
+			WriteInteger(w, int64(len(ps.channels[arg.Str])))
+		}
+
+	case sub == "NUMPAT" && len(args) == 2:
+		WriteInteger(w, int64(len(ps.patterns)))
+
+	default:
+		WriteError(w, "ERR unknown subcommand or wrong number of arguments for '"+args[1].Str+"'")
+	}
+}
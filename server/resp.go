@@ -163,3 +163,7 @@ func WriteArray(w *bufio.Writer, count int) {
 	w.WriteString(strconv.Itoa(count))
 	w.WriteString("\r\n")
 }
+
+func WriteNullArray(w *bufio.Writer) {
+	w.WriteString("*-1\r\n")
+}
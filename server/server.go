@@ -4,13 +4,27 @@ import (
 	"bufio"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"time"
 
 	"sakthirathinam/logra"
 )
 
+// expireInterval is how often the server deletes expired keys that nobody
+// reads, like the active expiry Redis runs from its serverCron.
+const expireInterval = 100 * time.Millisecond
+
 type Server struct {
-	db       *logra.LograDB
-	listener net.Listener
+	db         *logra.LograDB
+	listener   net.Listener
+	compaction compactionJob
+	pubsub     *pubsub
+	notifier   *keyspaceNotifier
+	waits      *waitQueues
+	done       chan struct{}
+	closeOnce  sync.Once
+	cron       sync.WaitGroup
 }
 
 func New(db *logra.LograDB, addr string) (*Server, error) {
@@ -19,7 +33,28 @@ func New(db *logra.LograDB, addr string) (*Server, error) {
 		return nil, err
 	}
 	log.Printf("Logra server listening on %s", addr)
-	return &Server{db: db, listener: ln}, nil
+	s := &Server{db: db, listener: ln, pubsub: newPubSub(), waits: newWaitQueues(), done: make(chan struct{})}
+	s.notifier = &keyspaceNotifier{ps: s.pubsub}
+	db.SetNotifier(eventHook{waits: s.waits, keyspace: s.notifier})
+	s.cron.Add(1)
+	go s.expireLoop()
+	return s, nil
+}
+
+func (s *Server) expireLoop() {
+	defer s.cron.Done()
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if _, err := s.db.ActiveExpireCycle(); err != nil {
+				log.Printf("active expiry: %v", err)
+			}
+		}
+	}
 }
 
 func (s *Server) Serve() error {
@@ -33,27 +68,71 @@ func (s *Server) Serve() error {
 }
 
 func (s *Server) handleConn(conn net.Conn) {
-	defer conn.Close()
-	br := bufio.NewReader(conn)
-	bw := bufio.NewWriter(conn)
+	c := newClient(conn)
+	defer s.dropClient(c)
+	requests := make(chan RESPValue, readAhead)
+	go c.readRequests(requests)
 
-	for {
-		val, err := ReadRESP(br)
-		if err != nil {
+	for val := range requests {
+		c.mu.Lock()
+		quit := s.handle(c, val)
+		c.bw.Flush()
+		c.mu.Unlock()
+		if quit {
 			return
 		}
+	}
+}
 
-		if val.Type == '*' {
-			HandleCommand(s.db, val.Array, bw)
-		} else {
-			WriteError(bw, "ERR expected array")
-		}
-		bw.Flush()
+// handle runs one request from c and reports whether the client asked to
+// disconnect. The caller holds c.mu.
+func (s *Server) handle(c *client, val RESPValue) bool {
+	if val.Type != '*' {
+		WriteError(c.bw, "ERR expected array")
+		return false
+	}
+	args := val.Array
+	if len(args) == 0 {
+		s.dispatch(s.db, args, c.bw)
+		return false
+	}
+
+	cmd := strings.ToUpper(args[0].Str)
+	switch {
+	case s.handleSubscribe(c, cmd, args, c.bw):
+	case cmd == "QUIT":
+		WriteSimpleString(c.bw, "OK")
+		return true
+	case s.handleTx(&c.tx, cmd, args, c.bw):
+	case s.handleBlocking(c, cmd, args):
+	default:
+		s.dispatch(s.db, args, c.bw)
+	}
+	return false
+}
+
+// dropClient releases everything a disconnected client held.
+func (s *Server) dropClient(c *client) {
+	c.close()
+	s.unsubscribeAll(c, false, nil)
+	s.unsubscribeAll(c, true, nil)
+	s.resetTx(&c.tx)
+}
+
+// dispatch runs one command against db, which is the server's database or,
+// inside EXEC, the transaction's view of it.
+func (s *Server) dispatch(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) > 0 && s.handleAdmin(db, strings.ToUpper(args[0].Str), args, w) {
+		return
 	}
+	HandleCommand(db, args, w)
 }
 
 func (s *Server) Close() error {
-	return s.listener.Close()
+	s.closeOnce.Do(func() { close(s.done) })
+	err := s.listener.Close()
+	s.cron.Wait()
+	return err
 }
 
 func (s *Server) Addr() net.Addr {
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"sakthirathinam/logra"
 )
@@ -133,6 +136,1431 @@ func TestUnknownCommand(t *testing.T) {
 	}
 }
 
+func TestMSetAndMGet(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, err := sendCommand(conn, "MSET", "a", "1", "b", "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+
+	val, err = sendCommand(conn, "MGET", "a", "nope", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Type != '*' || len(val.Array) != 3 {
+		t.Fatalf("expected array of 3, got %c len=%d", val.Type, len(val.Array))
+	}
+	if val.Array[0].Str != "1" || val.Array[1].Type != '$' || val.Array[1].Str != "" || val.Array[2].Str != "2" {
+		t.Fatalf("unexpected MGET reply %+v", val.Array)
+	}
+
+	val, _ = sendCommand(conn, "MSET", "a", "1", "b")
+	if val.Type != '-' {
+		t.Fatalf("expected error for odd MSET arguments, got %c", val.Type)
+	}
+}
+
+func TestMSetNX(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "MSETNX", "a", "1", "b", "2")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "MSETNX", "b", "3", "c", "4")
+	if val.Int != 0 {
+		t.Fatalf("expected 0, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "EXISTS", "c")
+	if val.Int != 0 {
+		t.Fatalf("MSETNX must not write c")
+	}
+}
+
+func TestCompactInBackground(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	for i := 0; i < 20; i++ {
+		sendCommand(conn, "SET", fmt.Sprintf("key%d", i), "v1")
+		sendCommand(conn, "SET", fmt.Sprintf("key%d", i), "v2")
+	}
+	sendCommand(conn, "DEL", "key0")
+
+	val, err := sendCommand(conn, "COMPACT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Type != '+' {
+		t.Fatalf("expected simple string, got %c %q", val.Type, val.Str)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		val, err = sendCommand(conn, "COMPACT", "STATUS")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(val.Str, "compaction_in_progress:0") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("compaction did not finish: %q", val.Str)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(val.Str, "compaction_last_status:ok") {
+		t.Fatalf("expected last status ok, got %q", val.Str)
+	}
+
+	val, _ = sendCommand(conn, "GET", "key5")
+	if val.Str != "v2" {
+		t.Fatalf("expected v2, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "EXISTS", "key0")
+	if val.Int != 0 {
+		t.Fatalf("expected key0 to stay deleted")
+	}
+
+	val, _ = sendCommand(conn, "INFO", "compaction")
+	if !strings.Contains(val.Str, "# Compaction") || !strings.Contains(val.Str, "compaction_last_status:ok") {
+		t.Fatalf("unexpected INFO output %q", val.Str)
+	}
+}
+
+func TestCompactCancelWhenIdle(t *testing.T) {
+	_, conn := setupTestServer(t)
+	val, err := sendCommand(conn, "COMPACT", "CANCEL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Type != '-' {
+		t.Fatalf("expected error, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestInfoKeyspace(t *testing.T) {
+	_, conn := setupTestServer(t)
+	sendCommand(conn, "SET", "a", "1")
+	sendCommand(conn, "SET", "b", "2")
+
+	val, err := sendCommand(conn, "INFO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(val.Str, "db0:keys=2") {
+		t.Fatalf("expected keyspace line, got %q", val.Str)
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "SET", "lock", "a", "NX", "PX", "30000")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "SET", "lock", "b", "NX", "PX", "30000")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null for NX on existing key, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "SET", "lock", "c", "XX", "GET")
+	if val.Str != "a" {
+		t.Fatalf("expected previous value a, got %q", val.Str)
+	}
+
+	for _, args := range [][]string{
+		{"SET", "k", "v", "NX", "XX"},
+		{"SET", "k", "v", "EX", "10", "PX", "100"},
+		{"SET", "k", "v", "EX", "10", "KEEPTTL"},
+		{"SET", "k", "v", "EX"},
+		{"SET", "k", "v", "EX", "0"},
+		{"SET", "k", "v", "EX", "ten"},
+	} {
+		val, _ = sendCommand(conn, args...)
+		if val.Type != '-' {
+			t.Fatalf("%v: expected error, got %c %q", args, val.Type, val.Str)
+		}
+	}
+
+	sendCommand(conn, "SET", "short", "v", "PX", "20")
+	time.Sleep(40 * time.Millisecond)
+	val, _ = sendCommand(conn, "GET", "short")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected expired key to be gone, got %q", val.Str)
+	}
+}
+
+func TestSetFamily(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "SETNX", "k", "1")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "SETNX", "k", "2")
+	if val.Int != 0 {
+		t.Fatalf("expected 0, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "GETSET", "k", "3")
+	if val.Str != "1" {
+		t.Fatalf("expected 1, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "GETDEL", "k")
+	if val.Str != "3" {
+		t.Fatalf("expected 3, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "GETDEL", "k")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null, got %q", val.Str)
+	}
+
+	val, _ = sendCommand(conn, "PSETEX", "p", "20", "v")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "GETEX", "p", "PERSIST")
+	if val.Str != "v" {
+		t.Fatalf("expected v, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "SETEX", "s", "-1", "v")
+	if val.Type != '-' {
+		t.Fatalf("expected error for negative ttl, got %c %q", val.Type, val.Str)
+	}
+	time.Sleep(40 * time.Millisecond)
+	val, _ = sendCommand(conn, "GET", "p")
+	if val.Str != "v" {
+		t.Fatalf("expected PERSIST to keep p, got %q", val.Str)
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	for _, tc := range []struct {
+		args []string
+		want RESPValue
+	}{
+		{[]string{"INCR", "n"}, RESPValue{Type: ':', Int: 1}},
+		{[]string{"INCRBY", "n", "10"}, RESPValue{Type: ':', Int: 11}},
+		{[]string{"DECR", "n"}, RESPValue{Type: ':', Int: 10}},
+		{[]string{"DECRBY", "n", "3"}, RESPValue{Type: ':', Int: 7}},
+		{[]string{"INCRBY", "n", "x"}, RESPValue{Type: '-', Str: "ERR value is not an integer or out of range"}},
+		{[]string{"SET", "s", "abc"}, RESPValue{Type: '+', Str: "OK"}},
+		{[]string{"INCR", "s"}, RESPValue{Type: '-', Str: "ERR value is not an integer or out of range"}},
+		{[]string{"INCRBYFLOAT", "f", "2.5"}, RESPValue{Type: '$', Str: "2.5"}},
+		{[]string{"INCRBYFLOAT", "s", "1"}, RESPValue{Type: '-', Str: "ERR value is not a valid float"}},
+		{[]string{"APPEND", "s", "def"}, RESPValue{Type: ':', Int: 6}},
+		{[]string{"STRLEN", "s"}, RESPValue{Type: ':', Int: 6}},
+		{[]string{"GETRANGE", "s", "1", "-2"}, RESPValue{Type: '$', Str: "bcde"}},
+		{[]string{"GETRANGE", "s", "-3", "100"}, RESPValue{Type: '$', Str: "def"}},
+		{[]string{"SETRANGE", "s", "3", "XYZW"}, RESPValue{Type: ':', Int: 7}},
+		{[]string{"GET", "s"}, RESPValue{Type: '$', Str: "abcXYZW"}},
+		{[]string{"SETRANGE", "s", "-1", "x"}, RESPValue{Type: '-', Str: "ERR offset is out of range"}},
+	} {
+		val, err := sendCommand(conn, tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.Type != tc.want.Type || val.Str != tc.want.Str || val.Int != tc.want.Int {
+			t.Fatalf("%v: got %c %q %d, want %c %q %d", tc.args, val.Type, val.Str, val.Int, tc.want.Type, tc.want.Str, tc.want.Int)
+		}
+	}
+}
+
+func TestExpireCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+	sendCommand(conn, "SET", "k", "v")
+
+	for _, tc := range []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"TTL", "missing"}, -2},
+		{[]string{"TTL", "k"}, -1},
+		{[]string{"EXPIRE", "missing", "10"}, 0},
+		{[]string{"EXPIRE", "k", "100"}, 1},
+		{[]string{"TTL", "k"}, 100},
+		{[]string{"EXPIRE", "k", "50", "GT"}, 0},
+		{[]string{"EXPIRE", "k", "200", "XX", "GT"}, 1},
+		{[]string{"PERSIST", "k"}, 1},
+		{[]string{"PTTL", "k"}, -1},
+		{[]string{"EXPIREAT", "k", "4102444800"}, 1},
+		{[]string{"EXPIRETIME", "k"}, 4102444800},
+		{[]string{"PEXPIRE", "k", "20"}, 1},
+	} {
+		val, err := sendCommand(conn, tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.Type != ':' || val.Int != tc.want {
+			t.Fatalf("%v: got %c %q %d, want %d", tc.args, val.Type, val.Str, val.Int, tc.want)
+		}
+	}
+
+	sendCommand(conn, "SET", "other", "v")
+	time.Sleep(40 * time.Millisecond)
+	val, _ := sendCommand(conn, "EXISTS", "k", "other")
+	if val.Int != 1 {
+		t.Fatalf("expected only other to exist, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "DBSIZE")
+	if val.Int != 1 {
+		t.Fatalf("expected DBSIZE 1, got %d", val.Int)
+	}
+
+	val, _ = sendCommand(conn, "EXPIRE", "other", "-1")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "GET", "other")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected negative EXPIRE to delete, got %q", val.Str)
+	}
+
+	for _, args := range [][]string{{"NX", "XX"}, {"GT", "NX"}, {"GT", "LT"}} {
+		val, _ = sendCommand(conn, append([]string{"EXPIRE", "k", "10"}, args...)...)
+		if val.Type != '-' || !strings.Contains(val.Str, "not compatible") {
+			t.Fatalf("EXPIRE %v: expected an incompatible options error, got %c %q", args, val.Type, val.Str)
+		}
+	}
+}
+
+func TestKeysAndScan(t *testing.T) {
+	_, conn := setupTestServer(t)
+	for i := 0; i < 30; i++ {
+		sendCommand(conn, "SET", fmt.Sprintf("user:%d", i), "v")
+	}
+	sendCommand(conn, "SET", "other", "v")
+
+	val, err := sendCommand(conn, "KEYS", "user:1*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// user:1 and user:10 .. user:19
+	if val.Type != '*' || len(val.Array) != 11 {
+		t.Fatalf("expected 11 keys, got %c %d", val.Type, len(val.Array))
+	}
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for {
+		val, err = sendCommand(conn, "SCAN", cursor, "MATCH", "user:*", "COUNT", "5", "TYPE", "string")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.Type != '*' || len(val.Array) != 2 {
+			t.Fatalf("unexpected SCAN reply %c %d", val.Type, len(val.Array))
+		}
+		for _, k := range val.Array[1].Array {
+			seen[k.Str] = true
+		}
+		cursor = val.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+	if len(seen) != 30 || seen["other"] {
+		t.Fatalf("expected the 30 user keys, got %d", len(seen))
+	}
+
+	val, _ = sendCommand(conn, "SCAN", "0", "TYPE", "hash")
+	if len(val.Array[1].Array) != 0 {
+		t.Fatalf("expected no hash keys, got %d", len(val.Array[1].Array))
+	}
+	val, _ = sendCommand(conn, "SCAN", "nope")
+	if val.Type != '-' {
+		t.Fatalf("expected error for invalid cursor, got %c", val.Type)
+	}
+	val, _ = sendCommand(conn, "TYPE", "other")
+	if val.Str != "string" {
+		t.Fatalf("expected string, got %q", val.Str)
+	}
+}
+
+func TestHashCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "HSET", "h", "a", "1", "b", "2")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 fields added, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "HGET", "h", "a")
+	if val.Str != "1" {
+		t.Fatalf("expected 1, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "HINCRBY", "h", "a", "10")
+	if val.Int != 11 {
+		t.Fatalf("expected 11, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "HGETALL", "h")
+	if len(val.Array) != 4 || val.Array[0].Str != "a" || val.Array[1].Str != "11" {
+		t.Fatalf("unexpected HGETALL %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "TYPE", "h")
+	if val.Str != "hash" {
+		t.Fatalf("expected hash, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "GET", "h")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "WRONGTYPE") {
+		t.Fatalf("expected WRONGTYPE, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "HSCAN", "h", "0", "MATCH", "b")
+	if len(val.Array) != 2 || val.Array[0].Str != "0" || len(val.Array[1].Array) != 2 {
+		t.Fatalf("unexpected HSCAN %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "HDEL", "h", "a", "b", "c")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 fields removed, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "EXISTS", "h")
+	if val.Int != 0 {
+		t.Fatal("expected hash gone with its last field")
+	}
+	val, _ = sendCommand(conn, "HGET", "h", "a")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestListCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "RPUSH", "q", "a", "b", "c")
+	if val.Int != 3 {
+		t.Fatalf("expected length 3, got %d", val.Int)
+	}
+	sendCommand(conn, "LPUSH", "q", "z")
+	val, _ = sendCommand(conn, "LRANGE", "q", "0", "-1")
+	if len(val.Array) != 4 || val.Array[0].Str != "z" || val.Array[3].Str != "c" {
+		t.Fatalf("unexpected LRANGE %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "LPOP", "q")
+	if val.Str != "z" {
+		t.Fatalf("expected z, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "RPOP", "q", "2")
+	if len(val.Array) != 2 || val.Array[0].Str != "c" || val.Array[1].Str != "b" {
+		t.Fatalf("unexpected RPOP with count %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "LMOVE", "q", "done", "LEFT", "RIGHT")
+	if val.Str != "a" {
+		t.Fatalf("expected a, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "LPOP", "q", "1")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected null array, got %+v", val)
+	}
+	val, _ = sendCommand(conn, "LLEN", "done")
+	if val.Int != 1 {
+		t.Fatalf("expected length 1, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "TYPE", "done")
+	if val.Str != "list" {
+		t.Fatalf("expected list, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "HSET", "done", "f", "v")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "WRONGTYPE") {
+		t.Fatalf("expected WRONGTYPE, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "SADD", "a", "1", "2", "3")
+	if val.Int != 3 {
+		t.Fatalf("expected 3 members added, got %d", val.Int)
+	}
+	sendCommand(conn, "SADD", "b", "2", "3", "4")
+	val, _ = sendCommand(conn, "SINTER", "a", "b")
+	if len(val.Array) != 2 || val.Array[0].Str != "2" || val.Array[1].Str != "3" {
+		t.Fatalf("unexpected SINTER %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "SUNIONSTORE", "u", "a", "b")
+	if val.Int != 4 {
+		t.Fatalf("expected 4 members stored, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "SISMEMBER", "u", "4")
+	if val.Int != 1 {
+		t.Fatal("expected 4 to be a member")
+	}
+	val, _ = sendCommand(conn, "SSCAN", "u", "0", "COUNT", "100")
+	if len(val.Array) != 2 || val.Array[0].Str != "0" || len(val.Array[1].Array) != 4 {
+		t.Fatalf("unexpected SSCAN %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "SPOP", "a")
+	if val.Type != '$' || val.Str == "" {
+		t.Fatalf("expected a member, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "SCARD", "a")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 members left, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "SRANDMEMBER", "a", "-9223372036854775808")
+	if val.Type != '-' || val.Str != "ERR value is out of range" {
+		t.Fatalf("expected a range error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "SRANDMEMBER", "a", "-4294967296")
+	if val.Type != '*' || len(val.Array) != 1<<20 {
+		t.Fatalf("expected a capped reply, got %c with %d members", val.Type, len(val.Array))
+	}
+	val, _ = sendCommand(conn, "SRANDMEMBER", "missing")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null, got %c %q", val.Type, val.Str)
+	}
+	sendCommand(conn, "DEL", "u")
+	val, _ = sendCommand(conn, "EXISTS", "u")
+	if val.Int != 0 {
+		t.Fatal("expected set deleted")
+	}
+	val, _ = sendCommand(conn, "TYPE", "b")
+	if val.Str != "set" {
+		t.Fatalf("expected set, got %q", val.Str)
+	}
+}
+
+func TestSortedSetCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	if val.Int != 3 {
+		t.Fatalf("expected 3 members added, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "ZADD", "z", "NX", "XX", "1", "a")
+	if val.Type != '-' || !strings.Contains(val.Str, "not compatible") {
+		t.Fatalf("expected an incompatible options error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "ZADD", "z", "XX", "INCR", "1.5", "a")
+	if val.Str != "2.5" {
+		t.Fatalf("expected 2.5, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "ZADD", "z", "XX", "INCR", "1", "missing")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "ZRANGE", "z", "0", "-1", "WITHSCORES")
+	want := []string{"b", "2", "a", "2.5", "c", "3"}
+	if len(val.Array) != len(want) {
+		t.Fatalf("unexpected ZRANGE %+v", val.Array)
+	}
+	for i, w := range want {
+		if val.Array[i].Str != w {
+			t.Fatalf("ZRANGE element %d: expected %q, got %q", i, w, val.Array[i].Str)
+		}
+	}
+	val, _ = sendCommand(conn, "ZRANGE", "z", "+inf", "(2", "BYSCORE", "REV", "LIMIT", "0", "1")
+	if len(val.Array) != 1 || val.Array[0].Str != "c" {
+		t.Fatalf("unexpected ZRANGE BYSCORE %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "ZRANGE", "z", "[a", "[b", "BYLEX")
+	if len(val.Array) != 2 {
+		t.Fatalf("unexpected ZRANGE BYLEX %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "ZRANGE", "z", "a", "b", "BYLEX")
+	if val.Type != '-' {
+		t.Fatalf("expected a range item error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "ZRANK", "z", "c", "WITHSCORE")
+	if len(val.Array) != 2 || val.Array[0].Int != 2 || val.Array[1].Str != "3" {
+		t.Fatalf("unexpected ZRANK %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "ZSCORE", "z", "missing")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected null, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "ZRANGESTORE", "top", "z", "0", "1", "REV")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 members stored, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "ZPOPMAX", "top")
+	if len(val.Array) != 2 || val.Array[0].Str != "c" || val.Array[1].Str != "3" {
+		t.Fatalf("unexpected ZPOPMAX %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "ZINCRBY", "z", "-10", "b")
+	if val.Str != "-8" {
+		t.Fatalf("expected -8, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "ZREM", "z", "a", "b", "c")
+	if val.Int != 3 {
+		t.Fatalf("expected 3 members removed, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "TYPE", "top")
+	if val.Str != "zset" {
+		t.Fatalf("expected zset, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "EXISTS", "z")
+	if val.Int != 0 {
+		t.Fatal("expected sorted set deleted")
+	}
+}
+
+func TestStreamCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "XADD", "s", "1-1", "f", "a")
+	if val.Str != "1-1" {
+		t.Fatalf("expected 1-1, got %c %q", val.Type, val.Str)
+	}
+	sendCommand(conn, "XADD", "s", "1-*", "f", "b")
+	sendCommand(conn, "XADD", "s", "MAXLEN", "~", "10", "2-0", "f", "c")
+	val, _ = sendCommand(conn, "XADD", "s", "1-0", "f", "old")
+	if val.Type != '-' || !strings.Contains(val.Str, "equal or smaller") {
+		t.Fatalf("expected an ID error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "XRANGE", "s", "(1-1", "+")
+	if len(val.Array) != 2 || val.Array[0].Array[0].Str != "1-2" || val.Array[1].Array[1].Array[1].Str != "c" {
+		t.Fatalf("unexpected XRANGE %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XREVRANGE", "s", "+", "-", "COUNT", "1")
+	if len(val.Array) != 1 || val.Array[0].Array[0].Str != "2-0" {
+		t.Fatalf("unexpected XREVRANGE %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XREAD", "COUNT", "1", "STREAMS", "s", "0")
+	if len(val.Array) != 1 || val.Array[0].Array[0].Str != "s" || len(val.Array[0].Array[1].Array) != 1 {
+		t.Fatalf("unexpected XREAD %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XREAD", "STREAMS", "s", "$")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected null array, got %c %+v", val.Type, val.Array)
+	}
+
+	val, _ = sendCommand(conn, "XGROUP", "CREATE", "s", "g", "0")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "XGROUP", "CREATE", "s", "g", "$")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "BUSYGROUP") {
+		t.Fatalf("expected BUSYGROUP, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "XREADGROUP", "GROUP", "g", "alice", "COUNT", "2", "STREAMS", "s", ">")
+	if len(val.Array) != 1 || len(val.Array[0].Array[1].Array) != 2 {
+		t.Fatalf("unexpected XREADGROUP %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XREADGROUP", "GROUP", "nope", "alice", "STREAMS", "s", ">")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "NOGROUP") {
+		t.Fatalf("expected NOGROUP, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "XACK", "s", "g", "1-1")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 acknowledged, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "XPENDING", "s", "g")
+	if len(val.Array) != 4 || val.Array[0].Int != 1 || val.Array[1].Str != "1-2" || val.Array[3].Array[0].Array[1].Str != "1" {
+		t.Fatalf("unexpected XPENDING summary %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XCLAIM", "s", "g", "bob", "0", "1-2", "JUSTID")
+	if len(val.Array) != 1 || val.Array[0].Str != "1-2" {
+		t.Fatalf("unexpected XCLAIM %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XPENDING", "s", "g", "-", "+", "10", "bob")
+	if len(val.Array) != 1 || val.Array[0].Array[1].Str != "bob" || val.Array[0].Array[3].Int != 1 {
+		t.Fatalf("unexpected XPENDING %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "XTRIM", "s", "MINID", "2")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 trimmed, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "XLEN", "s")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 entry, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "TYPE", "s")
+	if val.Str != "stream" {
+		t.Fatalf("expected stream, got %q", val.Str)
+	}
+}
+
+func TestXReadBlock(t *testing.T) {
+	srv, conn := setupTestServer(t)
+	reader, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	val, _ := sendCommand(reader, "XREAD", "BLOCK", "50", "STREAMS", "s", "$")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected null array after the timeout, got %c %+v", val.Type, val.Array)
+	}
+
+	sendCommand(conn, "XADD", "s", "1-0", "f", "old")
+	replies := make(chan RESPValue)
+	go func() {
+		val, _ := sendCommand(reader, "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+		replies <- val
+	}()
+	time.Sleep(50 * time.Millisecond)
+	sendCommand(conn, "XADD", "s", "2-0", "f", "new")
+
+	select {
+	case val = <-replies:
+	case <-time.After(5 * time.Second):
+		t.Fatal("XREAD did not wake up")
+	}
+	if len(val.Array) != 1 || len(val.Array[0].Array[1].Array) != 1 || val.Array[0].Array[1].Array[0].Array[0].Str != "2-0" {
+		t.Fatalf("unexpected XREAD %+v", val.Array)
+	}
+}
+
+func TestBlockingPop(t *testing.T) {
+	srv, conn := setupTestServer(t)
+	dial := func() net.Conn {
+		c, err := net.Dial("tcp", srv.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	// block sends a blocking command from a new connection and returns the
+	// channel its reply arrives on.
+	block := func(args ...string) (net.Conn, chan RESPValue) {
+		c := dial()
+		replies := make(chan RESPValue, 1)
+		go func() {
+			val, _ := sendCommand(c, args...)
+			replies <- val
+		}()
+		time.Sleep(30 * time.Millisecond)
+		return c, replies
+	}
+	wait := func(replies chan RESPValue) RESPValue {
+		select {
+		case val := <-replies:
+			return val
+		case <-time.After(5 * time.Second):
+			t.Fatal("blocked command did not return")
+		}
+		return RESPValue{}
+	}
+
+	val, _ := sendCommand(conn, "BRPOP", "q", "0.05")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected null array after the timeout, got %c %+v", val.Type, val.Array)
+	}
+	val, _ = sendCommand(conn, "BLPOP", "q", "-1")
+	if val.Type != '-' || !strings.Contains(val.Str, "negative") {
+		t.Fatalf("expected a negative timeout error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "BLPOP", "q", "1e-10")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected a tiny timeout to expire, got %c %+v", val.Type, val.Array)
+	}
+
+	// Clients are served in the order they blocked
+	_, first := block("BLPOP", "other", "q", "0")
+	_, second := block("BLPOP", "q", "0")
+	sendCommand(conn, "RPUSH", "q", "a")
+	val = wait(first)
+	if len(val.Array) != 2 || val.Array[0].Str != "q" || val.Array[1].Str != "a" {
+		t.Fatalf("unexpected BLPOP %+v", val.Array)
+	}
+	select {
+	case val = <-second:
+		t.Fatalf("second client served out of turn: %+v", val.Array)
+	case <-time.After(30 * time.Millisecond):
+	}
+	sendCommand(conn, "RPUSH", "q", "b")
+	if val = wait(second); len(val.Array) != 2 || val.Array[1].Str != "b" {
+		t.Fatalf("unexpected BLPOP %+v", val.Array)
+	}
+
+	// A client that hangs up while blocked leaves the line
+	gone, _ := block("BLPOP", "q", "0")
+	gone.Close()
+	time.Sleep(30 * time.Millisecond)
+	sendCommand(conn, "RPUSH", "q", "c")
+	val, _ = sendCommand(conn, "LRANGE", "q", "0", "-1")
+	if len(val.Array) != 1 || val.Array[0].Str != "c" {
+		t.Fatalf("expected the element to stay in the list, got %+v", val.Array)
+	}
+
+	// Also if it pipelined another command after the blocking one
+	sendCommand(conn, "DEL", "q")
+	gone = dial()
+	bw := bufio.NewWriter(gone)
+	for _, args := range [][]string{{"BLPOP", "q", "0"}, {"PING"}} {
+		WriteArray(bw, len(args))
+		for _, a := range args {
+			WriteBulkString(bw, a)
+		}
+	}
+	bw.Flush()
+	time.Sleep(30 * time.Millisecond)
+	gone.Close()
+	time.Sleep(30 * time.Millisecond)
+	_, next := block("BLPOP", "q", "0")
+	sendCommand(conn, "RPUSH", "q", "d")
+	if val = wait(next); len(val.Array) != 2 || val.Array[1].Str != "d" {
+		t.Fatalf("expected the waiting client to get d, got %+v", val.Array)
+	}
+
+	_, moved := block("BLMOVE", "src", "dst", "RIGHT", "LEFT", "0")
+	sendCommand(conn, "LPUSH", "src", "x")
+	if val = wait(moved); val.Str != "x" {
+		t.Fatalf("expected x, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "LRANGE", "dst", "0", "-1")
+	if len(val.Array) != 1 || val.Array[0].Str != "x" {
+		t.Fatalf("unexpected destination %+v", val.Array)
+	}
+
+	// Inside MULTI a blocking pop does not wait
+	sendCommand(conn, "MULTI")
+	sendCommand(conn, "BLPOP", "empty", "0")
+	val, _ = sendCommand(conn, "EXEC")
+	if len(val.Array) != 1 || val.Array[0].Type != '*' || val.Array[0].Array != nil {
+		t.Fatalf("unexpected EXEC reply %+v", val.Array)
+	}
+}
+
+func TestBitmapCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "SETBIT", "b", "7", "1")
+	if val.Int != 0 {
+		t.Fatalf("expected previous bit 0, got %d", val.Int)
+	}
+	sendCommand(conn, "SETBIT", "b", "100000", "1")
+	val, _ = sendCommand(conn, "GETBIT", "b", "100000")
+	if val.Int != 1 {
+		t.Fatalf("expected bit 1, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "STRLEN", "b")
+	if val.Int != 12501 {
+		t.Fatalf("expected length 12501, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "GETRANGE", "b", "0", "0")
+	if val.Str != "\x01" {
+		t.Fatalf("expected \\x01, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "SETBIT", "b", "1", "2")
+	if val.Type != '-' || !strings.Contains(val.Str, "bit is not an integer") {
+		t.Fatalf("expected a bit error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "SETBIT", "b", "4294967296", "1")
+	if val.Type != '-' || !strings.Contains(val.Str, "bit offset") {
+		t.Fatalf("expected an offset error, got %c %q", val.Type, val.Str)
+	}
+
+	sendCommand(conn, "SET", "s", "foobar")
+	for _, tc := range []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"BITCOUNT", "s"}, 26},
+		{[]string{"BITCOUNT", "s", "1", "1"}, 6},
+		{[]string{"BITCOUNT", "s", "5", "30", "BIT"}, 17},
+		{[]string{"BITPOS", "s", "0"}, 0},
+		{[]string{"BITPOS", "s", "1", "2"}, 17},
+		{[]string{"BITPOS", "s", "1", "7", "15", "BIT"}, 9},
+		{[]string{"BITOP", "OR", "dest", "s", "b"}, 12501},
+		{[]string{"BITCOUNT", "dest"}, 28},
+	} {
+		val, _ = sendCommand(conn, tc.args...)
+		if val.Type != ':' || val.Int != tc.want {
+			t.Fatalf("%v: expected %d, got %c %d %q", tc.args, tc.want, val.Type, val.Int, val.Str)
+		}
+	}
+	val, _ = sendCommand(conn, "BITCOUNT", "s", "1")
+	if val.Type != '-' {
+		t.Fatalf("expected a syntax error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "BITOP", "NOT", "dest", "s", "b")
+	if val.Type != '-' || !strings.Contains(val.Str, "single source key") {
+		t.Fatalf("expected a NOT error, got %c %q", val.Type, val.Str)
+	}
+
+	val, _ = sendCommand(conn, "BITFIELD", "f", "SET", "i8", "#1", "-100", "INCRBY", "i8", "#1", "-100", "OVERFLOW", "FAIL", "INCRBY", "u2", "0", "4", "GET", "i8", "8")
+	if len(val.Array) != 4 || val.Array[0].Int != 0 || val.Array[1].Int != 56 || val.Array[2].Type != '$' || val.Array[3].Int != 56 {
+		t.Fatalf("unexpected BITFIELD %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "BITFIELD", "f", "GET", "u64", "0")
+	if val.Type != '-' || !strings.Contains(val.Str, "Invalid bitfield type") {
+		t.Fatalf("expected a type error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "BITFIELD_RO", "f", "SET", "u8", "0", "1")
+	if val.Type != '-' || !strings.Contains(val.Str, "only supports the GET") {
+		t.Fatalf("expected a read-only error, got %c %q", val.Type, val.Str)
+	}
+	sendCommand(conn, "HSET", "h", "f", "v")
+	val, _ = sendCommand(conn, "GETBIT", "h", "0")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "WRONGTYPE") {
+		t.Fatalf("expected WRONGTYPE, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestHyperLogLogCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "PFADD", "h1", "a", "b", "c", "d")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "PFADD", "h1", "a")
+	if val.Int != 0 {
+		t.Fatalf("expected 0, got %d", val.Int)
+	}
+	sendCommand(conn, "PFADD", "h2", "c", "d", "e")
+	val, _ = sendCommand(conn, "PFCOUNT", "h1", "h2")
+	if val.Int != 5 {
+		t.Fatalf("expected 5, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "PFMERGE", "h3", "h1", "h2")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "PFCOUNT", "h3")
+	if val.Int != 5 {
+		t.Fatalf("expected 5, got %d", val.Int)
+	}
+	val, _ = sendCommand(conn, "TYPE", "h3")
+	if val.Str != "string" {
+		t.Fatalf("expected string, got %q", val.Str)
+	}
+
+	sendCommand(conn, "SET", "s", "plain")
+	val, _ = sendCommand(conn, "PFCOUNT", "s")
+	if val.Type != '-' || val.Str != "WRONGTYPE Key is not a valid HyperLogLog string value." {
+		t.Fatalf("expected an HLL type error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "PFCOUNT")
+	if val.Type != '-' {
+		t.Fatalf("expected an arity error, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestGeoCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 members added, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "GEOADD", "Sicily", "200", "10", "Nowhere")
+	if val.Type != '-' || val.Str != "ERR invalid longitude,latitude pair 200.000000,10.000000" {
+		t.Fatalf("expected a coordinate error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "GEODIST", "Sicily", "Palermo", "Catania", "km")
+	if val.Str != "166.2742" {
+		t.Fatalf("expected 166.2742, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "GEODIST", "Sicily", "Palermo", "Catania", "parsec")
+	if val.Type != '-' || !strings.Contains(val.Str, "unsupported unit") {
+		t.Fatalf("expected a unit error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "GEOPOS", "Sicily", "Palermo", "Nowhere")
+	if len(val.Array) != 2 || !strings.HasPrefix(val.Array[0].Array[0].Str, "13.36138") || val.Array[1].Array != nil {
+		t.Fatalf("unexpected GEOPOS %+v", val.Array)
+	}
+
+	val, _ = sendCommand(conn, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC", "WITHDIST")
+	if len(val.Array) != 2 || val.Array[0].Array[0].Str != "Catania" || val.Array[0].Array[1].Str != "56.4413" ||
+		val.Array[1].Array[0].Str != "Palermo" || val.Array[1].Array[1].Str != "190.4424" {
+		t.Fatalf("unexpected GEOSEARCH BYRADIUS %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "BYBOX", "100", "100", "km", "WITHHASH", "WITHCOORD")
+	if len(val.Array) != 1 || len(val.Array[0].Array) != 3 || val.Array[0].Array[0].Str != "Catania" || val.Array[0].Array[2].Type != '*' {
+		t.Fatalf("unexpected GEOSEARCH BYBOX %+v", val.Array)
+	}
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "FROMLONLAT", "15", "37", "BYRADIUS", "1", "km"}, "exactly one of FROMMEMBER or FROMLONLAT"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "ASC", "COUNT", "1"}, "exactly one of BYRADIUS and BYBOX"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Rome", "BYRADIUS", "1", "km"}, "could not decode requested zset member"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Catania", "BYRADIUS", "1", "km", "ANY"}, "ANY argument requires COUNT"},
+	} {
+		val, _ = sendCommand(conn, tc.args...)
+		if val.Type != '-' || !strings.Contains(val.Str, tc.want) {
+			t.Fatalf("%v: expected an error containing %q, got %c %q", tc.args, tc.want, val.Type, val.Str)
+		}
+	}
+}
+
+func TestJSONCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "JSON.SET", "doc", "$", `{"a":[1],"b":{"c":"x"},"n":1}`)
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.SET", "doc", "$.a", "[", "NX")
+	if val.Type != '-' || !strings.Contains(val.Str, "invalid JSON") {
+		t.Fatalf("expected a JSON error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.SET", "doc", "$.x", "1e400")
+	if val.Type != '-' || !strings.Contains(val.Str, "out of range") {
+		t.Fatalf("expected a range error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.SET", "doc", "$.a", "2", "NX")
+	if val.Type != '$' || val.Str != "" {
+		t.Fatalf("expected a null reply for NX, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.GET", "doc", "$.b.c")
+	if val.Str != `["x"]` {
+		t.Fatalf(`expected ["x"], got %c %q`, val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.GET", "doc")
+	if val.Str != `{"a":[1],"b":{"c":"x"},"n":1}` {
+		t.Fatalf("unexpected document %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.NUMINCRBY", "doc", "$.n", "1.5")
+	if val.Str != `[2.5]` {
+		t.Fatalf("expected [2.5], got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.ARRAPPEND", "doc", "$..a", "2", `"three"`)
+	if len(val.Array) != 1 || val.Array[0].Int != 3 {
+		t.Fatalf("expected [3], got %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "JSON.ARRAPPEND", "doc", ".a", "4")
+	if val.Type != ':' || val.Int != 4 {
+		t.Fatalf("expected 4, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.TYPE", "doc", "$.*")
+	if len(val.Array) != 3 || val.Array[0].Str != "array" || val.Array[1].Str != "object" || val.Array[2].Str != "number" {
+		t.Fatalf("unexpected JSON.TYPE %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "JSON.TYPE", "doc")
+	if val.Str != "object" {
+		t.Fatalf("expected object, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "TYPE", "doc")
+	if val.Str != "ReJSON-RL" {
+		t.Fatalf("expected ReJSON-RL, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.DEL", "doc", "$.b")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 deleted, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.FORGET", "doc")
+	if val.Int != 1 {
+		t.Fatalf("expected the key deleted, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.NUMINCRBY", "doc", "$.n", "1")
+	if val.Type != '-' || !strings.Contains(val.Str, "doesn't exist") {
+		t.Fatalf("expected a missing key error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "JSON.SET", "doc", "$.x", "1")
+	if val.Type != '-' || !strings.Contains(val.Str, "at the root") {
+		t.Fatalf("expected a root error, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestFilterCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "BF.RESERVE", "bf", "0.01", "1000", "EXPANSION", "4")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "BF.RESERVE", "bf", "0.01", "1000")
+	if val.Type != '-' || val.Str != "ERR item exists" {
+		t.Fatalf("expected item exists, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "BF.ADD", "bf", "a")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "BF.MADD", "bf", "a", "b", "c")
+	if len(val.Array) != 3 || val.Array[0].Int != 0 || val.Array[1].Int != 1 || val.Array[2].Int != 1 {
+		t.Fatalf("expected [0 1 1], got %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "BF.EXISTS", "bf", "b")
+	if val.Int != 1 {
+		t.Fatalf("expected b to exist, got %c %d", val.Type, val.Int)
+	}
+	val, _ = sendCommand(conn, "BF.MEXISTS", "bf", "c", "zzz")
+	if len(val.Array) != 2 || val.Array[0].Int != 1 || val.Array[1].Int != 0 {
+		t.Fatalf("expected [1 0], got %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "BF.INFO", "bf")
+	if len(val.Array) != 10 || val.Array[0].Str != "Capacity" || val.Array[1].Int != 1000 || val.Array[7].Int != 3 || val.Array[9].Int != 4 {
+		t.Fatalf("unexpected BF.INFO %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "BF.INFO", "nope")
+	if val.Type != '-' || val.Str != "ERR not found" {
+		t.Fatalf("expected not found, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "TYPE", "bf")
+	if val.Str != "MBbloom--" {
+		t.Fatalf("expected MBbloom--, got %q", val.Str)
+	}
+
+	val, _ = sendCommand(conn, "CF.RESERVE", "cf", "1000", "BUCKETSIZE", "4")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "CF.RESERVE", "cf2", "1000", "BUCKETSIZE", "300")
+	if val.Type != '-' || !strings.Contains(val.Str, "Bucket size") {
+		t.Fatalf("expected a bucket size error, got %c %q", val.Type, val.Str)
+	}
+	sendCommand(conn, "CF.ADD", "cf", "x")
+	sendCommand(conn, "CF.ADD", "cf", "x")
+	val, _ = sendCommand(conn, "CF.ADDNX", "cf", "x")
+	if val.Int != 0 {
+		t.Fatalf("expected 0 from CF.ADDNX, got %c %d", val.Type, val.Int)
+	}
+	val, _ = sendCommand(conn, "CF.COUNT", "cf", "x")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 copies, got %c %d", val.Type, val.Int)
+	}
+	val, _ = sendCommand(conn, "CF.DEL", "cf", "x")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 from CF.DEL, got %c %d", val.Type, val.Int)
+	}
+	val, _ = sendCommand(conn, "CF.MEXISTS", "cf", "x", "y")
+	if len(val.Array) != 2 || val.Array[0].Int != 1 || val.Array[1].Int != 0 {
+		t.Fatalf("expected [1 0], got %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "CF.INFO", "cf")
+	if len(val.Array) != 16 || val.Array[2].Str != "Number of buckets" || val.Array[3].Int != 256 || val.Array[7].Int != 1 || val.Array[9].Int != 1 {
+		t.Fatalf("unexpected CF.INFO %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "CF.DEL", "nope", "x")
+	if val.Type != '-' || val.Str != "ERR not found" {
+		t.Fatalf("expected not found, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "CF.EXISTS", "bf", "x")
+	if val.Type != '-' || !strings.HasPrefix(val.Str, "WRONGTYPE") {
+		t.Fatalf("expected WRONGTYPE, got %c %q", val.Type, val.Str)
+	}
+}
+
+func TestVectorCommands(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "VADD", "emb", "a", "[1, 0, 0]", "METRIC", "L2", "HNSW", "M", "8")
+	if val.Int != 1 {
+		t.Fatalf("expected 1, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	sendCommand(conn, "VADD", "emb", "b", "0,1,0")
+	sendCommand(conn, "VADD", "emb", "c", "0 0 1")
+	val, _ = sendCommand(conn, "VADD", "emb", "c", "0,0.5,1")
+	if val.Int != 0 {
+		t.Fatalf("expected 0 for a replaced id, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "VADD", "emb", "d", "1,2")
+	if val.Type != '-' || !strings.Contains(val.Str, "dimension mismatch") {
+		t.Fatalf("expected a dimension error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "VADD", "emb", "d", "1,x,2")
+	if val.Type != '-' || val.Str != "ERR invalid vector" {
+		t.Fatalf("expected an invalid vector error, got %c %q", val.Type, val.Str)
+	}
+	val, _ = sendCommand(conn, "VADD", "other", "d", "1,2", "METRIC", "HAMMING")
+	if val.Type != '-' || !strings.Contains(val.Str, "unsupported metric") {
+		t.Fatalf("expected a metric error, got %c %q", val.Type, val.Str)
+	}
+	for _, opts := range [][]string{{"METRIC", "DOT"}, {"M", "16"}, {"EF", "10"}} {
+		val, _ = sendCommand(conn, append([]string{"VADD", "emb", "d", "1,1,1"}, opts...)...)
+		if val.Type != '-' || !strings.Contains(val.Str, "options differ") {
+			t.Fatalf("VADD %v: expected an options error, got %c %q", opts, val.Type, val.Str)
+		}
+	}
+	val, _ = sendCommand(conn, "VADD", "emb", "d", "1,1,1", "METRIC", "L2", "HNSW", "M", "8")
+	if val.Type != ':' || val.Int != 1 {
+		t.Fatalf("expected VADD with the options of the set to add, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	sendCommand(conn, "VDEL", "emb", "d")
+
+	val, _ = sendCommand(conn, "VSIM", "emb", "0.9,0.1,0", "2", "WITHSCORES")
+	if len(val.Array) != 4 || val.Array[0].Str != "a" || val.Array[2].Str != "b" {
+		t.Fatalf("unexpected VSIM %+v", val.Array)
+	}
+	if d, _ := strconv.ParseFloat(val.Array[1].Str, 64); d < 0.14 || d > 0.15 {
+		t.Fatalf("expected a distance near 0.1414, got %q", val.Array[1].Str)
+	}
+	val, _ = sendCommand(conn, "VSIM", "emb", "0,0.4,1", "1", "TRUTH")
+	if len(val.Array) != 1 || val.Array[0].Str != "c" {
+		t.Fatalf("unexpected VSIM TRUTH %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "VSIM", "emb", "1,0,0", "0")
+	if val.Type != '-' {
+		t.Fatalf("expected an error for K of 0, got %c %q", val.Type, val.Str)
+	}
+
+	val, _ = sendCommand(conn, "VDEL", "emb", "a")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 from VDEL, got %c %d %q", val.Type, val.Int, val.Str)
+	}
+	val, _ = sendCommand(conn, "VCARD", "emb")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 vectors, got %c %d", val.Type, val.Int)
+	}
+	val, _ = sendCommand(conn, "TYPE", "emb")
+	if val.Str != "vectorset" {
+		t.Fatalf("expected vectorset, got %q", val.Str)
+	}
+}
+
+func TestMultiExec(t *testing.T) {
+	_, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "MULTI")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %q", val.Str)
+	}
+	for _, args := range [][]string{{"SET", "a", "1"}, {"INCR", "a"}, {"GET", "a"}} {
+		val, _ = sendCommand(conn, args...)
+		if val.Str != "QUEUED" {
+			t.Fatalf("%v: expected QUEUED, got %c %q", args, val.Type, val.Str)
+		}
+	}
+	val, _ = sendCommand(conn, "MULTI")
+	if val.Type != '-' {
+		t.Fatalf("expected error for nested MULTI, got %c", val.Type)
+	}
+
+	val, err := sendCommand(conn, "EXEC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val.Type != '*' || len(val.Array) != 3 {
+		t.Fatalf("expected 3 replies, got %c %d", val.Type, len(val.Array))
+	}
+	if val.Array[0].Str != "OK" || val.Array[1].Int != 2 || val.Array[2].Str != "2" {
+		t.Fatalf("unexpected EXEC replies %+v", val.Array)
+	}
+
+	val, _ = sendCommand(conn, "EXEC")
+	if val.Type != '-' {
+		t.Fatalf("expected error for EXEC without MULTI, got %c", val.Type)
+	}
+
+	sendCommand(conn, "MULTI")
+	sendCommand(conn, "SET", "b", "1")
+	val, _ = sendCommand(conn, "DISCARD")
+	if val.Str != "OK" {
+		t.Fatalf("expected OK, got %q", val.Str)
+	}
+	val, _ = sendCommand(conn, "EXISTS", "b")
+	if val.Int != 0 {
+		t.Fatalf("expected discarded SET to be dropped")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	srv, conn := setupTestServer(t)
+	other, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	sendCommand(conn, "SET", "balance", "10")
+	sendCommand(conn, "WATCH", "balance")
+	sendCommand(other, "SET", "balance", "20")
+	sendCommand(conn, "MULTI")
+	sendCommand(conn, "SET", "balance", "0")
+	val, _ := sendCommand(conn, "EXEC")
+	if val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected null array, got %c %+v", val.Type, val.Array)
+	}
+	val, _ = sendCommand(conn, "GET", "balance")
+	if val.Str != "20" {
+		t.Fatalf("expected 20, got %q", val.Str)
+	}
+
+	// EXEC released the watch, so the next transaction goes through
+	sendCommand(conn, "WATCH", "balance")
+	sendCommand(conn, "MULTI")
+	val, _ = sendCommand(conn, "WATCH", "x")
+	if val.Type != '-' {
+		t.Fatalf("expected error for WATCH inside MULTI, got %c", val.Type)
+	}
+	sendCommand(conn, "INCRBY", "balance", "5")
+	val, _ = sendCommand(conn, "EXEC")
+	if len(val.Array) != 1 || val.Array[0].Int != 25 {
+		t.Fatalf("unexpected EXEC reply %c %+v", val.Type, val.Array)
+	}
+}
+
+func TestPubSub(t *testing.T) {
+	srv, pub := setupTestServer(t)
+	sub, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	sub.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// Subscriber replies are read with one reader, as pushes may arrive
+	// back to back.
+	br := bufio.NewReader(sub)
+	send := func(args ...string) {
+		bw := bufio.NewWriter(sub)
+		WriteArray(bw, len(args))
+		for _, a := range args {
+			WriteBulkString(bw, a)
+		}
+		bw.Flush()
+	}
+	read := func() RESPValue {
+		val, err := ReadRESP(br)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return val
+	}
+
+	send("SUBSCRIBE", "news", "sport")
+	for i, ch := range []string{"news", "sport"} {
+		val := read()
+		if len(val.Array) != 3 || val.Array[0].Str != "subscribe" || val.Array[1].Str != ch || val.Array[2].Int != int64(i+1) {
+			t.Fatalf("unexpected subscribe reply %+v", val.Array)
+		}
+	}
+	send("PSUBSCRIBE", "n*")
+	read()
+
+	send("GET", "k")
+	if val := read(); val.Type != '-' {
+		t.Fatalf("expected error in subscribed mode, got %c %q", val.Type, val.Str)
+	}
+	send("PING")
+	if val := read(); len(val.Array) != 2 || val.Array[0].Str != "pong" {
+		t.Fatalf("unexpected PING reply in subscribed mode %+v", val)
+	}
+
+	val, _ := sendCommand(pub, "PUBLISH", "news", "hello")
+	if val.Int != 2 {
+		t.Fatalf("expected 2 receivers, got %d", val.Int)
+	}
+	msg := read()
+	if len(msg.Array) != 3 || msg.Array[0].Str != "message" || msg.Array[1].Str != "news" || msg.Array[2].Str != "hello" {
+		t.Fatalf("unexpected message %+v", msg.Array)
+	}
+	msg = read()
+	if len(msg.Array) != 4 || msg.Array[0].Str != "pmessage" || msg.Array[1].Str != "n*" || msg.Array[3].Str != "hello" {
+		t.Fatalf("unexpected pmessage %+v", msg.Array)
+	}
+
+	// Inside MULTI a message is only sent once EXEC commits
+	sendCommand(pub, "MULTI")
+	sendCommand(pub, "PUBLISH", "news", "discarded")
+	sendCommand(pub, "DISCARD")
+	sendCommand(pub, "WATCH", "w")
+	sendCommand(pub, "SET", "w", "1")
+	sendCommand(pub, "MULTI")
+	sendCommand(pub, "PUBLISH", "news", "aborted")
+	if val, _ = sendCommand(pub, "EXEC"); val.Type != '*' || val.Array != nil {
+		t.Fatalf("expected EXEC to abort, got %+v", val)
+	}
+	sendCommand(pub, "MULTI")
+	sendCommand(pub, "PUBLISH", "news", "committed")
+	if val, _ = sendCommand(pub, "EXEC"); len(val.Array) != 1 || val.Array[0].Int != 2 {
+		t.Fatalf("expected EXEC to count 2 receivers, got %+v", val.Array)
+	}
+	if msg = read(); len(msg.Array) != 3 || msg.Array[2].Str != "committed" {
+		t.Fatalf("expected only the committed message, got %+v", msg.Array)
+	}
+	read()
+
+	val, _ = sendCommand(pub, "PUBSUB", "CHANNELS")
+	if len(val.Array) != 2 || val.Array[0].Str != "news" || val.Array[1].Str != "sport" {
+		t.Fatalf("unexpected PUBSUB CHANNELS %+v", val.Array)
+	}
+	val, _ = sendCommand(pub, "PUBSUB", "NUMSUB", "news", "none")
+	if len(val.Array) != 4 || val.Array[1].Int != 1 || val.Array[3].Int != 0 {
+		t.Fatalf("unexpected PUBSUB NUMSUB %+v", val.Array)
+	}
+	val, _ = sendCommand(pub, "PUBSUB", "NUMPAT")
+	if val.Int != 1 {
+		t.Fatalf("expected 1 pattern, got %d", val.Int)
+	}
+
+	send("UNSUBSCRIBE")
+	read()
+	if val := read(); val.Array[0].Str != "unsubscribe" || val.Array[2].Int != 1 {
+		t.Fatalf("unexpected unsubscribe reply %+v", val.Array)
+	}
+	val, _ = sendCommand(pub, "PUBLISH", "sport", "x")
+	if val.Int != 0 {
+		t.Fatalf("expected no receivers after UNSUBSCRIBE, got %d", val.Int)
+	}
+}
+
+func TestKeyspaceNotifications(t *testing.T) {
+	srv, conn := setupTestServer(t)
+
+	val, _ := sendCommand(conn, "CONFIG", "GET", "notify-keyspace-events")
+	if len(val.Array) != 2 || val.Array[1].Str != "" {
+		t.Fatalf("expected notifications off by default, got %+v", val.Array)
+	}
+	val, _ = sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "KEx?")
+	if val.Type != '-' {
+		t.Fatalf("expected error for invalid flags, got %c %q", val.Type, val.Str)
+	}
+	sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "E$")
+	val, _ = sendCommand(conn, "CONFIG", "GET", "notify-keyspace-events")
+	if val.Array[1].Str != "$E" {
+		t.Fatalf("expected $E, got %q", val.Array[1].Str)
+	}
+	sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "AKE")
+	val, _ = sendCommand(conn, "CONFIG", "GET", "notify-keyspace-events")
+	if val.Array[1].Str != "AKE" {
+		t.Fatalf("expected AKE, got %q", val.Array[1].Str)
+	}
+	sendCommand(conn, "CONFIG", "SET", "notify-keyspace-events", "E$")
+
+	sub, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	sub.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := sendCommand(sub, "SUBSCRIBE", "__keyevent@0__:set"); err != nil {
+		t.Fatal(err)
+	}
+
+	// DEL is a generic event, which is not selected.
+	sendCommand(conn, "DEL", "other")
+	sendCommand(conn, "SET", "k", "v")
+	msg, err := ReadRESP(bufio.NewReader(sub))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msg.Array) != 3 || msg.Array[1].Str != "__keyevent@0__:set" || msg.Array[2].Str != "k" {
+		t.Fatalf("unexpected notification %+v", msg.Array)
+	}
+}
+
+func TestPubSubDisconnectsSlowSubscriber(t *testing.T) {
+	srv, pub := setupTestServer(t)
+	srv.SetPubSubBufferLimit(64 * 1024)
+	sub, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if val, _ := sendCommand(sub, "SUBSCRIBE", "firehose"); len(val.Array) != 3 {
+		t.Fatalf("unexpected subscribe reply %+v", val)
+	}
+
+	// The subscriber never reads, so once the socket buffers fill up its
+	// queue grows until it is cut off.
+	payload := strings.Repeat("x", 32*1024)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		val, err := sendCommand(pub, "PUBLISH", "firehose", payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if val.Int == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow subscriber was never disconnected")
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	code := m.Run()
 	fmt.Println()
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bufio"
+	"math"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+	"sakthirathinam/logra/internal/glob"
+)
+
+// handleSets serves the set commands. It reports whether cmd was one of them.
+func handleSets(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+	strs := func(args []RESPValue) []string {
+		s := make([]string, len(args))
+		for i, arg := range args {
+			s[i] = arg.Str
+		}
+		return s
+	}
+
+	switch cmd {
+	case "SADD", "SREM":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		change := db.SAdd
+		if cmd == "SREM" {
+			change = db.SRem
+		}
+		n, err := change(args[1].Str, strs(args[2:]))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "SMEMBERS":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		members, err := db.SMembers(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeBulkArray(w, members)
+		}
+
+	case "SISMEMBER":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		ok, err := db.SIsMember(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if ok {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case "SCARD":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.SCard(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "SINTER", "SUNION", "SDIFF":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		members, err := db.SetAlgebra(setOp(cmd), strs(args[1:]))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeBulkArray(w, members)
+		}
+
+	case "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		n, err := db.SetAlgebraStore(setOp(cmd), args[1].Str, strs(args[2:]))
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "SRANDMEMBER", "SPOP":
+		if !arity(len(args) == 2 || len(args) == 3) {
+			return true
+		}
+		count := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2].Str)
+			if err != nil || (n < 0 && cmd == "SPOP") {
+				WriteError(w, "ERR value is out of range, must be positive")
+				return true
+			}
+			// Like Redis, so that negating the count cannot overflow
+			if n < -(math.MaxInt64 / 2) {
+				WriteError(w, "ERR value is out of range")
+				return true
+			}
+			count = n
+		}
+		pick := db.SRandMember
+		if cmd == "SPOP" {
+			pick = db.SPop
+		}
+		members, err := pick(args[1].Str, count)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case len(args) == 3:
+			writeBulkArray(w, members)
+		case len(members) == 0:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, members[0])
+		}
+
+	case "SSCAN":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		cursor, err := strconv.ParseUint(args[2].Str, 10, 64)
+		if err != nil {
+			WriteError(w, "ERR invalid cursor")
+			return true
+		}
+		pattern, _, count, errMsg := parseScanArgs(args[3:], false)
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		var members []string
+		next, err := db.SScan(args[1].Str, cursor, count, func(member string) {
+			if pattern == "" || glob.Match(pattern, member) {
+				members = append(members, member)
+			}
+		})
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		WriteArray(w, 2)
+		WriteBulkString(w, strconv.FormatUint(next, 10))
+		writeBulkArray(w, members)
+
+	default:
+		return false
+	}
+	return true
+}
+
+func setOp(cmd string) logra.SetOp {
+	switch {
+	case strings.HasPrefix(cmd, "SINTER"):
+		return logra.SetInter
+	case strings.HasPrefix(cmd, "SUNION"):
+		return logra.SetUnion
+	}
+	return logra.SetDiff
+}
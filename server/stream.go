@@ -0,0 +1,667 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sakthirathinam/logra"
+)
+
+// handleStream serves the stream commands. It reports whether cmd was one of
+// them.
+func handleStream(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "XADD":
+		if !arity(len(args) >= 5) {
+			return true
+		}
+		var opts logra.XAddOptions
+		i := 2
+		if strings.EqualFold(args[i].Str, "NOMKSTREAM") {
+			opts.NoMkStream = true
+			i++
+		}
+		if i < len(args) && isTrimStrategy(args[i].Str) {
+			trim, n, errMsg := parseStreamTrim(args[i:])
+			if errMsg != "" {
+				WriteError(w, errMsg)
+				return true
+			}
+			opts.Trim = trim
+			i += n
+		}
+		if !arity(i+3 <= len(args) && (len(args)-i-1)%2 == 0) {
+			return true
+		}
+		fields := make([]logra.FieldValue, 0, (len(args)-i-1)/2)
+		for j := i + 1; j < len(args); j += 2 {
+			fields = append(fields, logra.FieldValue{Field: args[j].Str, Value: args[j+1].Str})
+		}
+		id, ok, err := db.XAdd(args[1].Str, args[i].Str, fields, opts)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok:
+			WriteNullBulk(w)
+		default:
+			WriteBulkString(w, id.String())
+		}
+
+	case "XLEN":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.XLen(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "XRANGE", "XREVRANGE":
+		if !arity(len(args) == 4 || len(args) == 6) {
+			return true
+		}
+		lo, hi := args[2].Str, args[3].Str
+		if cmd == "XREVRANGE" {
+			lo, hi = hi, lo
+		}
+		count := 0
+		if len(args) == 6 {
+			if !strings.EqualFold(args[4].Str, "COUNT") {
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+			n, err := strconv.Atoi(args[5].Str)
+			if err != nil {
+				WriteError(w, "ERR value is not an integer or out of range")
+				return true
+			}
+			if n <= 0 {
+				WriteArray(w, 0)
+				return true
+			}
+			count = n
+		}
+		start, ok1, err1 := parseRangeID(lo, false)
+		end, ok2, err2 := parseRangeID(hi, true)
+		if err := errors.Join(err1, err2); err != nil {
+			writeErr(w, err)
+			return true
+		}
+		if !ok1 || !ok2 {
+			WriteArray(w, 0)
+			return true
+		}
+		entries, err := db.XRange(args[1].Str, start, end, count, cmd == "XREVRANGE")
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeStreamEntries(w, entries)
+		}
+
+	case "XTRIM":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		trim, n, errMsg := parseStreamTrim(args[2:])
+		if errMsg == "" && 2+n != len(args) {
+			errMsg = "ERR syntax error"
+		}
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		evicted, err := db.XTrim(args[1].Str, trim)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(evicted))
+		}
+
+	case "XREAD", "XREADGROUP":
+		r, errMsg := parseStreamRead(args, cmd == "XREADGROUP")
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		// BLOCK is served by the connection (see blocking.go); here, as inside
+		// MULTI, the read returns at once.
+		if err := resolveLastIDs(db, &r); err != nil {
+			writeErr(w, err)
+			return true
+		}
+		replies, err := readStreams(db, r)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeStreamReplies(w, replies)
+		}
+
+	case "XGROUP":
+		if !arity(len(args) >= 2) {
+			return true
+		}
+		handleXGroup(db, args, w)
+
+	case "XACK":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		ids, err := parseStreamIDs(args[3:])
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		n, err := db.XAck(args[1].Str, args[2].Str, ids)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "XPENDING":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		handleXPending(db, args, w)
+
+	case "XCLAIM":
+		if !arity(len(args) >= 6) {
+			return true
+		}
+		handleXClaim(db, args, w)
+
+	default:
+		return false
+	}
+	return true
+}
+
+func isTrimStrategy(s string) bool {
+	return strings.EqualFold(s, "MAXLEN") || strings.EqualFold(s, "MINID")
+}
+
+// parseStreamTrim parses "MAXLEN|MINID [=|~] threshold [LIMIT count]" at the
+// start of args and returns how many arguments it took. Trimming is always
+// exact, which "~" allows for.
+func parseStreamTrim(args []RESPValue) (trim logra.StreamTrim, n int, errMsg string) {
+	if len(args) < 2 || !isTrimStrategy(args[0].Str) {
+		return trim, 0, "ERR syntax error"
+	}
+	byMaxLen := strings.EqualFold(args[0].Str, "MAXLEN")
+	n = 1
+	approx := false
+	if args[n].Str == "=" || args[n].Str == "~" {
+		approx = args[n].Str == "~"
+		n++
+	}
+	if n >= len(args) {
+		return trim, 0, "ERR syntax error"
+	}
+	if byMaxLen {
+		maxLen, err := strconv.Atoi(args[n].Str)
+		if err != nil {
+			return trim, 0, "ERR value is not an integer or out of range"
+		}
+		if maxLen < 0 {
+			return trim, 0, "ERR The MAXLEN argument must be >= 0."
+		}
+		trim.Strategy, trim.MaxLen = logra.TrimMaxLen, maxLen
+	} else {
+		minID, err := logra.ParseStreamID(args[n].Str, 0)
+		if err != nil {
+			return trim, 0, "ERR " + err.Error()
+		}
+		trim.Strategy, trim.MinID = logra.TrimMinID, minID
+	}
+	n++
+	if n+1 < len(args) && strings.EqualFold(args[n].Str, "LIMIT") {
+		if !approx {
+			return trim, 0, "ERR syntax error, LIMIT cannot be used without the special ~ option"
+		}
+		limit, err := strconv.Atoi(args[n+1].Str)
+		if err != nil || limit < 0 {
+			return trim, 0, "ERR The LIMIT argument must be >= 0."
+		}
+		trim.Limit = limit
+		n += 2
+	}
+	return trim, n, ""
+}
+
+// parseRangeID parses an end of an XRANGE interval: "-", "+", an ID, or an
+// ID after "(" to exclude it. An ID without a sequence number covers the
+// whole millisecond. It returns false if an exclusive end leaves no room.
+func parseRangeID(s string, end bool) (logra.StreamID, bool, error) {
+	switch s {
+	case "-":
+		return logra.StreamID{}, true, nil
+	case "+":
+		return logra.MaxStreamID, true, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	if exclusive {
+		s = s[1:]
+	}
+	var seq uint64
+	if end {
+		seq = logra.MaxStreamID.Seq
+	}
+	id, err := logra.ParseStreamID(s, seq)
+	if err != nil || !exclusive {
+		return id, true, err
+	}
+	if end {
+		id, ok := id.Prev()
+		return id, ok, nil
+	}
+	id, ok := id.Next()
+	return id, ok, nil
+}
+
+func parseStreamIDs(args []RESPValue) ([]logra.StreamID, error) {
+	ids := make([]logra.StreamID, len(args))
+	for i, arg := range args {
+		id, err := logra.ParseStreamID(arg.Str, 0)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = id
+	}
+	return ids, nil
+}
+
+// streamRead is a parsed XREAD or XREADGROUP.
+type streamRead struct {
+	group logra.GroupRead // Group is empty for XREAD
+	block int64           // milliseconds to block for, or -1
+	keys  []string
+	ids   []string
+}
+
+// parseStreamRead parses "[GROUP group consumer] [COUNT n] [BLOCK ms]
+// [NOACK] STREAMS key [key ...] id [id ...]". It returns an error message if
+// the arguments are invalid.
+func parseStreamRead(args []RESPValue, group bool) (r streamRead, errMsg string) {
+	r.block = -1
+	i := 1
+	if group {
+		if len(args) < 4 || !strings.EqualFold(args[1].Str, "GROUP") {
+			return r, "ERR syntax error"
+		}
+		r.group.Group, r.group.Consumer = args[2].Str, args[3].Str
+		i = 4
+	}
+	for ; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i].Str); {
+		case opt == "COUNT" && i+1 < len(args):
+			n, err := strconv.Atoi(args[i+1].Str)
+			if err != nil {
+				return r, "ERR value is not an integer or out of range"
+			}
+			r.group.Count = n
+			i++
+		case opt == "BLOCK" && i+1 < len(args):
+			ms, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+			if err != nil {
+				return r, "ERR timeout is not an integer or out of range"
+			}
+			if ms < 0 {
+				return r, "ERR timeout is negative"
+			}
+			r.block = ms
+			i++
+		case opt == "NOACK" && group:
+			r.group.NoAck = true
+		case opt == "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return r, "ERR Unbalanced '" + strings.ToLower(args[0].Str) + "' list of streams: for each stream key an ID or '$' must be specified."
+			}
+			for j := 0; j < len(rest)/2; j++ {
+				r.keys = append(r.keys, rest[j].Str)
+				r.ids = append(r.ids, rest[len(rest)/2+j].Str)
+			}
+			return r, ""
+		default:
+			return r, "ERR syntax error"
+		}
+	}
+	return r, "ERR syntax error"
+}
+
+// resolveLastIDs replaces the "$" IDs of an XREAD with the streams' top IDs,
+// so that only entries added afterwards are read.
+func resolveLastIDs(db *logra.LograDB, r *streamRead) error {
+	if r.group.Group != "" {
+		return nil
+	}
+	for i, id := range r.ids {
+		if id != "$" {
+			continue
+		}
+		last, err := db.XLastID(r.keys[i])
+		if err != nil {
+			return err
+		}
+		r.ids[i] = last.String()
+	}
+	return nil
+}
+
+// streamReply is the entries read from one stream.
+type streamReply struct {
+	key     string
+	entries []logra.StreamEntry
+}
+
+// readStreams runs a read whose "$" IDs are resolved. Streams with nothing
+// to read are left out, except for rereads of a consumer's pending entries.
+func readStreams(db *logra.LograDB, r streamRead) ([]streamReply, error) {
+	var replies []streamReply
+	for i, key := range r.keys {
+		var entries []logra.StreamEntry
+		history := false
+		if r.group.Group == "" {
+			after, err := logra.ParseStreamID(r.ids[i], 0)
+			if err != nil {
+				return nil, err
+			}
+			entries, err = db.XRead(key, after, r.group.Count)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			read := r.group
+			if r.ids[i] != ">" {
+				after, err := logra.ParseStreamID(r.ids[i], 0)
+				if err != nil {
+					return nil, err
+				}
+				read.History, read.After = true, after
+				history = true
+			}
+			var err error
+			entries, err = db.XReadGroup(key, read)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if len(entries) > 0 || history {
+			replies = append(replies, streamReply{key, entries})
+		}
+	}
+	return replies, nil
+}
+
+func writeStreamReplies(w *bufio.Writer, replies []streamReply) {
+	if len(replies) == 0 {
+		WriteNullArray(w)
+		return
+	}
+	WriteArray(w, len(replies))
+	for _, r := range replies {
+		WriteArray(w, 2)
+		WriteBulkString(w, r.key)
+		writeStreamEntries(w, r.entries)
+	}
+}
+
+// writeStreamEntries replies with entries as [id, [field, value, ...]]. An
+// entry that was trimmed has a null in place of its fields.
+func writeStreamEntries(w *bufio.Writer, entries []logra.StreamEntry) {
+	WriteArray(w, len(entries))
+	for _, e := range entries {
+		WriteArray(w, 2)
+		WriteBulkString(w, e.ID.String())
+		if e.Fields == nil {
+			WriteNullArray(w)
+			continue
+		}
+		WriteArray(w, 2*len(e.Fields))
+		for _, fv := range e.Fields {
+			WriteBulkString(w, fv.Field)
+			WriteBulkString(w, fv.Value)
+		}
+	}
+}
+
+// handleXGroup serves XGROUP CREATE, SETID and DESTROY.
+func handleXGroup(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	sub := strings.ToUpper(args[1].Str)
+	// groupID parses the ID a group starts after: an ID or "$"
+	groupID := func(s string) (logra.StreamID, bool, error) {
+		if s == "$" {
+			return logra.StreamID{}, true, nil
+		}
+		id, err := logra.ParseStreamID(s, 0)
+		return id, false, err
+	}
+
+	switch {
+	case sub == "CREATE" && (len(args) == 5 || len(args) == 6):
+		var opts logra.XGroupCreateOptions
+		if len(args) == 6 {
+			if !strings.EqualFold(args[5].Str, "MKSTREAM") {
+				WriteError(w, "ERR syntax error")
+				return
+			}
+			opts.MkStream = true
+		}
+		id, last, err := groupID(args[4].Str)
+		if err != nil {
+			writeErr(w, err)
+			return
+		}
+		opts.FromLast = last
+		if err := db.XGroupCreate(args[2].Str, args[3].Str, id, opts); err != nil {
+			writeErr(w, err)
+		} else {
+			WriteSimpleString(w, "OK")
+		}
+
+	case sub == "SETID" && len(args) == 5:
+		id, last, err := groupID(args[4].Str)
+		if err == nil {
+			err = db.XGroupSetID(args[2].Str, args[3].Str, id, last)
+		}
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteSimpleString(w, "OK")
+		}
+
+	case sub == "DESTROY" && len(args) == 4:
+		ok, err := db.XGroupDestroy(args[2].Str, args[3].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if ok {
+			WriteInteger(w, 1)
+		} else {
+			WriteInteger(w, 0)
+		}
+
+	case sub == "CREATE" || sub == "SETID" || sub == "DESTROY":
+		WriteError(w, "ERR wrong number of arguments for 'xgroup|"+strings.ToLower(sub)+"' command")
+
+	default:
+		WriteError(w, "ERR unknown subcommand '"+args[1].Str+"'. Try XGROUP HELP.")
+	}
+}
+
+// handleXPending serves both forms of XPENDING: the summary, and with
+// "[IDLE ms] start end count [consumer]" the pending entries themselves.
+func handleXPending(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	pending, err := db.XPending(args[1].Str, args[2].Str)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+
+	if len(args) == 3 {
+		if len(pending) == 0 {
+			WriteArray(w, 4)
+			WriteInteger(w, 0)
+			WriteNullBulk(w)
+			WriteNullBulk(w)
+			WriteNullArray(w)
+			return
+		}
+		counts := make(map[string]int)
+		var consumers []string
+		for _, pe := range pending {
+			if counts[pe.Consumer] == 0 {
+				consumers = append(consumers, pe.Consumer)
+			}
+			counts[pe.Consumer]++
+		}
+		sort.Strings(consumers)
+		WriteArray(w, 4)
+		WriteInteger(w, int64(len(pending)))
+		WriteBulkString(w, pending[0].ID.String())
+		WriteBulkString(w, pending[len(pending)-1].ID.String())
+		WriteArray(w, len(consumers))
+		for _, consumer := range consumers {
+			WriteArray(w, 2)
+			WriteBulkString(w, consumer)
+			WriteBulkString(w, strconv.Itoa(counts[consumer]))
+		}
+		return
+	}
+
+	rest := args[3:]
+	var minIdle int64
+	if strings.EqualFold(rest[0].Str, "IDLE") {
+		if len(rest) < 2 {
+			WriteError(w, "ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(rest[1].Str, 10, 64)
+		if err != nil {
+			WriteError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		minIdle = n
+		rest = rest[2:]
+	}
+	if len(rest) != 3 && len(rest) != 4 {
+		WriteError(w, "ERR syntax error")
+		return
+	}
+	start, ok1, err1 := parseRangeID(rest[0].Str, false)
+	end, ok2, err2 := parseRangeID(rest[1].Str, true)
+	if err := errors.Join(err1, err2); err != nil {
+		writeErr(w, err)
+		return
+	}
+	count, err := strconv.Atoi(rest[2].Str)
+	if err != nil {
+		WriteError(w, "ERR value is not an integer or out of range")
+		return
+	}
+
+	now := time.Now().UnixMilli()
+	var matched []logra.PendingEntry
+	for _, pe := range pending {
+		switch {
+		case !ok1 || !ok2 || len(matched) >= count:
+		case pe.ID.Less(start) || end.Less(pe.ID):
+		case len(rest) == 4 && pe.Consumer != rest[3].Str:
+		case now-pe.DeliveredAt < minIdle:
+		default:
+			matched = append(matched, pe)
+		}
+	}
+	WriteArray(w, len(matched))
+	for _, pe := range matched {
+		WriteArray(w, 4)
+		WriteBulkString(w, pe.ID.String())
+		WriteBulkString(w, pe.Consumer)
+		WriteInteger(w, now-pe.DeliveredAt)
+		WriteInteger(w, int64(pe.Deliveries))
+	}
+}
+
+// handleXClaim serves "XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME ms] [RETRYCOUNT n] [FORCE] [JUSTID]".
+func handleXClaim(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	minIdle, err := strconv.ParseInt(args[4].Str, 10, 64)
+	if err != nil {
+		WriteError(w, "ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+	i := 5
+	var ids []logra.StreamID
+	for ; i < len(args); i++ {
+		id, err := logra.ParseStreamID(args[i].Str, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		writeErr(w, logra.ErrStreamID)
+		return
+	}
+
+	var opts logra.XClaimOptions
+	for ; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Str)
+		switch opt {
+		case "FORCE":
+			opts.Force = true
+			continue
+		case "JUSTID":
+			opts.JustID = true
+			continue
+		}
+		if i+1 >= len(args) {
+			WriteError(w, "ERR syntax error")
+			return
+		}
+		n, err := strconv.ParseInt(args[i+1].Str, 10, 64)
+		if err != nil {
+			WriteError(w, "ERR value is not an integer or out of range")
+			return
+		}
+		switch opt {
+		case "IDLE":
+			opts.DeliveredAt = time.Now().UnixMilli() - n
+		case "TIME":
+			opts.DeliveredAt = n
+		case "RETRYCOUNT":
+			opts.RetryCount = int(n)
+		default:
+			WriteError(w, "ERR Unrecognized XCLAIM option '"+args[i].Str+"'")
+			return
+		}
+		i++
+	}
+
+	entries, err := db.XClaim(args[1].Str, args[2].Str, args[3].Str, time.Duration(minIdle)*time.Millisecond, ids, opts)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	if !opts.JustID {
+		writeStreamEntries(w, entries)
+		return
+	}
+	WriteArray(w, len(entries))
+	for _, e := range entries {
+		WriteBulkString(w, e.ID.String())
+	}
+}
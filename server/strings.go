@@ -0,0 +1,288 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"sakthirathinam/logra"
+)
+
+// parseExpire turns the argument of EX, PX, EXAT or PXAT into a deadline in
+// Unix milliseconds. On failure it returns the error reply to send.
+func parseExpire(unit, arg, cmd string) (int64, string) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, "ERR value is not an integer or out of range"
+	}
+	invalid := "ERR invalid expire time in '" + cmd + "' command"
+	if n <= 0 {
+		return 0, invalid
+	}
+	if (unit == "EX" || unit == "EXAT") && n > math.MaxInt64/1000 {
+		return 0, invalid
+	}
+
+	now := time.Now().UnixMilli()
+	switch unit {
+	case "EX":
+		n *= 1000
+		fallthrough
+	case "PX":
+		if n > math.MaxInt64-now {
+			return 0, invalid
+		}
+		return now + n, ""
+	case "EXAT":
+		return n * 1000, ""
+	default:
+		return n, ""
+	}
+}
+
+// handleSet serves SET key value [NX|XX] [GET] [EX s|PX ms|EXAT ts|PXAT ms|KEEPTTL].
+func handleSet(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) < 3 {
+		WriteError(w, "ERR wrong number of arguments for 'set' command")
+		return
+	}
+
+	var opts logra.SetOptions
+	hasExpire := false
+	for i := 3; i < len(args); i++ {
+		opt := strings.ToUpper(args[i].Str)
+		switch {
+		case opt == "NX" && !opts.XX:
+			opts.NX = true
+		case opt == "XX" && !opts.NX:
+			opts.XX = true
+		case opt == "GET":
+			opts.Get = true
+		case opt == "KEEPTTL" && !hasExpire:
+			opts.KeepTTL = true
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") && !hasExpire && !opts.KeepTTL && i+1 < len(args):
+			at, errMsg := parseExpire(opt, args[i+1].Str, "set")
+			if errMsg != "" {
+				WriteError(w, errMsg)
+				return
+			}
+			opts.ExpireAt = at
+			hasExpire = true
+			i++
+		default:
+			WriteError(w, "ERR syntax error")
+			return
+		}
+	}
+
+	old, written, err := db.SetWithOptions(args[1].Str, args[2].Str, opts)
+	switch {
+	case err != nil:
+		writeErr(w, err)
+	case opts.Get && old != nil:
+		WriteBulkString(w, old.Value)
+	case opts.Get || !written:
+		WriteNullBulk(w)
+	default:
+		WriteSimpleString(w, "OK")
+	}
+}
+
+// handleSetEx serves SETEX and PSETEX, whose second argument is the time to
+// live in seconds or milliseconds.
+func handleSetEx(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) {
+	name := strings.ToLower(cmd)
+	if len(args) != 4 {
+		WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		return
+	}
+	unit := "EX"
+	if cmd == "PSETEX" {
+		unit = "PX"
+	}
+	at, errMsg := parseExpire(unit, args[2].Str, name)
+	if errMsg != "" {
+		WriteError(w, errMsg)
+		return
+	}
+	if _, _, err := db.SetWithOptions(args[1].Str, args[3].Str, logra.SetOptions{ExpireAt: at}); err != nil {
+		writeErr(w, err)
+		return
+	}
+	WriteSimpleString(w, "OK")
+}
+
+// handleGetEx serves GETEX key [EX s|PX ms|EXAT ts|PXAT ms|PERSIST].
+func handleGetEx(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) < 2 {
+		WriteError(w, "ERR wrong number of arguments for 'getex' command")
+		return
+	}
+
+	var expireAt int64
+	persist := false
+	switch {
+	case len(args) == 2:
+	case len(args) == 3 && strings.ToUpper(args[2].Str) == "PERSIST":
+		persist = true
+	case len(args) == 4:
+		unit := strings.ToUpper(args[2].Str)
+		if unit != "EX" && unit != "PX" && unit != "EXAT" && unit != "PXAT" {
+			WriteError(w, "ERR syntax error")
+			return
+		}
+		at, errMsg := parseExpire(unit, args[3].Str, "getex")
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return
+		}
+		expireAt = at
+	default:
+		WriteError(w, "ERR syntax error")
+		return
+	}
+
+	rec, err := db.GetEx(args[1].Str, expireAt, persist)
+	if errors.Is(err, logra.ErrWrongType) {
+		writeErr(w, err)
+	} else if err != nil {
+		WriteNullBulk(w)
+	} else {
+		WriteBulkString(w, rec.Value)
+	}
+}
+
+// handleIncr serves INCR, DECR, INCRBY and DECRBY.
+func handleIncr(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) {
+	want := 2
+	if cmd == "INCRBY" || cmd == "DECRBY" {
+		want = 3
+	}
+	if len(args) != want {
+		WriteError(w, "ERR wrong number of arguments for '"+strings.ToLower(cmd)+"' command")
+		return
+	}
+
+	delta := int64(1)
+	if want == 3 {
+		n, err := strconv.ParseInt(args[2].Str, 10, 64)
+		if err != nil {
+			WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+			return
+		}
+		delta = n
+	}
+	if cmd == "DECR" || cmd == "DECRBY" {
+		if delta == math.MinInt64 {
+			WriteError(w, "ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	n, err := db.IncrBy(args[1].Str, delta)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	WriteInteger(w, n)
+}
+
+// handleIncrByFloat serves INCRBYFLOAT key increment.
+func handleIncrByFloat(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) != 3 {
+		WriteError(w, "ERR wrong number of arguments for 'incrbyfloat' command")
+		return
+	}
+	delta, err := strconv.ParseFloat(args[2].Str, 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		WriteError(w, "ERR "+logra.ErrNotFloat.Error())
+		return
+	}
+	value, err := db.IncrByFloat(args[1].Str, delta)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	WriteBulkString(w, value)
+}
+
+// substr returns s[start..end] with Redis GETRANGE semantics: both ends are
+// inclusive, negative indexes count from the end and out of range indexes
+// are clamped.
+func substr(s string, start, end int64) string {
+	n := int64(len(s))
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || start > end {
+		return ""
+	}
+	return s[start : end+1]
+}
+
+// handleGetRange serves GETRANGE key start end.
+func handleGetRange(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) != 4 {
+		WriteError(w, "ERR wrong number of arguments for 'getrange' command")
+		return
+	}
+	start, err1 := strconv.ParseInt(args[2].Str, 10, 64)
+	end, err2 := strconv.ParseInt(args[3].Str, 10, 64)
+	if err1 != nil || err2 != nil {
+		WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+		return
+	}
+	rec, err := db.Get(args[1].Str)
+	if errors.Is(err, logra.ErrWrongType) {
+		writeErr(w, err)
+		return
+	}
+	if err != nil {
+		WriteBulkString(w, "")
+		return
+	}
+	WriteBulkString(w, substr(rec.Value, start, end))
+}
+
+// handleSetRange serves SETRANGE key offset value.
+func handleSetRange(db *logra.LograDB, args []RESPValue, w *bufio.Writer) {
+	if len(args) != 4 {
+		WriteError(w, "ERR wrong number of arguments for 'setrange' command")
+		return
+	}
+	offset, err := strconv.ParseInt(args[2].Str, 10, 64)
+	if err != nil {
+		WriteError(w, "ERR "+logra.ErrNotInteger.Error())
+		return
+	}
+	if offset < 0 {
+		WriteError(w, "ERR offset is out of range")
+		return
+	}
+	if offset > logra.MaxStringSize {
+		WriteError(w, "ERR "+logra.ErrTooLarge.Error())
+		return
+	}
+	n, err := db.SetRange(args[1].Str, int(offset), args[3].Str)
+	if err != nil {
+		writeErr(w, err)
+		return
+	}
+	WriteInteger(w, int64(n))
+}
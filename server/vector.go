@@ -0,0 +1,175 @@
+package server
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"sakthirathinam/logra"
+)
+
+// vectorMetrics are the METRIC names of VADD.
+var vectorMetrics = map[string]logra.VectorMetric{"COSINE": logra.VectorCosine, "L2": logra.VectorL2, "DOT": logra.VectorDot}
+
+// handleVector serves the vector set commands. It reports whether cmd was
+// one of them.
+func handleVector(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "VADD":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		vec, ok := parseVector(args[3].Str)
+		if !ok {
+			WriteError(w, "ERR invalid vector")
+			return true
+		}
+		var opts logra.VectorOptions
+		for i := 4; i < len(args); i++ {
+			left := len(args) - i - 1
+			switch opt := strings.ToUpper(args[i].Str); {
+			case opt == "METRIC" && left >= 1:
+				metric, ok := vectorMetrics[strings.ToUpper(args[i+1].Str)]
+				if !ok {
+					WriteError(w, "ERR unsupported metric provided. please use COSINE, L2, DOT")
+					return true
+				}
+				opts.Metric = metric
+				i++
+			case opt == "HNSW":
+				opts.HNSW = true
+			case (opt == "M" || opt == "EF") && left >= 1:
+				n, err := strconv.Atoi(args[i+1].Str)
+				if err != nil || n < 1 || n > 4096 {
+					WriteError(w, "ERR "+opt+" must be between 1 and 4096")
+					return true
+				}
+				if opt == "M" {
+					opts.M = n
+				} else {
+					opts.EF = n
+				}
+				i++
+			default:
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+		}
+		isNew, err := db.VAdd(args[1].Str, args[2].Str, vec, opts)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case isNew:
+			WriteInteger(w, 1)
+		default:
+			WriteInteger(w, 0)
+		}
+
+	case "VSIM":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		vec, ok := parseVector(args[2].Str)
+		if !ok {
+			WriteError(w, "ERR invalid vector")
+			return true
+		}
+		k, err := strconv.Atoi(args[3].Str)
+		if err != nil || k <= 0 {
+			WriteError(w, "ERR K must be a positive integer")
+			return true
+		}
+		var opts logra.VSimOptions
+		withScores := false
+		for i := 4; i < len(args); i++ {
+			switch opt := strings.ToUpper(args[i].Str); {
+			case opt == "WITHSCORES":
+				withScores = true
+			case opt == "TRUTH":
+				opts.Exact = true
+			case opt == "EF" && i+1 < len(args):
+				if opts.EF, err = strconv.Atoi(args[i+1].Str); err != nil || opts.EF < 1 {
+					WriteError(w, "ERR EF must be a positive integer")
+					return true
+				}
+				i++
+			default:
+				WriteError(w, "ERR syntax error")
+				return true
+			}
+		}
+		matches, err := db.VSim(args[1].Str, vec, k, opts)
+		if err != nil {
+			writeErr(w, err)
+			return true
+		}
+		if withScores {
+			WriteArray(w, 2*len(matches))
+		} else {
+			WriteArray(w, len(matches))
+		}
+		for _, m := range matches {
+			WriteBulkString(w, m.ID)
+			if withScores {
+				WriteBulkString(w, strconv.FormatFloat(m.Distance, 'g', -1, 32))
+			}
+		}
+
+	case "VDEL":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		ok, err := db.VDel(args[1].Str, args[2].Str)
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case ok:
+			WriteInteger(w, 1)
+		default:
+			WriteInteger(w, 0)
+		}
+
+	case "VCARD":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.VCard(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// parseVector parses a vector written as numbers separated by commas or
+// spaces, optionally in brackets, such as "[0.1, 0.2, 0.3]".
+func parseVector(s string) ([]float32, bool) {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	fields := strings.FieldsFunc(s, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	vec := make([]float32, len(fields))
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 32)
+		if err != nil {
+			return nil, false
+		}
+		vec[i] = float32(x)
+	}
+	return vec, true
+}
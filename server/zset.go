@@ -0,0 +1,351 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+
+	"sakthirathinam/logra"
+)
+
+// handleZSet serves the sorted set commands. It reports whether cmd was one
+// of them.
+func handleZSet(db *logra.LograDB, cmd string, args []RESPValue, w *bufio.Writer) bool {
+	name := strings.ToLower(cmd)
+	arity := func(ok bool) bool {
+		if !ok {
+			WriteError(w, "ERR wrong number of arguments for '"+name+"' command")
+		}
+		return ok
+	}
+
+	switch cmd {
+	case "ZADD":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		var opts logra.ZAddOptions
+		incr := false
+		i := 2
+	flags:
+		for ; i < len(args); i++ {
+			switch strings.ToUpper(args[i].Str) {
+			case "NX":
+				opts.NX = true
+			case "XX":
+				opts.XX = true
+			case "GT":
+				opts.GT = true
+			case "LT":
+				opts.LT = true
+			case "CH":
+				opts.CH = true
+			case "INCR":
+				incr = true
+			default:
+				break flags
+			}
+		}
+		pairs := args[i:]
+		switch {
+		case len(pairs) == 0 || len(pairs)%2 != 0:
+			WriteError(w, "ERR syntax error")
+			return true
+		case opts.NX && opts.XX:
+			WriteError(w, "ERR XX and NX options at the same time are not compatible")
+			return true
+		case (opts.GT && opts.LT) || (opts.NX && (opts.GT || opts.LT)):
+			WriteError(w, "ERR GT, LT, and/or NX options at the same time are not compatible")
+			return true
+		case incr && len(pairs) != 2:
+			WriteError(w, "ERR INCR option supports a single increment-element pair")
+			return true
+		}
+		members := make([]logra.ScoredMember, 0, len(pairs)/2)
+		for j := 0; j < len(pairs); j += 2 {
+			score, ok := parseFloatArg(pairs[j].Str)
+			if !ok {
+				WriteError(w, "ERR value is not a valid float")
+				return true
+			}
+			members = append(members, logra.ScoredMember{Member: pairs[j+1].Str, Score: score})
+		}
+		if incr {
+			writeZIncr(w, db, args[1].Str, members[0], opts)
+			return true
+		}
+		n, err := db.ZAdd(args[1].Str, members, opts)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "ZINCRBY":
+		if !arity(len(args) == 4) {
+			return true
+		}
+		delta, ok := parseFloatArg(args[2].Str)
+		if !ok {
+			WriteError(w, "ERR value is not a valid float")
+			return true
+		}
+		writeZIncr(w, db, args[1].Str, logra.ScoredMember{Member: args[3].Str, Score: delta}, logra.ZAddOptions{})
+
+	case "ZSCORE":
+		if !arity(len(args) == 3) {
+			return true
+		}
+		score, ok, err := db.ZScore(args[1].Str, args[2].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else if ok {
+			WriteBulkString(w, logra.FormatScore(score))
+		} else {
+			WriteNullBulk(w)
+		}
+
+	case "ZCARD":
+		if !arity(len(args) == 2) {
+			return true
+		}
+		n, err := db.ZCard(args[1].Str)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "ZRANK", "ZREVRANK":
+		if !arity(len(args) == 3 || len(args) == 4) {
+			return true
+		}
+		withScore := len(args) == 4
+		if withScore && !strings.EqualFold(args[3].Str, "WITHSCORE") {
+			WriteError(w, "ERR syntax error")
+			return true
+		}
+		rank, score, ok, err := db.ZRank(args[1].Str, args[2].Str, cmd == "ZREVRANK")
+		switch {
+		case err != nil:
+			writeErr(w, err)
+		case !ok && withScore:
+			WriteNullArray(w)
+		case !ok:
+			WriteNullBulk(w)
+		case withScore:
+			WriteArray(w, 2)
+			WriteInteger(w, int64(rank))
+			WriteBulkString(w, logra.FormatScore(score))
+		default:
+			WriteInteger(w, int64(rank))
+		}
+
+	case "ZREM":
+		if !arity(len(args) >= 3) {
+			return true
+		}
+		members := make([]string, len(args)-2)
+		for i, arg := range args[2:] {
+			members[i] = arg.Str
+		}
+		n, err := db.ZRem(args[1].Str, members)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "ZRANGE":
+		if !arity(len(args) >= 4) {
+			return true
+		}
+		spec, withScores, errMsg := parseZRange(args[2:], true)
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		members, err := db.ZRange(args[1].Str, spec)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeScoredMembers(w, members, withScores)
+		}
+
+	case "ZRANGESTORE":
+		if !arity(len(args) >= 5) {
+			return true
+		}
+		spec, _, errMsg := parseZRange(args[3:], false)
+		if errMsg != "" {
+			WriteError(w, errMsg)
+			return true
+		}
+		n, err := db.ZRangeStore(args[1].Str, args[2].Str, spec)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			WriteInteger(w, int64(n))
+		}
+
+	case "ZPOPMIN", "ZPOPMAX":
+		if !arity(len(args) == 2 || len(args) == 3) {
+			return true
+		}
+		count := 1
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2].Str)
+			if err != nil || n < 0 {
+				WriteError(w, "ERR value is out of range, must be positive")
+				return true
+			}
+			count = n
+		}
+		pop := db.ZPopMin
+		if cmd == "ZPOPMAX" {
+			pop = db.ZPopMax
+		}
+		members, err := pop(args[1].Str, count)
+		if err != nil {
+			writeErr(w, err)
+		} else {
+			writeScoredMembers(w, members, true)
+		}
+
+	default:
+		return false
+	}
+	return true
+}
+
+// writeZIncr serves ZINCRBY and ZADD INCR, which reply with the new score,
+// or a null if the options prevented the change.
+func writeZIncr(w *bufio.Writer, db *logra.LograDB, key string, m logra.ScoredMember, opts logra.ZAddOptions) {
+	score, ok, err := db.ZIncrBy(key, m.Member, m.Score, opts)
+	switch {
+	case errors.Is(err, logra.ErrScoreNaN):
+		WriteError(w, "ERR resulting score is not a number (NaN)")
+	case err != nil:
+		writeErr(w, err)
+	case ok:
+		WriteBulkString(w, logra.FormatScore(score))
+	default:
+		WriteNullBulk(w)
+	}
+}
+
+func writeScoredMembers(w *bufio.Writer, members []logra.ScoredMember, withScores bool) {
+	if !withScores {
+		WriteArray(w, len(members))
+		for _, m := range members {
+			WriteBulkString(w, m.Member)
+		}
+		return
+	}
+	WriteArray(w, 2*len(members))
+	for _, m := range members {
+		WriteBulkString(w, m.Member)
+		WriteBulkString(w, logra.FormatScore(m.Score))
+	}
+}
+
+// parseFloatArg parses a score as Redis does, accepting inf and -inf but not
+// NaN.
+func parseFloatArg(s string) (float64, bool) {
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil && !math.IsNaN(f)
+}
+
+// parseZRange parses "start stop [BYSCORE | BYLEX] [REV] [LIMIT offset count]
+// [WITHSCORES]", the range arguments of ZRANGE and, without WITHSCORES,
+// ZRANGESTORE. It returns an error message if they are invalid.
+func parseZRange(args []RESPValue, withScoresOK bool) (spec logra.ZRangeSpec, withScores bool, errMsg string) {
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(args[i].Str); {
+		case opt == "BYSCORE":
+			spec.By = logra.ZRangeByScore
+		case opt == "BYLEX":
+			spec.By = logra.ZRangeByLex
+		case opt == "REV":
+			spec.Rev = true
+		case opt == "WITHSCORES" && withScoresOK:
+			withScores = true
+		case opt == "LIMIT" && i+2 < len(args):
+			offset, err1 := strconv.Atoi(args[i+1].Str)
+			count, err2 := strconv.Atoi(args[i+2].Str)
+			if err1 != nil || err2 != nil {
+				return spec, false, "ERR value is not an integer or out of range"
+			}
+			spec.Limit, spec.Offset, spec.Count = true, offset, count
+			i += 2
+		default:
+			return spec, false, "ERR syntax error"
+		}
+	}
+	if spec.Limit && spec.By == logra.ZRangeByRank {
+		return spec, false, "ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX"
+	}
+	if withScores && spec.By == logra.ZRangeByLex {
+		return spec, false, "ERR syntax error, WITHSCORES not supported in combination with BYLEX"
+	}
+
+	// With REV the range is given from its high end
+	lo, hi := args[0].Str, args[1].Str
+	if spec.Rev && spec.By != logra.ZRangeByRank {
+		lo, hi = hi, lo
+	}
+	var ok1, ok2 bool
+	switch spec.By {
+	case logra.ZRangeByRank:
+		var err1, err2 error
+		spec.Start, err1 = strconv.Atoi(lo)
+		spec.Stop, err2 = strconv.Atoi(hi)
+		if err1 != nil || err2 != nil {
+			return spec, false, "ERR value is not an integer or out of range"
+		}
+	case logra.ZRangeByScore:
+		spec.Min, ok1 = parseScoreBound(lo)
+		spec.Max, ok2 = parseScoreBound(hi)
+		if !ok1 || !ok2 {
+			return spec, false, "ERR min or max is not a float"
+		}
+	case logra.ZRangeByLex:
+		spec.LexMin, ok1 = parseLexBound(lo)
+		spec.LexMax, ok2 = parseLexBound(hi)
+		if !ok1 || !ok2 {
+			return spec, false, "ERR min or max not valid string range item"
+		}
+	}
+	return spec, withScores, ""
+}
+
+// parseScoreBound parses a score range end: a score, exclusive with a
+// leading "(".
+func parseScoreBound(s string) (logra.ScoreBound, bool) {
+	var b logra.ScoreBound
+	if strings.HasPrefix(s, "(") {
+		b.Exclusive = true
+		s = s[1:]
+	}
+	score, ok := parseFloatArg(s)
+	b.Score = score
+	return b, ok
+}
+
+// parseLexBound parses a lexicographic range end: "-", "+", or a member
+// after "[" for inclusive or "(" for exclusive.
+func parseLexBound(s string) (logra.LexBound, bool) {
+	switch {
+	case s == "-":
+		return logra.LexBound{Inf: -1}, true
+	case s == "+":
+		return logra.LexBound{Inf: 1}, true
+	case strings.HasPrefix(s, "["):
+		return logra.LexBound{Member: s[1:]}, true
+	case strings.HasPrefix(s, "("):
+		return logra.LexBound{Member: s[1:], Exclusive: true}, true
+	}
+	return logra.LexBound{}, false
+}
@@ -0,0 +1,324 @@
+package logra
+
+import (
+	"math/rand/v2"
+	"sort"
+)
+
+// A set is a collection whose elements are its members. Member records hold
+// a placeholder, as an empty value would read as a deletion.
+const (
+	setType        = "set"
+	setMemberValue = "1"
+)
+
+// setMembers returns the members of the set at key, or nil if it does not
+// exist. The caller must hold the lock.
+func (db *LograDB) setMembers(key string) (map[string]struct{}, error) {
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.elems, nil
+}
+
+// SAdd adds members to the set at key, creating it if missing, and returns
+// how many were not members yet.
+func (db *LograDB) SAdd(key string, members []string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil {
+		return 0, err
+	}
+	var ops []writeOp
+	if c == nil {
+		ops = db.createOps(key, setType)
+	}
+	added := make(map[string]bool)
+	for _, member := range members {
+		if added[member] {
+			continue
+		}
+		if c != nil {
+			if _, ok := c.elems[member]; ok {
+				continue
+			}
+		}
+		added[member] = true
+		ops = append(ops, writeOp{key: elemKey(key, member), value: setMemberValue})
+	}
+	if len(added) == 0 {
+		return 0, nil
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	db.notify(EventSet, "sadd", key)
+	return len(added), nil
+}
+
+// removeMembers deletes members of the set c at key, and the key with its
+// last member. The caller must hold the write lock.
+func (db *LograDB) removeMembers(key string, c *collection, members []string, event string) error {
+	ops := make([]writeOp, 0, len(members))
+	for _, member := range members {
+		ops = append(ops, writeOp{key: elemKey(key, member), del: true})
+	}
+	emptied := len(members) == len(c.elems)
+	if emptied {
+		ops = db.deleteOps(key)
+	}
+	if err := db.write(ops); err != nil {
+		return err
+	}
+	db.notify(EventSet, event, key)
+	if emptied {
+		db.notify(EventGeneric, "del", key)
+	}
+	return nil
+}
+
+// SRem removes members from the set at key and returns how many were
+// members.
+func (db *LograDB) SRem(key string, members []string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	removed := make(map[string]bool)
+	var found []string
+	for _, member := range members {
+		if _, ok := c.elems[member]; ok && !removed[member] {
+			removed[member] = true
+			found = append(found, member)
+		}
+	}
+	if len(found) == 0 {
+		return 0, nil
+	}
+	if err := db.removeMembers(key, c, found, "srem"); err != nil {
+		return 0, err
+	}
+	return len(found), nil
+}
+
+// SMembers returns the members of the set at key in byte order.
+func (db *LograDB) SMembers(key string) ([]string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.sortedElems(), nil
+}
+
+// SIsMember reports whether member is in the set at key.
+func (db *LograDB) SIsMember(key, member string) (bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	members, err := db.setMembers(key)
+	if err != nil {
+		return false, err
+	}
+	_, ok := members[member]
+	return ok, nil
+}
+
+// SCard returns the number of members of the set at key.
+func (db *LograDB) SCard(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	members, err := db.setMembers(key)
+	if err != nil {
+		return 0, err
+	}
+	return len(members), nil
+}
+
+// SetOp is a set algebra operation.
+type SetOp int
+
+const (
+	SetInter SetOp = iota
+	SetUnion
+	SetDiff // members of the first set that are in none of the others
+)
+
+// setAlgebra computes op over the sets at keys, treating missing keys as
+// empty sets, and returns the members in byte order. The caller must hold the
+// lock.
+func (db *LograDB) setAlgebra(op SetOp, keys []string) ([]string, error) {
+	sets := make([]map[string]struct{}, len(keys))
+	for i, key := range keys {
+		members, err := db.setMembers(key)
+		if err != nil {
+			return nil, err
+		}
+		sets[i] = members
+	}
+
+	var result []string
+	switch op {
+	case SetInter:
+		// Probe the smallest set against the others
+		sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+		for member := range sets[0] {
+			in := true
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; !ok {
+					in = false
+					break
+				}
+			}
+			if in {
+				result = append(result, member)
+			}
+		}
+	case SetUnion:
+		seen := make(map[string]struct{})
+		for _, set := range sets {
+			for member := range set {
+				if _, ok := seen[member]; !ok {
+					seen[member] = struct{}{}
+					result = append(result, member)
+				}
+			}
+		}
+	case SetDiff:
+		for member := range sets[0] {
+			in := false
+			for _, set := range sets[1:] {
+				if _, ok := set[member]; ok {
+					in = true
+					break
+				}
+			}
+			if !in {
+				result = append(result, member)
+			}
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// SetAlgebra returns the result of op over the sets at keys, in byte order.
+// Missing keys count as empty sets.
+func (db *LograDB) SetAlgebra(op SetOp, keys []string) ([]string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+	return db.setAlgebra(op, keys)
+}
+
+// SetAlgebraStore stores the result of op over the sets at keys in dst,
+// replacing whatever dst held, and returns its size. An empty result deletes
+// dst.
+func (db *LograDB) SetAlgebraStore(op SetOp, dst string, keys []string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	members, err := db.setAlgebra(op, keys)
+	if err != nil {
+		return 0, err
+	}
+	var ops []writeOp
+	if len(members) == 0 {
+		if db.indexed(dst) {
+			ops = db.deleteOps(dst)
+		}
+	} else {
+		ops = db.createOps(dst, setType)
+		for _, member := range members {
+			ops = append(ops, writeOp{key: elemKey(dst, member), value: setMemberValue})
+		}
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	if len(members) > 0 {
+		db.notify(EventSet, [...]string{"sinterstore", "sunionstore", "sdiffstore"}[op], dst)
+	} else if len(ops) > 0 {
+		db.notify(EventGeneric, "del", dst)
+	}
+	return len(members), nil
+}
+
+// maxRandomPicks caps the reply of a negative count, which may ask for far
+// more members than the set has.
+const maxRandomPicks = 1 << 20
+
+// randomMembers picks count members of c: distinct ones if count is
+// positive, and -count possibly repeated ones, at most maxRandomPicks, if it
+// is negative.
+func randomMembers(c *collection, count int) []string {
+	members := make([]string, 0, len(c.elems))
+	for member := range c.elems {
+		members = append(members, member)
+	}
+	if count < 0 {
+		n := maxRandomPicks
+		if count > -maxRandomPicks {
+			n = -count
+		}
+		picked := make([]string, n)
+		for i := range picked {
+			picked[i] = members[rand.IntN(len(members))]
+		}
+		return picked
+	}
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+	return members[:min(count, len(members))]
+}
+
+// SRandMember returns random members of the set at key: up to count distinct
+// ones if count is positive, or -count that may repeat, up to 1048576, if it
+// is negative.
+func (db *LograDB) SRandMember(key string, count int) ([]string, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil || count == 0 {
+		return nil, err
+	}
+	return randomMembers(c, count), nil
+}
+
+// SPop removes and returns up to count random members of the set at key.
+func (db *LograDB) SPop(key string, count int) ([]string, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil || count <= 0 {
+		return nil, err
+	}
+	members := randomMembers(c, count)
+	if err := db.removeMembers(key, c, members, "spop"); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// SScan calls fn for about count members of the set at key from cursor on
+// and returns the cursor to continue from, or 0 when the scan is complete.
+// It gives the same guarantees as ScanKeys.
+func (db *LograDB) SScan(key string, cursor uint64, count int, fn func(member string)) (uint64, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, setType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	return c.scan(cursor, count, fn), nil
+}
@@ -0,0 +1,829 @@
+package logra
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"sakthirathinam/logra/internal/skiplist"
+)
+
+/*
+**
+Streams
+A stream is a collection with four kinds of elements, told apart by their
+first byte:
+
+	"e" + <id>          -> the entry's fields
+	"t"                 -> the top ID, once trimming removed the newest entry
+	"g" + <group>       -> the last ID delivered to a consumer group
+	"p" + <id> + <group> -> "<delivered at> <deliveries> <consumer>"
+
+IDs are encoded as 16 big-endian bytes, so byte order is ID order and the
+entries can be kept in a skip list ordered by element. Like the log itself,
+a stream only grows at its end: XADD appends one record, and consumer group
+state is written as it changes and rebuilt with the entries on Open.
+**
+*/
+const streamType = "stream"
+
+const (
+	streamEntryElem   = 'e'
+	streamTopElem     = 't'
+	streamGroupElem   = 'g'
+	streamPendingElem = 'p'
+)
+
+var (
+	ErrStreamID         = errors.New("Invalid stream ID specified as stream command argument")
+	ErrStreamIDTooSmall = errors.New("The ID specified in XADD is equal or smaller than the target stream top item")
+	ErrStreamIDZero     = errors.New("The ID specified in XADD must be greater than 0-0")
+	ErrNoStream         = errors.New("The XGROUP subcommand requires the key to exist")
+	ErrNoGroup          = errors.New("NOGROUP No such key or consumer group")
+	ErrGroupExists      = errors.New("BUSYGROUP Consumer Group name already exists")
+)
+
+// StreamID identifies a stream entry: the Unix milliseconds it was added at
+// and a sequence number among the entries of that millisecond.
+type StreamID struct {
+	Ms, Seq uint64
+}
+
+// MaxStreamID is the largest ID, which "+" stands for in ranges.
+var MaxStreamID = StreamID{math.MaxUint64, math.MaxUint64}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id StreamID) Less(o StreamID) bool {
+	return id.Ms < o.Ms || (id.Ms == o.Ms && id.Seq < o.Seq)
+}
+
+// Next returns the smallest ID after id, and false if id is MaxStreamID.
+func (id StreamID) Next() (StreamID, bool) {
+	switch {
+	case id.Seq < math.MaxUint64:
+		return StreamID{id.Ms, id.Seq + 1}, true
+	case id.Ms < math.MaxUint64:
+		return StreamID{id.Ms + 1, 0}, true
+	}
+	return id, false
+}
+
+// Prev returns the largest ID before id, and false if id is 0-0.
+func (id StreamID) Prev() (StreamID, bool) {
+	switch {
+	case id.Seq > 0:
+		return StreamID{id.Ms, id.Seq - 1}, true
+	case id.Ms > 0:
+		return StreamID{id.Ms - 1, math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseStreamID parses "<ms>-<seq>", or "<ms>" with the sequence number seq.
+func ParseStreamID(s string, seq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, ErrStreamID
+	}
+	if hasSeq {
+		if seq, err = strconv.ParseUint(seqPart, 10, 64); err != nil {
+			return StreamID{}, ErrStreamID
+		}
+	}
+	return StreamID{ms, seq}, nil
+}
+
+func encodeStreamID(id StreamID) string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+	return string(b[:])
+}
+
+func decodeStreamID(s string) StreamID {
+	return StreamID{binary.BigEndian.Uint64([]byte(s[:8])), binary.BigEndian.Uint64([]byte(s[8:16]))}
+}
+
+func entryElem(id StreamID) string {
+	return string(streamEntryElem) + encodeStreamID(id)
+}
+
+func pendingElem(id StreamID, group string) string {
+	return string(streamPendingElem) + encodeStreamID(id) + group
+}
+
+// encodeFields packs field-value pairs as length-prefixed strings.
+func encodeFields(fields []FieldValue) string {
+	var b []byte
+	for _, fv := range fields {
+		b = binary.AppendUvarint(b, uint64(len(fv.Field)))
+		b = append(b, fv.Field...)
+		b = binary.AppendUvarint(b, uint64(len(fv.Value)))
+		b = append(b, fv.Value...)
+	}
+	return string(b)
+}
+
+func decodeFields(s string) []FieldValue {
+	var fields []FieldValue
+	next := func() string {
+		n, size := binary.Uvarint([]byte(s))
+		if size <= 0 || uint64(len(s)-size) < n {
+			s = ""
+			return ""
+		}
+		v := s[size : size+int(n)]
+		s = s[size+int(n):]
+		return v
+	}
+	for len(s) > 0 {
+		field := next()
+		fields = append(fields, FieldValue{field, next()})
+	}
+	return fields
+}
+
+// StreamEntry is one entry of a stream. Fields is nil for an entry that was
+// pending but has since been trimmed.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []FieldValue
+}
+
+// PendingEntry is an entry delivered to a consumer and not yet acknowledged.
+type PendingEntry struct {
+	ID          StreamID
+	Consumer    string
+	DeliveredAt int64 // Unix milliseconds of the last delivery
+	Deliveries  int
+}
+
+// stream is the in-memory state of a stream collection.
+type stream struct {
+	entries *skiplist.List // entry elements, in ID order
+	top     StreamID       // the largest ID ever added
+	groups  map[string]*streamGroup
+}
+
+type streamGroup struct {
+	// created is set while the group record exists. Pending entries may be
+	// loaded before it and deleted after it, so the struct can outlive it.
+	created bool
+	last    StreamID
+	pending map[StreamID]*PendingEntry
+}
+
+func newStream() *stream {
+	return &stream{entries: skiplist.New(), groups: make(map[string]*streamGroup)}
+}
+
+// group returns the consumer group called name, or nil if there is none.
+func (s *stream) group(name string) *streamGroup {
+	if g := s.groups[name]; g != nil && g.created {
+		return g
+	}
+	return nil
+}
+
+// groupState returns the state kept for the group called name, creating it
+// if needed.
+func (s *stream) groupState(name string) *streamGroup {
+	g := s.groups[name]
+	if g == nil {
+		g = &streamGroup{pending: make(map[StreamID]*PendingEntry)}
+		s.groups[name] = g
+	}
+	return g
+}
+
+// add and remove apply a write to one of the stream's element records.
+func (s *stream) add(elem, value string) {
+	switch elem[0] {
+	case streamEntryElem:
+		s.entries.Insert(skiplist.Element{Member: elem})
+		if id := decodeStreamID(elem[1:]); s.top.Less(id) {
+			s.top = id
+		}
+	case streamTopElem:
+		if id, err := ParseStreamID(value, 0); err == nil && s.top.Less(id) {
+			s.top = id
+		}
+	case streamGroupElem:
+		g := s.groupState(elem[1:])
+		g.created = true
+		g.last, _ = ParseStreamID(value, 0)
+	case streamPendingElem:
+		id := decodeStreamID(elem[1:17])
+		s.groupState(elem[17:]).pending[id] = decodePending(id, value)
+	}
+}
+
+func (s *stream) remove(elem string) {
+	switch elem[0] {
+	case streamEntryElem:
+		s.entries.Delete(skiplist.Element{Member: elem})
+	case streamGroupElem:
+		s.groupState(elem[1:]).created = false
+		s.dropIfUnused(elem[1:])
+	case streamPendingElem:
+		delete(s.groupState(elem[17:]).pending, decodeStreamID(elem[1:17]))
+		s.dropIfUnused(elem[17:])
+	}
+}
+
+func (s *stream) dropIfUnused(name string) {
+	if g := s.groups[name]; g != nil && !g.created && len(g.pending) == 0 {
+		delete(s.groups, name)
+	}
+}
+
+// value rebuilds the value of a record other than an entry from memory.
+func (s *stream) value(elem string) string {
+	switch elem[0] {
+	case streamTopElem:
+		return s.top.String()
+	case streamGroupElem:
+		return s.groupState(elem[1:]).last.String()
+	case streamPendingElem:
+		return encodePending(s.groupState(elem[17:]).pending[decodeStreamID(elem[1:17])])
+	}
+	return ""
+}
+
+func encodePending(pe *PendingEntry) string {
+	return strconv.FormatInt(pe.DeliveredAt, 10) + " " + strconv.Itoa(pe.Deliveries) + " " + pe.Consumer
+}
+
+func decodePending(id StreamID, value string) *PendingEntry {
+	parts := strings.SplitN(value, " ", 3)
+	pe := &PendingEntry{ID: id}
+	if len(parts) == 3 {
+		pe.DeliveredAt, _ = strconv.ParseInt(parts[0], 10, 64)
+		pe.Deliveries, _ = strconv.Atoi(parts[1])
+		pe.Consumer = parts[2]
+	}
+	return pe
+}
+
+// rangeIDs returns the IDs of up to count entries from start to end,
+// inclusive, from end down if rev is set. A count of 0 or less means no
+// limit.
+func (s *stream) rangeIDs(start, end StreamID, count int, rev bool) []StreamID {
+	lo, hi := entryElem(start), entryElem(end)
+	var ids []StreamID
+	var node *skiplist.Node
+	if rev {
+		node = s.entries.At(s.entries.Seek(func(e skiplist.Element) bool { return e.Member <= hi }) - 1)
+	} else {
+		node = s.entries.At(s.entries.Seek(func(e skiplist.Element) bool { return e.Member < lo }))
+	}
+	for node != nil && node.Member >= lo && node.Member <= hi && (count <= 0 || len(ids) < count) {
+		ids = append(ids, decodeStreamID(node.Member[1:]))
+		if rev {
+			node = node.Prev()
+		} else {
+			node = node.Next()
+		}
+	}
+	return ids
+}
+
+// streamOf returns the stream state at key, or nil if the key does not exist.
+// The caller must hold the lock.
+func (db *LograDB) streamOf(key string) (*stream, error) {
+	c, err := db.collectionOf(key, streamType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.stream, nil
+}
+
+// streamEntries reads the fields of the entries with ids. Entries that no
+// longer exist come back with nil fields. The caller must hold the lock.
+func (db *LograDB) streamEntries(key string, ids []StreamID) ([]StreamEntry, error) {
+	c := db.colls[key]
+	entries := make([]StreamEntry, len(ids))
+	for i, id := range ids {
+		entries[i].ID = id
+		if _, ok := c.elems[entryElem(id)]; !ok {
+			continue
+		}
+		rec, err := db.readEntry(elemKey(key, entryElem(id)))
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Fields = decodeFields(rec.Value)
+	}
+	return entries, nil
+}
+
+// nextStreamID returns the ID XADD gives a new entry for spec, which is "*",
+// "<ms>-*" or an explicit ID, on a stream whose top ID is top.
+func nextStreamID(spec string, top StreamID) (StreamID, error) {
+	if spec == "*" {
+		if ms := uint64(time.Now().UnixMilli()); ms > top.Ms {
+			return StreamID{ms, 0}, nil
+		}
+		id, ok := top.Next()
+		if !ok {
+			return StreamID{}, ErrStreamIDTooSmall
+		}
+		return id, nil
+	}
+	if msPart, ok := strings.CutSuffix(spec, "-*"); ok {
+		ms, err := strconv.ParseUint(msPart, 10, 64)
+		switch {
+		case err != nil:
+			return StreamID{}, ErrStreamID
+		case ms < top.Ms || (ms == top.Ms && top.Seq == math.MaxUint64):
+			return StreamID{}, ErrStreamIDTooSmall
+		case ms == top.Ms:
+			return StreamID{ms, top.Seq + 1}, nil
+		}
+		return StreamID{ms, 0}, nil
+	}
+	id, err := ParseStreamID(spec, 0)
+	switch {
+	case err != nil:
+		return StreamID{}, err
+	case id == StreamID{}:
+		return StreamID{}, ErrStreamIDZero
+	case !top.Less(id):
+		return StreamID{}, ErrStreamIDTooSmall
+	}
+	return id, nil
+}
+
+// StreamTrimStrategy selects which entries a StreamTrim evicts.
+type StreamTrimStrategy int
+
+const (
+	TrimNone   StreamTrimStrategy = iota
+	TrimMaxLen                    // the oldest entries beyond MaxLen
+	TrimMinID                     // the entries below MinID
+)
+
+// StreamTrim describes the entries XTrim and XAdd evict. Limit caps how many
+// are evicted at once; 0 means no cap.
+type StreamTrim struct {
+	Strategy StreamTrimStrategy
+	MaxLen   int
+	MinID    StreamID
+	Limit    int
+}
+
+// XAddOptions are the options of XAdd.
+type XAddOptions struct {
+	NoMkStream bool // do not create a missing stream
+	Trim       StreamTrim
+}
+
+// XAdd appends an entry to the stream at key, creating it unless
+// opts.NoMkStream is set, and returns its ID. id is "*" for an ID from the
+// clock, "<ms>-*" for the next sequence number of a given millisecond, or an
+// explicit ID above every ID added so far. It returns false if the stream is
+// missing and opts.NoMkStream is set.
+func (db *LograDB) XAdd(key, id string, fields []FieldValue, opts XAddOptions) (StreamID, bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	if s == nil && opts.NoMkStream {
+		return StreamID{}, false, nil
+	}
+	var top StreamID
+	if s != nil {
+		top = s.top
+	}
+	newID, err := nextStreamID(id, top)
+	if err != nil {
+		return StreamID{}, false, err
+	}
+	var ops []writeOp
+	if s == nil {
+		ops = db.createOps(key, streamType)
+	}
+	ops = append(ops, writeOp{key: elemKey(key, entryElem(newID)), value: encodeFields(fields)})
+	// The trim goes in the same batch, so a crash cannot leave the stream
+	// longer than asked
+	trimOps, trimmed := xtrimOps(key, s, opts.Trim, entryElem(newID))
+	if err := db.write(append(ops, trimOps...)); err != nil {
+		return StreamID{}, false, err
+	}
+	db.notify(EventStream, "xadd", key)
+	if trimmed > 0 {
+		db.notify(EventStream, "xtrim", key)
+	}
+	return newID, true, nil
+}
+
+// xtrimOps returns the writes that evict the entries trim selects from the
+// stream s at key, nil for a new stream, and how many entries they evict.
+// added, if not empty, is the element of an entry written in the same batch,
+// which counts as the newest.
+func xtrimOps(key string, s *stream, trim StreamTrim, added string) ([]writeOp, int) {
+	if trim.Strategy == TrimNone {
+		return nil, 0
+	}
+	total := 0
+	var top StreamID
+	if s != nil {
+		total, top = s.entries.Len(), s.top
+	}
+	if added != "" {
+		total++
+		top = decodeStreamID(added[1:])
+	}
+	var ops []writeOp
+	evict := func(member string) bool {
+		switch {
+		case trim.Limit > 0 && len(ops) == trim.Limit,
+			trim.Strategy == TrimMaxLen && total-len(ops) <= trim.MaxLen,
+			trim.Strategy == TrimMinID && !decodeStreamID(member[1:]).Less(trim.MinID):
+			return false
+		}
+		ops = append(ops, writeOp{key: elemKey(key, member), del: true})
+		return true
+	}
+	more := true
+	if s != nil {
+		for node := s.entries.First(); node != nil && more; node = node.Next() {
+			more = evict(node.Member)
+		}
+	}
+	if more && added != "" {
+		evict(added)
+	}
+	n := len(ops)
+	if n > 0 && n == total {
+		// The top ID must outlive the newest entry
+		ops = append(ops, writeOp{key: elemKey(key, string(streamTopElem)), value: top.String()})
+	}
+	return ops, n
+}
+
+// xtrim evicts the entries trim selects from the stream at key and returns
+// how many it evicted. The caller must hold the write lock.
+func (db *LograDB) xtrim(key string, trim StreamTrim) (int, error) {
+	ops, n := xtrimOps(key, db.colls[key].stream, trim, "")
+	if n == 0 {
+		return 0, nil
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	db.notify(EventStream, "xtrim", key)
+	return n, nil
+}
+
+// XTrim evicts the entries trim selects from the stream at key and returns
+// how many it evicted. A stream stays when it is trimmed empty.
+func (db *LograDB) XTrim(key string, trim StreamTrim) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	return db.xtrim(key, trim)
+}
+
+// XLen returns the number of entries in the stream at key.
+func (db *LograDB) XLen(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	s, err := db.streamOf(key)
+	if err != nil || s == nil {
+		return 0, err
+	}
+	return s.entries.Len(), nil
+}
+
+// XLastID returns the largest ID ever added to the stream at key, or 0-0 if
+// it does not exist.
+func (db *LograDB) XLastID(key string) (StreamID, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	s, err := db.streamOf(key)
+	if err != nil || s == nil {
+		return StreamID{}, err
+	}
+	return s.top, nil
+}
+
+// XRange returns up to count entries of the stream at key with IDs from
+// start to end, inclusive, from end down if rev is set. A count of 0 or less
+// means no limit.
+func (db *LograDB) XRange(key string, start, end StreamID, count int, rev bool) ([]StreamEntry, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	s, err := db.streamOf(key)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return db.streamEntries(key, s.rangeIDs(start, end, count, rev))
+}
+
+// XRead returns up to count entries of the stream at key with IDs after
+// after.
+func (db *LograDB) XRead(key string, after StreamID, count int) ([]StreamEntry, error) {
+	start, ok := after.Next()
+	if !ok {
+		return nil, nil
+	}
+	return db.XRange(key, start, MaxStreamID, count, false)
+}
+
+// XGroupCreateOptions are the options of XGroupCreate.
+type XGroupCreateOptions struct {
+	FromLast bool // start after the stream's top ID rather than at the given one
+	MkStream bool // create the stream, empty, if it is missing
+}
+
+// XGroupCreate creates a consumer group of the stream at key that delivers
+// the entries after start.
+func (db *LograDB) XGroupCreate(key, group string, start StreamID, opts XGroupCreateOptions) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return err
+	}
+	var ops []writeOp
+	switch {
+	case s == nil && !opts.MkStream:
+		return ErrNoStream
+	case s == nil:
+		ops = db.createOps(key, streamType)
+		if opts.FromLast {
+			start = StreamID{}
+		}
+	case s.group(group) != nil:
+		return ErrGroupExists
+	case opts.FromLast:
+		start = s.top
+	}
+	ops = append(ops, writeOp{key: elemKey(key, string(streamGroupElem)+group), value: start.String()})
+	if err := db.write(ops); err != nil {
+		return err
+	}
+	db.notify(EventStream, "xgroup-create", key)
+	return nil
+}
+
+// XGroupSetID makes the consumer group deliver the entries after id, or
+// after the stream's top ID with fromLast.
+func (db *LograDB) XGroupSetID(key, group string, id StreamID, fromLast bool) error {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return err
+	}
+	if s == nil || s.group(group) == nil {
+		return ErrNoGroup
+	}
+	if fromLast {
+		id = s.top
+	}
+	if err := db.write([]writeOp{{key: elemKey(key, string(streamGroupElem)+group), value: id.String()}}); err != nil {
+		return err
+	}
+	db.notify(EventStream, "xgroup-setid", key)
+	return nil
+}
+
+// XGroupDestroy deletes a consumer group and its pending entries, and
+// reports whether it existed.
+func (db *LograDB) XGroupDestroy(key, group string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return false, err
+	}
+	if s == nil {
+		return false, ErrNoGroup
+	}
+	g := s.group(group)
+	if g == nil {
+		return false, nil
+	}
+	ops := []writeOp{{key: elemKey(key, string(streamGroupElem)+group), del: true}}
+	for id := range g.pending {
+		ops = append(ops, writeOp{key: elemKey(key, pendingElem(id, group)), del: true})
+	}
+	if err := db.write(ops); err != nil {
+		return false, err
+	}
+	db.notify(EventStream, "xgroup-destroy", key)
+	return true, nil
+}
+
+// GroupRead describes a read by a consumer of a consumer group.
+type GroupRead struct {
+	Group, Consumer string
+	// History rereads the entries pending for Consumer after After, rather
+	// than delivering entries the group has not delivered yet.
+	History bool
+	After   StreamID
+	Count   int  // 0 or less means no limit
+	NoAck   bool // do not track new deliveries as pending
+}
+
+// XReadGroup reads the stream at key as a consumer of a consumer group. New
+// entries become pending for the consumer until they are acknowledged with
+// XAck.
+func (db *LograDB) XReadGroup(key string, read GroupRead) ([]StreamEntry, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.group(read.Group) == nil {
+		return nil, ErrNoGroup
+	}
+	g := s.group(read.Group)
+
+	if read.History {
+		var ids []StreamID
+		for id, pe := range g.pending {
+			if pe.Consumer == read.Consumer && read.After.Less(id) {
+				ids = append(ids, id)
+			}
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i].Less(ids[j]) })
+		if read.Count > 0 && len(ids) > read.Count {
+			ids = ids[:read.Count]
+		}
+		return db.streamEntries(key, ids)
+	}
+
+	start, ok := g.last.Next()
+	if !ok {
+		return nil, nil
+	}
+	ids := s.rangeIDs(start, MaxStreamID, read.Count, false)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	now := time.Now().UnixMilli()
+	var ops []writeOp
+	if !read.NoAck {
+		for _, id := range ids {
+			pe := &PendingEntry{ID: id, Consumer: read.Consumer, DeliveredAt: now, Deliveries: 1}
+			ops = append(ops, writeOp{key: elemKey(key, pendingElem(id, read.Group)), value: encodePending(pe)})
+		}
+	}
+	ops = append(ops, writeOp{key: elemKey(key, string(streamGroupElem)+read.Group), value: ids[len(ids)-1].String()})
+	if err := db.write(ops); err != nil {
+		return nil, err
+	}
+	return db.streamEntries(key, ids)
+}
+
+// XAck acknowledges entries pending in a consumer group and returns how many
+// were pending.
+func (db *LograDB) XAck(key, group string, ids []StreamID) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil || s == nil || s.group(group) == nil {
+		return 0, err
+	}
+	g := s.group(group)
+	acked := make(map[StreamID]bool)
+	var ops []writeOp
+	for _, id := range ids {
+		if g.pending[id] != nil && !acked[id] {
+			acked[id] = true
+			ops = append(ops, writeOp{key: elemKey(key, pendingElem(id, group)), del: true})
+		}
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	return len(ops), nil
+}
+
+// XPending returns the entries pending in a consumer group, in ID order.
+func (db *LograDB) XPending(key, group string) ([]PendingEntry, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.group(group) == nil {
+		return nil, ErrNoGroup
+	}
+	pending := make([]PendingEntry, 0, len(s.group(group).pending))
+	for _, pe := range s.group(group).pending {
+		pending = append(pending, *pe)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID.Less(pending[j].ID) })
+	return pending, nil
+}
+
+// XClaimOptions are the options of XClaim.
+type XClaimOptions struct {
+	DeliveredAt int64 // delivery time to record, in Unix milliseconds; 0 means now
+	RetryCount  int   // delivery count to record if positive
+	Force       bool  // claim entries that are not pending, as long as they exist
+	JustID      bool  // leave the delivery count alone and return no fields
+}
+
+// XClaim makes the entries with ids that have been pending in a consumer
+// group for at least minIdle pending for consumer instead, and returns them.
+// Pending entries that were trimmed are dropped from the group rather than
+// claimed.
+func (db *LograDB) XClaim(key, group, consumer string, minIdle time.Duration, ids []StreamID, opts XClaimOptions) ([]StreamEntry, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	s, err := db.streamOf(key)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil || s.group(group) == nil {
+		return nil, ErrNoGroup
+	}
+	g := s.group(group)
+	c := db.colls[key]
+	now := time.Now().UnixMilli()
+	deliveredAt := opts.DeliveredAt
+	if deliveredAt == 0 {
+		deliveredAt = now
+	}
+
+	var claimed []StreamID
+	var ops []writeOp
+	seen := make(map[StreamID]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		_, exists := c.elems[entryElem(id)]
+		pe := g.pending[id]
+		switch {
+		case pe == nil && (!opts.Force || !exists):
+			continue
+		case pe != nil && !exists:
+			ops = append(ops, writeOp{key: elemKey(key, pendingElem(id, group)), del: true})
+			continue
+		case pe != nil && now-pe.DeliveredAt < minIdle.Milliseconds():
+			continue
+		}
+		claim := PendingEntry{ID: id, Consumer: consumer, DeliveredAt: deliveredAt}
+		if pe != nil {
+			claim.Deliveries = pe.Deliveries
+		}
+		switch {
+		case opts.RetryCount > 0:
+			claim.Deliveries = opts.RetryCount
+		case !opts.JustID:
+			claim.Deliveries++
+		}
+		ops = append(ops, writeOp{key: elemKey(key, pendingElem(id, group)), value: encodePending(&claim)})
+		claimed = append(claimed, id)
+	}
+	if len(ops) > 0 {
+		if err := db.write(ops); err != nil {
+			return nil, err
+		}
+	}
+	if opts.JustID {
+		entries := make([]StreamEntry, len(claimed))
+		for i, id := range claimed {
+			entries[i].ID = id
+		}
+		return entries, nil
+	}
+	return db.streamEntries(key, claimed)
+}
@@ -0,0 +1,161 @@
+package logra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+)
+
+// MaxStringSize is the largest value SetRange may produce, as in Redis.
+const MaxStringSize = 512 * 1024 * 1024
+
+var (
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+	ErrNotFloat   = errors.New("value is not a valid float")
+	ErrOverflow   = errors.New("increment or decrement would overflow")
+	ErrNaN        = errors.New("increment would produce NaN or Infinity")
+	ErrTooLarge   = errors.New("string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// current returns the value of key, or "" and false if it is missing or
+// expired. The caller must hold the lock.
+func (db *LograDB) current(key string) (string, bool, error) {
+	if !db.has(key) {
+		return "", false, nil
+	}
+	rec, err := db.get(key)
+	if err != nil {
+		return "", false, err
+	}
+	return rec.Value, true, nil
+}
+
+// update writes a new value for key, keeping its deadline. A key that had
+// expired is new, so whatever it held is dropped with the same write, and a
+// string stored in chunks (see bitmap.go) is stored plainly again.
+func (db *LograDB) update(key, value string) error {
+	ops := append([]writeOp{{key: key, value: value}}, db.dropCollectionOps(key)...)
+	if db.expired(key) {
+		ops = append(ops, db.clearExpireOps(key)...)
+	}
+	return db.write(ops)
+}
+
+// IncrBy adds delta to the integer stored at key, treating a missing key as
+// 0, and returns the new value.
+func (db *LograDB) IncrBy(key string, delta int64) (int64, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	value, _, err := db.current(key)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if value != "" {
+		n, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return 0, ErrNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrOverflow
+	}
+	n += delta
+	if err := db.update(key, strconv.FormatInt(n, 10)); err != nil {
+		return 0, err
+	}
+	db.notify(EventString, "incrby", key)
+	return n, nil
+}
+
+// IncrByFloat adds delta to the number stored at key, treating a missing key
+// as 0, and returns the new value as stored.
+func (db *LograDB) IncrByFloat(key string, delta float64) (string, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	value, _, err := db.current(key)
+	if err != nil {
+		return "", err
+	}
+	var f float64
+	if value != "" {
+		f, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", ErrNotFloat
+		}
+	}
+	f += delta
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", ErrNaN
+	}
+	result := strconv.FormatFloat(f, 'f', -1, 64)
+	if err := db.update(key, result); err != nil {
+		return "", err
+	}
+	db.notify(EventString, "incrbyfloat", key)
+	return result, nil
+}
+
+// Append appends value to the string at key, creating it if missing, and
+// returns the new length.
+func (db *LograDB) Append(key, value string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	old, _, err := db.current(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return len(old), nil
+	}
+	if err := db.update(key, old+value); err != nil {
+		return 0, err
+	}
+	db.notify(EventString, "append", key)
+	return len(old) + len(value), nil
+}
+
+// StrLen returns the length of the value at key, or 0 if it is missing. The
+// length comes from the index, so the value is not read.
+func (db *LograDB) StrLen(key string) int {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	if !db.has(key) {
+		return 0
+	}
+	n, _ := db.stringLen(key)
+	return int(n)
+}
+
+// SetRange overwrites the string at key from offset on with value, padding
+// with zero bytes as needed, and returns the new length.
+func (db *LograDB) SetRange(key string, offset int, value string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	old, _, err := db.current(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return len(old), nil
+	}
+	if offset+len(value) > MaxStringSize {
+		return 0, ErrTooLarge
+	}
+
+	buf := []byte(old)
+	if end := offset + len(value); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	if err := db.update(key, string(buf)); err != nil {
+		return 0, err
+	}
+	db.notify(EventString, "setrange", key)
+	return len(buf), nil
+}
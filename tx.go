@@ -0,0 +1,214 @@
+package logra
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+**
+Transactions
+Transaction runs a function against a view of the database while holding the
+write lock. The view shares the index, storage and expiry table with the
+database but buffers its writes: reads see them through an overlay, and they
+are appended as one batch once the function returns, so on disk a
+transaction is all or nothing.
+
+WATCH is optimistic: while a key is watched, every write to it bumps its
+version, and Transaction refuses to run if a version changed.
+**
+*/
+
+// txn holds the buffered writes of a transaction.
+type txn struct {
+	ops     []writeOp
+	pending map[string]writeOp
+
+	// expireUndo keeps the deadline a key had before the transaction first
+	// changed it, so a failed commit can restore db.expires.
+	expireUndo map[string]expireState
+
+	// undo reverts in-memory changes other than deadlines if the commit
+	// fails.
+	undo []func()
+
+	// events are sent to the notifier once the writes are committed.
+	events []event
+
+	// afterCommit runs once the writes are committed, and not at all if the
+	// commit fails.
+	afterCommit []func()
+}
+
+type expireState struct {
+	at int64
+	ok bool
+}
+
+// watch is the version of a key that at least one caller watches.
+type watch struct {
+	refs    int
+	version uint64
+}
+
+// WatchedKey is a key as it was when Watch was called.
+type WatchedKey struct {
+	Key     string
+	version uint64
+	exists  bool
+}
+
+// Watch starts tracking changes to key. Pass the result to Transaction, and
+// release it with Unwatch.
+func (db *LograDB) Watch(key string) WatchedKey {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	w, ok := db.watches[key]
+	if !ok {
+		w = &watch{}
+		db.watches[key] = w
+	}
+	w.refs++
+	return WatchedKey{Key: key, version: w.version, exists: db.has(key)}
+}
+
+// Unwatch releases keys returned by Watch.
+func (db *LograDB) Unwatch(keys []WatchedKey) {
+	if len(keys) == 0 {
+		return
+	}
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	for _, key := range keys {
+		w, ok := db.watches[key.Key]
+		if !ok {
+			continue
+		}
+		if w.refs--; w.refs == 0 {
+			delete(db.watches, key.Key)
+		}
+	}
+}
+
+// touch bumps the version of key if it is watched. Changing a deadline or an
+// element counts as changing the key. The caller must hold the write lock.
+func (db *LograDB) touch(key string) {
+	if len(db.watches) == 0 {
+		return
+	}
+	if w, ok := db.watches[ownerKey(key)]; ok {
+		w.version++
+	}
+}
+
+// Transaction runs fn with a view of db whose writes are appended as one
+// batch when fn returns. The view must not be used after fn returns. If any
+// of watched was written, or appeared or expired, since Watch returned it, fn
+// is not run and Transaction returns false.
+func (db *LograDB) Transaction(watched []WatchedKey, fn func(tx *LograDB)) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	if db.tx != nil {
+		return false, fmt.Errorf("transactions can not be nested")
+	}
+	for _, key := range watched {
+		w, ok := db.watches[key.Key]
+		if !ok || w.version != key.version || db.has(key.Key) != key.exists {
+			return false, nil
+		}
+	}
+
+	view := &LograDB{
+		Index:    db.Index,
+		Storage:  db.Storage,
+		version:  db.version,
+		Flock:    db.Flock,
+		expires:  db.expires,
+		colls:    db.colls,
+		watches:  db.watches,
+		notifier: db.notifier,
+		tx: &txn{
+			pending:    make(map[string]writeOp),
+			expireUndo: make(map[string]expireState),
+		},
+	}
+	fn(view)
+
+	if err := db.appendOps(view.tx.ops); err != nil {
+		for key, st := range view.tx.expireUndo {
+			if st.ok {
+				db.expires[key] = st.at
+			} else {
+				delete(db.expires, key)
+			}
+		}
+		for i := len(view.tx.undo) - 1; i >= 0; i-- {
+			view.tx.undo[i]()
+		}
+		return false, err
+	}
+	for _, e := range view.tx.events {
+		db.notify(e.class, e.name, e.key)
+	}
+	for _, fn := range view.tx.afterCommit {
+		fn()
+	}
+	return true, nil
+}
+
+// AfterCommit defers fn, such as a side effect outside the database, until
+// the transaction db is a view of commits, and drops it if the commit fails.
+// It reports false, without keeping fn, if db is not a transaction's view.
+func (db *LograDB) AfterCommit(fn func()) bool {
+	if db.tx == nil {
+		return false
+	}
+	db.tx.afterCommit = append(db.tx.afterCommit, fn)
+	return true
+}
+
+// buffer queues ops for the batch written at the end of the transaction.
+func (t *txn) buffer(ops []writeOp) {
+	t.ops = append(t.ops, ops...)
+	for _, op := range ops {
+		t.pending[op.key] = op
+	}
+}
+
+// saveExpire remembers the deadline key had before the transaction first
+// changed it.
+func (t *txn) saveExpire(key string, expires map[string]int64) {
+	if _, saved := t.expireUndo[key]; saved {
+		return
+	}
+	at, ok := expires[key]
+	t.expireUndo[key] = expireState{at: at, ok: ok}
+}
+
+// lenDelta is how many keys the buffered writes add to the index.
+func (t *txn) lenDelta(db *LograDB) int {
+	delta := 0
+	for key, op := range t.pending {
+		indexed := db.Index.Has(key)
+		if !op.del && !indexed {
+			delta++
+		} else if op.del && indexed {
+			delta--
+		}
+	}
+	return delta
+}
+
+// pendingRecord returns the value a transaction wrote to key, if any. found
+// is false when the transaction did not write key; a buffered delete is
+// found but not ok.
+func (t *txn) pendingRecord(key string) (rec Record, found bool, ok bool) {
+	op, found := t.pending[key]
+	if !found || op.del {
+		return Record{}, found, false
+	}
+	return Record{Key: key, Value: op.value, Timestamp: time.Now().Unix()}, true, true
+}
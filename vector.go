@@ -0,0 +1,329 @@
+package logra
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"sakthirathinam/logra/internal/hnsw"
+)
+
+/*
+**
+Vectors
+A vector set is a collection of float32 vectors of one dimension, named by
+ID, with a record of the options it was created with:
+
+	"\x00logra:elem:<len>:<key>c"     -> metric, index, dimension, M, EF
+	"\x00logra:elem:<len>:<key>v<id>" -> the vector, little-endian float32s
+
+The vectors are kept in memory. VSim compares the query with each of them,
+or, in a set created with HNSW, searches an HNSW graph (internal/hnsw). The
+graph is not stored: it is rebuilt from the vectors as they are loaded on
+Open and kept in step with every add and delete.
+**
+*/
+const (
+	vectorType       = "vectorset"
+	vectorConfigElem = "c"
+	vectorElem       = 'v'
+	vectorConfigSize = 10
+)
+
+// VectorMetric is how a vector set measures distance. Smaller is closer.
+type VectorMetric byte
+
+const (
+	VectorCosine VectorMetric = iota + 1 // 1 minus the cosine similarity
+	VectorL2                             // Euclidean distance
+	VectorDot                            // the negated dot product
+)
+
+// VectorOptions configure a vector set when VAdd creates it. Those given to
+// VAdd on an existing set must match the ones it was created with.
+type VectorOptions struct {
+	Metric VectorMetric // VectorCosine if 0
+	HNSW   bool         // index with an HNSW graph rather than searching every vector
+	M      int          // links per node of the graph; 16 if 0
+	EF     int          // candidates considered when linking a new node; 200 if 0
+}
+
+// VSimOptions tune a VSim search.
+type VSimOptions struct {
+	EF    int  // candidates considered by an HNSW search; at least K
+	Exact bool // compare with every vector even if the set has a graph
+}
+
+// VectorMatch is a vector found by VSim and its distance to the query.
+type VectorMatch struct {
+	ID       string
+	Distance float64
+}
+
+var (
+	ErrVectorValue   = errors.New("vector must be non-empty with finite values")
+	ErrVectorOptions = errors.New("options differ from those of the existing vector set")
+)
+
+func errVectorDim(got, want int) error {
+	return fmt.Errorf("vector dimension mismatch - got %d but set has %d", got, want)
+}
+
+// vectorSet is the in-memory view of a vector set.
+type vectorSet struct {
+	VectorOptions
+	dim   int
+	vecs  map[string][]float32
+	graph *hnsw.Graph // nil unless the set is indexed with HNSW
+}
+
+func newVectorSet() *vectorSet {
+	return &vectorSet{vecs: make(map[string][]float32)}
+}
+
+func encodeVector(vec []float32) string {
+	buf := make([]byte, 0, 4*len(vec))
+	for _, f := range vec {
+		buf = binary.LittleEndian.AppendUint32(buf, math.Float32bits(f))
+	}
+	return string(buf)
+}
+
+func decodeVector(value string) []float32 {
+	vec := make([]float32, len(value)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32([]byte(value[4*i:])))
+	}
+	return vec
+}
+
+func (v *vectorSet) encodeConfig() string {
+	var buf [vectorConfigSize]byte
+	buf[0] = byte(v.Metric)
+	if v.HNSW {
+		buf[1] = 1
+	}
+	binary.BigEndian.PutUint32(buf[2:], uint32(v.dim))
+	binary.BigEndian.PutUint16(buf[6:], uint16(v.M))
+	binary.BigEndian.PutUint16(buf[8:], uint16(v.EF))
+	return string(buf[:])
+}
+
+// setConfig applies the options record, building the graph of an HNSW set
+// from the vectors loaded so far.
+func (v *vectorSet) setConfig(value string) {
+	if len(value) < vectorConfigSize {
+		return
+	}
+	v.Metric, v.HNSW = VectorMetric(value[0]), value[1] == 1
+	v.dim = int(binary.BigEndian.Uint32([]byte(value[2:])))
+	v.M = int(binary.BigEndian.Uint16([]byte(value[6:])))
+	v.EF = int(binary.BigEndian.Uint16([]byte(value[8:])))
+	if !v.HNSW || v.graph != nil {
+		return
+	}
+	v.graph = hnsw.New(v.M, v.EF, v.Metric.distance)
+	ids := make([]string, 0, len(v.vecs))
+	for id := range v.vecs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		v.graph.Insert(id, v.vecs[id])
+	}
+}
+
+func (v *vectorSet) add(id string, vec []float32) {
+	v.vecs[id] = vec
+	if v.graph != nil {
+		v.graph.Insert(id, vec)
+	}
+}
+
+func (v *vectorSet) remove(id string) {
+	delete(v.vecs, id)
+	if v.graph != nil {
+		v.graph.Delete(id)
+	}
+}
+
+// value rebuilds the record of elem.
+func (v *vectorSet) value(elem string) string {
+	if elem == vectorConfigElem {
+		return v.encodeConfig()
+	}
+	return encodeVector(v.vecs[elem[1:]])
+}
+
+func (m VectorMetric) distance(a, b []float32) float32 {
+	var dot, na, nb float32
+	switch m {
+	case VectorL2:
+		for i := range a {
+			d := a[i] - b[i]
+			dot += d * d
+		}
+		return float32(math.Sqrt(float64(dot)))
+	case VectorDot:
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return -dot
+	}
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/float32(math.Sqrt(float64(na)*float64(nb)))
+}
+
+func checkVector(vec []float32) error {
+	if len(vec) == 0 {
+		return ErrVectorValue
+	}
+	for _, f := range vec {
+		if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+			return ErrVectorValue
+		}
+	}
+	return nil
+}
+
+// VAdd stores vector as id in the vector set at key, creating the set with
+// opts if missing. For an existing set, any option opts sets must be the one
+// the set has, or VAdd fails with ErrVectorOptions. It reports whether id is
+// new.
+func (db *LograDB) VAdd(key, id string, vector []float32, opts VectorOptions) (bool, error) {
+	if err := checkVector(vector); err != nil {
+		return false, err
+	}
+
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, vectorType)
+	if err != nil {
+		return false, err
+	}
+	var ops []writeOp
+	isNew := true
+	if c == nil {
+		if opts.Metric > VectorDot {
+			return false, fmt.Errorf("unknown vector metric %d", opts.Metric)
+		}
+		v := vectorSet{VectorOptions: opts, dim: len(vector)}
+		if v.Metric == 0 {
+			v.Metric = VectorCosine
+		}
+		if v.M <= 0 {
+			v.M = 16
+		}
+		if v.EF <= 0 {
+			v.EF = 200
+		}
+		ops = append(db.createOps(key, vectorType), writeOp{key: elemKey(key, vectorConfigElem), value: v.encodeConfig()})
+	} else {
+		v := c.vectors
+		if opts.Metric != 0 && opts.Metric != v.Metric || opts.HNSW && !v.HNSW ||
+			opts.M > 0 && opts.M != v.M || opts.EF > 0 && opts.EF != v.EF {
+			return false, ErrVectorOptions
+		}
+		if len(vector) != v.dim {
+			return false, errVectorDim(len(vector), v.dim)
+		}
+		_, had := v.vecs[id]
+		isNew = !had
+	}
+	ops = append(ops, writeOp{key: elemKey(key, string(vectorElem)+id), value: encodeVector(vector)})
+	if err := db.write(ops); err != nil {
+		return false, err
+	}
+	db.notify(EventModule, "vadd", key)
+	return isNew, nil
+}
+
+// VDel removes id from the vector set at key, and the key with its last
+// vector. It reports whether id was there.
+func (db *LograDB) VDel(key, id string) (bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, vectorType)
+	if err != nil || c == nil {
+		return false, err
+	}
+	if _, ok := c.vectors.vecs[id]; !ok {
+		return false, nil
+	}
+	emptied := len(c.vectors.vecs) == 1
+	ops := []writeOp{{key: elemKey(key, string(vectorElem)+id), del: true}}
+	if emptied {
+		ops = db.deleteOps(key)
+	}
+	if err := db.write(ops); err != nil {
+		return false, err
+	}
+	db.notify(EventModule, "vdel", key)
+	if emptied {
+		db.notify(EventGeneric, "del", key)
+	}
+	return true, nil
+}
+
+// VSim returns the up to k vectors of the set at key closest to vector,
+// nearest first. In a set indexed with HNSW the search is approximate unless
+// opts.Exact is set.
+func (db *LograDB) VSim(key string, vector []float32, k int, opts VSimOptions) ([]VectorMatch, error) {
+	if err := checkVector(vector); err != nil || k <= 0 {
+		return nil, err
+	}
+
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, vectorType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	v := c.vectors
+	if len(vector) != v.dim {
+		return nil, errVectorDim(len(vector), v.dim)
+	}
+
+	var matches []VectorMatch
+	if v.graph != nil && !opts.Exact {
+		for _, r := range v.graph.Search(vector, k, opts.EF) {
+			matches = append(matches, VectorMatch{ID: r.ID, Distance: float64(r.Dist)})
+		}
+		return matches, nil
+	}
+	matches = make([]VectorMatch, 0, len(v.vecs))
+	for id, vec := range v.vecs {
+		matches = append(matches, VectorMatch{ID: id, Distance: float64(v.Metric.distance(vector, vec))})
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches[:min(k, len(matches))], nil
+}
+
+// VCard returns the number of vectors in the set at key.
+func (db *LograDB) VCard(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, vectorType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	return len(c.vectors.vecs), nil
+}
@@ -0,0 +1,451 @@
+package logra
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"sakthirathinam/logra/internal/skiplist"
+)
+
+/*
+**
+Sorted sets
+A sorted set is a collection whose elements are its members and whose
+element records hold the score. The order lives in memory only: a skip list
+per sorted set, rebuilt from the member records on Open, gives ranks and
+score or lexicographic ranges in O(log n).
+**
+*/
+const zsetType = "zset"
+
+var ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+// ScoredMember is a member of a sorted set and its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// FormatScore renders a score as sorted sets store and reply with it.
+func FormatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func parseScore(s string) float64 {
+	score, _ := strconv.ParseFloat(s, 64)
+	return score
+}
+
+// ZAddOptions are the conditions of ZAdd and ZIncrBy, as in Redis's ZADD.
+type ZAddOptions struct {
+	NX bool // only add new members
+	XX bool // only update existing members
+	GT bool // only update a score to a greater one
+	LT bool // only update a score to a lower one
+	CH bool // count updated members as well as added ones
+}
+
+// allows reports whether opts let a member with score old, if exists, get
+// the score new.
+func (opts ZAddOptions) allows(old float64, exists bool, new float64) bool {
+	if !exists {
+		return !opts.XX
+	}
+	return !opts.NX && (!opts.GT || new > old) && (!opts.LT || new < old)
+}
+
+// ZAdd adds members to the sorted set at key, or updates their scores,
+// subject to opts. Later pairs win when a member repeats. It returns how many
+// members were added, or with opts.CH added or updated.
+func (db *LograDB) ZAdd(key string, members []ScoredMember, opts ZAddOptions) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil {
+		return 0, err
+	}
+	var ops []writeOp
+	if c == nil {
+		ops = db.createOps(key, zsetType)
+	}
+	pending := make(map[string]float64)
+	score := func(member string) (float64, bool) {
+		if s, ok := pending[member]; ok {
+			return s, true
+		}
+		if c == nil {
+			return 0, false
+		}
+		s, ok := c.scores[member]
+		return s, ok
+	}
+
+	added, updated := 0, 0
+	for _, m := range members {
+		old, exists := score(m.Member)
+		if !opts.allows(old, exists, m.Score) || (exists && old == m.Score) {
+			continue
+		}
+		if exists {
+			updated++
+		} else {
+			added++
+		}
+		pending[m.Member] = m.Score
+		ops = append(ops, writeOp{key: elemKey(key, m.Member), value: FormatScore(m.Score)})
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	db.notify(EventZSet, "zadd", key)
+	if opts.CH {
+		return added + updated, nil
+	}
+	return added, nil
+}
+
+// ZIncrBy adds delta to the score of member in the sorted set at key,
+// adding it with score delta if missing, subject to opts. It returns the new
+// score, or false if opts prevented the change.
+func (db *LograDB) ZIncrBy(key, member string, delta float64, opts ZAddOptions) (float64, bool, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil {
+		return 0, false, err
+	}
+	var old float64
+	exists := false
+	if c != nil {
+		old, exists = c.scores[member]
+	}
+	score := old + delta
+	if math.IsNaN(score) {
+		return 0, false, ErrScoreNaN
+	}
+	if !opts.allows(old, exists, score) {
+		return 0, false, nil
+	}
+	var ops []writeOp
+	if c == nil {
+		ops = db.createOps(key, zsetType)
+	}
+	ops = append(ops, writeOp{key: elemKey(key, member), value: FormatScore(score)})
+	if err := db.write(ops); err != nil {
+		return 0, false, err
+	}
+	db.notify(EventZSet, "zincr", key)
+	return score, true, nil
+}
+
+// ZScore returns the score of member in the sorted set at key.
+func (db *LograDB) ZScore(key, member string) (float64, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil {
+		return 0, false, err
+	}
+	score, ok := c.scores[member]
+	return score, ok, nil
+}
+
+// ZCard returns the number of members of the sorted set at key.
+func (db *LograDB) ZCard(key string) (int, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	return len(c.elems), nil
+}
+
+// ZRank returns the 0-based rank of member in the sorted set at key, from
+// the highest score if rev is set, and its score.
+func (db *LograDB) ZRank(key, member string, rev bool) (int, float64, bool, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil {
+		return 0, 0, false, err
+	}
+	score, ok := c.scores[member]
+	if !ok {
+		return 0, 0, false, nil
+	}
+	rank := c.zsl.Rank(skiplist.Element{Member: member, Score: score})
+	if rev {
+		rank = len(c.elems) - 1 - rank
+	}
+	return rank, score, true, nil
+}
+
+// ZRem removes members from the sorted set at key and returns how many were
+// members. The key is deleted with its last member.
+func (db *LograDB) ZRem(key string, members []string) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil {
+		return 0, err
+	}
+	removed := make(map[string]bool)
+	var ops []writeOp
+	for _, member := range members {
+		if _, ok := c.elems[member]; ok && !removed[member] {
+			removed[member] = true
+			ops = append(ops, writeOp{key: elemKey(key, member), del: true})
+		}
+	}
+	if len(ops) == 0 {
+		return 0, nil
+	}
+	if err := db.zremove(key, c, ops, "zrem"); err != nil {
+		return 0, err
+	}
+	return len(removed), nil
+}
+
+// zremove writes ops, which delete members of the sorted set c at key, or
+// deletes the key if they are all of them.
+func (db *LograDB) zremove(key string, c *collection, ops []writeOp, event string) error {
+	emptied := len(ops) == len(c.elems)
+	if emptied {
+		ops = db.deleteOps(key)
+	}
+	if err := db.write(ops); err != nil {
+		return err
+	}
+	db.notify(EventZSet, event, key)
+	if emptied {
+		db.notify(EventGeneric, "del", key)
+	}
+	return nil
+}
+
+// ZRangeBy selects how a ZRangeSpec picks members.
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+// ScoreBound is one end of a score range.
+type ScoreBound struct {
+	Score     float64
+	Exclusive bool
+}
+
+// LexBound is one end of a lexicographic range. Inf is -1 for "-", the
+// lowest possible member, and 1 for "+", the highest.
+type LexBound struct {
+	Member    string
+	Exclusive bool
+	Inf       int
+}
+
+// ZRangeSpec is a range of a sorted set, as ZRANGE takes it.
+type ZRangeSpec struct {
+	By ZRangeBy
+
+	// Start and Stop are inclusive ranks for ZRangeByRank; negative ones
+	// count from the end.
+	Start, Stop int
+	// Min and Max bound the scores for ZRangeByScore.
+	Min, Max ScoreBound
+	// LexMin and LexMax bound the members for ZRangeByLex.
+	LexMin, LexMax LexBound
+
+	// Rev walks the range from the highest member down.
+	Rev bool
+
+	// With Limit, Offset members of a score or lexicographic range are
+	// skipped and at most Count returned; a negative Count means all.
+	Limit         bool
+	Offset, Count int
+}
+
+// bounds returns predicates for elements above the lower end and below the
+// upper end of a score or lexicographic range.
+func (spec ZRangeSpec) bounds() (aboveMin, belowMax func(skiplist.Element) bool) {
+	if spec.By == ZRangeByScore {
+		aboveMin = func(e skiplist.Element) bool {
+			return e.Score > spec.Min.Score || (!spec.Min.Exclusive && e.Score == spec.Min.Score)
+		}
+		belowMax = func(e skiplist.Element) bool {
+			return e.Score < spec.Max.Score || (!spec.Max.Exclusive && e.Score == spec.Max.Score)
+		}
+		return aboveMin, belowMax
+	}
+	aboveMin = func(e skiplist.Element) bool {
+		b := spec.LexMin
+		if b.Inf != 0 {
+			return b.Inf < 0
+		}
+		return e.Member > b.Member || (!b.Exclusive && e.Member == b.Member)
+	}
+	belowMax = func(e skiplist.Element) bool {
+		b := spec.LexMax
+		if b.Inf != 0 {
+			return b.Inf > 0
+		}
+		return e.Member < b.Member || (!b.Exclusive && e.Member == b.Member)
+	}
+	return aboveMin, belowMax
+}
+
+// zrange returns the members of c in spec.
+func (c *collection) zrange(spec ZRangeSpec) []ScoredMember {
+	n := len(c.elems)
+	var members []ScoredMember
+	step := func(node *skiplist.Node) *skiplist.Node {
+		if spec.Rev {
+			return node.Prev()
+		}
+		return node.Next()
+	}
+
+	if spec.By == ZRangeByRank {
+		start, stop, ok := listRange(spec.Start, spec.Stop, n)
+		if !ok {
+			return nil
+		}
+		rank := start
+		if spec.Rev {
+			rank = n - 1 - start
+		}
+		node := c.zsl.At(rank)
+		for i := start; i <= stop && node != nil; i++ {
+			members = append(members, ScoredMember{node.Member, node.Score})
+			node = step(node)
+		}
+		return members
+	}
+
+	aboveMin, belowMax := spec.bounds()
+	var node *skiplist.Node
+	inRange := belowMax
+	if spec.Rev {
+		node = c.zsl.At(c.zsl.Seek(belowMax) - 1)
+		inRange = aboveMin
+	} else {
+		node = c.zsl.At(c.zsl.Seek(func(e skiplist.Element) bool { return !aboveMin(e) }))
+	}
+	skip, count := 0, -1
+	if spec.Limit {
+		skip, count = spec.Offset, spec.Count
+	}
+	for ; node != nil && inRange(node.Element) && count != 0; node = step(node) {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		members = append(members, ScoredMember{node.Member, node.Score})
+		count--
+	}
+	return members
+}
+
+// ZRange returns the members of the sorted set at key in spec, with their
+// scores.
+func (db *LograDB) ZRange(key string, spec ZRangeSpec) ([]ScoredMember, error) {
+	db.Mutex.RLock()
+	defer db.Mutex.RUnlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.zrange(spec), nil
+}
+
+// ZRangeStore stores the members of the sorted set at src in spec as the
+// sorted set dst, replacing whatever dst held, and returns how many there
+// are. An empty range deletes dst.
+func (db *LograDB) ZRangeStore(dst, src string, spec ZRangeSpec) (int, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(src, zsetType)
+	if err != nil {
+		return 0, err
+	}
+	var members []ScoredMember
+	if c != nil {
+		members = c.zrange(spec)
+	}
+	var ops []writeOp
+	if len(members) == 0 {
+		if db.indexed(dst) {
+			ops = db.deleteOps(dst)
+		}
+	} else {
+		ops = db.createOps(dst, zsetType)
+		for _, m := range members {
+			ops = append(ops, writeOp{key: elemKey(dst, m.Member), value: FormatScore(m.Score)})
+		}
+	}
+	if err := db.write(ops); err != nil {
+		return 0, err
+	}
+	if len(members) > 0 {
+		db.notify(EventZSet, "zrangestore", dst)
+	} else if len(ops) > 0 {
+		db.notify(EventGeneric, "del", dst)
+	}
+	return len(members), nil
+}
+
+// zpop removes up to count members with the lowest scores, or the highest
+// with max, from the sorted set at key and returns them in that order.
+func (db *LograDB) zpop(key string, count int, max bool) ([]ScoredMember, error) {
+	db.Mutex.Lock()
+	defer db.Mutex.Unlock()
+
+	c, err := db.collectionOf(key, zsetType)
+	if err != nil || c == nil || count <= 0 {
+		return nil, err
+	}
+	members := c.zrange(ZRangeSpec{Start: 0, Stop: count - 1, Rev: max})
+	ops := make([]writeOp, len(members))
+	for i, m := range members {
+		ops[i] = writeOp{key: elemKey(key, m.Member), del: true}
+	}
+	event := "zpopmin"
+	if max {
+		event = "zpopmax"
+	}
+	if err := db.zremove(key, c, ops, event); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// ZPopMin removes and returns up to count members with the lowest scores.
+func (db *LograDB) ZPopMin(key string, count int) ([]ScoredMember, error) {
+	return db.zpop(key, count, false)
+}
+
+// ZPopMax removes and returns up to count members with the highest scores.
+func (db *LograDB) ZPopMax(key string, count int) ([]ScoredMember, error) {
+	return db.zpop(key, count, true)
+}